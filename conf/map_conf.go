@@ -11,6 +11,7 @@ const (
 	StringType     = "string"
 	IntType        = "int"
 	Int64Type      = "int64"
+	Float64Type    = "float64"
 	BoolType       = "bool"
 	StringListType = "[]string"
 	AliasMapType   = "[string string, string]"
@@ -100,6 +101,26 @@ func (conf MapConf) GetInt64(key string) (int64, error) {
 	return v, nil
 }
 
+func (conf MapConf) GetFloatOr(key string, deft float64) (float64, error) {
+	ret, err := conf.GetFloat(key)
+	if err != nil {
+		return deft, err
+	}
+	return ret, nil
+}
+
+func (conf MapConf) GetFloat(key string) (float64, error) {
+	value, exist := conf[key]
+	if !exist {
+		return 0, ErrConfMissingKey(key, Float64Type)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, ErrConfKeyType(key, Float64Type)
+	}
+	return v, nil
+}
+
 func (conf MapConf) GetBoolOr(key string, deft bool) (bool, error) {
 	ret, err := conf.GetBool(key)
 	if err != nil {
@@ -167,6 +188,14 @@ func (c MapConf) GetAliasList(key string) (aks []AliasKey, err error) {
 	return
 }
 
+func (conf MapConf) GetAliasListOr(key string, deft []AliasKey) ([]AliasKey, error) {
+	ret, err := conf.GetAliasList(key)
+	if err != nil {
+		return deft, err
+	}
+	return ret, err
+}
+
 func (conf MapConf) GetAliasMapOr(key string, deft map[string]string) (map[string]string, error) {
 	ret, err := conf.GetAliasMap(key)
 	if err != nil {
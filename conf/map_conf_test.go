@@ -34,6 +34,23 @@ func TestGetInt(t *testing.T) {
 	assert.Equal(t, key, 2)
 }
 
+func TestGetFloat(t *testing.T) {
+	c := MapConf{}
+	c["k1"] = "1.5"
+	c["k3"] = "abc"
+
+	key, err := c.GetFloat("k1")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, key, 1.5)
+
+	key, _ = c.GetFloatOr("k2", 2)
+	assert.Equal(t, key, float64(2))
+	_, err = c.GetFloat("k3")
+	assert.Error(t, err)
+}
+
 func TestGetBool(t *testing.T) {
 	c := MapConf{}
 	c["k1"] = "true"
@@ -99,6 +116,8 @@ func TestGetAliasList(t *testing.T) {
 	assert.Equal(t, name, "b")
 	assert.Equal(t, alias, "b")
 
+	key, _ = c.GetAliasListOr("k2", []AliasKey{})
+	assert.Equal(t, 0, len(key))
 }
 
 func TestGet(t *testing.T) {
@@ -0,0 +1,195 @@
+package mgr
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+// dead letter 的配置，dead_letter_path 和 sender_type 二选一
+const (
+	KeyDeadLetterPath       = "dead_letter_path"        // 解析失败的数据写入的本地文件，按大小滚动
+	KeyDeadLetterMaxSize    = "dead_letter_max_size"    // 单个文件的最大大小，单位MB，默认100
+	KeyDeadLetterMaxBackups = "dead_letter_max_backups" // 最多保留的历史文件个数，默认5
+	KeyDeadLetterKeep       = "dead_letter_keep"        // 内存中保留最近失败数据的条数，用于REST接口查询，默认100
+	KeyDeadLetterQueueSize  = "dead_letter_queue_size"  // 等待写入的批次上限，写入跟不上时丢弃新的数据，默认100
+)
+
+const (
+	defaultDeadLetterMaxSize    = 100
+	defaultDeadLetterMaxBackups = 5
+	defaultDeadLetterKeep       = 100
+	defaultDeadLetterQueueSize  = 100
+)
+
+// DeadLetter 一条解析失败，或者发送时遇到永久错误的数据。发送失败时Raw为json格式的数据，Parser为空
+type DeadLetter struct {
+	Raw    string    `json:"raw"`
+	Parser string    `json:"parser"`
+	Sender string    `json:"sender,omitempty"`
+	Error  string    `json:"error"`
+	Source string    `json:"source"`
+	Offset int64     `json:"offset"`
+	Time   time.Time `json:"time"`
+}
+
+// deadLetterQueue 保存解析或发送失败的数据，写入本地文件或者发给配置的sender，同时在内存中保留最近的若干条。
+// 写入由单独的goroutine完成，writer和sender不需要是并发安全的，写入慢时也不会阻塞解析与发送
+type deadLetterQueue struct {
+	mux     sync.Mutex // 保护recent、next和closed
+	writer  *utils.RotateWriter
+	sender  sender.Sender
+	recent  []DeadLetter
+	next    int
+	keep    int
+	queue   chan []DeadLetter
+	done    chan struct{}
+	closed  bool
+	dropped utils.Counter // queue已满时丢弃的条数
+}
+
+func newDeadLetterQueue(c conf.MapConf, sr *sender.SenderRegistry) (*deadLetterQueue, error) {
+	keep, _ := c.GetIntOr(KeyDeadLetterKeep, defaultDeadLetterKeep)
+	size, _ := c.GetIntOr(KeyDeadLetterQueueSize, defaultDeadLetterQueueSize)
+	q := &deadLetterQueue{keep: keep}
+	if path, _ := c.GetStringOr(KeyDeadLetterPath, ""); path != "" {
+		maxSize, _ := c.GetIntOr(KeyDeadLetterMaxSize, defaultDeadLetterMaxSize)
+		maxBackups, _ := c.GetIntOr(KeyDeadLetterMaxBackups, defaultDeadLetterMaxBackups)
+		w, err := utils.NewRotateWriter(path, int64(maxSize)*1024*1024, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		q.writer = w
+	} else if _, err := c.GetString(sender.KeySenderType); err == nil {
+		s, err := sr.NewSender(c)
+		if err != nil {
+			return nil, err
+		}
+		q.sender = s
+	}
+	q.start(size)
+	return q, nil
+}
+
+// start 启动写入的goroutine，size为等待写入的批次上限
+func (q *deadLetterQueue) start(size int) {
+	if size <= 0 {
+		size = defaultDeadLetterQueueSize
+	}
+	q.queue = make(chan []DeadLetter, size)
+	q.done = make(chan struct{})
+	go func() {
+		defer close(q.done)
+		for letters := range q.queue {
+			q.write(letters)
+		}
+	}()
+}
+
+// Add 保存失败的数据并交给写入的goroutine，等待写入的数据过多时丢弃并计数，不影响正常数据的处理
+func (q *deadLetterQueue) Add(letters []DeadLetter) {
+	if len(letters) <= 0 {
+		return
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	for _, l := range letters {
+		if q.keep <= 0 {
+			break
+		}
+		if len(q.recent) < q.keep {
+			q.recent = append(q.recent, l)
+		} else {
+			q.recent[q.next] = l
+		}
+		q.next = (q.next + 1) % q.keep
+	}
+	if q.closed || (q.writer == nil && q.sender == nil) {
+		return
+	}
+	select {
+	case q.queue <- letters:
+	default:
+		q.dropped.Add(int64(len(letters)))
+		log.Warnf("dead letter queue is full, drop %v letters", len(letters))
+	}
+}
+
+// write 写入本地文件或者发给sender，失败只记录日志
+func (q *deadLetterQueue) write(letters []DeadLetter) {
+	if q.writer != nil {
+		for _, l := range letters {
+			bs, err := json.Marshal(l)
+			if err != nil {
+				log.Errorf("marshal dead letter %v error %v", l, err)
+				continue
+			}
+			if _, err = q.writer.Write(append(bs, '\n')); err != nil {
+				log.Errorf("write dead letter error %v", err)
+				return
+			}
+		}
+	}
+	if q.sender != nil {
+		datas := make([]sender.Data, 0, len(letters))
+		for _, l := range letters {
+			datas = append(datas, sender.Data{
+				"raw":    l.Raw,
+				"parser": l.Parser,
+				"sender": l.Sender,
+				"error":  l.Error,
+				"source": l.Source,
+				"offset": l.Offset,
+				"time":   l.Time,
+			})
+		}
+		if err := q.sender.Send(datas); err != nil {
+			log.Errorf("send dead letter by %v error %v", q.sender.Name(), err)
+		}
+	}
+}
+
+// Dropped 返回因为写入跟不上而丢弃的条数
+func (q *deadLetterQueue) Dropped() int64 {
+	return q.dropped.Value()
+}
+
+// Recent 返回最近的n条失败数据，按时间从新到旧排列
+func (q *deadLetterQueue) Recent(n int) []DeadLetter {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if n <= 0 || n > len(q.recent) {
+		n = len(q.recent)
+	}
+	ret := make([]DeadLetter, 0, n)
+	for i := 1; i <= n; i++ {
+		idx := (q.next - i + len(q.recent)) % len(q.recent)
+		ret = append(ret, q.recent[idx])
+	}
+	return ret
+}
+
+// Close 写完已经加入的数据后关闭writer或sender
+func (q *deadLetterQueue) Close() error {
+	q.mux.Lock()
+	if q.closed {
+		q.mux.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.queue)
+	q.mux.Unlock()
+	<-q.done
+	if q.writer != nil {
+		return q.writer.Close()
+	}
+	if q.sender != nil {
+		return q.sender.Close()
+	}
+	return nil
+}
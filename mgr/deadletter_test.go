@@ -0,0 +1,158 @@
+package mgr
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/transforms"
+	"github.com/qiniu/logkit/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DeadLetterQueue(t *testing.T) {
+	dir := "Test_DeadLetterQueue"
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deadletter")
+	q, err := newDeadLetterQueue(conf.MapConf{
+		KeyDeadLetterPath: path,
+		KeyDeadLetterKeep: "3",
+	}, sender.NewSenderRegistry())
+	assert.NoError(t, err)
+	now := time.Now()
+	q.Add([]DeadLetter{
+		{Raw: "l1", Parser: "p", Error: "e1", Source: "f", Offset: 3, Time: now},
+		{Raw: "l2", Parser: "p", Error: "e2", Source: "f", Offset: 6, Time: now},
+	})
+	q.Add([]DeadLetter{
+		{Raw: "l3", Parser: "p", Error: "e3", Source: "f", Offset: 9, Time: now},
+		{Raw: "l4", Parser: "p", Error: "e4", Source: "f", Offset: 12, Time: now},
+	})
+	var raws []string
+	for _, l := range q.Recent(10) {
+		raws = append(raws, l.Raw)
+	}
+	assert.Equal(t, []string{"l4", "l3", "l2"}, raws)
+	assert.Len(t, q.Recent(1), 1)
+	assert.NoError(t, q.Close())
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var l DeadLetter
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &l))
+		letters = append(letters, l)
+	}
+	assert.Len(t, letters, 4)
+	assert.Equal(t, "l1", letters[0].Raw)
+	assert.Equal(t, "e4", letters[3].Error)
+	assert.Equal(t, int64(12), letters[3].Offset)
+}
+
+// serialSender 记录是否有并发的Send
+type serialSender struct {
+	inflight int32
+	overlap  int32
+	datas    int32
+}
+
+func (s *serialSender) Name() string { return "serial" }
+func (s *serialSender) Send(datas []sender.Data) error {
+	if atomic.AddInt32(&s.inflight, 1) > 1 {
+		atomic.StoreInt32(&s.overlap, 1)
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&s.datas, int32(len(datas)))
+	atomic.AddInt32(&s.inflight, -1)
+	return nil
+}
+func (s *serialSender) Close() error { return nil }
+
+func Test_DeadLetterQueueConcurrentAdd(t *testing.T) {
+	s := &serialSender{}
+	q := &deadLetterQueue{sender: s, keep: 10}
+	q.start(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.Add([]DeadLetter{{Raw: "l", Error: "e", Time: time.Now()}})
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, q.Close())
+	assert.Equal(t, int32(0), s.overlap)
+	assert.Equal(t, int32(10), s.datas)
+	assert.Equal(t, int64(0), q.Dropped())
+}
+
+// blockingSender 在unblock关闭前阻塞Send
+type blockingSender struct {
+	entered chan struct{}
+	unblock chan struct{}
+	datas   int32
+}
+
+func (s *blockingSender) Name() string { return "blocking" }
+func (s *blockingSender) Send(datas []sender.Data) error {
+	s.entered <- struct{}{}
+	<-s.unblock
+	atomic.AddInt32(&s.datas, int32(len(datas)))
+	return nil
+}
+func (s *blockingSender) Close() error { return nil }
+
+func Test_DeadLetterQueueDrop(t *testing.T) {
+	s := &blockingSender{entered: make(chan struct{}, 10), unblock: make(chan struct{})}
+	q := &deadLetterQueue{sender: s, keep: 10}
+	q.start(1)
+	q.Add([]DeadLetter{{Raw: "l1"}})
+	<-s.entered
+	// 写入阻塞时Add不会阻塞，queue满了之后丢弃
+	q.Add([]DeadLetter{{Raw: "l2"}})
+	q.Add([]DeadLetter{{Raw: "l3"}, {Raw: "l4"}})
+	assert.Equal(t, int64(2), q.Dropped())
+	assert.Len(t, q.Recent(10), 4)
+	close(s.unblock)
+	assert.NoError(t, q.Close())
+	assert.Equal(t, int32(2), s.datas)
+	q.Add([]DeadLetter{{Raw: "l5"}})
+	assert.Equal(t, int64(2), q.Dropped())
+}
+
+func Test_RunnerDeadLetters(t *testing.T) {
+	dir := "Test_RunnerDeadLetters"
+	defer os.RemoveAll(dir)
+	ps, err := parser.NewJsonParser(conf.MapConf{parser.KeyParserName: "json_parser"})
+	assert.NoError(t, err)
+	q, err := newDeadLetterQueue(conf.MapConf{KeyDeadLetterPath: filepath.Join(dir, "deadletter")}, sender.NewSenderRegistry())
+	assert.NoError(t, err)
+	mask, err := transforms.NewMaskTransformer(conf.MapConf{transforms.KeyMaskDetectors: transforms.DetectorPhone})
+	assert.NoError(t, err)
+	r := &LogExportRunner{parser: ps, deadLetter: q, transformers: []transforms.Transformer{mask}}
+	lines := []string{`{"a":1}`, `not json 13812345678`, `{"a":2}`}
+	_, err = ps.Parse(lines)
+	se, ok := err.(*utils.StatsError)
+	assert.True(t, ok)
+	r.addDeadLetters(lines, []lineOffset{{"f", 8}, {"f", 17}, {"f", 25}}, se)
+	letters := r.DeadLetters(10)
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "not json ***********", letters[0].Raw)
+	assert.Equal(t, "json_parser", letters[0].Parser)
+	assert.Equal(t, "f", letters[0].Source)
+	assert.Equal(t, int64(17), letters[0].Offset)
+	assert.NotEmpty(t, letters[0].Error)
+	q.Close()
+}
@@ -0,0 +1,242 @@
+package mgr
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+// 每个sender有独立的缓存与发送goroutine，一个sender故障时不会阻塞其他sender。
+// 一个batch被所有sender确认后才算处理完成，读取位置按最慢的sender推进，不同策略确认的时机不同：
+//  - block: 发送成功，或者达到重试次数丢弃后确认。缓存满时等待，读取会随之停止
+//  - disk: sender带有磁盘队列，写入磁盘队列后即确认
+//  - drop: 放入缓存时即确认，缓存满时直接丢弃该sender的数据
+
+// senderPolicy sender无法发送时runner的处理策略
+type senderPolicy struct {
+	policy           string
+	bufferSize       int
+	backoff          utils.Backoff
+	breakerThreshold int
+	breakerProbe     time.Duration
+}
+
+var defaultSenderPolicy = senderPolicy{
+	policy:     sender.PolicyBlock,
+	bufferSize: sender.DefaultBufferSize,
+	backoff: utils.Backoff{
+		Initial:    sender.DefaultRetryInterval,
+		Max:        sender.DefaultRetryMaxInterval,
+		Multiplier: sender.DefaultRetryMultiplier,
+		Jitter:     sender.DefaultRetryJitter,
+	},
+	breakerThreshold: sender.DefaultBreakerThreshold,
+	breakerProbe:     sender.DefaultBreakerProbeInterval,
+}
+
+func newSenderPolicy(c conf.MapConf) (p senderPolicy, err error) {
+	p.policy, _ = c.GetStringOr(sender.KeyPolicy, sender.PolicyBlock)
+	switch p.policy {
+	case sender.PolicyBlock, sender.PolicyDisk, sender.PolicyDrop:
+	default:
+		return p, fmt.Errorf("policy %v not supported, must be one of %v, %v and %v", p.policy, sender.PolicyBlock, sender.PolicyDisk, sender.PolicyDrop)
+	}
+	p.bufferSize, _ = c.GetIntOr(sender.KeyBufferSize, sender.DefaultBufferSize)
+	if p.bufferSize <= 0 {
+		return p, fmt.Errorf("%v must be positive", sender.KeyBufferSize)
+	}
+	d := defaultSenderPolicy
+	if p.backoff.Initial, err = getDuration(c, sender.KeyRetryInterval, d.backoff.Initial); err != nil {
+		return p, err
+	}
+	if p.backoff.Max, err = getDuration(c, sender.KeyRetryMaxInterval, d.backoff.Max); err != nil {
+		return p, err
+	}
+	if p.backoff.Max < p.backoff.Initial {
+		return p, fmt.Errorf("%v must not be less than %v", sender.KeyRetryMaxInterval, sender.KeyRetryInterval)
+	}
+	p.backoff.Multiplier, _ = c.GetFloatOr(sender.KeyRetryMultiplier, d.backoff.Multiplier)
+	if p.backoff.Multiplier < 1 {
+		return p, fmt.Errorf("%v must not be less than 1", sender.KeyRetryMultiplier)
+	}
+	p.backoff.Jitter, _ = c.GetFloatOr(sender.KeyRetryJitter, d.backoff.Jitter)
+	if p.backoff.Jitter < 0 || p.backoff.Jitter > 1 {
+		return p, fmt.Errorf("%v must be between 0 and 1", sender.KeyRetryJitter)
+	}
+	p.breakerThreshold, _ = c.GetIntOr(sender.KeyBreakerThreshold, d.breakerThreshold)
+	if p.breakerProbe, err = getDuration(c, sender.KeyBreakerProbeInterval, d.breakerProbe); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// getDuration 读取"500ms"、"1m"这样的时间配置
+func getDuration(c conf.MapConf, key string, deft time.Duration) (time.Duration, error) {
+	v, _ := c.GetStringOr(key, "")
+	if v == "" {
+		return deft, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %v", key, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%v must not be negative", key)
+	}
+	return d, nil
+}
+
+// delivery 交给一个sender的batch
+type delivery struct {
+	seq   int64
+	datas []sender.Data
+}
+
+// senderWorker 一个sender的缓存
+type senderWorker struct {
+	sender  sender.Sender
+	policy  senderPolicy
+	queue   chan delivery
+	breaker *utils.CircuitBreaker
+	metrics *SenderMetrics
+}
+
+func (w *senderWorker) ackOnSend() bool {
+	return w.policy.policy != sender.PolicyDrop
+}
+
+func (w *senderWorker) setBuffered() {
+	if w.metrics != nil {
+		w.metrics.Buffered.Set(float64(len(w.queue)))
+	}
+}
+
+// ackTracker 记录每个batch还有多少个sender没有确认，所有sender都确认后按序号连续地推进
+type ackTracker struct {
+	mux     sync.Mutex
+	pending map[int64]int
+	last    int64
+	onAck   func(seq int64)
+}
+
+func newAckTracker(onAck func(seq int64)) *ackTracker {
+	return &ackTracker{pending: make(map[int64]int), onAck: onAck}
+}
+
+// add 登记一个batch需要确认的次数，必须按序号依次调用
+func (t *ackTracker) add(seq int64, n int) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.pending[seq] = n
+	t.advance()
+}
+
+func (t *ackTracker) ack(seq int64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.pending[seq]--
+	t.advance()
+}
+
+func (t *ackTracker) advance() {
+	last := t.last
+	for {
+		n, ok := t.pending[t.last+1]
+		if !ok || n > 0 {
+			break
+		}
+		delete(t.pending, t.last+1)
+		t.last++
+	}
+	if t.last > last {
+		t.onAck(t.last)
+	}
+}
+
+// newSenderWorkers 每次Run时创建，缓存不在多次Run之间复用
+func (r *LogExportRunner) newSenderWorkers() []*senderWorker {
+	workers := make([]*senderWorker, 0, len(r.senders))
+	for i, s := range r.senders {
+		p := defaultSenderPolicy
+		if i < len(r.policies) {
+			p = r.policies[i]
+		}
+		workers = append(workers, &senderWorker{
+			sender:  s,
+			policy:  p,
+			queue:   make(chan delivery, p.bufferSize),
+			breaker: utils.NewCircuitBreaker(p.breakerThreshold, p.breakerProbe),
+			metrics: r.metrics.Senders[s.Name()],
+		})
+	}
+	return workers
+}
+
+// dispatch 把处理好的batch交给每个sender，block和disk策略的缓存满时等待
+func (r *LogExportRunner) dispatch(workers []*senderWorker, acks *ackTracker, seq int64, datas []sender.Data) {
+	routed := make([][]sender.Data, len(workers))
+	n := 0
+	for i, w := range workers {
+		routed[i] = r.route(i, datas)
+		if len(routed[i]) > 0 && w.ackOnSend() {
+			n++
+		}
+	}
+	// 先登记再放入缓存，避免sender在登记之前确认
+	acks.add(seq, n)
+	for i, w := range workers {
+		if len(routed[i]) <= 0 {
+			continue
+		}
+		d := delivery{seq: seq, datas: routed[i]}
+		if w.ackOnSend() {
+			w.queue <- d
+		} else {
+			select {
+			case w.queue <- d:
+			default:
+				r.drop(w, d.datas)
+			}
+		}
+		w.setBuffered()
+	}
+}
+
+// drop 缓存已满时丢弃数据，计入该sender的失败数
+func (r *LogExportRunner) drop(w *senderWorker, datas []sender.Data) {
+	name := w.sender.Name()
+	log.Warnf("runner %s, sender %s buffer is full, drop %v datas", r.Name(), name, len(datas))
+	r.statsMux.Lock()
+	info := r.rs.SenderStats[name]
+	info.Errors += int64(len(datas))
+	r.rs.SenderStats[name] = info
+	r.statsMux.Unlock()
+	if w.metrics != nil {
+		w.metrics.Dropped.Add(int64(len(datas)))
+		w.metrics.Errors.Add(int64(len(datas)))
+	}
+}
+
+// deliver 依次发送一个sender缓存中的batch，直到缓存被关闭
+func (r *LogExportRunner) deliver(w *senderWorker, acks *ackTracker) {
+	for d := range w.queue {
+		w.setBuffered()
+		// runner已经退出，剩余的batch不再发送，也不确认
+		if atomic.LoadInt32(&r.stopped) > 0 {
+			continue
+		}
+		if !r.trySend(w, d.datas) {
+			continue
+		}
+		r.speed.observeDelay(d.datas, time.Now())
+		if w.ackOnSend() {
+			acks.ack(d.seq)
+		}
+	}
+}
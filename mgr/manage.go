@@ -0,0 +1,322 @@
+package mgr
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qiniu/log"
+	config "github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/utils"
+)
+
+// 通过REST接口创建的runner配置默认保存的目录
+const defaultRestDir = "./.logkitconfs"
+
+// 被停止的runner配置文件会加上该后缀，logkit重启后不会再启动
+const stoppedConfSuffix = ".stopped"
+
+var (
+	ErrRunnerNotFound   = errors.New("runner not found")
+	ErrRunnerExist      = errors.New("runner already exist")
+	ErrRunnerNotManaged = errors.New("runner is loaded from confs_path, please modify its conf file instead")
+)
+
+// restConfDir 返回通过REST接口管理的runner配置目录的绝对路径
+func (m *Manager) restConfDir() (string, error) {
+	dir := m.RestDir
+	if dir == "" {
+		dir = defaultRestDir
+	}
+	return filepath.Abs(dir)
+}
+
+func (m *Manager) restConfPath(name string) (string, error) {
+	dir, err := m.restConfDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".conf"), nil
+}
+
+func fileExist(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// runningConfPath 返回正在运行的名为name的runner的配置文件路径
+func (m *Manager) runningConfPath(name string) (string, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for confPath, r := range m.runners {
+		if r.Name() == name {
+			return confPath, true
+		}
+	}
+	return "", false
+}
+
+// loadRestConfs 启动时加载通过REST接口创建的runner，被停止的runner不会启动
+func (m *Manager) loadRestConfs() {
+	dir, err := m.restConfDir()
+	if err != nil {
+		log.Errorf("get rest conf dir error %v", err)
+		return
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("read rest conf dir %v error %v", dir, err)
+		}
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".conf") {
+			continue
+		}
+		m.Add(filepath.Join(dir, f.Name()))
+	}
+}
+
+func writeRunnerConfig(path string, rc RunnerConfig) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(rc, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// startRestRunner 同步创建并启动通过REST接口管理的runner，创建失败时返回*ValidationError
+func (m *Manager) startRestRunner(path string, rc RunnerConfig) error {
+	err := m.addSync(path, rc)
+	if err == nil || err == ErrRunnerExist {
+		return err
+	}
+	return &ValidationError{Fields: []FieldError{{Field: "runner", Error: err.Error()}}}
+}
+
+// AddRunner 校验runner配置，保存到rest_dir目录后启动，runner创建失败时删除保存的配置并返回错误
+func (m *Manager) AddRunner(rc RunnerConfig) error {
+	if err := m.ValidateRunnerConfig(rc); err != nil {
+		return err
+	}
+	m.restLock.Lock()
+	defer m.restLock.Unlock()
+	path, err := m.restConfPath(rc.RunnerName)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.runningConfPath(rc.RunnerName); ok || m.isStopped(rc.RunnerName) {
+		return ErrRunnerExist
+	}
+	if fileExist(path) || fileExist(path+stoppedConfSuffix) {
+		return ErrRunnerExist
+	}
+	if err = writeRunnerConfig(path, rc); err != nil {
+		return err
+	}
+	if err = m.startRestRunner(path, rc); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// UpdateRunner 更新通过REST接口创建的runner的配置，正在运行的runner会使用新的配置重启
+func (m *Manager) UpdateRunner(name string, rc RunnerConfig) error {
+	if rc.RunnerName == "" {
+		rc.RunnerName = name
+	}
+	if rc.RunnerName != name {
+		return &ValidationError{Fields: []FieldError{{Field: "name", Error: "runner name can not be changed"}}}
+	}
+	if err := m.ValidateRunnerConfig(rc); err != nil {
+		return err
+	}
+	m.restLock.Lock()
+	defer m.restLock.Unlock()
+	path, err := m.restConfPath(name)
+	if err != nil {
+		return err
+	}
+	if !fileExist(path) && !fileExist(path+stoppedConfSuffix) {
+		if _, ok := m.runningConfPath(name); ok || m.isStopped(name) {
+			return ErrRunnerNotManaged
+		}
+		return ErrRunnerNotFound
+	}
+	if fileExist(path + stoppedConfSuffix) {
+		return writeRunnerConfig(path+stoppedConfSuffix, rc)
+	}
+	var old RunnerConfig
+	if err = config.LoadEx(&old, path); err != nil {
+		return err
+	}
+	if confPath, ok := m.runningConfPath(name); ok {
+		m.Remove(confPath)
+	}
+	if err = writeRunnerConfig(path, rc); err != nil {
+		return err
+	}
+	if err = m.startRestRunner(path, rc); err != nil {
+		// 新配置无法创建runner时恢复原来的配置
+		if werr := writeRunnerConfig(path, old); werr != nil {
+			log.Errorf("restore runner %v config error %v", name, werr)
+			return err
+		}
+		m.Add(path)
+		return err
+	}
+	return nil
+}
+
+// DeleteRunner 停止并删除通过REST接口创建的runner
+func (m *Manager) DeleteRunner(name string) error {
+	m.restLock.Lock()
+	defer m.restLock.Unlock()
+	path, err := m.restConfPath(name)
+	if err != nil {
+		return err
+	}
+	if !fileExist(path) && !fileExist(path+stoppedConfSuffix) {
+		if _, ok := m.runningConfPath(name); ok || m.isStopped(name) {
+			return ErrRunnerNotManaged
+		}
+		return ErrRunnerNotFound
+	}
+	if confPath, ok := m.runningConfPath(name); ok {
+		m.Remove(confPath)
+	}
+	for _, p := range []string{path, path + stoppedConfSuffix} {
+		if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// StopRunner 停止runner。通过REST接口创建的runner重启logkit后仍然保持停止，
+// confs_path中的runner只在本次运行期间停止，修改配置文件或重启logkit后会重新启动
+func (m *Manager) StopRunner(name string) error {
+	m.restLock.Lock()
+	defer m.restLock.Unlock()
+	confPath, ok := m.runningConfPath(name)
+	if !ok {
+		if m.isStopped(name) {
+			return nil
+		}
+		path, err := m.restConfPath(name)
+		if err != nil {
+			return err
+		}
+		if fileExist(path + stoppedConfSuffix) {
+			return nil
+		}
+		return ErrRunnerNotFound
+	}
+	m.Remove(confPath)
+	path, err := m.restConfPath(name)
+	if err != nil {
+		return err
+	}
+	if realPath, _, err := utils.GetRealPath(path); err == nil && realPath == confPath {
+		return os.Rename(path, path+stoppedConfSuffix)
+	}
+	m.lock.Lock()
+	m.stopped[name] = confPath
+	m.lock.Unlock()
+	return nil
+}
+
+// StartRunner 启动被停止的runner，runner已经在运行时不做任何操作
+func (m *Manager) StartRunner(name string) error {
+	m.restLock.Lock()
+	defer m.restLock.Unlock()
+	return m.startRunner(name)
+}
+
+func (m *Manager) startRunner(name string) error {
+	if _, ok := m.runningConfPath(name); ok {
+		return nil
+	}
+	path, err := m.restConfPath(name)
+	if err != nil {
+		return err
+	}
+	if fileExist(path + stoppedConfSuffix) {
+		if err = os.Rename(path+stoppedConfSuffix, path); err != nil {
+			return err
+		}
+		m.Add(path)
+		return nil
+	}
+	m.lock.Lock()
+	confPath, ok := m.stopped[name]
+	delete(m.stopped, name)
+	m.lock.Unlock()
+	if ok {
+		m.Add(confPath)
+		return nil
+	}
+	if fileExist(path) {
+		// 配置存在但runner还在创建中
+		m.Add(path)
+		return nil
+	}
+	return ErrRunnerNotFound
+}
+
+// RestartRunner 重启runner，runner被停止时直接启动
+func (m *Manager) RestartRunner(name string) error {
+	m.restLock.Lock()
+	defer m.restLock.Unlock()
+	confPath, ok := m.runningConfPath(name)
+	if !ok {
+		return m.startRunner(name)
+	}
+	m.Remove(confPath)
+	m.Add(confPath)
+	return nil
+}
+
+// GetRunnerConfig 返回runner的配置，包括被停止的runner
+func (m *Manager) GetRunnerConfig(name string) (rc RunnerConfig, err error) {
+	confPath, ok := m.runningConfPath(name)
+	if !ok {
+		m.lock.RLock()
+		confPath, ok = m.stopped[name]
+		m.lock.RUnlock()
+	}
+	if !ok {
+		path, err := m.restConfPath(name)
+		if err != nil {
+			return rc, err
+		}
+		if fileExist(path) {
+			confPath = path
+		} else if fileExist(path + stoppedConfSuffix) {
+			confPath = path + stoppedConfSuffix
+		} else {
+			return rc, ErrRunnerNotFound
+		}
+	}
+	err = config.LoadEx(&rc, confPath)
+	return
+}
+
+func (m *Manager) isStopped(name string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	_, ok := m.stopped[name]
+	return ok
+}
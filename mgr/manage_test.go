@@ -0,0 +1,124 @@
+package mgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func waitRunning(m *Manager, name string, running bool) bool {
+	for i := 0; i < 50; i++ {
+		if _, ok := m.runningConfPath(name); ok == running {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+func Test_ManageRunner(t *testing.T) {
+	dir, err := filepath.Abs("Test_ManageRunner")
+	assert.NoError(t, err)
+	logpath := filepath.Join(dir, "logdir")
+	restDir := filepath.Join(dir, "confs")
+	assert.NoError(t, os.MkdirAll(logpath, 0755))
+	defer os.RemoveAll(dir)
+
+	m, err := NewManager(ManagerConfig{RestDir: restDir})
+	assert.NoError(t, err)
+	defer m.Stop()
+
+	rc := RunnerConfig{
+		RunnerInfo: RunnerInfo{RunnerName: "managed", MaxBatchLen: 1},
+		ReaderConfig: conf.MapConf{
+			"log_path":  logpath,
+			"meta_path": filepath.Join(dir, "meta"),
+			"mode":      "dir",
+		},
+		ParserConf: conf.MapConf{"name": "p", "type": "notexist"},
+		Filter:     "a ==",
+	}
+	err = m.AddRunner(rc)
+	ve, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	var fields []string
+	for _, f := range ve.Fields {
+		fields = append(fields, f.Field)
+	}
+	assert.Equal(t, []string{"parser", "filter", "senders"}, fields)
+
+	rc.ParserConf = conf.MapConf{"name": "p", "type": "raw"}
+	rc.Filter = ""
+	rc.SenderConfig = []conf.MapConf{{"name": "s", "sender_type": "discard"}}
+	rc.ReaderConfig["log_path"] = filepath.Join(dir, "notexist")
+	err = m.AddRunner(rc)
+	ve, ok = err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, "runner", ve.Fields[0].Field)
+	_, err = os.Stat(filepath.Join(restDir, "managed.conf"))
+	assert.True(t, os.IsNotExist(err))
+
+	rc.ReaderConfig["log_path"] = logpath
+	assert.NoError(t, m.AddRunner(rc))
+	_, ok = m.runningConfPath("managed")
+	assert.True(t, ok)
+	assert.Equal(t, ErrRunnerExist, m.AddRunner(rc))
+	_, err = os.Stat(filepath.Join(restDir, "managed.conf"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.StopRunner("managed"))
+	assert.True(t, waitRunning(m, "managed", false))
+	_, err = os.Stat(filepath.Join(restDir, "managed.conf.stopped"))
+	assert.NoError(t, err)
+	assert.NoError(t, m.StopRunner("managed"))
+
+	rc.MaxBatchLen = 10
+	assert.NoError(t, m.UpdateRunner("managed", rc))
+	assert.NoError(t, m.StartRunner("managed"))
+	rc.ReaderConfig["log_path"] = filepath.Join(dir, "notexist")
+	_, ok = m.UpdateRunner("managed", rc).(*ValidationError)
+	assert.True(t, ok)
+	assert.True(t, waitRunning(m, "managed", true))
+	rc.ReaderConfig["log_path"] = logpath
+	assert.NoError(t, m.StopRunner("managed"))
+	assert.True(t, waitRunning(m, "managed", false))
+	got, err := m.GetRunnerConfig("managed")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, got.MaxBatchLen)
+
+	assert.NoError(t, m.StartRunner("managed"))
+	assert.True(t, waitRunning(m, "managed", true))
+	assert.NoError(t, m.RestartRunner("managed"))
+	assert.True(t, waitRunning(m, "managed", true))
+
+	assert.NoError(t, m.DeleteRunner("managed"))
+	assert.True(t, waitRunning(m, "managed", false))
+	_, err = os.Stat(filepath.Join(restDir, "managed.conf"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, ErrRunnerNotFound, m.DeleteRunner("managed"))
+	assert.Equal(t, ErrRunnerNotFound, m.StartRunner("managed"))
+
+	rs := &RestService{mgr: m}
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/logkit/runners/other", bytes.NewBufferString(`{"name":"other","reader":{"mode":"dir"}}`))
+	req.Header["*"] = []string{"other"}
+	rs.PostRunner(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	ve = &ValidationError{}
+	assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), ve))
+	assert.Equal(t, "reader.log_path", ve.Fields[0].Field)
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/logkit/runners/other/stop", nil)
+	req.Header["*"] = []string{"other"}
+	rs.PostRunnerStop(rw, req)
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
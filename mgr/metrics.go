@@ -0,0 +1,149 @@
+package mgr
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+var startTime = time.Now()
+
+// RunnerMetrics runner的监控指标，计数在处理数据时原子地累加，/metrics 接口直接读取
+type RunnerMetrics struct {
+	ReadLines       utils.Counter
+	ReadBytes       utils.Counter
+	ParseSuccess    utils.Counter
+	ParseErrors     utils.Counter
+	LagBytes        utils.Gauge
+	LagFiles        utils.Gauge
+	LagRecords      utils.Gauge               // 非文件类reader的延迟，单位为消息、行或文档
+	BatchFill       utils.Gauge               // 上一个batch达到batch_len或batch_size的比例
+	DeadLetterDrops utils.Counter             // dead letter写入跟不上时丢弃的条数
+	Senders         map[string]*SenderMetrics // 创建runner时初始化，之后不再修改
+}
+
+// SenderMetrics 每个sender的监控指标
+type SenderMetrics struct {
+	Success      utils.Counter
+	Errors       utils.Counter
+	FtQueueDepth utils.Gauge
+	FtQueueBytes utils.Gauge
+	Buffered     utils.Gauge      // runner中等待该sender发送的batch数
+	Dropped      utils.Counter    // sender_policy为drop时因缓存已满丢弃的数据条数
+	BreakerOpen  utils.Gauge      // 熔断时为1
+	Latency      *utils.Histogram // 每次调用Send的耗时，单位秒
+}
+
+func newRunnerMetrics(senders []sender.Sender) *RunnerMetrics {
+	rm := &RunnerMetrics{Senders: make(map[string]*SenderMetrics)}
+	for _, s := range senders {
+		rm.Senders[s.Name()] = &SenderMetrics{Latency: utils.NewHistogram(utils.DefaultLatencyBuckets)}
+	}
+	return rm
+}
+
+func (rm *RunnerMetrics) setLag(rl RunnerLag) {
+	if rl.Unit == reader.LagUnitBytes {
+		rm.LagBytes.Set(float64(rl.Size))
+		rm.LagFiles.Set(float64(rl.Files))
+		return
+	}
+	rm.LagRecords.Set(float64(rl.Size))
+}
+
+type runnerMetricsEntry struct {
+	name    string
+	metrics *RunnerMetrics
+}
+
+type senderMetricsEntry struct {
+	runner  string
+	sender  string
+	metrics *SenderMetrics
+}
+
+// WriteMetrics 以 Prometheus 文本格式输出logkit以及所有runner的监控指标
+func (m *Manager) WriteMetrics(w io.Writer) {
+	m.lock.RLock()
+	var runners []runnerMetricsEntry
+	for _, r := range m.runners {
+		if mr, ok := r.(interface {
+			Metrics() *RunnerMetrics
+		}); ok {
+			runners = append(runners, runnerMetricsEntry{name: r.Name(), metrics: mr.Metrics()})
+		}
+	}
+	total := len(m.runners)
+	m.lock.RUnlock()
+	sort.Slice(runners, func(i, j int) bool {
+		return runners[i].name < runners[j].name
+	})
+	var senders []senderMetricsEntry
+	for _, r := range runners {
+		names := make([]string, 0, len(r.metrics.Senders))
+		for name := range r.metrics.Senders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			senders = append(senders, senderMetricsEntry{runner: r.name, sender: name, metrics: r.metrics.Senders[name]})
+		}
+	}
+
+	utils.WriteMetricHeader(w, "logkit_runners", "Number of running runners.", "gauge")
+	utils.WriteMetric(w, "logkit_runners", nil, float64(total))
+	utils.WriteMetricHeader(w, "logkit_start_time_seconds", "Start time of logkit since unix epoch in seconds.", "gauge")
+	utils.WriteMetric(w, "logkit_start_time_seconds", nil, float64(startTime.Unix()))
+
+	runnerFamilies := []struct {
+		name, help, typ string
+		value           func(*RunnerMetrics) float64
+	}{
+		{"logkit_runner_read_lines_total", "Lines read by the runner.", "counter", func(rm *RunnerMetrics) float64 { return float64(rm.ReadLines.Value()) }},
+		{"logkit_runner_read_bytes_total", "Bytes read by the runner.", "counter", func(rm *RunnerMetrics) float64 { return float64(rm.ReadBytes.Value()) }},
+		{"logkit_runner_parse_success_total", "Lines parsed successfully.", "counter", func(rm *RunnerMetrics) float64 { return float64(rm.ParseSuccess.Value()) }},
+		{"logkit_runner_parse_errors_total", "Lines failed to parse.", "counter", func(rm *RunnerMetrics) float64 { return float64(rm.ParseErrors.Value()) }},
+		{"logkit_runner_lag_bytes", "Bytes not read yet.", "gauge", func(rm *RunnerMetrics) float64 { return rm.LagBytes.Value() }},
+		{"logkit_runner_lag_files", "Files not read yet.", "gauge", func(rm *RunnerMetrics) float64 { return rm.LagFiles.Value() }},
+		{"logkit_runner_lag_records", "Messages, rows or documents not read yet by non-file readers.", "gauge", func(rm *RunnerMetrics) float64 { return rm.LagRecords.Value() }},
+		{"logkit_runner_dead_letter_dropped_total", "Dead letters dropped because writing them could not keep up.", "counter", func(rm *RunnerMetrics) float64 { return float64(rm.DeadLetterDrops.Value()) }},
+		{"logkit_runner_batch_fill_ratio", "How full the last batch was compared to batch_len or batch_size.", "gauge", func(rm *RunnerMetrics) float64 { return rm.BatchFill.Value() }},
+	}
+	for _, f := range runnerFamilies {
+		utils.WriteMetricHeader(w, f.name, f.help, f.typ)
+		for _, r := range runners {
+			utils.WriteMetric(w, f.name, []utils.MetricLabel{{Name: "runner", Value: r.name}}, f.value(r.metrics))
+		}
+	}
+
+	senderFamilies := []struct {
+		name, help, typ string
+		value           func(*SenderMetrics) float64
+	}{
+		{"logkit_sender_success_total", "Datas sent successfully.", "counter", func(sm *SenderMetrics) float64 { return float64(sm.Success.Value()) }},
+		{"logkit_sender_errors_total", "Datas failed to send.", "counter", func(sm *SenderMetrics) float64 { return float64(sm.Errors.Value()) }},
+		{"logkit_sender_ft_queue_depth", "Batches in the fault tolerant queue.", "gauge", func(sm *SenderMetrics) float64 { return sm.FtQueueDepth.Value() }},
+		{"logkit_sender_ft_queue_bytes", "Bytes in the fault tolerant queue.", "gauge", func(sm *SenderMetrics) float64 { return sm.FtQueueBytes.Value() }},
+		{"logkit_sender_buffered_batches", "Batches waiting in the runner for this sender.", "gauge", func(sm *SenderMetrics) float64 { return sm.Buffered.Value() }},
+		{"logkit_sender_breaker_open", "Whether the circuit breaker of the sender is open.", "gauge", func(sm *SenderMetrics) float64 { return sm.BreakerOpen.Value() }},
+		{"logkit_sender_dropped_total", "Datas dropped because the sender buffer was full.", "counter", func(sm *SenderMetrics) float64 { return float64(sm.Dropped.Value()) }},
+	}
+	for _, f := range senderFamilies {
+		utils.WriteMetricHeader(w, f.name, f.help, f.typ)
+		for _, s := range senders {
+			utils.WriteMetric(w, f.name, senderLabels(s), f.value(s.metrics))
+		}
+	}
+	utils.WriteMetricHeader(w, "logkit_sender_send_duration_seconds", "Latency of each send call.", "histogram")
+	for _, s := range senders {
+		utils.WriteHistogram(w, "logkit_sender_send_duration_seconds", senderLabels(s), s.metrics.Latency)
+	}
+}
+
+func senderLabels(s senderMetricsEntry) []utils.MetricLabel {
+	return []utils.MetricLabel{{Name: "runner", Value: s.runner}, {Name: "sender", Value: s.sender}}
+}
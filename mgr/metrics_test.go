@@ -0,0 +1,57 @@
+package mgr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/logkit/sender"
+	"github.com/stretchr/testify/assert"
+)
+
+type metricsRunner struct {
+	Runner
+	name    string
+	metrics *RunnerMetrics
+}
+
+func (r *metricsRunner) Name() string {
+	return r.name
+}
+
+func (r *metricsRunner) Metrics() *RunnerMetrics {
+	return r.metrics
+}
+
+func Test_WriteMetrics(t *testing.T) {
+	m, err := NewManager(ManagerConfig{})
+	assert.NoError(t, err)
+	s, err := sender.NewDiscardSender(nil)
+	assert.NoError(t, err)
+	rm := newRunnerMetrics([]sender.Sender{s})
+	rm.ReadLines.Add(10)
+	rm.ParseErrors.Inc()
+	rm.BatchFill.Set(0.5)
+	sm := rm.Senders[s.Name()]
+	sm.Success.Add(9)
+	sm.Latency.Observe(0.02)
+	m.runners["r1.conf"] = &metricsRunner{name: "r1", metrics: rm}
+
+	buf := &bytes.Buffer{}
+	m.WriteMetrics(buf)
+	out := buf.String()
+	for _, line := range []string{
+		"logkit_runners 1",
+		`logkit_runner_read_lines_total{runner="r1"} 10`,
+		`logkit_runner_parse_errors_total{runner="r1"} 1`,
+		`logkit_runner_batch_fill_ratio{runner="r1"} 0.5`,
+		`logkit_sender_success_total{runner="r1",sender="` + s.Name() + `"} 9`,
+		`logkit_sender_send_duration_seconds_bucket{runner="r1",sender="` + s.Name() + `",le="0.025"} 1`,
+		`logkit_sender_send_duration_seconds_count{runner="r1",sender="` + s.Name() + `"} 1`,
+		"# TYPE logkit_sender_send_duration_seconds histogram",
+	} {
+		assert.True(t, strings.Contains(out, line+"\n"), line)
+	}
+	// 同名指标的HELP只出现一次
+	assert.Equal(t, 1, strings.Count(out, "# HELP logkit_runner_read_lines_total "))
+}
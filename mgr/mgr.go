@@ -12,6 +12,7 @@ import (
 	config "github.com/qiniu/logkit/conf"
 	"github.com/qiniu/logkit/parser"
 	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/transforms"
 	"github.com/qiniu/logkit/utils"
 
 	"github.com/howeyc/fsnotify"
@@ -24,6 +25,7 @@ type ManagerConfig struct {
 	BindHost string `json:"bind_host"`
 	Idc      string `json:"idc"`
 	Zone     string `json:"zone"`
+	RestDir  string `json:"rest_dir"` // 通过REST接口创建的runner配置保存的目录，默认为 ./.logkitconfs
 }
 
 type cleanQueue struct {
@@ -34,12 +36,15 @@ type cleanQueue struct {
 type Manager struct {
 	ManagerConfig
 	lock        sync.RWMutex
+	restLock    sync.Mutex // 保证REST接口对runner的检查和修改是原子的
 	cleanlock   sync.Mutex
 	cleanChan   chan cleaner.CleanSignal
 	cleanQueues map[string]*cleanQueue
 	runners     map[string]Runner
+	stopped     map[string]string            // 通过REST接口停止的confs_path中的runner，名字到配置文件的映射
 	watchers    map[uint64]*fsnotify.Watcher // inode到watcher的映射表
 	pregistry   *parser.ParserRegistry
+	tregistry   *transforms.TransformerRegistry
 	sregistry   *sender.SenderRegistry
 }
 
@@ -50,13 +55,20 @@ func NewManager(conf ManagerConfig) (*Manager, error) {
 }
 
 func NewCustomManager(conf ManagerConfig, pr *parser.ParserRegistry, sr *sender.SenderRegistry) (*Manager, error) {
+	return NewCustomManagerWithTransforms(conf, pr, transforms.NewTransformerRegistry(), sr)
+}
+
+// NewCustomManagerWithTransforms 创建Manager，runner中的transforms使用tr中注册的transformer
+func NewCustomManagerWithTransforms(conf ManagerConfig, pr *parser.ParserRegistry, tr *transforms.TransformerRegistry, sr *sender.SenderRegistry) (*Manager, error) {
 	m := &Manager{
 		ManagerConfig: conf,
 		cleanChan:     make(chan cleaner.CleanSignal),
 		cleanQueues:   make(map[string]*cleanQueue),
 		runners:       make(map[string]Runner),
+		stopped:       make(map[string]string),
 		watchers:      make(map[uint64]*fsnotify.Watcher),
 		pregistry:     pr,
+		tregistry:     tr,
 		sregistry:     sr,
 	}
 	return m, nil
@@ -176,7 +188,7 @@ func (m *Manager) Add(confPath string) {
 				return
 			}
 
-			if runner, err = NewCustomRunner(conf, m.cleanChan, m.pregistry, m.sregistry); err != nil {
+			if runner, err = NewCustomRunnerWithTransforms(conf, m.cleanChan, m.pregistry, m.tregistry, m.sregistry); err != nil {
 				errVal, ok := err.(*os.PathError)
 				if !ok {
 					log.Errorf("NewRunner(%v) failed: %v", conf.RunnerName, err)
@@ -189,17 +201,7 @@ func (m *Manager) Add(confPath string) {
 			}
 			break
 		}
-		m.lock.Lock()
-		defer m.lock.Unlock()
-		// double check
-		if _, ok := m.runners[confPath]; ok {
-			return
-		}
-		m.addCleanQueue(runner.Cleaner())
-		log.Infof("%s added: %#v", conf.RunnerName, confPath)
-		go runner.Run()
-		m.runners[confPath] = runner
-		log.Infof("new runner %s is added, total %d", conf.RunnerName, len(m.runners))
+		m.register(confPath, conf.RunnerName, runner)
 		return
 	}
 
@@ -208,6 +210,40 @@ func (m *Manager) Add(confPath string) {
 
 	return
 }
+
+// addSync 同步创建并启动runner，创建失败时直接返回错误而不是在后台重试
+func (m *Manager) addSync(confPath string, conf RunnerConfig) error {
+	confPath, _, err := utils.GetRealPath(confPath)
+	if err != nil {
+		return err
+	}
+	runner, err := NewCustomRunnerWithTransforms(conf, m.cleanChan, m.pregistry, m.tregistry, m.sregistry)
+	if err != nil {
+		return err
+	}
+	if !m.register(confPath, conf.RunnerName, runner) {
+		runner.Stop()
+		return ErrRunnerExist
+	}
+	return nil
+}
+
+// register 将创建好的runner加入管理并启动，confPath对应的runner已经存在时返回false
+func (m *Manager) register(confPath, name string, runner Runner) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	// double check
+	if _, ok := m.runners[confPath]; ok {
+		return false
+	}
+	m.addCleanQueue(runner.Cleaner())
+	log.Infof("%s added: %#v", name, confPath)
+	go runner.Run()
+	m.runners[confPath] = runner
+	log.Infof("new runner %s is added, total %d", name, len(m.runners))
+	return true
+}
+
 func (m *Manager) isRunning(confPath string) bool {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
@@ -362,6 +398,7 @@ func (m *Manager) Watch(confsPath []string) (err error) {
 	if err != nil {
 		log.Errorf("addWatchers error : %v", err)
 	}
+	m.loadRestConfs()
 	go m.detectMoreWatchers(confsPath)
 	go m.clean()
 	return
@@ -374,3 +411,21 @@ func (m *Manager) Status() (rss map[string]RunnerStatus) {
 	}
 	return
 }
+
+// DeadLetters 返回名为name的runner最近n条解析失败的数据，runner不存在时返回false
+func (m *Manager) DeadLetters(name string, n int) ([]DeadLetter, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, r := range m.runners {
+		if r.Name() != name {
+			continue
+		}
+		if dr, ok := r.(interface {
+			DeadLetters(int) []DeadLetter
+		}); ok {
+			return dr.DeadLetters(n), true
+		}
+		return []DeadLetter{}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,271 @@
+package mgr
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+// runner 的数据处理分为三个阶段，之间通过有界的channel连接：
+//  1. 读取：Run 所在的goroutine按batch读取数据，同时负责同步读取位置
+//  2. 解析：parse_workers 个goroutine并发解析不同的batch
+//  3. 处理：一个goroutine按读取的顺序依次做transform、聚合，然后放入每个sender的缓存
+//  4. 发送：每个sender一个goroutine，依次发送自己缓存中的batch，见delivery.go
+// reader的SyncMeta只能同步它当前的读取位置，所以只有在读取的所有batch都被所有sender确认后才会同步。
+
+// 流水线一直有数据时，最多隔这么久等待一次所有batch处理完成，然后同步读取位置
+const metaSyncInterval = 10 * time.Second
+
+// batchJob 一个batch在流水线中的数据
+type batchJob struct {
+	seq     int64
+	lines   []string
+	froms   []string
+	offsets []lineOffset
+	datas   []sender.Data
+	parsed  chan struct{} // 解析完成后关闭
+}
+
+func (r *LogExportRunner) Run() {
+	if r.cleaner != nil {
+		go r.cleaner.Run()
+	}
+	defer close(r.exitChan)
+
+	workers := len(r.parsers)
+	jobs := make(chan *batchJob, workers)
+	ordered := make(chan *batchJob, 2*workers)
+	var wg sync.WaitGroup
+	for _, p := range r.parsers {
+		wg.Add(1)
+		go func(p parser.LogParser) {
+			defer wg.Done()
+			for job := range jobs {
+				r.parse(p, job)
+				close(job.parsed)
+			}
+		}(p)
+	}
+	acks := newAckTracker(func(seq int64) {
+		atomic.StoreInt64(&r.acked, seq)
+		select {
+		case r.ackNotify <- struct{}{}:
+		default:
+		}
+	})
+	senderWorkers := r.newSenderWorkers()
+	var sendWg sync.WaitGroup
+	for _, w := range senderWorkers {
+		sendWg.Add(1)
+		go func(w *senderWorker) {
+			defer sendWg.Done()
+			r.deliver(w, acks)
+		}(w)
+	}
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		r.process(ordered, senderWorkers, acks)
+	}()
+
+	seq := r.read(jobs, ordered)
+	close(jobs)
+	close(ordered)
+	wg.Wait()
+	<-processed
+	for _, w := range senderWorkers {
+		close(w.queue)
+	}
+	sendWg.Wait()
+	if atomic.LoadInt64(&r.acked) == seq {
+		r.syncMeta()
+	}
+	log.Debugf("runner %v exited from run", r.RunnerName)
+	r.exitChan <- struct{}{}
+}
+
+// read 按batch读取数据交给解析阶段，runner停止时返回最后一个batch的序号
+func (r *LogExportRunner) read(jobs, ordered chan<- *batchJob) (seq int64) {
+	datasourceTag := r.meta.GetDataSourceTag()
+	var synced int64
+	lastSync := time.Now()
+	// 已经读取的batch全部处理完成时同步读取位置，此时reader的位置正好是最后一个batch的结束处
+	syncAcked := func() bool {
+		if atomic.LoadInt64(&r.acked) != seq {
+			return false
+		}
+		if synced < seq {
+			r.syncMeta()
+			synced = seq
+		}
+		lastSync = time.Now()
+		return true
+	}
+	// 上一次读取时reader已经没有更多数据
+	idle := false
+	for {
+		if atomic.LoadInt32(&r.stopped) > 0 {
+			return
+		}
+		// 一直有batch在处理时读取位置无法同步，需要等待流水线中的batch全部处理完。
+		// 数据已经读完时继续读取也只会等待，所以直接等待处理完成后尽早同步
+		if !syncAcked() && (idle || time.Since(lastSync) >= metaSyncInterval) {
+			if !r.waitAcked(seq) {
+				return
+			}
+			continue
+		}
+
+		job := &batchJob{parsed: make(chan struct{})}
+		for !r.batchFullOrTimeout() {
+			line, err := r.reader.ReadLine()
+			idle = err == io.EOF || len(line) <= 0
+			if err != nil && err != io.EOF {
+				log.Warnf("runner %s, reader %s - error: %v", r.Name(), r.reader.Name(), err)
+				break
+			}
+			if len(line) <= 0 {
+				log.Debugf("runner %s, reader %s cannot get any content", r.Name(), r.reader.Name())
+				time.Sleep(2 * time.Second)
+				continue
+			}
+			if r.MaxBatchSize > 0 && len(line) >= r.MaxBatchSize {
+				log.Errorf("runner %s, reader %s read lines larger than MaxBatchSize %v, content is %s", r.Name(), r.reader.Name(), r.MaxBatchSize, line)
+				continue
+			}
+			job.lines = append(job.lines, line)
+			r.metrics.ReadLines.Inc()
+			r.metrics.ReadBytes.Add(int64(len(line)))
+			r.speed.readLines.Add(1)
+			r.speed.readBytes.Add(int64(len(line)))
+			if datasourceTag != "" {
+				job.froms = append(job.froms, r.reader.Source())
+			}
+			if r.deadLetter != nil {
+				job.offsets = append(job.offsets, r.currentOffset())
+			}
+			r.batchLen++
+			r.batchSize += len(line)
+		}
+		r.metrics.BatchFill.Set(r.batchFill())
+		r.batchLen = 0
+		r.batchSize = 0
+		r.lastSend = time.Now()
+
+		if len(job.lines) <= 0 && atomic.LoadInt32(&r.stopped) > 0 {
+			return
+		}
+		// 没有读到数据也要交给后面的阶段，已经结束的聚合窗口需要发送
+		seq++
+		job.seq = seq
+		ordered <- job
+		if len(job.lines) <= 0 {
+			close(job.parsed)
+			continue
+		}
+		jobs <- job
+	}
+}
+
+// waitAcked 等待序号为seq的batch处理完成，runner停止时返回false
+func (r *LogExportRunner) waitAcked(seq int64) bool {
+	for atomic.LoadInt64(&r.acked) != seq {
+		if atomic.LoadInt32(&r.stopped) > 0 {
+			return false
+		}
+		select {
+		case <-r.ackNotify:
+		case <-time.After(time.Second):
+		}
+	}
+	return true
+}
+
+// parse 解析一个batch，在解析阶段的goroutine中并发调用
+func (r *LogExportRunner) parse(p parser.LogParser, job *batchJob) {
+	datas, err := p.Parse(job.lines)
+	se, ok := err.(*utils.StatsError)
+	r.statsMux.Lock()
+	if ok {
+		err = se.ErrorDetail
+		r.rs.ParserStats.Errors += se.Errors
+		r.rs.ParserStats.Success += se.Success
+		r.metrics.ParseErrors.Add(se.Errors)
+		r.metrics.ParseSuccess.Add(se.Success)
+	} else if err != nil {
+		r.rs.ParserStats.Errors++
+		r.metrics.ParseErrors.Inc()
+	} else {
+		r.rs.ParserStats.Success++
+		r.metrics.ParseSuccess.Inc()
+	}
+	r.statsMux.Unlock()
+	if err != nil {
+		log.Errorf("runner %s, parser %s error : %v ", r.Name(), p.Name(), err.Error())
+	}
+	r.speed.parseLines.Add(int64(len(datas)))
+	if ok && r.deadLetter != nil {
+		r.addDeadLetters(job.lines, job.offsets, se)
+	}
+	if len(datas) <= 0 {
+		log.Debug("runner received parsed data length = 0")
+		return
+	}
+	//把datasourcetag加到data里，前提是认为[]line变成[]data以后是一一对应的，一旦错位就不加
+	if datasourceTag := r.meta.GetDataSourceTag(); datasourceTag != "" {
+		var errorIndex []int
+		if se != nil {
+			errorIndex = se.ErrorIndex
+		}
+		if len(datas)+len(errorIndex) == len(job.froms) {
+			var j int = 0
+			for i, v := range job.froms {
+				if se != nil && se.ErrorIndexIn(i) {
+					continue
+				}
+				if j >= len(datas) {
+					continue
+				}
+				if dt, ok := datas[j][datasourceTag]; ok {
+					log.Debugf("%v datasource tag already has data %v, ignore %v", r.Name(), dt, v)
+				} else {
+					datas[j][datasourceTag] = v
+				}
+				j++
+			}
+		} else {
+			log.Errorf("%v datasourcetag add error, datas %v not match with froms %v", r.Name(), datas, job.froms)
+		}
+	}
+	job.datas = datas
+}
+
+// process 按读取的顺序处理解析好的batch，交给每个sender的缓存
+func (r *LogExportRunner) process(ordered <-chan *batchJob, workers []*senderWorker, acks *ackTracker) {
+	for job := range ordered {
+		<-job.parsed
+		// 没有登记的batch永远不会被确认，读取位置不会越过它
+		if atomic.LoadInt32(&r.stopped) > 0 {
+			continue
+		}
+		datas := job.datas
+		if len(job.lines) <= 0 {
+			if r.aggregator != nil {
+				datas = r.aggregator.Flush(time.Now())
+			}
+		} else if len(datas) > 0 {
+			datas = r.transform(datas)
+			if r.aggregator != nil {
+				datas = r.aggregate(datas)
+			}
+		}
+		// 数据全部被transformer丢弃或者还在聚合中时没有sender需要确认，直接完成
+		r.dispatch(workers, acks, job.seq, datas)
+	}
+}
@@ -0,0 +1,410 @@
+package mgr
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sliceReader 依次返回lines，SyncMeta时检查读取的数据是否都已经发送
+type sliceReader struct {
+	mux     sync.Mutex
+	lines   []string
+	read    int
+	sent    func() int
+	syncs   int
+	badSync bool
+}
+
+func (sr *sliceReader) Name() string   { return "slice_reader" }
+func (sr *sliceReader) Source() string { return "slice" }
+func (sr *sliceReader) ReadLine() (string, error) {
+	sr.mux.Lock()
+	defer sr.mux.Unlock()
+	if sr.read >= len(sr.lines) {
+		return "", nil
+	}
+	sr.read++
+	return sr.lines[sr.read-1], nil
+}
+func (sr *sliceReader) SetMode(mode string, v interface{}) error { return nil }
+func (sr *sliceReader) Close() error                             { return nil }
+func (sr *sliceReader) SyncMeta() {
+	sr.mux.Lock()
+	defer sr.mux.Unlock()
+	sr.syncs++
+	if sr.sent() < sr.read {
+		sr.badSync = true
+	}
+}
+
+// slowParser 解析每个batch的耗时不同，多个解析goroutine完成的顺序与读取顺序不同
+type slowParser struct{}
+
+func (p *slowParser) Name() string { return "slow_parser" }
+func (p *slowParser) Parse(lines []string) ([]sender.Data, error) {
+	datas := make([]sender.Data, 0, len(lines))
+	for _, l := range lines {
+		n, _ := strconv.Atoi(l)
+		time.Sleep(time.Duration(10-n%5*2) * time.Millisecond)
+		datas = append(datas, sender.Data{"n": n})
+	}
+	return datas, nil
+}
+
+type recordSender struct {
+	name  string
+	delay time.Duration
+	mux   sync.Mutex
+	got   []int
+}
+
+func (s *recordSender) Name() string { return s.name }
+func (s *recordSender) Send(datas []sender.Data) error {
+	time.Sleep(s.delay)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, d := range datas {
+		s.got = append(s.got, d["n"].(int))
+	}
+	return nil
+}
+func (s *recordSender) Close() error { return nil }
+func (s *recordSender) sent() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.got)
+}
+
+func Test_RunnerPipelineOrder(t *testing.T) {
+	metaDir := "Test_RunnerPipelineOrder"
+	defer os.RemoveAll(metaDir)
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		reader.KeyMetaPath: metaDir,
+		reader.KeyLogPath:  metaDir,
+		reader.KeyMode:     reader.ModeMysql,
+	})
+	assert.NoError(t, err)
+
+	var lines, exp []int
+	for i := 0; i < 30; i++ {
+		lines = append(lines, i)
+		exp = append(exp, i)
+	}
+	fast := &recordSender{name: "fast"}
+	slow := &recordSender{name: "slow", delay: 20 * time.Millisecond}
+	rd := &sliceReader{sent: func() int {
+		if n := fast.sent(); n < slow.sent() {
+			return n
+		}
+		return slow.sent()
+	}}
+	for _, l := range lines {
+		rd.lines = append(rd.lines, strconv.Itoa(l))
+	}
+	info := RunnerInfo{RunnerName: "pipeline", MaxBatchLen: 2, MaxBatchInteval: 1}
+	r, err := NewLogExportRunnerWithService(info, rd, nil, &slowParser{}, []sender.Sender{fast, slow}, meta)
+	assert.NoError(t, err)
+	for i := 1; i < 4; i++ {
+		r.parsers = append(r.parsers, &slowParser{})
+	}
+
+	go r.Run()
+	deadline := time.Now().Add(20 * time.Second)
+	for rd.sent() < len(lines) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	r.Stop()
+
+	assert.Equal(t, exp, fast.got)
+	assert.Equal(t, exp, slow.got)
+	assert.True(t, rd.syncs > 0)
+	assert.False(t, rd.badSync, "meta synced before datas were sent")
+}
+
+func Test_RunnerParseWorkers(t *testing.T) {
+	ps := parser.NewParserRegistry()
+	metaDir := "Test_RunnerParseWorkers"
+	defer os.RemoveAll(metaDir)
+	logPath := metaDir + "/log"
+	assert.NoError(t, os.MkdirAll(logPath, 0755))
+	rc := RunnerConfig{
+		RunnerInfo: RunnerInfo{RunnerName: "parse_workers", ParseWorkers: 3},
+		ReaderConfig: conf.MapConf{
+			reader.KeyLogPath:  logPath,
+			reader.KeyMetaPath: metaDir + "/meta",
+			reader.KeyMode:     reader.ModeDir,
+		},
+		ParserConf:   conf.MapConf{parser.KeyParserType: parser.TypeRaw},
+		SenderConfig: []conf.MapConf{{"sender_type": "discard"}},
+	}
+	r, err := NewLogExportRunner(rc, nil, ps, sender.NewSenderRegistry())
+	assert.NoError(t, err)
+	defer r.reader.Close()
+	assert.Equal(t, 3, len(r.parsers))
+	assert.Equal(t, r.parser, r.parsers[0])
+	assert.True(t, r.parsers[1] != r.parsers[2])
+}
+
+type downSender struct{}
+
+func (s *downSender) Name() string                   { return "down" }
+func (s *downSender) Send(datas []sender.Data) error { return errors.New("sender is down") }
+func (s *downSender) Close() error                   { return nil }
+
+func Test_RunnerSenderPolicyDrop(t *testing.T) {
+	metaDir := "Test_RunnerSenderPolicyDrop"
+	defer os.RemoveAll(metaDir)
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		reader.KeyMetaPath: metaDir,
+		reader.KeyLogPath:  metaDir,
+		reader.KeyMode:     reader.ModeMysql,
+	})
+	assert.NoError(t, err)
+
+	var exp []int
+	up := &recordSender{name: "up"}
+	rd := &sliceReader{sent: up.sent}
+	for i := 0; i < 20; i++ {
+		exp = append(exp, i)
+		rd.lines = append(rd.lines, strconv.Itoa(i))
+	}
+	info := RunnerInfo{RunnerName: "policy", MaxBatchLen: 2, MaxBatchInteval: 1}
+	r, err := NewLogExportRunnerWithService(info, rd, nil, &slowParser{}, []sender.Sender{up, &downSender{}}, meta)
+	assert.NoError(t, err)
+	r.policies = []senderPolicy{defaultSenderPolicy, {policy: sender.PolicyDrop, bufferSize: 1}}
+
+	go r.Run()
+	deadline := time.Now().Add(20 * time.Second)
+	for up.sent() < len(exp) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	// 故障的sender不影响其他sender，也不阻止读取位置的同步
+	assert.Equal(t, exp, up.got)
+	for time.Now().Before(deadline) {
+		rd.mux.Lock()
+		syncs := rd.syncs
+		rd.mux.Unlock()
+		if syncs > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	r.Stop()
+	assert.True(t, rd.syncs > 0)
+	assert.False(t, rd.badSync)
+	assert.True(t, r.metrics.Senders["down"].Dropped.Value() > 0)
+}
+
+func Test_ackTracker(t *testing.T) {
+	var acked []int64
+	tr := newAckTracker(func(seq int64) { acked = append(acked, seq) })
+	tr.add(1, 2)
+	tr.add(2, 0)
+	tr.add(3, 1)
+	tr.ack(3)
+	assert.Empty(t, acked)
+	tr.ack(1)
+	assert.Empty(t, acked)
+	// 最慢的sender确认后，之后已经完成的batch一起推进
+	tr.ack(1)
+	assert.Equal(t, []int64{3}, acked)
+	tr.add(4, 0)
+	assert.Equal(t, []int64{3, 4}, acked)
+}
+
+func Test_newSenderPolicy(t *testing.T) {
+	p, err := newSenderPolicy(conf.MapConf{})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultSenderPolicy, p)
+	p, err = newSenderPolicy(conf.MapConf{
+		sender.KeyPolicy:               sender.PolicyDrop,
+		sender.KeyBufferSize:           "3",
+		sender.KeyRetryInterval:        "500ms",
+		sender.KeyRetryMaxInterval:     "1m",
+		sender.KeyRetryMultiplier:      "1.5",
+		sender.KeyRetryJitter:          "0",
+		sender.KeyBreakerThreshold:     "0",
+		sender.KeyBreakerProbeInterval: "10s",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, senderPolicy{
+		policy:       sender.PolicyDrop,
+		bufferSize:   3,
+		backoff:      utils.Backoff{Initial: 500 * time.Millisecond, Max: time.Minute, Multiplier: 1.5},
+		breakerProbe: 10 * time.Second,
+	}, p)
+	for _, c := range []conf.MapConf{
+		{sender.KeyPolicy: "retry"},
+		{sender.KeyBufferSize: "0"},
+		{sender.KeyRetryInterval: "1"},
+		{sender.KeyRetryInterval: "1m", sender.KeyRetryMaxInterval: "1s"},
+		{sender.KeyRetryMultiplier: "0.5"},
+		{sender.KeyRetryJitter: "2"},
+	} {
+		_, err = newSenderPolicy(c)
+		assert.Error(t, err, "%v", c)
+	}
+
+	c := diskPolicyConf(conf.MapConf{sender.KeySenderType: sender.TypeDiscard}, "meta/sender_0")
+	assert.Equal(t, "true", c[sender.KeyFaultTolerant])
+	assert.Equal(t, "meta/sender_0", c[sender.KeyFtSaveLogPath])
+}
+
+type errSender struct {
+	err   error
+	calls int
+}
+
+func (s *errSender) Name() string                   { return "err" }
+func (s *errSender) Send(datas []sender.Data) error { s.calls++; return s.err }
+func (s *errSender) Close() error                   { return nil }
+
+func newTestRunner(s sender.Sender) *LogExportRunner {
+	return &LogExportRunner{
+		RunnerInfo: RunnerInfo{RunnerName: "retry"},
+		senders:    []sender.Sender{s},
+		rs:         RunnerStatus{SenderStats: map[string]utils.StatsInfo{}},
+		metrics:    newRunnerMetrics([]sender.Sender{s}),
+		speed:      newSpeedTracker([]sender.Sender{s}),
+	}
+}
+
+func Test_TrySendPermanentError(t *testing.T) {
+	s := &errSender{err: sender.NewPermanentError(errors.New("400 invalid schema"))}
+	r := newTestRunner(s)
+	r.deadLetter = &deadLetterQueue{keep: 10}
+	w := r.newSenderWorkers()[0]
+	r.MaxBatchTryTimes = 0
+
+	// 永久错误不重试，直接写入dead letter
+	assert.True(t, r.trySend(w, []sender.Data{{"a": 1}}))
+	assert.Equal(t, 1, s.calls)
+	letters := r.DeadLetters(10)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, `{"a":1}`, letters[0].Raw)
+	assert.Equal(t, "err", letters[0].Sender)
+	assert.Equal(t, "400 invalid schema", letters[0].Error)
+	assert.Equal(t, int64(1), r.rs.SenderStats["err"].Errors)
+}
+
+func Test_TrySendPermanentDatas(t *testing.T) {
+	datas := []sender.Data{{"a": 1}, {"a": "x"}}
+	s := &errSender{err: sender.NewSendError("503 service unavailable", datas[:1], sender.TypeDefault).WithPermanent(datas[1:])}
+	r := newTestRunner(s)
+	r.deadLetter = &deadLetterQueue{keep: 10}
+	r.MaxBatchTryTimes = 1
+	w := r.newSenderWorkers()[0]
+
+	// 附带的永久失败的数据直接写入dead letter，其余数据按重试次数重试
+	assert.True(t, r.trySend(w, datas))
+	assert.Equal(t, 1, s.calls)
+	letters := r.DeadLetters(10)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, `{"a":"x"}`, letters[0].Raw)
+}
+
+func Test_TrySendStats(t *testing.T) {
+	s := &errSender{}
+	r := newTestRunner(s)
+	w := r.newSenderWorkers()[0]
+
+	// drop与trySend的统计都是增量更新，互不覆盖
+	r.drop(w, []sender.Data{{"a": 1}, {"a": 2}})
+	assert.True(t, r.trySend(w, []sender.Data{{"a": 3}}))
+	assert.Equal(t, utils.StatsInfo{Errors: 2, Success: 1}, r.rs.SenderStats["err"])
+
+	// runner退出时提前返回，已经发生的失败同样计入统计
+	s.err = errors.New("503 service unavailable")
+	atomic.StoreInt32(&r.stopped, 1)
+	assert.False(t, r.trySend(w, []sender.Data{{"a": 4}}))
+	assert.Equal(t, utils.StatsInfo{Errors: 3, Success: 1}, r.rs.SenderStats["err"])
+}
+
+func Test_TrySendBackoffAndBreaker(t *testing.T) {
+	s := &errSender{err: errors.New("503 service unavailable")}
+	r := newTestRunner(s)
+	r.MaxBatchTryTimes = 4
+	r.policies = []senderPolicy{{
+		policy:           sender.PolicyBlock,
+		bufferSize:       1,
+		backoff:          utils.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 2},
+		breakerThreshold: 2,
+		breakerProbe:     200 * time.Millisecond,
+	}}
+	w := r.newSenderWorkers()[0]
+
+	// 连续失败两次后熔断，之后每隔200ms探测一次
+	start := time.Now()
+	assert.True(t, r.trySend(w, []sender.Data{{"a": 1}}))
+	assert.Equal(t, 4, s.calls)
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+	assert.Equal(t, utils.BreakerOpen, w.breaker.State())
+	assert.Equal(t, float64(1), r.metrics.Senders["err"].BreakerOpen.Value())
+
+	// 熔断期间runner退出时不再等待
+	atomic.StoreInt32(&r.stopped, 1)
+	assert.False(t, r.trySend(w, []sender.Data{{"a": 1}}))
+	assert.Equal(t, 4, s.calls)
+}
+
+// splitSender 整体拒绝包含bad的batch，其他情况第一次只发送成功前一半
+type splitSender struct {
+	calls   [][]int
+	partial bool
+}
+
+func (s *splitSender) Name() string { return "split" }
+func (s *splitSender) Send(datas []sender.Data) error {
+	var ns []int
+	for _, d := range datas {
+		ns = append(ns, d["n"].(int))
+	}
+	s.calls = append(s.calls, ns)
+	for _, d := range datas {
+		if d["bad"] != nil {
+			return sender.NewSendError("entity too large", datas, sender.TypeBinaryUnpack)
+		}
+	}
+	if s.partial && len(datas) > 1 {
+		s.partial = false
+		return sender.NewSendError("partial failure", datas[len(datas)/2:], sender.TypeDefault)
+	}
+	return nil
+}
+func (s *splitSender) Close() error { return nil }
+
+func Test_TrySendSplitAndRetry(t *testing.T) {
+	s := &splitSender{partial: true}
+	r := newTestRunner(s)
+	r.deadLetter = &deadLetterQueue{keep: 10}
+	w := r.newSenderWorkers()[0]
+	w.policy.backoff = utils.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1}
+
+	// 部分失败时只重试失败的数据
+	datas := []sender.Data{{"n": 0}, {"n": 1}, {"n": 2}, {"n": 3}}
+	assert.True(t, r.trySend(w, datas))
+	assert.Equal(t, [][]int{{0, 1, 2, 3}, {2, 3}}, s.calls)
+
+	// 被整体拒绝时不断对半拆分，直到找出有问题的数据
+	s.calls = nil
+	datas = []sender.Data{{"n": 0}, {"n": 1}, {"n": 2, "bad": true}, {"n": 3}, {"n": 4}}
+	assert.True(t, r.trySend(w, datas))
+	assert.Equal(t, [][]int{{0, 1, 2, 3, 4}, {0, 1}, {2, 3, 4}, {2}, {3, 4}}, s.calls)
+	letters := r.DeadLetters(10)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, `{"bad":true,"n":2}`, letters[0].Raw)
+	assert.Equal(t, utils.BreakerClosed, w.breaker.State())
+}
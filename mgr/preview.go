@@ -0,0 +1,149 @@
+package mgr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+const (
+	defaultPreviewLines   = 10
+	maxPreviewLines       = 1000
+	defaultPreviewTimeout = 10 // 秒，file reader 读到文件末尾时会等待3秒
+)
+
+// ParserPreviewRequest POST /logkit/parser/preview 的请求
+type ParserPreviewRequest struct {
+	Parser conf.MapConf `json:"parser"`
+	Lines  []string     `json:"lines"`
+}
+
+// LineError 第Index行数据处理失败的原因
+type LineError struct {
+	Index int    `json:"index"`
+	Line  string `json:"line"`
+	Error string `json:"error"`
+}
+
+// ParserPreview 试解析的结果
+type ParserPreview struct {
+	Datas  []sender.Data `json:"datas"`
+	Errors []LineError   `json:"errors"`
+	Error  string        `json:"error,omitempty"` // 无法对应到某一行的错误
+}
+
+// ReaderPreviewRequest POST /logkit/reader/preview 的请求
+type ReaderPreviewRequest struct {
+	Reader  conf.MapConf `json:"reader"`
+	N       int          `json:"n"`       // 最多读取的行数，默认10
+	Timeout int          `json:"timeout"` // 最长等待时间，单位秒，默认10
+}
+
+// PreviewParser 使用parser配置解析样例数据，不会影响正在运行的runner
+func PreviewParser(ps *parser.ParserRegistry, req ParserPreviewRequest) (ParserPreview, error) {
+	if len(req.Parser) <= 0 {
+		return ParserPreview{}, errors.New("parser config can not be empty")
+	}
+	p, err := ps.NewLogParser(req.Parser)
+	if err != nil {
+		return ParserPreview{}, err
+	}
+	datas, err := p.Parse(req.Lines)
+	preview := ParserPreview{Datas: datas, Errors: []LineError{}}
+	if preview.Datas == nil {
+		preview.Datas = []sender.Data{}
+	}
+	if se, ok := err.(*utils.StatsError); ok {
+		for _, idx := range se.ErrorIndex {
+			if idx < 0 || idx >= len(req.Lines) {
+				continue
+			}
+			le := LineError{Index: idx, Line: req.Lines[idx]}
+			if lerr := se.IndexErrors[idx]; lerr != nil {
+				le.Error = lerr.Error()
+			} else if se.ErrorDetail != nil {
+				le.Error = se.ErrorDetail.Error()
+			}
+			preview.Errors = append(preview.Errors, le)
+		}
+		if len(se.ErrorIndex) <= 0 && se.ErrorDetail != nil {
+			preview.Error = se.ErrorDetail.Error()
+		}
+	} else if err != nil {
+		preview.Error = err.Error()
+	}
+	return preview, nil
+}
+
+// PreviewReader 按照reader配置读取前N行数据，使用临时的meta目录，不会改变该配置对应的读取位置。
+// 只支持读取本地文件的reader，kafka等reader会加入正在使用的consumer group或者提交读取位置，影响线上的runner
+func PreviewReader(req ReaderPreviewRequest) ([]string, error) {
+	if len(req.Reader) <= 0 {
+		return nil, errors.New("reader config can not be empty")
+	}
+	mode, _ := req.Reader.GetStringOr(reader.KeyMode, reader.ModeDir)
+	switch mode {
+	case reader.ModeDir, reader.ModeFile, reader.ModeTailx:
+	default:
+		return nil, fmt.Errorf("preview of reader mode %v is not supported, only %v, %v and %v can be previewed", mode, reader.ModeDir, reader.ModeFile, reader.ModeTailx)
+	}
+	n := req.N
+	if n <= 0 {
+		n = defaultPreviewLines
+	}
+	if n > maxPreviewLines {
+		n = maxPreviewLines
+	}
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = defaultPreviewTimeout
+	}
+	metaDir, err := ioutil.TempDir("", "logkit_preview")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(metaDir)
+	c := conf.MapConf{}
+	for k, v := range req.Reader {
+		c[k] = v
+	}
+	c[reader.KeyMetaPath] = metaDir
+	c[reader.KeyFileDone] = metaDir
+	meta, err := reader.NewMetaWithConf(c)
+	if err != nil {
+		return nil, err
+	}
+	rd, err := reader.NewFileBufReaderWithMeta(c, meta)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rd.Close(); err != nil {
+			log.Errorf("close preview reader %v error %v", rd.Name(), err)
+		}
+	}()
+	lines := []string{}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for len(lines) < n && time.Now().Before(deadline) {
+		line, err := rd.ReadLine()
+		if err != nil && err != io.EOF {
+			return lines, err
+		}
+		if len(line) <= 0 {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
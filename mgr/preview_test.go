@@ -0,0 +1,66 @@
+package mgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParserPreview(t *testing.T) {
+	m, err := NewManager(ManagerConfig{})
+	assert.NoError(t, err)
+	rs := &RestService{mgr: m}
+	body := `{
+		"parser":{"name":"csv","type":"csv","csv_schema":"logtype string, xx long","csv_splitter":" "},
+		"lines":["hello 123","bad line format","x 789"]
+	}`
+	rw := httptest.NewRecorder()
+	rs.PostParserPreview(rw, httptest.NewRequest("POST", "/logkit/parser/preview", bytes.NewBufferString(body)))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	var preview ParserPreview
+	assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), &preview))
+	assert.Len(t, preview.Datas, 2)
+	assert.Equal(t, "hello", preview.Datas[0]["logtype"])
+	assert.Len(t, preview.Errors, 1)
+	assert.Equal(t, 1, preview.Errors[0].Index)
+	assert.Equal(t, "bad line format", preview.Errors[0].Line)
+	assert.NotEmpty(t, preview.Errors[0].Error)
+
+	rw = httptest.NewRecorder()
+	rs.PostParserPreview(rw, httptest.NewRequest("POST", "/logkit/parser/preview", bytes.NewBufferString(`{"parser":{"type":"notexist"}}`)))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func Test_ReaderPreview(t *testing.T) {
+	dir := "Test_ReaderPreview"
+	logpath := filepath.Join(dir, "logdir")
+	metapath := filepath.Join(dir, "meta")
+	assert.NoError(t, os.MkdirAll(logpath, 0755))
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(logpath, "log1"), []byte("a\nb\nc\n"), 0644))
+
+	rs := &RestService{}
+	body := `{"reader":{"log_path":"` + logpath + `","meta_path":"` + metapath + `","mode":"dir","read_from":"oldest"},"n":2}`
+	rw := httptest.NewRecorder()
+	rs.PostReaderPreview(rw, httptest.NewRequest("POST", "/logkit/reader/preview", bytes.NewBufferString(body)))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	var lines []string
+	assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), &lines))
+	assert.Equal(t, []string{"a\n", "b\n"}, lines)
+	// 预览不会使用配置中的meta目录
+	_, err := os.Stat(metapath)
+	assert.True(t, os.IsNotExist(err))
+
+	// 不支持预览会影响线上数据的reader
+	body = `{"reader":{"mode":"kafka","kafka_groupid":"logkit","kafka_topic":"test","kafka_zookeeper":"127.0.0.1:2181"}}`
+	rw = httptest.NewRecorder()
+	rs.PostReaderPreview(rw, httptest.NewRequest("POST", "/logkit/reader/preview", bytes.NewBufferString(body)))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
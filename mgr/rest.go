@@ -39,6 +39,17 @@ func NewRestService(mgr *Manager) *RestService {
 
 	mux := rest.NewServeMux()
 	mux.HandleFunc("GET"+PREFIX+"/status", rs.GetStatus)
+	mux.HandleFunc("GET/metrics", rs.GetMetrics)
+	mux.HandleFunc("GET"+PREFIX+"/deadletters/*", rs.GetDeadLetters)
+	mux.HandleFunc("POST"+PREFIX+"/parser/preview", rs.PostParserPreview)
+	mux.HandleFunc("POST"+PREFIX+"/reader/preview", rs.PostReaderPreview)
+	mux.HandleFunc("GET"+PREFIX+"/runners/*", rs.GetRunner)
+	mux.HandleFunc("POST"+PREFIX+"/runners/*", rs.PostRunner)
+	mux.HandleFunc("PUT"+PREFIX+"/runners/*", rs.PutRunner)
+	mux.HandleFunc("DELETE"+PREFIX+"/runners/*", rs.DeleteRunner)
+	mux.HandleFunc("POST"+PREFIX+"/runners/*/start", rs.PostRunnerStart)
+	mux.HandleFunc("POST"+PREFIX+"/runners/*/stop", rs.PostRunnerStop)
+	mux.HandleFunc("POST"+PREFIX+"/runners/*/restart", rs.PostRunnerRestart)
 	var (
 		port     = DEFAULT_PORT
 		address  string
@@ -95,6 +106,167 @@ func (rs *RestService) GetStatus(rw http.ResponseWriter, req *http.Request) {
 	return
 }
 
+const defaultDeadLetterCount = 10
+
+// get /logkit/deadletters/<runnerName>?n=10
+func (rs *RestService) GetDeadLetters(rw http.ResponseWriter, req *http.Request) {
+	name := req.Header.Get("*")
+	n := defaultDeadLetterCount
+	if ns := req.URL.Query().Get("n"); ns != "" {
+		var err error
+		if n, err = strconv.Atoi(ns); err != nil {
+			http.Error(rw, fmt.Sprintf("invalid n %v", ns), http.StatusBadRequest)
+			return
+		}
+	}
+	letters, ok := rs.mgr.DeadLetters(name, n)
+	if !ok {
+		http.Error(rw, fmt.Sprintf("runner %v not found", name), http.StatusNotFound)
+		return
+	}
+	br, _ := json.Marshal(letters)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(br)
+}
+
+// post /logkit/parser/preview
+func (rs *RestService) PostParserPreview(rw http.ResponseWriter, req *http.Request) {
+	var pr ParserPreviewRequest
+	if err := json.NewDecoder(req.Body).Decode(&pr); err != nil {
+		http.Error(rw, fmt.Sprintf("decode request body error %v", err), http.StatusBadRequest)
+		return
+	}
+	preview, err := PreviewParser(rs.mgr.pregistry, pr)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	br, _ := json.Marshal(preview)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(br)
+}
+
+// post /logkit/reader/preview
+func (rs *RestService) PostReaderPreview(rw http.ResponseWriter, req *http.Request) {
+	var rr ReaderPreviewRequest
+	if err := json.NewDecoder(req.Body).Decode(&rr); err != nil {
+		http.Error(rw, fmt.Sprintf("decode request body error %v", err), http.StatusBadRequest)
+		return
+	}
+	lines, err := PreviewReader(rr)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	br, _ := json.Marshal(lines)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(br)
+}
+
+// writeManageError 根据runner管理接口的错误类型返回对应的状态码，配置校验失败时返回每个配置项的错误
+func writeManageError(rw http.ResponseWriter, err error) {
+	if ve, ok := err.(*ValidationError); ok {
+		br, _ := json.Marshal(ve)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write(br)
+		return
+	}
+	switch err {
+	case ErrRunnerNotFound:
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case ErrRunnerExist:
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case ErrRunnerNotManaged:
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func decodeRunnerConfig(req *http.Request) (rc RunnerConfig, err error) {
+	if err = json.NewDecoder(req.Body).Decode(&rc); err != nil {
+		err = &ValidationError{Fields: []FieldError{{Field: "body", Error: err.Error()}}}
+	}
+	return
+}
+
+// get /logkit/runners/<runnerName>
+func (rs *RestService) GetRunner(rw http.ResponseWriter, req *http.Request) {
+	rc, err := rs.mgr.GetRunnerConfig(req.Header.Get("*"))
+	if err != nil {
+		writeManageError(rw, err)
+		return
+	}
+	br, _ := json.Marshal(rc)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(br)
+}
+
+// post /logkit/runners/<runnerName>
+func (rs *RestService) PostRunner(rw http.ResponseWriter, req *http.Request) {
+	name := req.Header.Get("*")
+	rc, err := decodeRunnerConfig(req)
+	if err == nil {
+		if rc.RunnerName == "" {
+			rc.RunnerName = name
+		}
+		if rc.RunnerName != name {
+			err = &ValidationError{Fields: []FieldError{{Field: "name", Error: fmt.Sprintf("runner name %v not match with %v in url", rc.RunnerName, name)}}}
+		} else {
+			err = rs.mgr.AddRunner(rc)
+		}
+	}
+	if err != nil {
+		writeManageError(rw, err)
+	}
+}
+
+// put /logkit/runners/<runnerName>
+func (rs *RestService) PutRunner(rw http.ResponseWriter, req *http.Request) {
+	rc, err := decodeRunnerConfig(req)
+	if err == nil {
+		err = rs.mgr.UpdateRunner(req.Header.Get("*"), rc)
+	}
+	if err != nil {
+		writeManageError(rw, err)
+	}
+}
+
+// delete /logkit/runners/<runnerName>
+func (rs *RestService) DeleteRunner(rw http.ResponseWriter, req *http.Request) {
+	if err := rs.mgr.DeleteRunner(req.Header.Get("*")); err != nil {
+		writeManageError(rw, err)
+	}
+}
+
+// post /logkit/runners/<runnerName>/start
+func (rs *RestService) PostRunnerStart(rw http.ResponseWriter, req *http.Request) {
+	if err := rs.mgr.StartRunner(req.Header.Get("*")); err != nil {
+		writeManageError(rw, err)
+	}
+}
+
+// post /logkit/runners/<runnerName>/stop
+func (rs *RestService) PostRunnerStop(rw http.ResponseWriter, req *http.Request) {
+	if err := rs.mgr.StopRunner(req.Header.Get("*")); err != nil {
+		writeManageError(rw, err)
+	}
+}
+
+// post /logkit/runners/<runnerName>/restart
+func (rs *RestService) PostRunnerRestart(rw http.ResponseWriter, req *http.Request) {
+	if err := rs.mgr.RestartRunner(req.Header.Get("*")); err != nil {
+		writeManageError(rw, err)
+	}
+}
+
+// get /metrics
+func (rs *RestService) GetMetrics(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	rs.mgr.WriteMetrics(rw)
+}
+
 // Stop will stop RestService
 func (rs *RestService) Stop() {
 	rs.l.Close()
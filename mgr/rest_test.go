@@ -120,6 +120,17 @@ func Test_Rest(t *testing.T) {
 	if err != nil {
 		t.Error(err, out.String())
 	}
+	// 速度与时间相关，单独检查后不再参与比较
+	rs1 := rss["test1.csv"]
+	if rs1.Speed.ReadLines <= 0 || rs1.Speed.ParseLines <= 0 {
+		t.Errorf("runner speed should be positive but got %v", rs1.Speed)
+	}
+	if ss := rs1.SenderSpeeds["file_sender"]; ss.Lines <= 0 || ss.LastSuccess == nil {
+		t.Errorf("sender speed error got %v", ss)
+	}
+	rs1.Speed = RunnerSpeed{}
+	rs1.SenderSpeeds = nil
+	rss["test1.csv"] = rs1
 	rp, err := filepath.Abs(logpath)
 	if err != nil {
 		t.Error(err)
@@ -131,6 +142,7 @@ func Test_Rest(t *testing.T) {
 			Lag: RunnerLag{
 				Size:  0,
 				Files: 0,
+				Unit:  "bytes",
 			},
 			ParserStats: utils.StatsInfo{
 				Errors:  0,
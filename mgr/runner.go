@@ -1,13 +1,15 @@
 package mgr
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -17,6 +19,7 @@ import (
 	"github.com/qiniu/logkit/parser"
 	"github.com/qiniu/logkit/reader"
 	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/transforms"
 	"github.com/qiniu/logkit/utils"
 )
 
@@ -34,18 +37,27 @@ type Runner interface {
 }
 
 type RunnerStatus struct {
-	Name        string                     `json:"name"`
-	Logpath     string                     `json:"logpath"`
-	Lag         RunnerLag                  `json:"lag,omitempty"`
-	ParserStats utils.StatsInfo            `json:"parserStats,omitempty"`
-	SenderStats map[string]utils.StatsInfo `json:"senderStats,omitempty"`
-	Error       error                      `json:"error,omitempty"`
+	Name            string                      `json:"name"`
+	Logpath         string                      `json:"logpath"`
+	Lag             RunnerLag                   `json:"lag,omitempty"`
+	ParserStats     utils.StatsInfo             `json:"parserStats,omitempty"`
+	TransformStats  map[string]utils.StatsInfo  `json:"transformStats,omitempty"`
+	TransformCounts map[string]map[string]int64 `json:"transformCounts,omitempty"`
+	AggregatorStats utils.StatsInfo             `json:"aggregatorStats,omitempty"`
+	SenderStats     map[string]utils.StatsInfo  `json:"senderStats,omitempty"`
+	Speed           RunnerSpeed                 `json:"speed"`
+	SenderSpeeds    map[string]SenderSpeed      `json:"senderSpeeds,omitempty"`
+	EventDelay      *float64                    `json:"eventDelay,omitempty"`      // 最近发送的数据从产生到发送的平均延迟，单位秒
+	DeadLetterDrops int64                       `json:"deadLetterDrops,omitempty"` // dead letter写入跟不上时丢弃的条数
+	Error           error                       `json:"error,omitempty"`
 }
 
 type RunnerLag struct {
-	Size   int64 `json:"size"`
-	Files  int64 `json:"files"`
-	Ftlags int64 `json:"ftlags"`
+	Size    int64            `json:"size"`
+	Files   int64            `json:"files"`
+	Ftlags  int64            `json:"ftlags"`
+	Unit    string           `json:"unit,omitempty"`    // size的单位，见reader.LagInfo
+	Details map[string]int64 `json:"details,omitempty"` // 每个分区、sql或文件的延迟
 }
 
 // RunnerConfig 从多数据源读取，经过解析后，发往多个数据目的地
@@ -54,6 +66,10 @@ type RunnerConfig struct {
 	ReaderConfig  conf.MapConf   `json:"reader"`
 	CleanerConfig conf.MapConf   `json:"cleaner"`
 	ParserConf    conf.MapConf   `json:"parser"`
+	Filter        string         `json:"filter"` // 条件表达式，只有满足条件的数据才会交给transforms和senders
+	Transforms    []conf.MapConf `json:"transforms"`
+	Aggregator    conf.MapConf   `json:"aggregator"`  // 配置后将数据按窗口聚合，只发送聚合的结果
+	DeadLetter    conf.MapConf   `json:"dead_letter"` // 配置后保存解析失败的原始数据
 	SenderConfig  []conf.MapConf `json:"senders"`
 }
 
@@ -63,21 +79,35 @@ type RunnerInfo struct {
 	MaxBatchSize     int    `json:"batch_size"`      // 每个read batch的字节数
 	MaxBatchInteval  int    `json:"batch_interval"`  // 最大发送时间间隔
 	MaxBatchTryTimes int    `json:"batch_try_times"` // 最大发送次数，小于等于0代表无限重试
+	ParseWorkers     int    `json:"parse_workers"`   // 并发解析的goroutine数，默认为1
 }
 
 type LogExportRunner struct {
 	RunnerInfo
 
-	stopped  int32
-	exitChan chan struct{}
-	reader   reader.Reader
-	cleaner  *cleaner.Cleaner
-	parser   parser.LogParser
-	senders  []sender.Sender
-	rs       RunnerStatus
+	stopped      int32
+	exitChan     chan struct{}
+	reader       reader.Reader
+	cleaner      *cleaner.Cleaner
+	parser       parser.LogParser
+	parsers      []parser.LogParser // 每个解析goroutine使用一个，第一个即parser
+	transformers []transforms.Transformer
+	senders      []sender.Sender
+	routes       []*transforms.Expr // 与senders一一对应，为nil时发送全部数据
+	policies     []senderPolicy     // 与senders一一对应，没有时使用默认策略
+	aggregator   *transforms.Aggregator
+	deadLetter   *deadLetterQueue
+	rs           RunnerStatus
+	statsMux     sync.Mutex                 // 保护rs和ftStats，各个阶段的goroutine会并发更新
+	ftStats      map[string]utils.StatsInfo // 带磁盘队列的sender上一次返回的累计统计，用于计算增量
+	metrics      *RunnerMetrics
+	speed        *speedTracker
 
 	meta *reader.Meta
 
+	acked     int64         // 已经被所有sender确认的最后一个batch的序号
+	ackNotify chan struct{} // acked更新时通知读取阶段
+
 	batchLen  int
 	batchSize int
 	lastSend  time.Time
@@ -92,20 +122,37 @@ func NewRunner(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal) (runner Ru
 }
 
 func NewCustomRunner(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, ps *parser.ParserRegistry, sr *sender.SenderRegistry) (runner Runner, err error) {
+	return NewCustomRunnerWithTransforms(rc, cleanChan, ps, nil, sr)
+}
+
+// NewCustomRunnerWithTransforms 创建Runner，可以使用自定义的parser、transformer和sender，registry为nil时使用默认的
+func NewCustomRunnerWithTransforms(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, ps *parser.ParserRegistry, tr *transforms.TransformerRegistry, sr *sender.SenderRegistry) (runner Runner, err error) {
 	if ps == nil {
 		ps = parser.NewParserRegistry()
 	}
+	if tr == nil {
+		tr = transforms.NewTransformerRegistry()
+	}
 	if sr == nil {
 		sr = sender.NewSenderRegistry()
 	}
-	return NewLogExportRunner(rc, cleanChan, ps, sr)
+	return NewLogExportRunnerWithTransforms(rc, cleanChan, ps, tr, sr)
 }
 
 func NewRunnerWithService(info RunnerInfo, reader reader.Reader, cleaner *cleaner.Cleaner, parser parser.LogParser, senders []sender.Sender, meta *reader.Meta) (runner Runner, err error) {
-	return NewLogExportRunnerWithService(info, reader, cleaner, parser, senders, meta)
+	return NewLogExportRunnerWithServiceAndTransforms(info, reader, cleaner, parser, nil, senders, meta)
+}
+
+func NewRunnerWithServiceAndTransforms(info RunnerInfo, reader reader.Reader, cleaner *cleaner.Cleaner, parser parser.LogParser, transformers []transforms.Transformer, senders []sender.Sender, meta *reader.Meta) (runner Runner, err error) {
+	return NewLogExportRunnerWithServiceAndTransforms(info, reader, cleaner, parser, transformers, senders, meta)
 }
 
 func NewLogExportRunnerWithService(info RunnerInfo, reader reader.Reader, cleaner *cleaner.Cleaner, parser parser.LogParser, senders []sender.Sender, meta *reader.Meta) (runner *LogExportRunner, err error) {
+	return NewLogExportRunnerWithServiceAndTransforms(info, reader, cleaner, parser, nil, senders, meta)
+}
+
+// NewLogExportRunnerWithServiceAndTransforms 使用已经创建好的各个组件创建Runner，transformers按顺序处理解析后的数据
+func NewLogExportRunnerWithServiceAndTransforms(info RunnerInfo, reader reader.Reader, cleaner *cleaner.Cleaner, parser parser.LogParser, transformers []transforms.Transformer, senders []sender.Sender, meta *reader.Meta) (runner *LogExportRunner, err error) {
 	if info.MaxBatchLen <= 0 && info.MaxBatchSize <= 0 {
 		info.MaxBatchSize = defaultMaxBatchSize
 	}
@@ -115,8 +162,12 @@ func NewLogExportRunnerWithService(info RunnerInfo, reader reader.Reader, cleane
 	runner = &LogExportRunner{
 		RunnerInfo: info,
 		exitChan:   make(chan struct{}),
+		ackNotify:  make(chan struct{}, 1),
 		lastSend:   time.Now(), // 上一次发送时间
-		rs:         RunnerStatus{SenderStats: make(map[string]utils.StatsInfo)},
+		rs: RunnerStatus{
+			TransformStats: make(map[string]utils.StatsInfo),
+			SenderStats:    make(map[string]utils.StatsInfo),
+		},
 	}
 	if reader == nil {
 		err = errors.New("reader can not be nil")
@@ -137,21 +188,31 @@ func NewLogExportRunnerWithService(info RunnerInfo, reader reader.Reader, cleane
 		return
 	}
 	runner.parser = parser
+	runner.parsers = append(runner.parsers, parser)
+	runner.transformers = transformers
 	if len(senders) < 1 {
 		err = errors.New("senders can not be nil")
 		return
 	}
 	runner.senders = senders
+	runner.metrics = newRunnerMetrics(senders)
+	runner.speed = newSpeedTracker(senders)
 	return runner, nil
 }
 
 func NewLogExportRunner(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, ps *parser.ParserRegistry, sr *sender.SenderRegistry) (runner *LogExportRunner, err error) {
+	return NewLogExportRunnerWithTransforms(rc, cleanChan, ps, transforms.NewTransformerRegistry(), sr)
+}
+
+// NewLogExportRunnerWithTransforms 根据配置创建Runner，transforms中的transformer由tr创建
+func NewLogExportRunnerWithTransforms(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, ps *parser.ParserRegistry, tr *transforms.TransformerRegistry, sr *sender.SenderRegistry) (runner *LogExportRunner, err error) {
 	runnerInfo := RunnerInfo{
 		RunnerName:       rc.RunnerName,
 		MaxBatchSize:     rc.MaxBatchSize,
 		MaxBatchLen:      rc.MaxBatchLen,
 		MaxBatchInteval:  rc.MaxBatchInteval,
 		MaxBatchTryTimes: rc.MaxBatchTryTimes,
+		ParseWorkers:     rc.ParseWorkers,
 	}
 
 	rc.ReaderConfig[utils.GlobalKeyName] = rc.RunnerName
@@ -179,164 +240,470 @@ func NewLogExportRunner(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, p
 			return nil, err
 		}
 	}
+	timeKey, _ := rc.ParserConf.GetStringOr(parser.KeyTimestampKey, "")
 	parser, err := ps.NewLogParser(rc.ParserConf)
 	if err != nil {
 		return nil, err
 	}
+	schema := senderSchema(rc, parser)
+	transformers := make([]transforms.Transformer, 0)
+	if rc.Filter != "" {
+		// runner 级别的 filter 作为第一个transformer，被过滤的数据不再经过后续处理
+		t, err := transforms.NewFilterTransformer(conf.MapConf{
+			transforms.KeyTransformName: transforms.TypeFilter,
+			transforms.KeyFilterExpr:    rc.Filter,
+		})
+		if err != nil {
+			return nil, err
+		}
+		transformers = append(transformers, t)
+	}
+	for _, c := range rc.Transforms {
+		t, err := tr.NewTransformer(c)
+		if err != nil {
+			return nil, err
+		}
+		transformers = append(transformers, t)
+	}
 	senders := make([]sender.Sender, 0)
-	for _, c := range rc.SenderConfig {
+	routes := make([]*transforms.Expr, 0)
+	policies := make([]senderPolicy, 0)
+	for i, c := range rc.SenderConfig {
+		var route *transforms.Expr
+		if cond, _ := c.GetStringOr(sender.KeyRouteIf, ""); cond != "" {
+			route, err = transforms.NewExpr(cond)
+			if err != nil {
+				return nil, err
+			}
+		}
+		policy, err := newSenderPolicy(c)
+		if err != nil {
+			return nil, err
+		}
+		if policy.policy == sender.PolicyDisk {
+			c = diskPolicyConf(c, filepath.Join(meta.Dir(), "sender_"+strconv.Itoa(i)))
+		}
+		c = parserSchemaConf(c, schema)
 		s, err := sr.NewSender(c)
 		if err != nil {
 			return nil, err
 		}
 		senders = append(senders, s)
+		routes = append(routes, route)
+		policies = append(policies, policy)
 	}
-	return NewLogExportRunnerWithService(runnerInfo, rd, cl, parser, senders, meta)
-}
-
-// trySend 尝试发送数据，如果此时runner退出返回false，其他情况无论是达到最大重试次数还是发送成功，都返回true
-func (r *LogExportRunner) trySend(s sender.Sender, datas []sender.Data, times int) bool {
-	if len(datas) <= 0 {
-		return true
+	var aggregator *transforms.Aggregator
+	if len(rc.Aggregator) > 0 {
+		aggregator, err = transforms.NewAggregator(rc.Aggregator, meta.Dir())
+		if err != nil {
+			return nil, err
+		}
 	}
-	if _, ok := r.rs.SenderStats[s.Name()]; !ok {
-		r.rs.SenderStats[s.Name()] = utils.StatsInfo{}
+	runner, err = NewLogExportRunnerWithServiceAndTransforms(runnerInfo, rd, cl, parser, transformers, senders, meta)
+	if err != nil {
+		return nil, err
 	}
-	info := r.rs.SenderStats[s.Name()]
-	cnt := 1
-	for {
-		// 至少尝试一次。如果任务已经停止，那么只尝试一次
-		if cnt > 1 && atomic.LoadInt32(&r.stopped) > 0 {
-			return false
+	// 每个解析goroutine使用单独的parser，parser不需要是并发安全的
+	for i := 1; i < rc.ParseWorkers; i++ {
+		p, err := ps.NewLogParser(rc.ParserConf)
+		if err != nil {
+			return nil, err
+		}
+		runner.parsers = append(runner.parsers, p)
+	}
+	// dead letter启动了写入的goroutine，最后创建
+	if len(rc.DeadLetter) > 0 {
+		if runner.deadLetter, err = newDeadLetterQueue(rc.DeadLetter, sr); err != nil {
+			return nil, err
+		}
+	}
+	runner.routes = routes
+	runner.policies = policies
+	runner.aggregator = aggregator
+	// 带磁盘队列的sender自己重试，使用同样的退避与熔断配置，永久错误的数据同样写入dead letter
+	for i, s := range senders {
+		ft, ok := s.(*sender.FtSender)
+		if !ok {
+			continue
+		}
+		p := policies[i]
+		ft.SetRetryPolicy(p.backoff, utils.NewCircuitBreaker(p.breakerThreshold, p.breakerProbe))
+		ft.SetDeadLetter(func(datas []sender.Data, err error) {
+			runner.addSendDeadLetters(ft, datas, err)
+		})
+	}
+	runner.speed.timeKey = timeKey
+	return runner, nil
+}
+
+// transform 依次使用每个transformer处理数据，并记录每个transformer的统计信息
+func (r *LogExportRunner) transform(datas []sender.Data) []sender.Data {
+	for _, t := range r.transformers {
+		if len(datas) <= 0 {
+			break
 		}
-		err := s.Send(datas)
+		newDatas, err := t.Transform(datas)
+		r.statsMux.Lock()
+		info := r.rs.TransformStats[t.Name()]
 		if se, ok := err.(*utils.StatsError); ok {
 			err = se.ErrorDetail
-			if se.Ft {
-				info.Errors = se.Errors
-				info.Success = se.Success
-				r.rs.Lag.Ftlags = se.Ftlag
-			} else {
-				info.Errors += se.Errors
-				info.Success += se.Success
-			}
+			info.Errors += se.Errors
+			info.Success += se.Success
 		} else if err != nil {
 			info.Errors++
 		} else {
 			info.Success++
 		}
+		r.rs.TransformStats[t.Name()] = info
+		r.statsMux.Unlock()
 		if err != nil {
-			log.Error(err)
-			time.Sleep(time.Second)
-			if times <= 0 || cnt < times {
-				cnt++
-				continue
-			}
-			log.Errorf("retry send %v times, but still error %v, discard datas %v ... total %v lines", cnt, err, datas[0], len(datas))
+			log.Errorf("runner %s, transformer %s error : %v ", r.Name(), t.Name(), err)
 		}
-		break
+		datas = newDatas
 	}
-	r.rs.SenderStats[s.Name()] = info
-	return true
+	return datas
 }
 
-func (r *LogExportRunner) Run() {
-	if r.cleaner != nil {
-		go r.cleaner.Run()
+// aggregate 聚合数据，返回已经结束的窗口的统计结果
+func (r *LogExportRunner) aggregate(datas []sender.Data) []sender.Data {
+	datas, err := r.aggregator.Aggregate(datas, time.Now())
+	if se, ok := err.(*utils.StatsError); ok {
+		err = se.ErrorDetail
+		r.statsMux.Lock()
+		r.rs.AggregatorStats.Errors += se.Errors
+		r.rs.AggregatorStats.Success += se.Success
+		r.statsMux.Unlock()
 	}
-	defer close(r.exitChan)
-	datasourceTag := r.meta.GetDataSourceTag()
-	for {
-		if atomic.LoadInt32(&r.stopped) > 0 {
-			log.Debugf("runner %v exited from run", r.RunnerName)
-			r.exitChan <- struct{}{}
-			return
-		}
-		// read data
-		var lines, froms []string
-		for !r.batchFullOrTimeout() {
-			line, err := r.reader.ReadLine()
-			if err != nil && err != io.EOF {
-				log.Warnf("runner %s, reader %s - error: %v", r.Name(), r.reader.Name(), err)
-				break
-			}
-			if len(line) <= 0 {
-				log.Debugf("runner %s, reader %s cannot get any content", r.Name(), r.reader.Name())
-				time.Sleep(2 * time.Second)
-				continue
-			}
-			if len(line) >= r.MaxBatchSize {
-				log.Errorf("runner %s, reader %s read lines larger than MaxBatchSize %v, content is %s", r.Name(), r.reader.Name(), r.MaxBatchSize, line)
-				continue
-			}
-			lines = append(lines, line)
-			if datasourceTag != "" {
-				froms = append(froms, r.reader.Source())
-			}
-			r.batchLen++
-			r.batchSize += len(line)
+	if err != nil {
+		log.Errorf("runner %s, aggregator error : %v ", r.Name(), err)
+	}
+	return datas
+}
+
+// diskPolicyConf 使用disk策略的sender开启fault_tolerant，没有配置磁盘队列路径时保存在defaultPath
+func diskPolicyConf(c conf.MapConf, defaultPath string) conf.MapConf {
+	if ft, _ := c.GetBoolOr(sender.KeyFaultTolerant, false); ft {
+		return c
+	}
+	nc := make(conf.MapConf, len(c)+2)
+	for k, v := range c {
+		nc[k] = v
+	}
+	nc[sender.KeyFaultTolerant] = "true"
+	if _, ok := nc[sender.KeyFtSaveLogPath]; !ok {
+		nc[sender.KeyFtSaveLogPath] = defaultPath
+	}
+	return nc
+}
+
+// parserSchema 把parser给出的字段类型按字段名排序后拼接成"name type,name type"的形式
+func parserSchema(p parser.LogParser) string {
+	sp, ok := p.(parser.SchemaParser)
+	if !ok {
+		return ""
+	}
+	schema := sp.Schema()
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]string, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, name+" "+schema[name])
+	}
+	return strings.Join(fields, ",")
+}
+
+// senderSchema 返回交给sender的字段类型。transformer和聚合会改变字段名和类型，配置了它们时不再使用parser给出的类型
+func senderSchema(rc RunnerConfig, p parser.LogParser) string {
+	if len(rc.Transforms) > 0 || len(rc.Aggregator) > 0 {
+		return ""
+	}
+	return parserSchema(p)
+}
+
+// parserSchemaConf 把parser的字段类型填入sender的配置，用户配置了parser_schema时以用户的配置为准
+func parserSchemaConf(c conf.MapConf, schema string) conf.MapConf {
+	if schema == "" {
+		return c
+	}
+	if _, ok := c[sender.KeyParserSchema]; ok {
+		return c
+	}
+	nc := make(conf.MapConf, len(c)+1)
+	for k, v := range c {
+		nc[k] = v
+	}
+	nc[sender.KeyParserSchema] = schema
+	return nc
+}
+
+// syncMeta 同步读取位置，同时保存聚合中的窗口，两者需要保持一致
+func (r *LogExportRunner) syncMeta() {
+	r.reader.SyncMeta()
+	if r.aggregator != nil {
+		if err := r.aggregator.Save(); err != nil {
+			log.Errorf("runner %s, save aggregator windows error : %v ", r.Name(), err)
 		}
-		r.batchLen = 0
-		r.batchSize = 0
-		r.lastSend = time.Now()
+	}
+}
+
+type lineOffset struct {
+	source string
+	offset int64
+}
+
+// currentOffset 返回刚读取的一行数据所在的文件和结束位置
+func (r *LogExportRunner) currentOffset() lineOffset {
+	if or, ok := r.reader.(reader.OffsetReader); ok {
+		source, offset := or.Offset()
+		return lineOffset{source: source, offset: offset}
+	}
+	return lineOffset{source: r.reader.Source()}
+}
 
-		if len(lines) <= 0 {
-			log.Debug("runner fetched 0 lines")
+// addDeadLetters 把解析失败的原始数据写入dead letter
+func (r *LogExportRunner) addDeadLetters(lines []string, offsets []lineOffset, se *utils.StatsError) {
+	if len(se.ErrorIndex) <= 0 {
+		return
+	}
+	now := time.Now()
+	letters := make([]DeadLetter, 0, len(se.ErrorIndex))
+	for _, idx := range se.ErrorIndex {
+		if idx < 0 || idx >= len(lines) {
 			continue
 		}
-		// parse data
-		datas, err := r.parser.Parse(lines)
-		se, ok := err.(*utils.StatsError)
-		if ok {
+		l := DeadLetter{
+			Raw:    r.maskRaw(lines[idx]),
+			Parser: r.parser.Name(),
+			Time:   now,
+		}
+		if err := se.IndexErrors[idx]; err != nil {
+			l.Error = err.Error()
+		} else if se.ErrorDetail != nil {
+			l.Error = se.ErrorDetail.Error()
+		}
+		if idx < len(offsets) {
+			l.Source = offsets[idx].source
+			l.Offset = offsets[idx].offset
+		}
+		letters = append(letters, l)
+	}
+	r.deadLetter.Add(letters)
+}
+
+// maskRaw 原始数据没有经过transformer，使用配置的脱敏transformer处理后再写入dead letter
+func (r *LogExportRunner) maskRaw(raw string) string {
+	for _, t := range r.transformers {
+		if m, ok := t.(transforms.RawMasker); ok {
+			raw = m.MaskRaw(raw)
+		}
+	}
+	return raw
+}
+
+// addSendDeadLetters 把发送时遇到永久错误的数据写入dead letter，没有配置dead letter时丢弃
+func (r *LogExportRunner) addSendDeadLetters(s sender.Sender, datas []sender.Data, err error) {
+	if r.deadLetter == nil {
+		log.Errorf("runner %s has no dead letter, discard %v datas which sender %s cannot send", r.Name(), len(datas), s.Name())
+		return
+	}
+	now := time.Now()
+	letters := make([]DeadLetter, 0, len(datas))
+	for _, d := range datas {
+		raw, merr := json.Marshal(d)
+		if merr != nil {
+			raw = []byte(fmt.Sprint(d))
+		}
+		letters = append(letters, DeadLetter{
+			Raw:    string(raw),
+			Sender: s.Name(),
+			Error:  err.Error(),
+			Time:   now,
+		})
+	}
+	r.deadLetter.Add(letters)
+}
+
+// DeadLetters 返回最近n条解析或发送失败的数据，没有配置dead letter时返回空
+func (r *LogExportRunner) DeadLetters(n int) []DeadLetter {
+	if r.deadLetter == nil {
+		return []DeadLetter{}
+	}
+	return r.deadLetter.Recent(n)
+}
+
+// route 返回第i个sender需要发送的数据
+func (r *LogExportRunner) route(i int, datas []sender.Data) []sender.Data {
+	if i >= len(r.routes) || r.routes[i] == nil {
+		return datas
+	}
+	return r.routes[i].Filter(datas)
+}
+
+// trySend 尝试发送数据，失败时按指数退避只重试失败的数据，熔断期间只按间隔探测。
+// 因为过大等原因被整体拒绝的数据会被拆成两半分别发送，直到找出有问题的数据。
+// 如果此时runner退出返回false，其他情况无论是达到最大重试次数、遇到永久错误还是发送成功，都返回true
+func (r *LogExportRunner) trySend(w *senderWorker, datas []sender.Data) bool {
+	if len(datas) <= 0 {
+		return true
+	}
+	s := w.sender
+	sm := r.metrics.Senders[s.Name()]
+	times := r.MaxBatchTryTimes
+	// 待发送的数据，拆分后的数据从栈顶依次发送，保持原来的顺序
+	pending := [][]sender.Data{datas}
+	cnt := 1
+	for len(pending) > 0 {
+		batch := pending[len(pending)-1]
+		// 至少尝试一次。如果任务已经停止，那么只尝试一次
+		if cnt > 1 && atomic.LoadInt32(&r.stopped) > 0 {
+			return false
+		}
+		// 熔断期间等待的时间不计入重试次数
+		if wait := w.breaker.Wait(); wait > 0 {
+			if !r.sleep(wait) {
+				return false
+			}
+			continue
+		}
+		start := time.Now()
+		err := s.Send(batch)
+		if sm != nil {
+			sm.Latency.Observe(time.Since(start).Seconds())
+		}
+		if se, ok := err.(*utils.StatsError); ok {
 			err = se.ErrorDetail
-			r.rs.ParserStats.Errors += se.Errors
-			r.rs.ParserStats.Success += se.Success
+			errs, success := se.Errors, se.Success
+			if se.Ft {
+				errs, success = r.ftStatsDelta(s.Name(), se)
+				if sm != nil {
+					sm.FtQueueDepth.Set(float64(se.Ftlag))
+					sm.FtQueueBytes.Set(float64(se.FtBytes))
+				}
+			}
+			r.addSenderStats(s.Name(), errs, success)
+			if sm != nil {
+				sm.Errors.Add(errs)
+				sm.Success.Add(success)
+			}
 		} else if err != nil {
-			r.rs.ParserStats.Errors++
+			r.addSenderStats(s.Name(), 1, 0)
+			if sm != nil {
+				sm.Errors.Inc()
+			}
 		} else {
-			r.rs.ParserStats.Success++
+			r.addSenderStats(s.Name(), 0, 1)
+			if sm != nil {
+				sm.Success.Inc()
+			}
 		}
+		// 发送成功的数据条数只在这里计入速度，部分失败时只计成功的部分
+		var failDatas, permanentDatas []sender.Data
 		if err != nil {
-			log.Errorf("runner %s, parser %s error : %v ", r.Name(), r.parser.Name(), err.Error())
+			failDatas = sender.FailDatas(err, batch)
+			permanentDatas = sender.PermanentDatas(err)
 		}
-		// send data
-		if len(datas) <= 0 {
-			log.Debug("runner received parsed data length = 0")
+		if sent := len(batch) - len(failDatas) - len(permanentDatas); sent > 0 {
+			r.speed.sent(s.Name(), int64(sent))
+		}
+		// 可以重试的错误中附带的永久失败的数据不再重试
+		if len(permanentDatas) > 0 {
+			log.Errorf("runner %s, sender %s %v datas will not be retried: %v", r.Name(), s.Name(), len(permanentDatas), err)
+			r.addSendDeadLetters(s, permanentDatas, err)
+		}
+		if err == nil {
+			w.breaker.Success()
+			pending = pending[:len(pending)-1]
+			cnt = 1
 			continue
 		}
-		//把datasourcetag加到data里，前提是认为[]line变成[]data以后是一一对应的，一旦错位就不加
-
-		if datasourceTag != "" {
-			if len(datas)+len(se.ErrorIndex) == len(froms) {
-				var j int = 0
-				for i, v := range froms {
-					if se.ErrorIndexIn(i) {
-						continue
-					}
-					if j >= len(datas) {
-						continue
-					}
-					if dt, ok := datas[j][datasourceTag]; ok {
-						log.Debugf("%v datasource tag already has data %v, ignore %v", r.Name(), dt, v)
-					} else {
-						datas[j][datasourceTag] = v
-					}
-				}
-			} else {
-				log.Errorf("%v datasourcetag add error, datas %v not match with froms %v", r.Name(), datas, froms)
+		// 永久错误与被拒绝的数据说明sender本身可以正常处理请求，不计入熔断
+		if sender.IsPermanentError(err) {
+			log.Errorf("runner %s, sender %s permanent error %v, %v datas will not be retried", r.Name(), s.Name(), err, len(failDatas))
+			r.addSendDeadLetters(s, failDatas, err)
+			pending = pending[:len(pending)-1]
+			cnt = 1
+			continue
+		}
+		if sender.NeedSplit(err) {
+			pending = pending[:len(pending)-1]
+			cnt = 1
+			if len(failDatas) <= 1 {
+				log.Errorf("runner %s, sender %s rejected datas %v: %v", r.Name(), s.Name(), failDatas, err)
+				r.addSendDeadLetters(s, failDatas, err)
+				continue
 			}
+			half := len(failDatas) / 2
+			pending = append(pending, failDatas[half:], failDatas[:half])
+			continue
 		}
-		success := true
-		for _, s := range r.senders {
-			if !r.trySend(s, datas, r.MaxBatchTryTimes) {
-				success = false
-				break
+		w.breaker.Failure()
+		log.Error(err)
+		if times <= 0 || cnt < times {
+			if !r.sleep(w.policy.backoff.Duration(cnt)) {
+				return false
 			}
+			// 只重试失败的数据
+			if len(failDatas) > 0 {
+				pending[len(pending)-1] = failDatas
+			}
+			cnt++
+			continue
 		}
-		if success {
-			r.reader.SyncMeta()
+		log.Errorf("retry send %v times, but still error %v, discard datas %v ... total %v lines", cnt, err, batch[0], len(failDatas))
+		pending = pending[:len(pending)-1]
+		cnt = 1
+	}
+	if sm != nil {
+		sm.BreakerOpen.Set(0)
+		if w.breaker.State() != utils.BreakerClosed {
+			sm.BreakerOpen.Set(1)
 		}
 	}
+	return true
+}
+
+// addSenderStats 累加sender的统计，与drop等其他地方的更新互不覆盖
+func (r *LogExportRunner) addSenderStats(name string, errs, success int64) {
+	r.statsMux.Lock()
+	defer r.statsMux.Unlock()
+	info := r.rs.SenderStats[name]
+	info.Errors += errs
+	info.Success += success
+	r.rs.SenderStats[name] = info
+}
+
+// ftStatsDelta 带磁盘队列的sender返回的是累计的统计，换算成与上一次相比的增量，并更新磁盘队列的积压
+func (r *LogExportRunner) ftStatsDelta(name string, se *utils.StatsError) (errs, success int64) {
+	r.statsMux.Lock()
+	defer r.statsMux.Unlock()
+	if r.ftStats == nil {
+		r.ftStats = make(map[string]utils.StatsInfo)
+	}
+	last := r.ftStats[name]
+	r.ftStats[name] = utils.StatsInfo{Errors: se.Errors, Success: se.Success}
+	r.rs.Lag.Ftlags = se.Ftlag
+	return se.Errors - last.Errors, se.Success - last.Success
+}
+
+// sleep 等待d，runner退出时提前返回false
+func (r *LogExportRunner) sleep(d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for {
+		if atomic.LoadInt32(&r.stopped) > 0 {
+			return false
+		}
+		remain := deadline.Sub(time.Now())
+		if remain <= 0 {
+			return true
+		}
+		if remain > 100*time.Millisecond {
+			remain = 100 * time.Millisecond
+		}
+		time.Sleep(remain)
+	}
 }
 
 func (r *LogExportRunner) Stop() {
@@ -366,6 +733,19 @@ func (r *LogExportRunner) Stop() {
 			log.Warnf("sender %v of runner %v closed", s.Name(), r.Name())
 		}
 	}
+	for _, t := range r.transformers {
+		// 加载了本地数据库的transformer需要关闭
+		if c, ok := t.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Errorf("cannot close transformer name: %s, err: %v", t.Name(), err)
+			}
+		}
+	}
+	if r.deadLetter != nil {
+		if err := r.deadLetter.Close(); err != nil {
+			log.Errorf("cannot close dead letter of runner %v, err: %v", r.Name(), err)
+		}
+	}
 	if r.cleaner != nil {
 		r.cleaner.Close()
 	}
@@ -408,58 +788,101 @@ func (r *LogExportRunner) batchFullOrTimeout() bool {
 	return false
 }
 
-func (r *LogExportRunner) LagStats() (rl RunnerLag, err error) {
-	mf := r.meta.MetaFile()
-
-	bd, err := ioutil.ReadFile(mf)
-	if err != nil {
-		log.Errorf("Read meta File err %v", err)
-		return
+// batchFill 返回当前batch达到batch_len或batch_size的比例，取两者中较大的
+func (r *LogExportRunner) batchFill() float64 {
+	var fill float64
+	if r.MaxBatchLen > 0 {
+		fill = float64(r.batchLen) / float64(r.MaxBatchLen)
 	}
-	ss := strings.Split(strings.TrimSpace(string(bd)), "\t")
-	if len(ss) != 2 {
-		err = fmt.Errorf("metafile format err %v", ss)
-		log.Error(err)
-		return
+	if r.MaxBatchSize > 0 {
+		if f := float64(r.batchSize) / float64(r.MaxBatchSize); f > fill {
+			fill = f
+		}
 	}
-	logreading, logsize := ss[0], ss[1]
-	size, err := strconv.ParseInt(logsize, 10, 64)
-	if err != nil {
-		log.Errorf("parse log meta error %v", err)
+	return fill
+}
+
+// LagStats 返回reader的读取延迟，reader没有实现reader.LagReporter时返回reader.ErrLagNotSupported
+func (r *LogExportRunner) LagStats() (rl RunnerLag, err error) {
+	lr, ok := r.reader.(reader.LagReporter)
+	if !ok {
+		err = reader.ErrLagNotSupported
 		return
 	}
-
-	logs, err := utils.ReadDirByTime(r.meta.LogPath())
+	info, err := lr.Lag()
 	if err != nil {
-		log.Errorf("ReadDirByTime err %v", err)
+		log.Errorf("runner %v get lag of reader %v error %v", r.Name(), r.reader.Name(), err)
 		return
 	}
-	logreading = filepath.Base(logreading)
-	rl = RunnerLag{Files: 0, Size: -size}
-	for _, l := range logs {
-		if l.IsDir() {
-			continue
-		}
-		rl.Size += l.Size()
-		if l.Name() == logreading {
-			break
-		}
-		rl.Files++
+	rl = RunnerLag{
+		Size:    info.Size,
+		Files:   info.Files,
+		Unit:    info.SizeUnit,
+		Details: info.Details,
 	}
 	return
 }
 
+// Metrics 返回runner的监控指标，lag是当前的状态，在调用时更新
+func (r *LogExportRunner) Metrics() *RunnerMetrics {
+	if rl, err := r.LagStats(); err == nil {
+		r.metrics.setLag(rl)
+	}
+	if r.deadLetter != nil {
+		r.metrics.DeadLetterDrops.Set(r.deadLetter.Dropped())
+	}
+	return r.metrics
+}
+
 func (r *LogExportRunner) Status() RunnerStatus {
+	speed := r.speed.speed()
+	senderSpeeds := r.speed.senderSpeeds()
+	delay, hasDelay := r.speed.eventDelay()
+	// lag可能需要访问数据源，不在锁中计算
+	rl, lagErr := r.LagStats()
+	counts := make(map[string]map[string]int64)
+	for _, t := range r.transformers {
+		if c, ok := t.(transforms.Counter); ok {
+			counts[t.Name()] = c.Counts()
+		}
+	}
+
+	r.statsMux.Lock()
+	defer r.statsMux.Unlock()
 	r.rs.Name = r.RunnerName
 	r.rs.Logpath = r.meta.LogPath()
-	rl, err := r.LagStats()
-	if err != nil {
-		r.rs.Error = err
-		return r.rs
+	r.rs.Speed = speed
+	r.rs.SenderSpeeds = senderSpeeds
+	if hasDelay {
+		r.rs.EventDelay = &delay
+	}
+	if r.deadLetter != nil {
+		r.rs.DeadLetterDrops = r.deadLetter.Dropped()
 	}
+	if lagErr != nil && lagErr != reader.ErrLagNotSupported {
+		r.rs.Error = lagErr
+		return r.copyStatus()
+	}
+	r.rs.Error = nil
+	rl.Ftlags = r.rs.Lag.Ftlags
 	r.rs.Lag = rl
-	//self assign
-	//r.rs.ParserStats = r.rs.ParserStats
-	//r.rs.SenderStats = r.rs.SenderStats
-	return r.rs
+	r.metrics.setLag(rl)
+	if len(counts) > 0 {
+		r.rs.TransformCounts = counts
+	}
+	return r.copyStatus()
+}
+
+// copyStatus 复制rs，返回后各个阶段继续更新统计不会影响调用方，需要持有statsMux
+func (r *LogExportRunner) copyStatus() RunnerStatus {
+	rs := r.rs
+	rs.TransformStats = make(map[string]utils.StatsInfo, len(r.rs.TransformStats))
+	for k, v := range r.rs.TransformStats {
+		rs.TransformStats[k] = v
+	}
+	rs.SenderStats = make(map[string]utils.StatsInfo, len(r.rs.SenderStats))
+	for k, v := range r.rs.SenderStats {
+		rs.SenderStats[k] = v
+	}
+	return rs
 }
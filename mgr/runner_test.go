@@ -13,6 +13,8 @@ import (
 	"github.com/qiniu/logkit/parser"
 	"github.com/qiniu/logkit/reader"
 	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/transforms"
+	"github.com/qiniu/logkit/utils"
 
 	"github.com/qiniu/log"
 	"github.com/stretchr/testify/assert"
@@ -162,3 +164,104 @@ func Test_Run(t *testing.T) {
 		assert.Equal(t, absLogpath, dt["testtag"])
 	}
 }
+
+func Test_RunnerTransform(t *testing.T) {
+	tr := transforms.NewTransformerRegistry()
+	var transformers []transforms.Transformer
+	for _, c := range []conf.MapConf{
+		{"type": "drop_if", "key": "level", "drop_if_value": "DEBUG"},
+		{"type": "convert", "key": "status", "convert_to": "long"},
+		{"type": "rename", "key": "status", "new": "code"},
+	} {
+		trans, err := tr.NewTransformer(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transformers = append(transformers, trans)
+	}
+	r := &LogExportRunner{
+		transformers: transformers,
+		rs: RunnerStatus{
+			TransformStats: make(map[string]utils.StatsInfo),
+		},
+	}
+	datas := r.transform([]sender.Data{
+		{"level": "DEBUG", "status": "200"},
+		{"level": "INFO", "status": "200"},
+		{"level": "INFO", "status": "xx"},
+	})
+	assert.Equal(t, []sender.Data{
+		{"level": "INFO", "code": int64(200)},
+		{"level": "INFO", "code": "xx"},
+	}, datas)
+	exp := map[string]utils.StatsInfo{
+		"drop_if:level":  {Success: 3},
+		"convert:status": {Success: 1, Errors: 1},
+		"rename:status":  {Success: 2},
+	}
+	assert.Equal(t, exp, r.rs.TransformStats)
+}
+
+func Test_RunnerRoute(t *testing.T) {
+	route, err := transforms.NewExpr(`status >= 500`)
+	assert.NoError(t, err)
+	r := &LogExportRunner{
+		routes: []*transforms.Expr{nil, route},
+	}
+	datas := []sender.Data{{"status": 200}, {"status": "502"}}
+	assert.Equal(t, datas, r.route(0, datas))
+	assert.Equal(t, []sender.Data{{"status": "502"}}, r.route(1, datas))
+	assert.Equal(t, datas, r.route(2, datas))
+}
+
+func Test_SpeedTrackerDelay(t *testing.T) {
+	s, err := sender.NewDiscardSender(nil)
+	assert.NoError(t, err)
+	st := newSpeedTracker([]sender.Sender{s})
+	_, ok := st.eventDelay()
+	assert.False(t, ok)
+	st.timeKey = "time"
+	now := time.Unix(1500000100, 0)
+	st.observeDelay([]sender.Data{
+		{"time": time.Unix(1500000000, 0)},
+		{"time": "2017-07-14T02:40:50Z"},
+		{"time": "invalid"},
+		{"other": 1},
+	}, now)
+	delay, ok := st.eventDelay()
+	assert.True(t, ok)
+	assert.Equal(t, float64(75), delay)
+
+	st.sent(s.Name(), 3)
+	speeds := st.senderSpeeds()
+	assert.NotNil(t, speeds[s.Name()].LastSuccess)
+	assert.True(t, speeds[s.Name()].Lines > 0)
+}
+
+func Test_parserSchemaConf(t *testing.T) {
+	ps := parser.NewParserRegistry()
+	p, err := ps.NewLogParser(conf.MapConf{
+		"type":       "csv",
+		"csv_schema": "status long, cost float, msg string",
+	})
+	assert.NoError(t, err)
+	schema := parserSchema(p)
+	assert.Equal(t, "cost float,msg string,status long", schema)
+	assert.Equal(t, schema, senderSchema(RunnerConfig{}, p))
+	// transformer和聚合可能改变字段名和类型
+	assert.Equal(t, "", senderSchema(RunnerConfig{Transforms: []conf.MapConf{{"type": "rename"}}}, p))
+	assert.Equal(t, "", senderSchema(RunnerConfig{Aggregator: conf.MapConf{"agg_interval": "60"}}, p))
+
+	c := conf.MapConf{sender.KeySenderType: sender.TypeElastic}
+	nc := parserSchemaConf(c, schema)
+	assert.Equal(t, schema, nc[sender.KeyParserSchema])
+	// 不修改原来的配置，用户的配置优先
+	_, ok := c[sender.KeyParserSchema]
+	assert.False(t, ok)
+	c[sender.KeyParserSchema] = "status string"
+	assert.Equal(t, "status string", parserSchemaConf(c, schema)[sender.KeyParserSchema])
+
+	raw, err := ps.NewLogParser(conf.MapConf{"type": "raw"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", parserSchema(raw))
+}
@@ -0,0 +1,119 @@
+package mgr
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/transforms"
+	"github.com/qiniu/logkit/utils"
+)
+
+// 计算速度的时间窗口
+const speedWindow = time.Minute
+
+// RunnerSpeed runner最近一分钟每秒的平均速度
+type RunnerSpeed struct {
+	ReadLines  float64 `json:"readLines"`
+	ReadBytes  float64 `json:"readBytes"`
+	ParseLines float64 `json:"parseLines"` // 解析成功的条数
+}
+
+// SenderSpeed sender最近一分钟每秒发送成功的平均条数以及最后一次发送成功的时间
+type SenderSpeed struct {
+	Lines       float64    `json:"lines"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+}
+
+type senderSpeedTracker struct {
+	lines       *utils.RateCounter
+	lastSuccess int64 // unix纳秒，原子操作
+}
+
+// speedTracker 统计runner的速度以及日志从产生到发送的延迟，可以在Status中并发读取
+type speedTracker struct {
+	readLines  *utils.RateCounter
+	readBytes  *utils.RateCounter
+	parseLines *utils.RateCounter
+	senders    map[string]*senderSpeedTracker // 创建runner时初始化，之后不再修改
+	timeKey    string
+	delay      uint64 // 最近一次发送的数据的平均延迟，单位秒，float64的bits
+	hasDelay   int32
+}
+
+func newSpeedTracker(senders []sender.Sender) *speedTracker {
+	st := &speedTracker{
+		readLines:  utils.NewRateCounter(speedWindow),
+		readBytes:  utils.NewRateCounter(speedWindow),
+		parseLines: utils.NewRateCounter(speedWindow),
+		senders:    make(map[string]*senderSpeedTracker),
+	}
+	for _, s := range senders {
+		st.senders[s.Name()] = &senderSpeedTracker{lines: utils.NewRateCounter(speedWindow)}
+	}
+	return st
+}
+
+func (st *speedTracker) sent(name string, success int64) {
+	ss, ok := st.senders[name]
+	if !ok || success <= 0 {
+		return
+	}
+	ss.lines.Add(success)
+	atomic.StoreInt64(&ss.lastSuccess, time.Now().UnixNano())
+}
+
+// observeDelay 根据timestamp_key计算数据从产生到now的平均延迟
+func (st *speedTracker) observeDelay(datas []sender.Data, now time.Time) {
+	if st.timeKey == "" {
+		return
+	}
+	var total float64
+	var cnt int
+	for _, d := range datas {
+		v, ok := d[st.timeKey]
+		if !ok {
+			continue
+		}
+		t, err := transforms.ToTime(v)
+		if err != nil {
+			continue
+		}
+		total += now.Sub(t).Seconds()
+		cnt++
+	}
+	if cnt > 0 {
+		atomic.StoreUint64(&st.delay, math.Float64bits(total/float64(cnt)))
+		atomic.StoreInt32(&st.hasDelay, 1)
+	}
+}
+
+func (st *speedTracker) speed() RunnerSpeed {
+	return RunnerSpeed{
+		ReadLines:  st.readLines.Rate(),
+		ReadBytes:  st.readBytes.Rate(),
+		ParseLines: st.parseLines.Rate(),
+	}
+}
+
+func (st *speedTracker) senderSpeeds() map[string]SenderSpeed {
+	speeds := make(map[string]SenderSpeed, len(st.senders))
+	for name, ss := range st.senders {
+		speed := SenderSpeed{Lines: ss.lines.Rate()}
+		if ns := atomic.LoadInt64(&ss.lastSuccess); ns > 0 {
+			t := time.Unix(0, ns)
+			speed.LastSuccess = &t
+		}
+		speeds[name] = speed
+	}
+	return speeds
+}
+
+// eventDelay 返回最近一次发送的数据的平均延迟，没有配置timestamp_key或者还没有发送过数据时返回false
+func (st *speedTracker) eventDelay() (float64, bool) {
+	if atomic.LoadInt32(&st.hasDelay) <= 0 {
+		return 0, false
+	}
+	return math.Float64frombits(atomic.LoadUint64(&st.delay)), true
+}
@@ -0,0 +1,145 @@
+package mgr
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/transforms"
+)
+
+var validRunnerName = regexp.MustCompile(`^[a-zA-Z0-9_.\-]+$`)
+
+var supportedReaderModes = map[string]bool{
+	reader.ModeDir:     true,
+	reader.ModeFile:    true,
+	reader.ModeTailx:   true,
+	reader.ModeMysql:   true,
+	reader.ModeMssql:   true,
+	reader.ModeElastic: true,
+	reader.ModeMongo:   true,
+	reader.ModeKafka:   true,
+}
+
+// FieldError 某个配置项的错误，Field 为配置项的路径，如 senders[0].sender_type
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// ValidationError runner配置校验失败，包含所有出错的配置项
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Error)
+	}
+	return "invalid runner config, " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field string, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Error: fmt.Sprintf(format, args...)})
+}
+
+// ValidateRunnerConfig 在启动runner之前校验配置，只创建parser和transformer，不会创建reader和sender，校验失败时返回*ValidationError
+func (m *Manager) ValidateRunnerConfig(rc RunnerConfig) error {
+	ve := &ValidationError{}
+	if rc.RunnerName == "" {
+		ve.add("name", "runner name can not be empty")
+	} else if !validRunnerName.MatchString(rc.RunnerName) {
+		ve.add("name", "runner name %v can only contain letters, digits, '_', '-' and '.'", rc.RunnerName)
+	}
+	if rc.MaxBatchLen < 0 {
+		ve.add("batch_len", "can not be negative")
+	}
+	if rc.MaxBatchSize < 0 {
+		ve.add("batch_size", "can not be negative")
+	}
+	if rc.MaxBatchInteval < 0 {
+		ve.add("batch_interval", "can not be negative")
+	}
+	if rc.ParseWorkers < 0 {
+		ve.add("parse_workers", "can not be negative")
+	}
+
+	if len(rc.ReaderConfig) <= 0 {
+		ve.add("reader", "reader config can not be empty")
+	} else {
+		mode, _ := rc.ReaderConfig.GetStringOr(reader.KeyMode, reader.ModeDir)
+		if !supportedReaderModes[mode] {
+			ve.add("reader."+reader.KeyMode, "mode %v not supported", mode)
+		}
+		if mode == reader.ModeDir || mode == reader.ModeFile || mode == reader.ModeTailx {
+			if _, err := rc.ReaderConfig.GetString(reader.KeyLogPath); err != nil {
+				ve.add("reader."+reader.KeyLogPath, "is required in %v mode", mode)
+			}
+		}
+	}
+
+	if len(rc.ParserConf) <= 0 {
+		ve.add("parser", "parser config can not be empty")
+	} else if _, err := m.pregistry.NewLogParser(rc.ParserConf); err != nil {
+		ve.add("parser", "%v", err)
+	}
+
+	if rc.Filter != "" {
+		if _, err := transforms.NewExpr(rc.Filter); err != nil {
+			ve.add("filter", "%v", err)
+		}
+	}
+	for i, c := range rc.Transforms {
+		t, err := m.tregistry.NewTransformer(c)
+		if err != nil {
+			ve.add(fmt.Sprintf("transforms[%d]", i), "%v", err)
+			continue
+		}
+		if closer, ok := t.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	if len(rc.Aggregator) > 0 {
+		if _, err := transforms.NewAggregator(rc.Aggregator, ""); err != nil {
+			ve.add("aggregator", "%v", err)
+		}
+	}
+	if len(rc.DeadLetter) > 0 {
+		path, _ := rc.DeadLetter.GetStringOr(KeyDeadLetterPath, "")
+		senderType, _ := rc.DeadLetter.GetStringOr(sender.KeySenderType, "")
+		if path == "" && senderType == "" {
+			ve.add("dead_letter", "one of %v and %v is required", KeyDeadLetterPath, sender.KeySenderType)
+		} else if path == "" && !m.sregistry.HasType(senderType) {
+			ve.add("dead_letter."+sender.KeySenderType, "sender type %v not supported", senderType)
+		}
+	}
+
+	if len(rc.SenderConfig) <= 0 {
+		ve.add("senders", "senders can not be empty")
+	}
+	for i, c := range rc.SenderConfig {
+		field := fmt.Sprintf("senders[%d]", i)
+		senderType, err := c.GetString(sender.KeySenderType)
+		if err != nil {
+			ve.add(field+"."+sender.KeySenderType, "is required")
+		} else if !m.sregistry.HasType(senderType) {
+			ve.add(field+"."+sender.KeySenderType, "sender type %v not supported", senderType)
+		}
+		if cond, _ := c.GetStringOr(sender.KeyRouteIf, ""); cond != "" {
+			if _, err := transforms.NewExpr(cond); err != nil {
+				ve.add(field+"."+sender.KeyRouteIf, "%v", err)
+			}
+		}
+		if _, err := newSenderPolicy(c); err != nil {
+			ve.add(field+"."+sender.KeyPolicy, "%v", err)
+		}
+	}
+	if len(ve.Fields) > 0 {
+		return ve
+	}
+	return nil
+}
@@ -89,6 +89,21 @@ func NewCsvParser(c conf.MapConf) (LogParser, error) {
 	}, nil
 }
 
+// Schema 实现SchemaParser，jsonmap类型的列不在其中
+func (p *CsvParser) Schema() map[string]string {
+	schema := make(map[string]string)
+	for _, f := range p.schema {
+		if f.dataType == TypeJsonMap {
+			continue
+		}
+		schema[f.name] = string(f.dataType)
+	}
+	for _, l := range p.labels {
+		schema[l.name] = string(TypeString)
+	}
+	return schema
+}
+
 func parseSchemaFieldList(schema string) (fieldList []string, err error) {
 	fieldList = make([]string, 0)
 	schema = strings.TrimSpace(schema)
@@ -366,7 +381,7 @@ func (p *CsvParser) Parse(lines []string) ([]sender.Data, error) {
 		if err != nil {
 			p.schemaErr.Output(err)
 			se.AddErrors()
-			se.ErrorIndex = append(se.ErrorIndex, idx)
+			se.AddErrorIndex(idx, err)
 			continue
 		}
 		datas = append(datas, d)
@@ -213,3 +213,13 @@ func Test_convertValue(t *testing.T) {
 		convertValue(v, "jsonmap")
 	}
 }
+
+func Test_CsvParserSchema(t *testing.T) {
+	p, err := NewCsvParser(conf.MapConf{
+		KeyParserName: "testparser",
+		KeyCSVSchema:  "a long, b string, c float, d jsonmap{x long}",
+		KeyLabels:     "e nb1684",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "long", "b": "string", "c": "float", "e": "string"}, p.(SchemaParser).Schema())
+}
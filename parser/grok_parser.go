@@ -233,6 +233,23 @@ func (gp *GrokParser) Name() string {
 	return gp.name
 }
 
+// Schema 实现SchemaParser，只包含patterns中指定了类型的字段，没有指定类型的字段由sender自行处理
+func (gp *GrokParser) Schema() map[string]string {
+	schema := make(map[string]string)
+	for _, pattern := range gp.namedPatterns {
+		for k, t := range gp.typeMap[pattern] {
+			if _, ok := schema[k]; ok || t == DROP {
+				continue
+			}
+			schema[k] = t
+		}
+	}
+	for _, l := range gp.labels {
+		schema[l.name] = STRING
+	}
+	return schema
+}
+
 func (gp *GrokParser) Parse(lines []string) ([]sender.Data, error) {
 	datas := []sender.Data{}
 	se := &utils.StatsError{}
@@ -241,7 +258,7 @@ func (gp *GrokParser) Parse(lines []string) ([]sender.Data, error) {
 		if err != nil {
 			gp.schemaErr.Output(err)
 			se.AddErrors()
-			se.ErrorIndex = append(se.ErrorIndex, idx)
+			se.AddErrorIndex(idx, err)
 			continue
 		}
 		if len(data) < 1 { //数据不为空的时候发送
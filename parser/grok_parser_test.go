@@ -623,3 +623,15 @@ func TestParseMultiLine(t *testing.T) {
 			"message":   "pid 4109 script_filename = /data/html/log.ushengsheng.com/index.php [0x00007fec119d1720] curl_exec() /data/html/xyframework/base/XySoaClient.php:357 [0x00007fec119d1590] request_post() /data/html/xyframework/base/XySoaClient.php:284 [0x00007fff39d538b0] __call() unknown:0 [0x00007fec119d13a8] add() /data/html/log.ushengsheng.com/1/interface/ErrorLogInterface.php:70 [0x00007fec119d1298] log() /data/html/log.ushengsheng.com/1/interface/ErrorLogInterface.php:30 [0x00007fec119d1160] android() /data/html/xyframework/core/x.php:215 [0x00007fec119d0ff8] +++ dump failed",
 		}, data)
 }
+
+func TestGrokSchema(t *testing.T) {
+	p := &GrokParser{
+		Patterns: []string{"%{TESTLOG}"},
+		CustomPatterns: `
+			TESTLOG %{NUMBER:num:long} %{WORD:client} %{NUMBER:cost:float} %{HTTPDATE:ts:date} %{WORD:x:drop}
+		`,
+		labels: []label{{name: "host", dataValue: "h1"}},
+	}
+	assert.NoError(t, p.compile())
+	assert.Equal(t, map[string]string{"num": LONG, "cost": FLOAT, "ts": DATE, "host": STRING}, p.Schema())
+}
@@ -44,7 +44,7 @@ func (im *JsonParser) Parse(lines []string) ([]sender.Data, error) {
 		if err != nil {
 			im.schemaErr.Output(err)
 			se.AddErrors()
-			se.ErrorIndex = append(se.ErrorIndex, idx)
+			se.AddErrorIndex(idx, err)
 			continue
 		}
 		datas = append(datas, data)
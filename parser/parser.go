@@ -15,11 +15,18 @@ type LogParser interface {
 	Parse(lines []string) (datas []sender.Data, err error)
 }
 
+// SchemaParser 解析结果中字段类型确定的parser，返回字段名到类型的映射，类型为long、float、string、date。
+// runner把字段类型交给sender，例如elasticsearch sender据此生成索引模板
+type SchemaParser interface {
+	Schema() map[string]string
+}
+
 // conf 字段
 const (
-	KeyParserName = utils.GlobalKeyName
-	KeyParserType = "type"
-	KeyLabels     = "labels" // 额外增加的标签信息，比如机器信息等
+	KeyParserName   = utils.GlobalKeyName
+	KeyParserType   = "type"
+	KeyLabels       = "labels"        // 额外增加的标签信息，比如机器信息等
+	KeyTimestampKey = "timestamp_key" // 解析结果中表示日志产生时间的字段，配置后runner会统计日志从产生到发送的延迟
 )
 
 // parser 的类型
@@ -301,7 +301,7 @@ func (p *QiniulogParser) Parse(lines []string) ([]sender.Data, error) {
 				err := fmt.Errorf("QiniulogParser can not parse [%v] as newline", line)
 				se.ErrorDetail = err
 				se.AddErrors()
-				se.ErrorIndex = append(se.ErrorIndex, idx)
+				se.AddErrorIndex(idx, err)
 				return datas, se
 			}
 			p.lastline += line
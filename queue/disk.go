@@ -29,6 +29,7 @@ type diskQueue struct {
 	readFileNum  int64
 	writeFileNum int64
 	depth        int64
+	bytes        int64 // 队列中未被消费的数据大小，不持久化，启动时根据数据文件计算
 
 	sync.RWMutex
 
@@ -49,6 +50,7 @@ type diskQueue struct {
 	// (but not yet sent over readChan)
 	nextReadPos     int64
 	nextReadFileNum int64
+	lastReadBytes   int64 // 上一次读出的消息占用的字节数，被消费后从bytes中减去
 
 	readFile  *os.File
 	writeFile *os.File
@@ -96,6 +98,7 @@ func NewDiskQueue(name string, dataPath string, maxBytesPerFile int64,
 	if err != nil && !os.IsNotExist(err) {
 		log.Warnf("ERROR: diskqueue(%s) failed to retrieveMetaData - %s", d.name, err)
 	}
+	d.resetBytes()
 
 	go d.ioLoop()
 
@@ -112,6 +115,27 @@ func (d *diskQueue) Depth() int64 {
 	return atomic.LoadInt64(&d.depth)
 }
 
+// Bytes returns the size of unconsumed data in the queue
+func (d *diskQueue) Bytes() int64 {
+	return atomic.LoadInt64(&d.bytes)
+}
+
+// resetBytes 根据读写位置和数据文件的大小重新计算未被消费的数据大小
+func (d *diskQueue) resetBytes() {
+	var total int64
+	for i := d.readFileNum; i < d.writeFileNum; i++ {
+		if fi, err := os.Stat(d.fileName(i)); err == nil {
+			total += fi.Size()
+		}
+	}
+	total += d.writePos
+	total -= d.readPos
+	if total < 0 {
+		total = 0
+	}
+	atomic.StoreInt64(&d.bytes, total)
+}
+
 // ReadChan returns the []byte channel for reading data
 func (d *diskQueue) ReadChan() chan []byte {
 	return d.readChan
@@ -229,6 +253,7 @@ func (d *diskQueue) skipToNextRWFile() error {
 	d.nextReadFileNum = d.writeFileNum
 	d.nextReadPos = 0
 	atomic.StoreInt64(&d.depth, 0)
+	atomic.StoreInt64(&d.bytes, 0)
 
 	return err
 }
@@ -283,6 +308,7 @@ func (d *diskQueue) readOne() ([]byte, error) {
 	}
 
 	totalBytes := int64(4 + msgSize)
+	d.lastReadBytes = totalBytes
 
 	// we only advance next* because we have not yet sent this to consumers
 	// (where readFileNum, readPos will actually be advanced)
@@ -358,6 +384,7 @@ func (d *diskQueue) writeOne(data []byte) error {
 	totalBytes := int64(4 + dataLen)
 	d.writePos += totalBytes
 	atomic.AddInt64(&d.depth, 1)
+	atomic.AddInt64(&d.bytes, totalBytes)
 
 	// 注意这里是写完这一个消息之后才滚动
 	if d.writePos > d.maxBytesPerFile {
@@ -513,6 +540,7 @@ func (d *diskQueue) moveForward() {
 	d.readFileNum = d.nextReadFileNum
 	d.readPos = d.nextReadPos
 	depth := atomic.AddInt64(&d.depth, -1)
+	atomic.AddInt64(&d.bytes, -d.lastReadBytes)
 
 	// see if we need to clean up the old file
 	// 尝试清除已经读过的文件
@@ -562,6 +590,7 @@ func (d *diskQueue) handleReadError() {
 	d.readPos = 0
 	d.nextReadFileNum = d.readFileNum
 	d.nextReadPos = 0
+	d.resetBytes()
 
 	// significant state change, schedule a sync on the next iteration
 	d.needSync = true
@@ -59,6 +59,35 @@ func TestDiskQueueRoll(t *testing.T) {
 	assert.Equal(t, dq.(*diskQueue).writePos, int64(0))
 }
 
+func TestDiskQueueBytes(t *testing.T) {
+	dqName := "test_disk_queue_bytes" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	msg := bytes.Repeat([]byte{0}, 10)
+	ml := int64(len(msg))
+	dq := NewDiskQueue(dqName, tmpDir, 9*(ml+4), int32(ml), 1<<10, 2500, 2500, 2*time.Second, 10*1024*1024)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, dq.Put(msg))
+	}
+	assert.Equal(t, 10*(ml+4), dq.Bytes())
+	<-dq.ReadChan()
+	<-dq.ReadChan()
+	// 消费之后才会更新
+	for i := 0; i < 10 && dq.Bytes() != 8*(ml+4); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 8*(ml+4), dq.Bytes())
+	dq.Close()
+
+	// 重新打开时根据数据文件计算
+	dq = NewDiskQueue(dqName, tmpDir, 9*(ml+4), int32(ml), 1<<10, 2500, 2500, 2*time.Second, 10*1024*1024)
+	defer dq.Close()
+	assert.Equal(t, dq.Depth()*(ml+4), dq.Bytes())
+}
+
 func assertFileNotExist(t *testing.T, fn string) {
 	f, err := os.OpenFile(fn, os.O_RDONLY, 0600)
 	assert.Equal(t, f, (*os.File)(nil))
@@ -9,5 +9,6 @@ type BackendQueue interface {
 	Close() error
 	Delete() error
 	Depth() int64
+	Bytes() int64
 	Empty() error
 }
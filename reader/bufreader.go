@@ -54,6 +54,10 @@ type BufReader struct {
 	mux     sync.Mutex
 	decoder mahonia.Decoder
 
+	offsetMux  sync.RWMutex // 保护offsetFile和offset，Lag会在其他goroutine中读取
+	offsetFile string       // 最近一次读取后的位置，见Offset
+	offset     int64
+
 	meta            *Meta // 存放offset的元信息
 	multiLineRegexp *regexp.Regexp
 }
@@ -120,6 +124,7 @@ func NewReaderSize(rd FileReader, meta *Meta, size int) (*BufReader, error) {
 		}
 	}
 	r.lineCache = string(linesbytes)
+	r.publishOffset()
 	return r, nil
 }
 
@@ -199,6 +204,7 @@ func (b *BufReader) buffered() int { return b.w - b.r }
 func (b *BufReader) readSlice(delim byte) (line []byte, err error) {
 	b.mux.Lock()
 	defer b.mux.Unlock()
+	defer b.publishOffset()
 	for {
 
 		// Search buffer.
@@ -366,6 +372,29 @@ func (b *BufReader) Source() string {
 	return b.rd.Source()
 }
 
+// Offset 返回底层文件的读取位置减去还未读取的缓存，文件切换时缓存中可能还有上一个文件的数据，此时结果是近似的。
+// 返回的是最近一次读取之后的位置，可以在读取之外的goroutine中调用
+func (b *BufReader) Offset() (string, int64) {
+	b.offsetMux.RLock()
+	defer b.offsetMux.RUnlock()
+	return b.offsetFile, b.offset
+}
+
+// publishOffset 读取之后更新Offset返回的位置，需要持有mux，底层FileReader的状态只在读取时改变
+func (b *BufReader) publishOffset() {
+	file, offset := b.rd.Source(), int64(0)
+	if or, ok := b.rd.(OffsetReader); ok {
+		file, offset = or.Offset()
+		offset -= int64(b.buffered())
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	b.offsetMux.Lock()
+	b.offsetFile, b.offset = file, offset
+	b.offsetMux.Unlock()
+}
+
 func (b *BufReader) Close() error {
 	return b.rd.Close()
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/qiniu/log"
 	"github.com/wvanbergen/kafka/consumergroup"
+	"github.com/wvanbergen/kazoo-go"
 )
 
 type KafkaReader struct {
@@ -33,6 +34,11 @@ type KafkaReader struct {
 	status  int32
 	mux     sync.Mutex
 	started bool
+
+	lag       lagCache
+	lagKazoo  *kazoo.Kazoo  // 计算延迟时查询已经提交的offset，只在lag的锁中使用
+	lagClient sarama.Client // 计算延迟时查询分区最新的offset
+	lagClosed bool          // Close之后不再创建连接，只在lag的锁中使用
 }
 
 func NewKafkaReader(meta *Meta, consumerGroup string,
@@ -71,6 +77,18 @@ func (kr *KafkaReader) ReadLine() (data string, err error) {
 
 }
 func (kr *KafkaReader) Close() (err error) {
+	kr.lag.mux.Lock()
+	kr.lagClosed = true
+	kr.lag.at = time.Time{} // 不再返回关闭前缓存的结果
+	if kr.lagClient != nil {
+		kr.lagClient.Close()
+		kr.lagClient = nil
+	}
+	if kr.lagKazoo != nil {
+		kr.lagKazoo.Close()
+		kr.lagKazoo = nil
+	}
+	kr.lag.mux.Unlock()
 	if atomic.CompareAndSwapInt32(&kr.status, StatusRunning, StatusStoping) {
 		log.Infof("%v stopping", kr.Name())
 	} else {
@@ -157,3 +175,66 @@ func (kr *KafkaReader) run() {
 func (kr *KafkaReader) SetMode(mode string, v interface{}) error {
 	return errors.New("KafkaReader not support readmode")
 }
+
+// Lag 返回consumer group在每个分区上的延迟，即分区最新的offset减去zookeeper中已经提交的offset
+func (kr *KafkaReader) Lag() (*LagInfo, error) {
+	return kr.lag.get(kr.kafkaLag)
+}
+
+func (kr *KafkaReader) kafkaLag() (*LagInfo, error) {
+	if kr.lagClosed {
+		return nil, fmt.Errorf("%v is closed", kr.Name())
+	}
+	if kr.lagKazoo == nil {
+		kz, err := kazoo.NewKazoo(kr.ZookeeperPeers, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%v connect zookeeper error %v", kr.Name(), err)
+		}
+		kr.lagKazoo = kz
+	}
+	if kr.lagClient == nil {
+		brokers, err := kr.lagKazoo.BrokerList()
+		if err != nil {
+			return nil, fmt.Errorf("%v get broker list error %v", kr.Name(), err)
+		}
+		client, err := sarama.NewClient(brokers, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%v connect brokers %v error %v", kr.Name(), brokers, err)
+		}
+		kr.lagClient = client
+	}
+	group := kr.lagKazoo.Consumergroup(kr.ConsumerGroup)
+	info := &LagInfo{SizeUnit: LagUnitMessages, Details: make(map[string]int64)}
+	for _, topic := range kr.Topics {
+		partitions, err := kr.lagClient.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("%v get partitions of topic %v error %v", kr.Name(), topic, err)
+		}
+		for _, partition := range partitions {
+			newest, err := kr.lagClient.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("%v get newest offset of %v/%v error %v", kr.Name(), topic, partition, err)
+			}
+			committed, err := group.FetchOffset(topic, partition)
+			if err != nil {
+				return nil, fmt.Errorf("%v fetch committed offset of %v/%v error %v", kr.Name(), topic, partition, err)
+			}
+			if committed < 0 {
+				// 还没有提交过offset，按照read_from开始消费的位置计算
+				committed = newest
+				if w := strings.ToLower(kr.Whence); w == WhenceOldest || w == "" {
+					if committed, err = kr.lagClient.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+						return nil, fmt.Errorf("%v get oldest offset of %v/%v error %v", kr.Name(), topic, partition, err)
+					}
+				}
+			}
+			lag := newest - committed
+			if lag < 0 {
+				lag = 0
+			}
+			info.Details[fmt.Sprintf("%v/%v", topic, partition)] = lag
+			info.Size += lag
+		}
+	}
+	return info, nil
+}
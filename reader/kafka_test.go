@@ -5,6 +5,7 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/qiniu/logkit/conf"
 
@@ -34,3 +35,15 @@ func TestKafkaReader(t *testing.T) {
 	}
 	assert.EqualValues(t, "KafkaReader:[topic1],[group1]", er.Name())
 }
+
+func TestKafkaReaderLagAfterClose(t *testing.T) {
+	kr, err := NewKafkaReader(nil, "group1", []string{"topic1"}, []string{"localhost:2181"}, "oldest")
+	assert.NoError(t, err)
+	kr.lag.info, kr.lag.at = &LagInfo{Size: 1}, time.Now()
+	assert.NoError(t, kr.Close())
+	// 关闭后不会返回缓存的结果，也不会重新连接zookeeper
+	_, err = kr.Lag()
+	assert.Error(t, err)
+	assert.Nil(t, kr.lagKazoo)
+	assert.Nil(t, kr.lagClient)
+}
@@ -0,0 +1,110 @@
+package reader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/qiniu/logkit/utils"
+)
+
+// LagReporter 可以计算读取延迟的reader，即数据源中还未被读取的数据量
+type LagReporter interface {
+	Lag() (*LagInfo, error)
+}
+
+// LagInfo 的Size单位
+const (
+	LagUnitBytes    = "bytes"    // 文件类reader，未读取的字节数
+	LagUnitMessages = "messages" // kafka，未消费的消息数
+	LagUnitRows     = "rows"     // mysql、mssql，offset之后的行数
+	LagUnitDocs     = "docs"     // mongo，offset之后的文档数
+)
+
+// LagInfo reader的读取延迟
+type LagInfo struct {
+	Size     int64            // 未读取的数据总量，单位为SizeUnit
+	SizeUnit string           // Size的单位
+	Files    int64            // 还未读取的文件数，只有文件类reader有意义
+	Details  map[string]int64 // 每个分区、sql或文件各自的延迟，单位与Size相同
+}
+
+var ErrLagNotSupported = errors.New("reader does not support lag report")
+
+// 需要访问外部服务计算的延迟的缓存时间，避免频繁调用监控接口时给数据源带来压力
+const lagCacheTTL = 30 * time.Second
+
+// lagCache 缓存延迟的计算结果，零值可用，compute在持有锁时调用
+type lagCache struct {
+	mux  sync.Mutex
+	at   time.Time
+	info *LagInfo
+	err  error
+}
+
+func (c *lagCache) get(compute func() (*LagInfo, error)) (*LagInfo, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !c.at.IsZero() && time.Since(c.at) < lagCacheTTL {
+		return c.info, c.err
+	}
+	c.info, c.err = compute()
+	c.at = time.Now()
+	return c.info, c.err
+}
+
+// Lag 由底层的FileReader计算延迟，根据meta中已经同步的位置计算，不包括缓存中的数据
+func (b *BufReader) Lag() (*LagInfo, error) {
+	lr, ok := b.rd.(LagReporter)
+	if !ok {
+		return nil, ErrLagNotSupported
+	}
+	return lr.Lag()
+}
+
+// Lag 返回当前文件未读取的大小，以及之后按修改时间排序的文件的个数与大小
+func (sf *SeqFile) Lag() (*LagInfo, error) {
+	logreading, offset, err := sf.meta.ReadOffset()
+	if err != nil {
+		return nil, err
+	}
+	logs, err := utils.ReadDirByTime(sf.meta.LogPath())
+	if err != nil {
+		return nil, err
+	}
+	logreading = filepath.Base(logreading)
+	info := &LagInfo{Size: -offset, SizeUnit: LagUnitBytes}
+	for _, l := range logs {
+		if l.IsDir() {
+			continue
+		}
+		info.Size += l.Size()
+		if l.Name() == logreading {
+			break
+		}
+		info.Files++
+	}
+	return info, nil
+}
+
+// Lag 返回文件未读取的大小，文件被轮转后按新文件的大小计算
+func (sf *SingleFile) Lag() (*LagInfo, error) {
+	file, offset, err := sf.meta.ReadOffset()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	fi, err := os.Stat(sf.path)
+	if err != nil {
+		return nil, err
+	}
+	info := &LagInfo{Size: fi.Size(), SizeUnit: LagUnitBytes}
+	if file == sf.path {
+		info.Size -= offset
+	}
+	if info.Size < 0 {
+		info.Size = 0
+	}
+	return info, nil
+}
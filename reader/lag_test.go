@@ -0,0 +1,166 @@
+package reader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SingleFileLag(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "Test_SingleFileLag")
+	os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "lag.log")
+	metaDir := filepath.Join(dir, "meta")
+	createTestFile(fileName, "1234567890")
+
+	meta, err := NewMeta(metaDir, metaDir, fileName, ModeFile, defautFileRetention)
+	assert.NoError(t, err)
+	sf, err := NewSingleFile(meta, fileName, WhenceOldest)
+	assert.NoError(t, err)
+	defer sf.Close()
+
+	// 还没有同步过meta时，整个文件都是延迟
+	info, err := sf.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), info.Size)
+	assert.Equal(t, LagUnitBytes, info.SizeUnit)
+
+	p := make([]byte, 4)
+	_, err = sf.Read(p)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.SyncMeta())
+	info, err = sf.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), info.Size)
+
+	br, err := NewReaderSize(sf, meta, 1024)
+	assert.NoError(t, err)
+	var lr LagReporter = br
+	info, err = lr.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), info.Size)
+}
+
+func Test_SeqFileLag(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "Test_SeqFileLag")
+	os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+	metaDir := filepath.Join(dir, "meta")
+	assert.NoError(t, os.MkdirAll(logDir, 0755))
+	defer os.RemoveAll(dir)
+	old := filepath.Join(logDir, "a.log")
+	createTestFile(old, "12345")
+	createTestFile(filepath.Join(logDir, "b.log"), "67890abc")
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(old, past, past))
+
+	meta, err := NewMeta(metaDir, metaDir, logDir, ModeDir, defautFileRetention)
+	assert.NoError(t, err)
+	sf, err := NewSeqFile(meta, logDir, false, nil, "*", WhenceOldest)
+	assert.NoError(t, err)
+	defer sf.Close()
+
+	p := make([]byte, 3)
+	_, err = sf.Read(p)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.SyncMeta())
+	// 当前文件剩余2字节，之后还有一个8字节的文件
+	info, err := sf.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), info.Size)
+	assert.Equal(t, int64(1), info.Files)
+	assert.Equal(t, LagUnitBytes, info.SizeUnit)
+}
+
+func Test_MultiReaderLag(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "Test_MultiReaderLag")
+	os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+	metaDir := filepath.Join(dir, "meta")
+	assert.NoError(t, os.MkdirAll(logDir, 0755))
+	defer os.RemoveAll(dir)
+	file1 := filepath.Join(logDir, "a.log")
+	file2 := filepath.Join(logDir, "b.log")
+	createTestFile(file1, "a1\na2\n")
+	createTestFile(file2, "b1\nb2\nb3\n")
+
+	meta, err := NewMeta(metaDir, metaDir, logDir, ModeTailx, defautFileRetention)
+	assert.NoError(t, err)
+	mr, err := NewMultiReader(meta, filepath.Join(logDir, "*.log"), WhenceOldest, "1h", "100ms", 128)
+	assert.NoError(t, err)
+	defer mr.Close()
+	mr.Start()
+
+	// Lag与读取并发进行
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_, err := mr.Lag()
+			assert.NoError(t, err)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	lines := 0
+	deadline := time.Now().Add(10 * time.Second)
+	for lines < 5 && time.Now().Before(deadline) {
+		line, _ := mr.ReadLine()
+		if line != "" {
+			lines++
+		}
+	}
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, 5, lines)
+
+	// 全部读完后没有延迟，每个文件都有记录
+	info, err := mr.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size)
+	assert.Equal(t, int64(0), info.Files)
+	assert.Equal(t, map[string]int64{file1: 0, file2: 0}, info.Details)
+}
+
+func Test_lagCache(t *testing.T) {
+	var c lagCache
+	calls := 0
+	compute := func() (*LagInfo, error) {
+		calls++
+		return &LagInfo{Size: int64(calls), SizeUnit: LagUnitRows}, nil
+	}
+	info, err := c.get(compute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), info.Size)
+	info, err = c.get(compute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), info.Size)
+	assert.Equal(t, 1, calls)
+
+	// 出错的结果同样被缓存
+	var ce lagCache
+	_, err = ce.get(func() (*LagInfo, error) { return nil, errors.New("unreachable") })
+	assert.Error(t, err)
+	_, err = ce.get(compute)
+	assert.Error(t, err)
+}
+
+func Test_SqlCountSQL(t *testing.T) {
+	mr := &SqlReader{offsetKey: "id"}
+	assert.Equal(t, "SELECT COUNT(*) FROM (select * from t) AS logkit_lag WHERE id >= 100;", mr.countSQL("select * from t", 100))
+	mr.offsetKey = ""
+	assert.Equal(t, "SELECT COUNT(*) FROM (select * from t) AS logkit_lag;", mr.countSQL("select * from t", 100))
+}
@@ -352,6 +352,11 @@ func (m *Meta) DoneFilePath() string {
 	return m.doneFilePath
 }
 
+// Dir 返回meta的存放目录
+func (m *Meta) Dir() string {
+	return m.dir
+}
+
 func (m *Meta) LogPath() string {
 	return m.logpath
 }
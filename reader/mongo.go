@@ -3,6 +3,7 @@ package reader
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,10 +37,14 @@ type MongoReader struct {
 	session  *mgo.Session
 	offset   interface{} //对于默认的offset_key: "_id", 是objectID作为offset，存储的表现形式是string，其他则是int64
 
+	offsetMux sync.RWMutex // 保护offset，Lag和SyncMeta会在其他goroutine中读取
+
 	execOnStart bool
 	status      int32
 	started     bool
 	mux         sync.Mutex
+
+	lag lagCache
 }
 
 func NewMongoReader(meta *Meta, readBatch int, host, database, collection, offsetkey, cronSched, filters, certfile string, execOnStart bool) (mr *MongoReader, err error) {
@@ -183,8 +188,9 @@ func (mr *MongoReader) run() {
 
 func (mr *MongoReader) catQuery(c string, lastID interface{}, mgoSession *mgo.Session) *mgo.Query {
 	query := bson.M{}
-	if f, ok := mr.collectionFilters[c]; ok {
-		query = bson.M(f)
+	// 复制一份，避免修改配置的filter
+	for k, v := range mr.collectionFilters[c] {
+		query[k] = v
 	}
 	if lastID != nil {
 		query[mr.offsetkey] = bson.M{"$gt": lastID}
@@ -220,7 +226,9 @@ func (mr *MongoReader) exec() (err error) {
 			return nil
 		}
 		if id, ok := result[mr.offsetkey]; ok {
+			mr.offsetMux.Lock()
 			mr.offset = id
+			mr.offsetMux.Unlock()
 		}
 		bytes, ierr := json.Marshal(result)
 		if ierr != nil {
@@ -235,19 +243,46 @@ func (mr *MongoReader) exec() (err error) {
 	return nil
 }
 
+// Lag 返回collection中比当前offset更新的文档数
+func (mr *MongoReader) Lag() (*LagInfo, error) {
+	return mr.lag.get(mr.mongoLag)
+}
+
+func (mr *MongoReader) mongoLag() (*LagInfo, error) {
+	session, err := utils.MongoDail(mr.host, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	session.SetSocketTimeout(time.Second * 5)
+	session.SetSyncTimeout(time.Second * 5)
+	n, err := mr.catQuery(mr.collection, mr.currentOffset(), session).Count()
+	if err != nil {
+		return nil, fmt.Errorf("%v count lag error %v", mr.Name(), err)
+	}
+	return &LagInfo{Size: int64(n), SizeUnit: LagUnitDocs}, nil
+}
+
+func (mr *MongoReader) currentOffset() interface{} {
+	mr.offsetMux.RLock()
+	defer mr.offsetMux.RUnlock()
+	return mr.offset
+}
+
 //SyncMeta 从队列取数据时同步队列，作用在于保证数据不重复。
 func (mr *MongoReader) SyncMeta() {
 	var key string
 	var offset int64
+	current := mr.currentOffset()
 	if mr.offsetkey == MongoDefaultOffsetKey {
-		if id, ok := mr.offset.(bson.ObjectId); ok {
+		if id, ok := current.(bson.ObjectId); ok {
 			key = id.Hex()
 		}
 	} else {
 		key = mr.offsetkey
-		if ofs, ok := mr.offset.(int64); ok {
+		if ofs, ok := current.(int64); ok {
 			offset = ofs
-		} else if ofs, ok := mr.offset.(int); ok {
+		} else if ofs, ok := current.(int); ok {
 			offset = int64(ofs)
 		}
 	}
@@ -22,6 +22,7 @@ type MultiReader struct {
 	started         bool
 	status          int32
 	fileReaders     map[uint64]*ActiveReader
+	armux           sync.RWMutex         // 保护fileReaders，Lag会在其他goroutine中读取
 	scs             []reflect.SelectCase //在updateSelectCase中更新
 	scs2Inode       []uint64             //在updateSelectCase中更新
 	mux             sync.Mutex
@@ -202,7 +203,9 @@ func (mr *MultiReader) Expire() {
 		if ok {
 			ar.Close()
 		}
+		mr.armux.Lock()
 		delete(mr.fileReaders, inode)
+		mr.armux.Unlock()
 		delete(mr.cacheMap, strconv.FormatUint(inode, 10))
 	}
 }
@@ -262,7 +265,9 @@ func (mr *MultiReader) StatLogPath() {
 			}
 		}
 		go ar.Run()
+		mr.armux.Lock()
 		mr.fileReaders[inode] = ar
+		mr.armux.Unlock()
 	}
 	if len(newaddsInode) > 0 {
 		log.Debugf("StatLogPath find new logpath: %v; %v", strings.Join(newaddsPath, ", "), newaddsInode)
@@ -391,10 +396,12 @@ func (mr *MultiReader) ReadLine() (data string, err error) {
 
 //SyncMeta 从队列取数据时同步队列，作用在于保证数据不重复。
 func (mr *MultiReader) SyncMeta() {
+	mr.armux.RLock()
 	for inode, ar := range mr.fileReaders {
 		ar.SyncMeta()
 		mr.cacheMap[strconv.FormatUint(inode, 10)] = ar.readcache
 	}
+	mr.armux.RUnlock()
 	buf, err := json.Marshal(mr.cacheMap)
 	if err != nil {
 		log.Errorf("%v sync meta error %v, cacheMap %v", mr.Name(), err, mr.cacheMap)
@@ -407,3 +414,32 @@ func (mr *MultiReader) SyncMeta() {
 	}
 	return
 }
+
+// Lag 返回每个正在追踪的文件未读取的大小，Files为还有数据未读取的文件数
+func (mr *MultiReader) Lag() (*LagInfo, error) {
+	mr.armux.RLock()
+	readers := make([]*ActiveReader, 0, len(mr.fileReaders))
+	for _, ar := range mr.fileReaders {
+		readers = append(readers, ar)
+	}
+	mr.armux.RUnlock()
+	info := &LagInfo{SizeUnit: LagUnitBytes, Details: make(map[string]int64)}
+	for _, ar := range readers {
+		file, offset := ar.br.Offset()
+		fi, err := os.Stat(file)
+		if err != nil {
+			log.Debugf("%v stat %v for lag error %v", mr.Name(), file, err)
+			continue
+		}
+		lag := fi.Size() - offset
+		if lag < 0 {
+			lag = 0
+		}
+		info.Details[ar.logpath] = lag
+		info.Size += lag
+		if lag > 0 {
+			info.Files++
+		}
+	}
+	return info, nil
+}
@@ -21,6 +21,12 @@ type Reader interface {
 	SyncMeta()
 }
 
+// OffsetReader 可以返回当前读取位置的reader，用于记录出错数据的来源
+type OffsetReader interface {
+	// Offset 返回当前读取的文件，以及最近一次读取的数据结束处在文件中的偏移
+	Offset() (file string, offset int64)
+}
+
 // FileReader reader 接口方法
 type FileReader interface {
 	Name() string
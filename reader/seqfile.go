@@ -178,6 +178,10 @@ func (sf *SeqFile) Source() string {
 	return sf.dir
 }
 
+func (sf *SeqFile) Offset() (string, int64) {
+	return sf.currFile, sf.offset
+}
+
 func (sf *SeqFile) Close() (err error) {
 	atomic.AddInt32(&sf.stopped, 1)
 	if sf.f == nil {
@@ -158,6 +158,10 @@ func (sf *SingleFile) Source() string {
 	return sf.path
 }
 
+func (sf *SingleFile) Offset() (string, int64) {
+	return sf.path, sf.offset
+}
+
 func (sf *SingleFile) Close() (err error) {
 	atomic.AddInt32(&sf.stopped, 1)
 	return sf.f.Close()
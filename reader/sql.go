@@ -40,11 +40,15 @@ type SqlReader struct {
 	offsets  []int64  // 当前处理文件的sql的offset
 	syncSQLs []string // 当前在查询的sqls
 
+	offsetMux sync.RWMutex // 保护offsets和syncSQLs，Lag和SyncMeta会在其他goroutine中读取
+
 	status  int32
 	mux     sync.Mutex
 	started bool
 
 	execOnStart bool
+
+	lag lagCache
 }
 
 const (
@@ -268,20 +272,13 @@ func (mr *SqlReader) run() {
 		log.Infof("%v successfully finnished", mr.Name())
 	}()
 
-	var connectStr string
-	switch mr.dbtype {
-	case "mysql":
-		connectStr = mr.datasource + "/" + mr.database
-	case "mssql":
-		connectStr = mr.datasource + ";database=" + mr.database
-	}
 	// 开始work逻辑
 	for {
 		if atomic.LoadInt32(&mr.status) == StatusStoping {
 			log.Warnf("%v stopped from running", mr.Name())
 			return
 		}
-		err := mr.exec(connectStr)
+		err := mr.exec(mr.connectStr())
 		if err == nil {
 			log.Infof("%v successfully exec", mr.Name())
 			return
@@ -291,6 +288,16 @@ func (mr *SqlReader) run() {
 	}
 }
 
+func (mr *SqlReader) connectStr() string {
+	switch mr.dbtype {
+	case "mysql":
+		return mr.datasource + "/" + mr.database
+	case "mssql":
+		return mr.datasource + ";database=" + mr.database
+	}
+	return ""
+}
+
 func (mr *SqlReader) exec(connectStr string) (err error) {
 	now := time.Now()
 	db, err := sql.Open(mr.dbtype, connectStr)
@@ -303,8 +310,10 @@ func (mr *SqlReader) exec(connectStr string) (err error) {
 	}
 	//更新sqls
 	sqls := updateSqls(mr.rawsqls, now)
+	mr.offsetMux.Lock()
 	mr.updateOffsets(sqls)
 	mr.syncSQLs = sqls
+	mr.offsetMux.Unlock()
 	log.Infof("%v start to work, sqls %v offsets %v", mr.Name(), mr.syncSQLs, mr.offsets)
 
 	for idx := range mr.syncSQLs {
@@ -354,6 +363,7 @@ func (mr *SqlReader) exec(connectStr string) (err error) {
 				}
 				mr.readChan <- ret
 
+				mr.offsetMux.Lock()
 				if offsetKeyIndex >= 0 {
 					mr.offsets[idx], err = strconv.ParseInt(string(values[offsetKeyIndex]), 10, 64)
 					if err != nil {
@@ -362,6 +372,7 @@ func (mr *SqlReader) exec(connectStr string) (err error) {
 					}
 				}
 				mr.offsets[idx]++
+				mr.offsetMux.Unlock()
 			}
 		}
 	}
@@ -375,8 +386,57 @@ func (mr *SqlReader) getSQL(idx int) string {
 	return fmt.Sprintf("%s LIMIT %d,%d;", mr.syncSQLs[idx], mr.offsets[idx], mr.offsets[idx]+int64(mr.readBatch))
 }
 
+// countSQL 返回统计sql在offset之后还有多少行数据的语句
+func (mr *SqlReader) countSQL(rawSQL string, offset int64) string {
+	if len(mr.offsetKey) > 0 {
+		return fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS logkit_lag WHERE %v >= %d;", rawSQL, mr.offsetKey, offset)
+	}
+	return fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS logkit_lag;", rawSQL)
+}
+
+// Lag 返回每条sql在当前offset之后还未读取的行数
+func (mr *SqlReader) Lag() (*LagInfo, error) {
+	return mr.lag.get(mr.sqlLag)
+}
+
+func (mr *SqlReader) sqlLag() (*LagInfo, error) {
+	mr.offsetMux.RLock()
+	sqls := append([]string{}, mr.syncSQLs...)
+	offsets := append([]int64{}, mr.offsets...)
+	mr.offsetMux.RUnlock()
+	db, err := sql.Open(mr.dbtype, mr.connectStr())
+	if err != nil {
+		return nil, fmt.Errorf("%v open %v failed: %v", mr.Name(), mr.dbtype, err)
+	}
+	defer db.Close()
+	info := &LagInfo{SizeUnit: LagUnitRows, Details: make(map[string]int64)}
+	for idx, rawSQL := range sqls {
+		var offset int64
+		if idx < len(offsets) {
+			offset = offsets[idx]
+		}
+		var count int64
+		countSQL := mr.countSQL(rawSQL, offset)
+		if err = db.QueryRow(countSQL).Scan(&count); err != nil {
+			return nil, fmt.Errorf("%v query lag <%v> error %v", mr.Name(), countSQL, err)
+		}
+		// 没有offset_key时offset是已经读取的行数
+		if len(mr.offsetKey) <= 0 {
+			count -= offset
+		}
+		if count < 0 {
+			count = 0
+		}
+		info.Details[rawSQL] = count
+		info.Size += count
+	}
+	return info, nil
+}
+
 //SyncMeta 从队列取数据时同步队列，作用在于保证数据不重复。
 func (mr *SqlReader) SyncMeta() {
+	mr.offsetMux.RLock()
+	defer mr.offsetMux.RUnlock()
 	encodeSQLs := make([]string, 0)
 	for _, sql := range mr.syncSQLs {
 		encodeSQLs = append(encodeSQLs, strings.Replace(sql, " ", "@", -1))
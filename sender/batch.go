@@ -0,0 +1,131 @@
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// 非2xx响应中最多读取的错误信息长度
+const maxErrorBodySize = 64 * 1024
+
+// lineBatch 一个请求中的数据，每条数据编码为一行
+type lineBatch struct {
+	datas []Data
+	index []int // 数据在Send参数中的位置
+	lines [][]byte
+	size  int
+}
+
+// join 用sep连接所有行
+func (b *lineBatch) join(sep []byte) []byte {
+	return bytes.Join(b.lines, sep)
+}
+
+// lineBatcher 按条数和字节数把数据分成多个请求，为0时不限制，单条数据超过限制时单独发送
+type lineBatcher struct {
+	maxLines int
+	maxBytes int
+	batches  []*lineBatch
+}
+
+func (b *lineBatcher) add(i int, d Data, line []byte) {
+	var cur *lineBatch
+	if n := len(b.batches); n > 0 {
+		cur = b.batches[n-1]
+	}
+	if cur == nil || (b.maxLines > 0 && len(cur.lines) >= b.maxLines) ||
+		(b.maxBytes > 0 && cur.size+len(line)+1 > b.maxBytes) {
+		cur = &lineBatch{}
+		b.batches = append(b.batches, cur)
+	}
+	cur.datas = append(cur.datas, d)
+	cur.index = append(cur.index, i)
+	cur.lines = append(cur.lines, line)
+	cur.size += len(line) + 1
+}
+
+// newBodyRequest 创建请求，开启gzip时压缩请求体并设置Content-Encoding
+func newBodyRequest(method, url, contentType string, body []byte, gz bool) (*http.Request, error) {
+	var reader io.Reader = bytes.NewReader(body)
+	if gz {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		reader = &buf
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if gz {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return req, nil
+}
+
+// doRequest 发送请求，返回状态码、响应的header以及非2xx时的响应体，请求没有完成时状态码为0
+func doRequest(client *http.Client, req *http.Request) (status int, header http.Header, msg []byte, err error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return resp.StatusCode, resp.Header, nil, nil
+	}
+	msg, _ = ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	return resp.StatusCode, resp.Header, bytes.TrimSpace(msg), nil
+}
+
+// batchResult 汇总一次Send中所有请求的结果
+type batchResult struct {
+	total     int
+	invalid   []Data // 无法编码的数据，重试也不会成功
+	failDatas []Data
+	lastErr   error
+	permanent bool // 所有失败的请求都不需要重试
+	split     bool // 所有失败的请求都是因为过大或者个别数据不合法被整体拒绝
+}
+
+func newBatchResult(total int) *batchResult {
+	return &batchResult{total: total, permanent: true, split: true}
+}
+
+func (r *batchResult) addInvalid(d Data, err error) {
+	r.invalid = append(r.invalid, d)
+	r.lastErr = err
+}
+
+// addFailed 记录失败请求中的数据，status为0说明请求没有完成，网络错误都可以重试
+func (r *batchResult) addFailed(datas []Data, status int, err error, permanent, split bool) {
+	r.failDatas = append(r.failDatas, datas...)
+	r.lastErr = err
+	r.permanent = r.permanent && status > 0 && permanent
+	r.split = r.split && split
+}
+
+// err 返回发送的错误，失败的数据按请求的结果重试或者拆分，无法编码的数据通过PermanentDatas交给dead letter
+func (r *batchResult) err(name string) error {
+	if r.lastErr == nil {
+		return nil
+	}
+	msg := fmt.Sprintf("%v failed to send %v of %v datas, last error %v", name, len(r.invalid)+len(r.failDatas), r.total, r.lastErr)
+	switch {
+	case len(r.failDatas) <= 0 || (r.permanent && !r.split):
+		return NewPermanentError(NewSendError(msg, append(r.invalid, r.failDatas...), TypeDefault))
+	case r.split:
+		return NewSendError(msg, r.failDatas, TypeBinaryUnpack).WithPermanent(r.invalid)
+	}
+	return NewSendError(msg, r.failDatas, TypeDefault).WithPermanent(r.invalid)
+}
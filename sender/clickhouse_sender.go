@@ -0,0 +1,256 @@
+package sender
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/qiniu/log"
+)
+
+// ClickhouseSender 通过ClickHouse的http接口以JSONEachRow格式批量写入数据
+type ClickhouseSender struct {
+	name          string
+	url           string
+	database      string
+	table         string
+	columns       []conf.AliasKey // 为空时数据原样写入
+	query         string
+	settings      url.Values
+	username      string
+	password      string
+	gzip          bool
+	maxBatchBytes int
+	client        *http.Client
+}
+
+// Clickhouse sender 的可配置字段
+const (
+	KeyClickhouseHost          = "clickhouse_host" // http接口地址，如http://127.0.0.1:8123
+	KeyClickhouseDatabase      = "clickhouse_database"
+	KeyClickhouseTable         = "clickhouse_table"
+	KeyClickhouseColumns       = "clickhouse_columns"      // 写入的列，支持别名，如"reqid id,status"，不填时数据原样写入
+	KeyClickhouseUsername      = "clickhouse_username"     // 用户名
+	KeyClickhousePassword      = "clickhouse_password"     // 密码
+	KeyClickhouseSettings      = "clickhouse_settings"     // 查询的设置，如"max_insert_block_size=100000,insert_quorum=2"
+	KeyClickhouseAsyncInsert   = "clickhouse_async_insert" // 使用async_insert写入，并等待写入完成
+	KeyClickhouseGzip          = "clickhouse_gzip"         // 是否gzip压缩请求体
+	KeyClickhouseMaxBatchBytes = "clickhouse_max_batch_bytes"
+	KeyClickhouseTimeout       = "clickhouse_timeout"
+
+	KeyClickhouseTLSCACert             = "clickhouse_tls_ca_cert"
+	KeyClickhouseTLSCert               = "clickhouse_tls_cert"
+	KeyClickhouseTLSKey                = "clickhouse_tls_key"
+	KeyClickhouseTLSInsecureSkipVerify = "clickhouse_tls_insecure_skip_verify"
+)
+
+const (
+	defaultClickhouseDatabase      = "default"
+	defaultClickhouseMaxBatchBytes = 10 * 1024 * 1024
+	defaultClickhouseTimeout       = 60 * time.Second
+)
+
+// clickhouseDataErrors 数据本身无法解析时的错误码，一条数据出错会导致整个请求失败，需要拆分后重试找出有问题的数据
+var clickhouseDataErrors = map[int]bool{
+	6:   true, // CANNOT_PARSE_TEXT
+	26:  true, // CANNOT_PARSE_QUOTED_STRING
+	27:  true, // CANNOT_PARSE_INPUT_ASSERTION_FAILED
+	38:  true, // CANNOT_PARSE_DATE
+	41:  true, // CANNOT_PARSE_DATETIME
+	53:  true, // TYPE_MISMATCH
+	69:  true, // ARGUMENT_OUT_OF_BOUND
+	70:  true, // CANNOT_CONVERT_TYPE
+	72:  true, // CANNOT_PARSE_NUMBER
+	117: true, // INCORRECT_DATA
+	131: true, // TOO_LARGE_STRING_SIZE
+	349: true, // CANNOT_INSERT_NULL_IN_ORDINARY_COLUMN
+}
+
+// clickhousePermanentErrors 表结构、权限或者配置有问题时的错误码，重试也不会成功
+var clickhousePermanentErrors = map[int]bool{
+	8:   true, // THERE_IS_NO_COLUMN
+	16:  true, // NO_SUCH_COLUMN_IN_TABLE
+	47:  true, // UNKNOWN_IDENTIFIER
+	60:  true, // UNKNOWN_TABLE
+	62:  true, // SYNTAX_ERROR
+	73:  true, // UNKNOWN_FORMAT
+	81:  true, // UNKNOWN_DATABASE
+	115: true, // UNKNOWN_SETTING
+	192: true, // UNKNOWN_USER
+	193: true, // WRONG_PASSWORD
+	194: true, // REQUIRED_PASSWORD
+	497: true, // ACCESS_DENIED
+	516: true, // AUTHENTICATION_FAILED
+}
+
+var clickhouseCodeRe = regexp.MustCompile(`Code: (\d+)`)
+
+// NewClickhouseSender 创建clickhouse的sender
+func NewClickhouseSender(c conf.MapConf) (s Sender, err error) {
+	host, err := c.GetString(KeyClickhouseHost)
+	if err != nil {
+		return
+	}
+	table, err := c.GetString(KeyClickhouseTable)
+	if err != nil {
+		return
+	}
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+	cs := &ClickhouseSender{
+		url:      strings.TrimSuffix(host, "/") + "/",
+		table:    table,
+		settings: url.Values{},
+	}
+	cs.database, _ = c.GetStringOr(KeyClickhouseDatabase, defaultClickhouseDatabase)
+	cs.name, _ = c.GetStringOr(KeyName, fmt.Sprintf("clickhouseSender:(%v,table:%v.%v)", host, cs.database, table))
+	cs.columns, _ = c.GetAliasListOr(KeyClickhouseColumns, []conf.AliasKey{})
+	cs.query = cs.insertQuery()
+	cs.username, _ = c.GetStringOr(KeyClickhouseUsername, "")
+	cs.password, _ = c.GetStringOr(KeyClickhousePassword, "")
+	cs.gzip, _ = c.GetBoolOr(KeyClickhouseGzip, false)
+	cs.maxBatchBytes, _ = c.GetIntOr(KeyClickhouseMaxBatchBytes, defaultClickhouseMaxBatchBytes)
+
+	// 时间序列化为RFC3339格式，需要best_effort才能解析
+	cs.settings.Set("date_time_input_format", "best_effort")
+	if async, _ := c.GetBoolOr(KeyClickhouseAsyncInsert, false); async {
+		cs.settings.Set("async_insert", "1")
+		cs.settings.Set("wait_for_async_insert", "1")
+	}
+	settings, _ := c.GetStringListOr(KeyClickhouseSettings, []string{})
+	for _, kv := range settings {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%v: setting %v should be like name=value", KeyClickhouseSettings, kv)
+		}
+		cs.settings.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	timeout := defaultClickhouseTimeout
+	if v, _ := c.GetStringOr(KeyClickhouseTimeout, ""); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("%v: %v", KeyClickhouseTimeout, err)
+		}
+	}
+	ca, _ := c.GetStringOr(KeyClickhouseTLSCACert, "")
+	cert, _ := c.GetStringOr(KeyClickhouseTLSCert, "")
+	key, _ := c.GetStringOr(KeyClickhouseTLSKey, "")
+	insecure, _ := c.GetBoolOr(KeyClickhouseTLSInsecureSkipVerify, false)
+	tlsConfig, err := newTLSConfig(ca, cert, key, insecure)
+	if err != nil {
+		return nil, err
+	}
+	cs.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	return cs, nil
+}
+
+func (s *ClickhouseSender) Name() string {
+	return s.name
+}
+
+func (s *ClickhouseSender) Close() error {
+	return nil
+}
+
+func quoteClickhouse(name string) string {
+	return "`" + strings.Replace(strings.Replace(name, `\`, `\\`, -1), "`", "\\`", -1) + "`"
+}
+
+func (s *ClickhouseSender) insertQuery() string {
+	query := "INSERT INTO " + quoteClickhouse(s.database) + "." + quoteClickhouse(s.table)
+	if len(s.columns) > 0 {
+		cols := make([]string, len(s.columns))
+		for i, c := range s.columns {
+			cols[i] = quoteClickhouse(c.Alias)
+		}
+		query += " (" + strings.Join(cols, ", ") + ")"
+	}
+	return query + " FORMAT JSONEachRow"
+}
+
+// encode 把一条数据编码为JSONEachRow的一行，配置了列时只保留这些字段并改名
+func (s *ClickhouseSender) encode(d Data) ([]byte, error) {
+	if len(s.columns) <= 0 {
+		return json.Marshal(d)
+	}
+	row := make(map[string]interface{}, len(s.columns))
+	for _, c := range s.columns {
+		if v, ok := d[c.Key]; ok {
+			row[c.Alias] = v
+		}
+	}
+	return json.Marshal(row)
+}
+
+// Send 按clickhouse_max_batch_bytes拆分成多个请求依次发送，根据ClickHouse的错误码判断是否需要重试
+func (s *ClickhouseSender) Send(datas []Data) error {
+	result := newBatchResult(len(datas))
+	batcher := &lineBatcher{maxBytes: s.maxBatchBytes}
+	for i, d := range datas {
+		line, err := s.encode(d)
+		if err != nil {
+			result.addInvalid(d, err)
+			continue
+		}
+		batcher.add(i, d, line)
+	}
+	for _, b := range batcher.batches {
+		status, code, err := s.post(append(b.join([]byte("\n")), '\n'))
+		if err == nil {
+			continue
+		}
+		permanent := clickhousePermanentErrors[code] || (code == 0 && IsPermanentStatus(status))
+		result.addFailed(b.datas, status, err, permanent, status == http.StatusRequestEntityTooLarge || clickhouseDataErrors[code])
+	}
+	err := result.err(s.Name())
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// clickhouseErrorCode 从X-ClickHouse-Exception-Code或者错误信息中取出错误码，没有时返回0
+func clickhouseErrorCode(header http.Header, msg []byte) int {
+	if code, err := strconv.Atoi(header.Get("X-ClickHouse-Exception-Code")); err == nil {
+		return code
+	}
+	if m := clickhouseCodeRe.FindSubmatch(msg); m != nil {
+		code, _ := strconv.Atoi(string(m[1]))
+		return code
+	}
+	return 0
+}
+
+// post 发送一个请求，返回非2xx的状态码和ClickHouse的错误码，请求没有完成时状态码为0
+func (s *ClickhouseSender) post(body []byte) (status int, code int, err error) {
+	params := url.Values{}
+	for k, v := range s.settings {
+		params[k] = v
+	}
+	params.Set("database", s.database)
+	params.Set("query", s.query)
+	req, err := newBodyRequest(http.MethodPost, s.url+"?"+params.Encode(), "application/x-ndjson", body, s.gzip)
+	if err != nil {
+		return
+	}
+	if s.username != "" {
+		req.Header.Set("X-ClickHouse-User", s.username)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+	status, header, msg, err := doRequest(s.client, req)
+	if err != nil || (status >= 200 && status < 300) {
+		return status, 0, err
+	}
+	return status, clickhouseErrorCode(header, msg), fmt.Errorf("clickhouse %v %v: %s", status, http.StatusText(status), msg)
+}
@@ -0,0 +1,108 @@
+package sender
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClickhouseSender(t *testing.T) {
+	rec := &httpRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	s, err := NewClickhouseSender(conf.MapConf{
+		KeyClickhouseHost:          srv.URL,
+		KeyClickhouseDatabase:      "logs",
+		KeyClickhouseTable:         "nginx",
+		KeyClickhouseColumns:       "reqid id,status,time",
+		KeyClickhouseUsername:      "user",
+		KeyClickhousePassword:      "pass",
+		KeyClickhouseAsyncInsert:   "true",
+		KeyClickhouseSettings:      "wait_for_async_insert=0,insert_quorum=2",
+		KeyClickhouseGzip:          "true",
+		KeyClickhouseMaxBatchBytes: "80",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	ts := time.Date(2017, 7, 1, 9, 0, 0, 0, time.UTC)
+	assert.NoError(t, s.Send([]Data{
+		{"reqid": "a", "status": 200, "time": ts, "other": 1},
+		{"reqid": "b", "status": 404},
+		{"reqid": "c"},
+	}))
+	assert.Equal(t, []string{
+		"{\"id\":\"a\",\"status\":200,\"time\":\"2017-07-01T09:00:00Z\"}\n{\"id\":\"b\",\"status\":404}\n",
+		"{\"id\":\"c\"}\n",
+	}, rec.bodies)
+	q := rec.reqs[0].URL.Query()
+	assert.Equal(t, "INSERT INTO `logs`.`nginx` (`id`, `status`, `time`) FORMAT JSONEachRow", q.Get("query"))
+	assert.Equal(t, "logs", q.Get("database"))
+	assert.Equal(t, "1", q.Get("async_insert"))
+	assert.Equal(t, "0", q.Get("wait_for_async_insert"))
+	assert.Equal(t, "2", q.Get("insert_quorum"))
+	assert.Equal(t, "best_effort", q.Get("date_time_input_format"))
+	assert.Equal(t, "user", rec.reqs[0].Header.Get("X-ClickHouse-User"))
+	assert.Equal(t, "pass", rec.reqs[0].Header.Get("X-ClickHouse-Key"))
+}
+
+func TestClickhouseSenderErrors(t *testing.T) {
+	rec := &httpRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	s, err := NewClickhouseSender(conf.MapConf{
+		KeyClickhouseHost:  srv.URL,
+		KeyClickhouseTable: "nginx",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	datas := []Data{{"a": 1}, {"a": "x"}}
+
+	// 数据无法解析时拆分后重试
+	rec.status = statusCode(http.StatusInternalServerError)
+	rec.response = "Code: 27. DB::Exception: Cannot parse input: expected '\"' before: 'x'"
+	err = s.Send(datas)
+	se, ok := err.(*SendError)
+	assert.True(t, ok)
+	assert.Equal(t, TypeBinaryUnpack, se.ErrorType)
+	assert.Equal(t, datas, FailDatas(err, datas))
+	assert.Equal(t, "INSERT INTO `default`.`nginx` FORMAT JSONEachRow", rec.reqs[0].URL.Query().Get("query"))
+
+	// 表不存在时不再重试
+	rec.status = statusCode(http.StatusNotFound)
+	rec.header = http.Header{"X-Clickhouse-Exception-Code": []string{"60"}}
+	rec.response = "Code: 60. DB::Exception: Table default.nginx does not exist."
+	err = s.Send(datas)
+	assert.True(t, IsPermanentError(err))
+
+	// 过多的part等错误可以重试
+	rec.status = statusCode(http.StatusInternalServerError)
+	rec.header = http.Header{"X-Clickhouse-Exception-Code": []string{"252"}}
+	rec.response = "Code: 252. DB::Exception: Too many parts"
+	err = s.Send(datas)
+	assert.False(t, IsPermanentError(err))
+	assert.False(t, NeedSplit(err))
+	assert.Equal(t, datas, FailDatas(err, datas))
+	// 无法编码的数据不随可以重试的数据一起重试
+	invalid := Data{"a": math.Inf(1)}
+	err = s.Send(append([]Data{invalid}, datas...))
+	assert.Equal(t, datas, FailDatas(err, nil))
+	assert.Equal(t, []Data{invalid}, PermanentDatas(err))
+
+	// 没有错误码时按状态码判断
+	rec.status = statusCode(http.StatusForbidden)
+	rec.header = nil
+	rec.response = "forbidden"
+	assert.True(t, IsPermanentError(s.Send(datas)))
+}
+
+func TestClickhouseErrorCode(t *testing.T) {
+	assert.Equal(t, 60, clickhouseErrorCode(http.Header{"X-Clickhouse-Exception-Code": []string{"60"}}, nil))
+	assert.Equal(t, 27, clickhouseErrorCode(http.Header{}, []byte("Code: 27. DB::Exception: Cannot parse input")))
+	assert.Equal(t, 0, clickhouseErrorCode(http.Header{}, []byte("bad gateway")))
+}
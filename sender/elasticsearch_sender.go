@@ -1,8 +1,17 @@
 package sender
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/qiniu/logkit/conf"
 
@@ -10,23 +19,66 @@ import (
 	"gopkg.in/olivere/elastic.v3"
 )
 
+// ElasticsearchSender 通过bulk接口写入elasticsearch。elastic.v3只支持es 2.x的接口，
+// 为了兼容es 6、7之后去掉type的接口，请求直接使用http发送，只借用elastic.v3的返回结构
 type ElasticsearchSender struct {
 	name string
 
-	host      []string
-	retention int
-	indexName string
-	eType     string
+	hosts       []string
+	next        int
+	index       *fieldTemplate
+	timeKey     string
+	location    *time.Location
+	eType       string
+	version     int
+	idKey       string
+	idHash      bool
+	routingKey  string
+	pipeline    string
+	username    string
+	password    string
+	aliasFields map[string]string
+	client      *http.Client
+	now         func() time.Time
 
-	aliasFields   map[string]string
-	elasticClient *elastic.Client
+	mux sync.Mutex
 }
 
 const (
 	KeyElasticHost  = "elastic_host"
-	KeyElasticIndex = "elastic_index"
+	KeyElasticIndex = "elastic_index" // 索引名，%Y%m%d等引用数据的时间，%{field}引用数据中的字段，如logs-%Y.%m.%d
 	KeyElasticType  = "elastic_type"
 	KeyElasticAlias = "elastic_keys"
+
+	KeyElasticVersion      = "elastic_version"       // es的主版本号，默认为5，7及以上默认不再指定type
+	KeyElasticTimestampKey = "elastic_timestamp_key" // 索引名中的时间取自该字段，不配置时使用发送时的时间
+	KeyElasticTimezone     = "elastic_timezone"      // 索引名中时间的时区，默认为UTC，Local表示本机时区
+	KeyElasticIDKey        = "elastic_id_key"        // 作为文档_id的字段，重试时覆盖同一个文档而不会重复写入
+	KeyElasticIDHash       = "elastic_id_hash"       // 没有_id字段时使用文档内容的sha1作为_id
+	KeyElasticRoutingKey   = "elastic_routing_key"   // 作为routing的字段
+	KeyElasticPipeline     = "elastic_pipeline"      // 写入时使用的ingest pipeline
+	KeyElasticUsername     = "elastic_username"      // basic认证的用户名
+	KeyElasticPassword     = "elastic_password"      // basic认证的密码
+	KeyElasticTimeout      = "elastic_timeout"
+
+	KeyElasticTemplateName    = "elastic_template_name"      // 配置后启动时创建或更新该索引模板
+	KeyElasticTemplatePattern = "elastic_template_pattern"   // 模板匹配的索引，默认把elastic_index中的时间和字段替换为*
+	KeyElasticMapping         = "elastic_mapping"            // 字段在es中的类型，形如"status long,client_ip ip"，优先于parser给出的类型
+	KeyElasticShards          = "elastic_number_of_shards"   // 模板中索引的分片数
+	KeyElasticReplicas        = "elastic_number_of_replicas" // 模板中索引的副本数
+	KeyElasticILMPolicy       = "elastic_ilm_policy"         // 模板中索引使用的ILM策略，需要es 6.6以上
+	KeyElasticRolloverAlias   = "elastic_rollover_alias"     // ILM滚动时使用的别名
+
+	KeyElasticTLSCACert             = "elastic_tls_ca_cert"
+	KeyElasticTLSCert               = "elastic_tls_cert"
+	KeyElasticTLSKey                = "elastic_tls_key"
+	KeyElasticTLSInsecureSkipVerify = "elastic_tls_insecure_skip_verify"
+)
+
+const (
+	defaultType           string = "logkit"
+	defaultElasticVersion        = 5
+	defaultElasticTimeout        = 30 * time.Second
 )
 
 func NewElasticSender(conf conf.MapConf) (sender Sender, err error) {
@@ -36,78 +88,463 @@ func NewElasticSender(conf conf.MapConf) (sender Sender, err error) {
 		return
 	}
 	for i, h := range host {
-		if !strings.HasPrefix(h, "http://") {
-			host[i] = fmt.Sprintf("http://%s", h)
+		if !strings.HasPrefix(h, "http://") && !strings.HasPrefix(h, "https://") {
+			h = fmt.Sprintf("http://%s", h)
 		}
+		host[i] = strings.TrimSuffix(h, "/")
 	}
 
 	index, err := conf.GetString(KeyElasticIndex)
 	if err != nil {
 		return
 	}
-	eType, _ := conf.GetStringOr(KeyElasticType, defaultType)
+	version, _ := conf.GetIntOr(KeyElasticVersion, defaultElasticVersion)
+	deftType := defaultType
+	if version >= 7 {
+		deftType = ""
+	}
+	eType, _ := conf.GetStringOr(KeyElasticType, deftType)
 	name, _ := conf.GetStringOr(KeyName, fmt.Sprintf("elasticSender:(elasticUrl:%s,index:%s,type:%s)", host, index, eType))
 	fields, _ := conf.GetAliasMapOr(KeyElasticAlias, make(map[string]string))
 
-	return newElasticsearchSender(name, host, index, eType, fields)
-}
-
-const defaultType string = "logkit"
-
-func newElasticsearchSender(name string, hosts []string, index, eType string, fields map[string]string) (e *ElasticsearchSender, err error) {
-
-	client, err := elastic.NewClient(elastic.SetURL(hosts...))
+	es, err := newElasticsearchSender(name, host, index, eType, fields)
 	if err != nil {
 		return
 	}
+	es.version = version
+	es.timeKey, _ = conf.GetStringOr(KeyElasticTimestampKey, "")
+	tz, _ := conf.GetStringOr(KeyElasticTimezone, "UTC")
+	if es.location, err = time.LoadLocation(tz); err != nil {
+		return nil, fmt.Errorf("%v: %v", KeyElasticTimezone, err)
+	}
+	es.idKey, _ = conf.GetStringOr(KeyElasticIDKey, "")
+	es.idHash, _ = conf.GetBoolOr(KeyElasticIDHash, false)
+	es.routingKey, _ = conf.GetStringOr(KeyElasticRoutingKey, "")
+	es.pipeline, _ = conf.GetStringOr(KeyElasticPipeline, "")
+	es.username, _ = conf.GetStringOr(KeyElasticUsername, "")
+	es.password, _ = conf.GetStringOr(KeyElasticPassword, "")
 
-	exists, err := client.IndexExists(index).Do()
+	timeout := defaultElasticTimeout
+	if v, _ := conf.GetStringOr(KeyElasticTimeout, ""); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("%v: %v", KeyElasticTimeout, err)
+		}
+	}
+	ca, _ := conf.GetStringOr(KeyElasticTLSCACert, "")
+	cert, _ := conf.GetStringOr(KeyElasticTLSCert, "")
+	key, _ := conf.GetStringOr(KeyElasticTLSKey, "")
+	insecure, _ := conf.GetBoolOr(KeyElasticTLSInsecureSkipVerify, false)
+	tlsConfig, err := newTLSConfig(ca, cert, key, insecure)
 	if err != nil {
-		return
+		return nil, err
 	}
-	if !exists {
-		_, errIn := client.CreateIndex(index).Do()
-		if errIn != nil {
-			return nil, errIn
+	es.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+
+	// 模板需要在创建索引之前生效
+	if name, _ := conf.GetStringOr(KeyElasticTemplateName, ""); name != "" {
+		if err = es.putTemplate(name, conf); err != nil {
+			return nil, err
+		}
+	}
+	// 固定的索引在启动时创建，按时间或字段生成的索引由es在写入时自动创建
+	if es.index.Static() && !strings.Contains(index, "%") {
+		if err = es.ensureIndex(index); err != nil {
+			return nil, err
 		}
 	}
+	return es, nil
+}
 
+func newElasticsearchSender(name string, hosts []string, index, eType string, fields map[string]string) (e *ElasticsearchSender, err error) {
+	t, err := newFieldTemplate(index)
+	if err != nil {
+		return
+	}
 	e = &ElasticsearchSender{
-		name:          name,
-		host:          hosts,
-		indexName:     index,
-		elasticClient: client,
-		eType:         eType,
-		aliasFields:   fields,
+		name:        name,
+		hosts:       hosts,
+		index:       t,
+		eType:       eType,
+		version:     defaultElasticVersion,
+		location:    time.UTC,
+		aliasFields: fields,
+		client:      &http.Client{Timeout: defaultElasticTimeout},
+		now:         time.Now,
 	}
 	return
 }
 
 func (this *ElasticsearchSender) Name() string {
-	return "//" + this.indexName
+	return this.name
 }
 
-func (this *ElasticsearchSender) Send(data []Data) (err error) {
-	client := this.elasticClient
+// request 依次尝试每个host，请求没有完成时换下一个host重试，状态码为0表示所有host都无法访问
+func (this *ElasticsearchSender) request(method, path, contentType string, body []byte) (int, []byte, error) {
+	var lastErr error
+	for range this.hosts {
+		this.mux.Lock()
+		host := this.hosts[this.next%len(this.hosts)]
+		this.next++
+		this.mux.Unlock()
 
-	bulkService := client.Bulk()
+		req, err := http.NewRequest(method, host+path, bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if this.username != "" {
+			req.SetBasicAuth(this.username, this.password)
+		}
+		resp, err := this.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		content, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp.StatusCode, content, nil
+		}
+		if len(content) > 1024 {
+			content = content[:1024]
+		}
+		return resp.StatusCode, content, fmt.Errorf("%v %v%v: %v %s", method, host, path, resp.Status, bytes.TrimSpace(content))
+	}
+	return 0, nil, lastErr
+}
+
+// ensureIndex 索引不存在时创建
+func (this *ElasticsearchSender) ensureIndex(index string) error {
+	status, _, err := this.request(http.MethodHead, "/"+url.PathEscape(index), "", nil)
+	if err == nil {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return err
+	}
+	status, content, err := this.request(http.MethodPut, "/"+url.PathEscape(index), "", nil)
+	// 其他logkit同时创建了该索引
+	if status == http.StatusBadRequest && bytes.Contains(content, []byte("already_exists")) {
+		return nil
+	}
+	return err
+}
+
+// esFieldTypes parser给出的类型在es中对应的类型
+var esFieldTypes = map[string]string{
+	"long":   "long",
+	"float":  "double",
+	"date":   "date",
+	"string": "keyword",
+}
 
-	makeDoc := true
-	if len(this.aliasFields) == 0 {
-		makeDoc = false
+// parseFieldTypes 解析"name type,name type"形式的字段类型
+func parseFieldTypes(key string, list []string) (map[string]string, error) {
+	types := make(map[string]string)
+	for _, f := range list {
+		parts := strings.Fields(f)
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%v: %v should be like \"name type\"", key, f)
+		}
+		types[parts[0]] = parts[1]
 	}
+	return types, nil
+}
+
+// mappingProperties 根据parser_schema与elastic_mapping生成mapping中的properties，配置了elastic_keys时使用改名后的字段
+func (this *ElasticsearchSender) mappingProperties(c conf.MapConf) (map[string]interface{}, error) {
+	schemaList, _ := c.GetStringListOr(KeyParserSchema, []string{})
+	schema, err := parseFieldTypes(KeyParserSchema, schemaList)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string)
+	for name, t := range schema {
+		esType, ok := esFieldTypes[t]
+		if !ok {
+			continue
+		}
+		if len(this.aliasFields) > 0 {
+			if name, ok = this.aliasFields[name]; !ok {
+				continue
+			}
+		}
+		types[name] = esType
+	}
+	mappingList, _ := c.GetStringListOr(KeyElasticMapping, []string{})
+	mapping, err := parseFieldTypes(KeyElasticMapping, mappingList)
+	if err != nil {
+		return nil, err
+	}
+	for name, t := range mapping {
+		types[name] = t
+	}
+	props := make(map[string]interface{}, len(types))
+	for name, t := range types {
+		// es 5之前没有keyword和text，使用string
+		if this.version < 5 && t == "keyword" {
+			props[name] = map[string]interface{}{"type": "string", "index": "not_analyzed"}
+		} else if this.version < 5 && t == "text" {
+			props[name] = map[string]interface{}{"type": "string"}
+		} else {
+			props[name] = map[string]interface{}{"type": t}
+		}
+	}
+	return props, nil
+}
+
+// templatePattern 把索引名中的时间和字段替换为*，作为模板匹配的索引
+func templatePattern(index string) string {
+	var buf []byte
+	for i := 0; i < len(index); i++ {
+		if index[i] != '%' || i+1 >= len(index) {
+			buf = append(buf, index[i])
+			continue
+		}
+		if index[i+1] == '{' {
+			if end := strings.IndexByte(index[i:], '}'); end > 0 {
+				i += end
+			}
+		} else {
+			i++
+		}
+		if len(buf) == 0 || buf[len(buf)-1] != '*' {
+			buf = append(buf, '*')
+		}
+	}
+	return strings.ToLower(string(buf))
+}
+
+// putTemplate 创建或更新索引模板，es 8使用_index_template接口，之前的版本使用_template接口
+func (this *ElasticsearchSender) putTemplate(name string, c conf.MapConf) error {
+	props, err := this.mappingProperties(c)
+	if err != nil {
+		return err
+	}
+	settings := make(map[string]interface{})
+	if shards, _ := c.GetIntOr(KeyElasticShards, 0); shards > 0 {
+		settings["number_of_shards"] = shards
+	}
+	if replicas, err := c.GetInt(KeyElasticReplicas); err == nil {
+		settings["number_of_replicas"] = replicas
+	}
+	if policy, _ := c.GetStringOr(KeyElasticILMPolicy, ""); policy != "" {
+		settings["index.lifecycle.name"] = policy
+	}
+	if alias, _ := c.GetStringOr(KeyElasticRolloverAlias, ""); alias != "" {
+		settings["index.lifecycle.rollover_alias"] = alias
+	}
+	if len(props) == 0 && len(settings) == 0 {
+		log.Warnf("%v: template %v has neither mapping nor settings, skip", this.name, name)
+		return nil
+	}
+	pattern, _ := c.GetStringOr(KeyElasticTemplatePattern, templatePattern(this.index.String()))
+
+	var mappings interface{} = map[string]interface{}{"properties": props}
+	if this.version < 7 {
+		eType := this.eType
+		if eType == "" {
+			eType = "_doc"
+		}
+		mappings = map[string]interface{}{eType: mappings}
+	}
+	body := map[string]interface{}{}
+	path := "/_template/" + url.PathEscape(name)
+	switch {
+	case this.version >= 8:
+		path = "/_index_template/" + url.PathEscape(name)
+		tmpl := map[string]interface{}{"mappings": mappings}
+		if len(settings) > 0 {
+			tmpl["settings"] = settings
+		}
+		body["index_patterns"] = []string{pattern}
+		body["template"] = tmpl
+	case this.version >= 6:
+		body["index_patterns"] = []string{pattern}
+	default:
+		body["template"] = pattern
+	}
+	if this.version < 8 {
+		body["mappings"] = mappings
+		if len(settings) > 0 {
+			body["settings"] = settings
+		}
+	}
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if _, _, err = this.request(http.MethodPut, path, "application/json", content); err != nil {
+		return fmt.Errorf("put elasticsearch template %v error: %v", name, err)
+	}
+	log.Infof("%v: put elasticsearch template %v for %v", this.name, name, pattern)
+	return nil
+}
+
+// indexName 根据数据的时间和字段生成索引名，es的索引名只能是小写
+func (this *ElasticsearchSender) indexName(d Data, now time.Time) (string, bool) {
+	ts := recordTime(d, this.timeKey, now).In(this.location)
+	index, ok := this.index.renderTime(d, ts, func(v interface{}) string { return fmt.Sprint(v) })
+	if !ok {
+		return "", false
+	}
+	return strings.ToLower(index), true
+}
+
+// action 生成一条数据在bulk请求中的两行
+func (this *ElasticsearchSender) action(doc Data, now time.Time) ([]byte, error) {
+	index, ok := this.indexName(doc, now)
+	if !ok {
+		return nil, fmt.Errorf("data %v does not have the fields in index %v", doc, this.index)
+	}
+	source, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	meta := map[string]interface{}{"_index": index}
+	if this.eType != "" {
+		meta["_type"] = this.eType
+	}
+	if id := this.docID(doc, source); id != "" {
+		meta["_id"] = id
+	}
+	if this.routingKey != "" {
+		if v, ok := doc[this.routingKey]; ok && v != nil {
+			// es 7去掉了元数据中的下划线
+			if this.version >= 7 {
+				meta["routing"] = fmt.Sprint(v)
+			} else {
+				meta["_routing"] = fmt.Sprint(v)
+			}
+		}
+	}
+	line, err := json.Marshal(map[string]interface{}{"index": meta})
+	if err != nil {
+		return nil, err
+	}
+	line = append(line, '\n')
+	line = append(line, source...)
+	return append(line, '\n'), nil
+}
+
+// docID 优先使用elastic_id_key字段，配置了elastic_id_hash时使用文档内容的sha1，否则由es生成_id
+func (this *ElasticsearchSender) docID(doc Data, source []byte) string {
+	if this.idKey != "" {
+		if v, ok := doc[this.idKey]; ok && v != nil {
+			if id := fmt.Sprint(v); id != "" {
+				return id
+			}
+		}
+	}
+	if this.idHash {
+		sum := sha1.Sum(source)
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+func (this *ElasticsearchSender) Send(data []Data) (err error) {
+	now := this.now()
+	makeDoc := len(this.aliasFields) > 0
+	var body bytes.Buffer
+	// invalid 是无法转换为bulk请求的数据，重试也不会成功
+	var sent, invalid, failDatas []Data
+	var lastErr error
 	for _, doc := range data {
+		d := doc
 		if makeDoc {
-			doc = this.wrapDoc(doc)
+			d = this.wrapDoc(doc)
+		}
+		line, err := this.action(d, now)
+		if err != nil {
+			invalid = append(invalid, doc)
+			lastErr = err
+			continue
+		}
+		body.Write(line)
+		sent = append(sent, doc)
+	}
+	permanent := true
+	if len(sent) > 0 {
+		path := "/_bulk"
+		if this.pipeline != "" {
+			path += "?pipeline=" + url.QueryEscape(this.pipeline)
+		}
+		status, content, err := this.request(http.MethodPost, path, "application/x-ndjson", body.Bytes())
+		switch {
+		case err != nil && status == http.StatusRequestEntityTooLarge:
+			// 请求过大时拆分后重新发送
+			return NewSendError("elasticsearch request entity too large: "+err.Error(), append(invalid, sent...), TypeBinaryUnpack)
+		case err != nil:
+			failDatas = sent
+			lastErr = err
+			permanent = status > 0 && IsPermanentStatus(status)
+		default:
+			resp := &elastic.BulkResponse{}
+			if err := json.Unmarshal(content, resp); err != nil {
+				return NewSendError("elasticsearch bulk response unmarshal error: "+err.Error(), sent, TypeDefault).WithPermanent(invalid)
+			}
+			if err := bulkError(resp, sent); err != nil {
+				failDatas = FailDatas(err, sent)
+				lastErr = err
+				permanent = IsPermanentError(err)
+			}
 		}
-		bulkService.Add(elastic.NewBulkIndexRequest().Index(this.indexName).Type(this.eType).Doc(&doc))
 	}
+	if lastErr == nil {
+		return nil
+	}
+	msg := fmt.Sprintf("elasticsearch sender failed to send %v of %v datas, last error %v", len(invalid)+len(failDatas), len(data), lastErr)
+	if permanent {
+		return NewPermanentError(NewSendError(msg, append(invalid, failDatas...), TypeDefault))
+	}
+	// 可以重试的数据继续重试，无法转换的数据交给dead letter
+	return NewSendError(msg, failDatas, TypeDefault).WithPermanent(invalid)
+}
 
-	_, err = bulkService.Do()
-	if err != nil {
-		return
+// bulkError 根据bulk中每条请求的结果返回失败的数据，只有全部失败都是永久错误时才返回PermanentError
+func bulkError(resp *elastic.BulkResponse, datas []Data) error {
+	if resp == nil || !resp.Errors {
+		return nil
 	}
-	return
+	var failDatas []Data
+	var lastErr string
+	permanent := true
+	for i, item := range resp.Items {
+		if i >= len(datas) {
+			break
+		}
+		for _, r := range item {
+			if r == nil || (r.Error == nil && r.Status < 300) {
+				continue
+			}
+			failDatas = append(failDatas, datas[i])
+			permanent = permanent && IsPermanentStatus(r.Status)
+			if r.Error != nil {
+				lastErr = r.Error.Type + ": " + r.Error.Reason
+			}
+		}
+	}
+	if len(failDatas) <= 0 {
+		return nil
+	}
+	se := NewSendError(fmt.Sprintf("elasticsearch bulk failed %v of %v datas, last error %v", len(failDatas), len(datas), lastErr), failDatas, TypeDefault)
+	if permanent {
+		return NewPermanentError(se)
+	}
+	return se
 }
 
 func (this *ElasticsearchSender) Close() error {
@@ -0,0 +1,233 @@
+package sender
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+func TestBulkError(t *testing.T) {
+	datas := []Data{{"a": 1}, {"a": 2}, {"a": 3}}
+	assert.Nil(t, bulkError(nil, datas))
+	assert.Nil(t, bulkError(&elastic.BulkResponse{}, datas))
+
+	resp := &elastic.BulkResponse{
+		Errors: true,
+		Items: []map[string]*elastic.BulkResponseItem{
+			{"index": {Status: 201}},
+			{"index": {Status: 400, Error: &elastic.ErrorDetails{Type: "mapper_parsing_exception", Reason: "failed to parse [a]"}}},
+			{"index": {Status: 429, Error: &elastic.ErrorDetails{Type: "es_rejected_execution_exception", Reason: "queue full"}}},
+		},
+	}
+	// 只返回失败的数据，其中有可以重试的错误
+	err := bulkError(resp, datas)
+	assert.False(t, IsPermanentError(err))
+	assert.Equal(t, []Data{{"a": 2}, {"a": 3}}, FailDatas(err, datas))
+
+	resp.Items = resp.Items[:2]
+	err = bulkError(resp, datas)
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, []Data{{"a": 2}}, FailDatas(err, datas))
+}
+
+// fakeElastic 记录收到的请求，bulk请求按items返回每条数据的结果
+type fakeElastic struct {
+	mux     sync.Mutex
+	reqs    []*http.Request
+	bodies  []string
+	indices map[string]bool
+	items   func(i int, meta map[string]map[string]interface{}) int
+}
+
+func (f *fakeElastic) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.reqs = append(f.reqs, req)
+	f.bodies = append(f.bodies, string(body))
+	if user, pass, ok := req.BasicAuth(); !ok || user != "elastic" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if req.URL.Path != "/_bulk" {
+		index := strings.TrimPrefix(req.URL.Path, "/")
+		switch req.Method {
+		case http.MethodHead:
+			if !f.indices[index] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.indices[index] = true
+		}
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	resp := elastic.BulkResponse{}
+	for i := 0; i < len(lines); i += 2 {
+		var meta map[string]map[string]interface{}
+		json.Unmarshal([]byte(lines[i]), &meta)
+		status := http.StatusCreated
+		if f.items != nil {
+			status = f.items(i/2, meta)
+		}
+		item := &elastic.BulkResponseItem{Status: status}
+		if status >= 300 {
+			resp.Errors = true
+			item.Error = &elastic.ErrorDetails{Type: "error", Reason: "rejected"}
+		}
+		resp.Items = append(resp.Items, map[string]*elastic.BulkResponseItem{"index": item})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestElasticsearchSender(t *testing.T) {
+	fe := &fakeElastic{indices: map[string]bool{}}
+	srv := httptest.NewServer(fe)
+	defer srv.Close()
+
+	c := conf.MapConf{
+		KeyElasticHost:         srv.URL,
+		KeyElasticIndex:        "logs-%{app}-%Y.%m.%d",
+		KeyElasticVersion:      "7",
+		KeyElasticTimestampKey: "time",
+		KeyElasticIDKey:        "id",
+		KeyElasticIDHash:       "true",
+		KeyElasticRoutingKey:   "user",
+		KeyElasticPipeline:     "geoip",
+		KeyElasticUsername:     "elastic",
+		KeyElasticPassword:     "secret",
+	}
+	s, err := NewElasticSender(c)
+	assert.NoError(t, err)
+	// 按时间生成的索引不在启动时创建
+	assert.Equal(t, 0, len(fe.reqs))
+	es := s.(*ElasticsearchSender)
+	es.now = func() time.Time { return time.Date(2017, 7, 1, 9, 0, 0, 0, time.UTC) }
+
+	datas := []Data{
+		{"app": "Nginx", "id": "a1", "user": "u1", "time": "2017-06-30T23:00:00-02:00"},
+		{"app": "nginx", "msg": "hello"},
+		{"msg": "no app"},
+	}
+	err = s.Send(datas)
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, []Data{datas[2]}, FailDatas(err, datas))
+	assert.Equal(t, "geoip", fe.reqs[0].URL.Query().Get("pipeline"))
+	lines := strings.Split(strings.TrimSpace(fe.bodies[0]), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, `{"index":{"_id":"a1","_index":"logs-nginx-2017.07.01","routing":"u1"}}`, lines[0])
+	sum := sha1.Sum([]byte(lines[3]))
+	assert.Equal(t, `{"index":{"_id":"`+hex.EncodeToString(sum[:])+`","_index":"logs-nginx-2017.07.01"}}`, lines[2])
+
+	// 只有被拒绝的数据作为失败返回
+	fe.items = func(i int, meta map[string]map[string]interface{}) int {
+		if i == 1 {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusCreated
+	}
+	err = s.Send(datas[:2])
+	assert.False(t, IsPermanentError(err))
+	assert.Equal(t, []Data{datas[1]}, FailDatas(err, datas[:2]))
+
+	// 可以重试的数据和无法转换的数据同时存在时，只重试前者
+	err = s.Send(datas)
+	assert.False(t, IsPermanentError(err))
+	assert.Equal(t, []Data{datas[1]}, FailDatas(err, datas))
+	assert.Equal(t, []Data{datas[2]}, PermanentDatas(err))
+}
+
+func TestElasticsearchSenderStaticIndex(t *testing.T) {
+	fe := &fakeElastic{indices: map[string]bool{}}
+	srv := httptest.NewServer(fe)
+	defer srv.Close()
+
+	c := conf.MapConf{
+		KeyElasticHost:     "127.0.0.1:1," + srv.URL,
+		KeyElasticIndex:    "logs",
+		KeyElasticUsername: "elastic",
+		KeyElasticPassword: "secret",
+	}
+	s, err := NewElasticSender(c)
+	assert.NoError(t, err)
+	assert.True(t, fe.indices["logs"])
+	assert.NoError(t, s.Send([]Data{{"a": 1}}))
+	last := strings.Split(fe.bodies[len(fe.bodies)-1], "\n")[0]
+	assert.Equal(t, `{"index":{"_index":"logs","_type":"logkit"}}`, last)
+
+	c[KeyElasticPassword] = "wrong"
+	_, err = NewElasticSender(c)
+	assert.Error(t, err)
+}
+
+func TestElasticsearchTemplate(t *testing.T) {
+	fe := &fakeElastic{indices: map[string]bool{}}
+	srv := httptest.NewServer(fe)
+	defer srv.Close()
+
+	c := conf.MapConf{
+		KeyElasticHost:          srv.URL,
+		KeyElasticIndex:         "logs-%{app}-%Y.%m.%d",
+		KeyElasticVersion:       "7",
+		KeyElasticUsername:      "elastic",
+		KeyElasticPassword:      "secret",
+		KeyElasticTemplateName:  "logkit",
+		KeyElasticMapping:       "client ip,status long",
+		KeyElasticReplicas:      "0",
+		KeyElasticILMPolicy:     "logs",
+		KeyParserSchema:         "cost float,status string,ts date",
+		KeyElasticRolloverAlias: "logs",
+	}
+	_, err := NewElasticSender(c)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(fe.reqs))
+	assert.Equal(t, "/_template/logkit", fe.reqs[0].URL.Path)
+	assert.Equal(t, "application/json", fe.reqs[0].Header.Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"index_patterns":["logs-*-*.*.*"],
+		"settings":{"number_of_replicas":0,"index.lifecycle.name":"logs","index.lifecycle.rollover_alias":"logs"},
+		"mappings":{"properties":{
+			"client":{"type":"ip"},
+			"cost":{"type":"double"},
+			"status":{"type":"long"},
+			"ts":{"type":"date"}
+		}}
+	}`, fe.bodies[0])
+
+	// es 8使用_index_template，es 5使用template并且mapping在type之下
+	c[KeyElasticVersion] = "8"
+	c[KeyElasticTemplatePattern] = "logs-*"
+	delete(c, KeyElasticMapping)
+	delete(c, KeyElasticReplicas)
+	delete(c, KeyElasticILMPolicy)
+	delete(c, KeyElasticRolloverAlias)
+	_, err = NewElasticSender(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "/_index_template/logkit", fe.reqs[1].URL.Path)
+	assert.JSONEq(t, `{"index_patterns":["logs-*"],"template":{"mappings":{"properties":{
+		"cost":{"type":"double"},"status":{"type":"keyword"},"ts":{"type":"date"}}}}}`, fe.bodies[1])
+
+	c[KeyElasticVersion] = "5"
+	c[KeyElasticAlias] = "status code"
+	_, err = NewElasticSender(c)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"template":"logs-*","mappings":{"logkit":{"properties":{"code":{"type":"keyword"}}}}}`, fe.bodies[2])
+}
+
+func TestTemplatePattern(t *testing.T) {
+	assert.Equal(t, "logs-*-*.*.*", templatePattern("logs-%{app}-%Y.%m.%d"))
+	assert.Equal(t, "logs-*", templatePattern("Logs-%{app}%Y"))
+	assert.Equal(t, "logs", templatePattern("logs"))
+}
@@ -3,6 +3,7 @@ package sender
 import (
 	"bytes"
 	"encoding/json"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -48,6 +49,11 @@ type FtSender struct {
 	backupOnly  bool // 是否只使用backup queue
 	procs       int  //发送并发数
 	se          *utils.StatsError
+
+	mux        sync.RWMutex
+	backoff    utils.Backoff                 // 从backup queue重试时的等待时间
+	breaker    *utils.CircuitBreaker         // 连续失败后暂停从队列中发送
+	deadLetter func(datas []Data, err error) // 处理重试也不会成功的数据，为空时丢弃
 }
 
 type datasContext struct {
@@ -84,6 +90,13 @@ func newFtSender(innerSender Sender, saveLogPath string, syncEvery int64, writeL
 		backupOnly:  backupOnly,
 		procs:       procs,
 		se:          &utils.StatsError{Ft: true},
+		backoff: utils.Backoff{
+			Initial:    DefaultRetryInterval,
+			Max:        DefaultRetryMaxInterval,
+			Multiplier: DefaultRetryMultiplier,
+			Jitter:     DefaultRetryJitter,
+		},
+		breaker: utils.NewCircuitBreaker(DefaultBreakerThreshold, DefaultBreakerProbeInterval),
 	}
 	go ftSender.asyncSendLogFromDiskQueue()
 	return &ftSender, nil
@@ -93,10 +106,43 @@ func (ft *FtSender) Name() string {
 	return ft.innerSender.Name() + "(ft)"
 }
 
+// SetRetryPolicy 设置从队列发送失败后的指数退避与熔断
+func (ft *FtSender) SetRetryPolicy(backoff utils.Backoff, breaker *utils.CircuitBreaker) {
+	ft.mux.Lock()
+	defer ft.mux.Unlock()
+	ft.backoff = backoff
+	ft.breaker = breaker
+}
+
+// SetDeadLetter 设置重试也不会成功的数据的处理方式，不设置时这些数据会被丢弃
+func (ft *FtSender) SetDeadLetter(f func(datas []Data, err error)) {
+	ft.mux.Lock()
+	defer ft.mux.Unlock()
+	ft.deadLetter = f
+}
+
+func (ft *FtSender) retryPolicy() (utils.Backoff, *utils.CircuitBreaker) {
+	ft.mux.RLock()
+	defer ft.mux.RUnlock()
+	return ft.backoff, ft.breaker
+}
+
+// discard 把重试也不会成功的数据交给dead letter
+func (ft *FtSender) discard(datas []Data, err error) {
+	ft.mux.RLock()
+	deadLetter := ft.deadLetter
+	ft.mux.RUnlock()
+	if deadLetter == nil {
+		log.Errorf("%s cannot write points, discard %v datas: %v", ft.innerSender.Name(), len(datas), err)
+		return
+	}
+	deadLetter(datas, err)
+}
+
 func (ft *FtSender) Send(datas []Data) error {
 	if ft.backupOnly {
 		// 尝试直接发送数据，当数据失败的时候会加入到本地重试队列。外部不需要重试
-		err := ft.trySendDatas(datas, 1)
+		err := ft.trySendDatas(datas, time.Second)
 		if err != nil {
 			log.Warn(ft.innerSender.Name() + " trySendDatas err" + err.Error())
 			ft.se.AddErrors()
@@ -106,12 +152,14 @@ func (ft *FtSender) Send(datas []Data) error {
 		// 容错队列会保证重试，此处不向外部暴露发送错误信息
 		ft.se.ErrorDetail = nil
 		ft.se.Ftlag = ft.backupQueue.Depth()
+		ft.se.FtBytes = ft.backupQueue.Bytes()
 	} else {
 		err := ft.saveToFile(datas)
 		if err != nil {
 			return err
 		}
 		ft.se.Ftlag = ft.backupQueue.Depth() + ft.logQueue.Depth()
+		ft.se.FtBytes = ft.backupQueue.Bytes() + ft.logQueue.Bytes()
 		ft.se.ErrorDetail = nil
 	}
 	return ft.se
@@ -181,7 +229,7 @@ func (ft *FtSender) asyncSendLogFromDiskQueue() {
 }
 
 // trySend 从bytes反序列化数据后尝试发送数据
-func (ft *FtSender) trySendBytes(dat []byte, failSleep int) (err error) {
+func (ft *FtSender) trySendBytes(dat []byte, failSleep time.Duration) (err error) {
 	datas, err := ft.unmarshalData(dat)
 	if err != nil {
 		return
@@ -189,44 +237,93 @@ func (ft *FtSender) trySendBytes(dat []byte, failSleep int) (err error) {
 	return ft.trySendDatas(datas, failSleep)
 }
 
-// trySendDatas 尝试发送数据，如果失败，将失败数据加入backup queue，并睡眠指定时间。返回结果为是否正常发送
-func (ft *FtSender) trySendDatas(datas []Data, failSleep int) (err error) {
+// trySendDatas 尝试发送数据，如果失败，将可以重试的数据加入backup queue，并睡眠指定时间，
+// 重试也不会成功的数据交给dead letter。返回结果为是否正常发送
+func (ft *FtSender) trySendDatas(datas []Data, failSleep time.Duration) (err error) {
+	_, breaker := ft.retryPolicy()
 	err = ft.innerSender.Send(datas)
 	if c, ok := err.(*utils.StatsError); ok {
 		err = c.ErrorDetail
 	}
-	if err != nil {
-		log.Errorf("%s cannot write points + %v", ft.innerSender.Name(), err)
-		failCtx := new(datasContext)
-		var binaryUnpack bool
-		se, succ := err.(*SendError)
-		if !succ {
-			// 如果不是SendError 默认所有的数据都发送失败
-			log.Infof("error type is not *SendError! reSend all datas by default")
-			failCtx.Datas = datas
-		} else {
-			failCtx.Datas = se.failDatas
-			if se.ErrorType == TypeBinaryUnpack {
-				binaryUnpack = true
-			}
+	if err == nil {
+		breaker.Success()
+		return
+	}
+	// 永久错误与被拒绝的数据说明sender本身可以正常处理请求，不计入熔断
+	if IsPermanentError(err) {
+		// 重试也不会成功，不再放入重试队列
+		ft.discard(FailDatas(err, datas), err)
+		return
+	}
+	if pd := PermanentDatas(err); len(pd) > 0 {
+		ft.discard(pd, err)
+	}
+	log.Errorf("%s cannot write points + %v", ft.innerSender.Name(), err)
+	failCtx := new(datasContext)
+	var binaryUnpack bool
+	se, succ := err.(*SendError)
+	if !succ {
+		// 如果不是SendError 默认所有的数据都发送失败
+		log.Infof("error type is not *SendError! reSend all datas by default")
+		failCtx.Datas = datas
+	} else {
+		failCtx.Datas = se.failDatas
+		if se.ErrorType == TypeBinaryUnpack {
+			binaryUnpack = true
 		}
-		if binaryUnpack {
-			lens := len(failCtx.Datas) / 2
-			if lens > 0 {
-				newFailCtx := new(datasContext)
-				newFailCtx.Datas = failCtx.Datas[0:lens]
-				failCtx.Datas = failCtx.Datas[lens:]
-				nnBytes, _ := json.Marshal(newFailCtx)
-				ft.backupQueue.Put(nnBytes)
-			}
+	}
+	if binaryUnpack {
+		lens := len(failCtx.Datas) / 2
+		if lens <= 0 {
+			// 已经拆分到单条数据仍然被拒绝，重试也不会成功
+			ft.discard(failCtx.Datas, err)
+			return
 		}
-		newBytes, _ := json.Marshal(failCtx)
-		ft.backupQueue.Put(newBytes)
-		time.Sleep(time.Second * time.Duration(failSleep))
+		newFailCtx := new(datasContext)
+		newFailCtx.Datas = failCtx.Datas[0:lens]
+		failCtx.Datas = failCtx.Datas[lens:]
+		nnBytes, _ := json.Marshal(newFailCtx)
+		ft.backupQueue.Put(nnBytes)
+	} else {
+		breaker.Failure()
 	}
+	newBytes, _ := json.Marshal(failCtx)
+	ft.backupQueue.Put(newBytes)
+	ft.sleep(failSleep)
 	return
 }
 
+// sleep 等待d，sender关闭时提前返回false
+func (ft *FtSender) sleep(d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for {
+		if atomic.LoadInt32(&ft.stopped) > 0 {
+			return false
+		}
+		remain := deadline.Sub(time.Now())
+		if remain <= 0 {
+			return true
+		}
+		if remain > 100*time.Millisecond {
+			remain = 100 * time.Millisecond
+		}
+		time.Sleep(remain)
+	}
+}
+
+// waitBreaker 熔断期间等待到可以探测时再发送，等待时sender关闭则把数据放回原来的队列并返回false
+func (ft *FtSender) waitBreaker(q queue.BackendQueue, dat []byte) bool {
+	_, breaker := ft.retryPolicy()
+	wait := breaker.Wait()
+	if wait <= 0 || ft.sleep(wait) {
+		return true
+	}
+	if err := q.Put(dat); err != nil {
+		log.Errorf("%s cannot put data back into queue %v, error %v", ft.innerSender.Name(), q.Name(), err)
+	}
+	return false
+}
+
 func (ft *FtSender) sendFromStreamQueue() {
 	readChan := ft.logQueue.ReadChan()
 	timer := time.NewTicker(time.Second)
@@ -237,7 +334,10 @@ func (ft *FtSender) sendFromStreamQueue() {
 		}
 		select {
 		case dat := <-readChan:
-			err := ft.trySendBytes(dat, 1)
+			if !ft.waitBreaker(ft.logQueue, dat) {
+				continue
+			}
+			err := ft.trySendBytes(dat, time.Second)
 			if err != nil {
 				log.Errorf("%s cannot send points from queue %v, error %v", ft.innerSender.Name(), ft.logQueue.Name(), err)
 				ft.se.AddErrors()
@@ -253,7 +353,8 @@ func (ft *FtSender) sendFromStreamQueue() {
 func (ft *FtSender) retryFromBackupQueue() {
 	readChan := ft.backupQueue.ReadChan()
 	timer := time.NewTicker(time.Second)
-	waitCnt := 1
+	// 连续失败的次数，按指数退避等待
+	retry := 1
 	for {
 		if atomic.LoadInt32(&ft.stopped) > 0 {
 			ft.exitChan <- struct{}{}
@@ -261,17 +362,18 @@ func (ft *FtSender) retryFromBackupQueue() {
 		}
 		select {
 		case dat := <-readChan:
-			err := ft.trySendBytes(dat, waitCnt)
+			if !ft.waitBreaker(ft.backupQueue, dat) {
+				continue
+			}
+			backoff, _ := ft.retryPolicy()
+			err := ft.trySendBytes(dat, backoff.Duration(retry))
 			if err == nil {
-				waitCnt = 1
+				retry = 1
 				ft.se.AddSuccess()
 			} else {
 				log.Errorf("%s cannot send points from queue %v, error is %v", ft.innerSender.Name(), ft.backupQueue.Name(), err)
 				ft.se.AddErrors()
-				waitCnt++
-				if waitCnt > 10 {
-					waitCnt = 10
-				}
+				retry++
 			}
 		case <-timer.C:
 			continue
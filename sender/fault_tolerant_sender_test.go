@@ -1,7 +1,9 @@
 package sender
 
 import (
+	"errors"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -42,3 +44,53 @@ func TestFtSender(t *testing.T) {
 		t.Error("Ft sender error exp 1 but got", fts.backupQueue.Depth())
 	}
 }
+
+type ftErrSender struct {
+	err   error
+	calls int32
+}
+
+func (s *ftErrSender) Name() string { return "ftErr" }
+func (s *ftErrSender) Send(datas []Data) error {
+	atomic.AddInt32(&s.calls, 1)
+	return s.err
+}
+func (s *ftErrSender) Close() error { return nil }
+
+func TestFtSenderDeadLetter(t *testing.T) {
+	dir := "TestFtSenderDeadLetter"
+	defer os.RemoveAll(dir)
+	inner := &ftErrSender{err: NewPermanentError(NewSendError("400 invalid schema", []Data{{"a": 2}}, TypeDefault))}
+	fts, err := newFtSender(inner, dir, 1, defaultWriteLimit, true, 1)
+	assert.NoError(t, err)
+	var letters []Data
+	fts.SetDeadLetter(func(datas []Data, err error) {
+		letters = append(letters, datas...)
+		assert.True(t, IsPermanentError(err))
+	})
+	// 永久错误的数据交给dead letter，不放入重试队列
+	fts.Send([]Data{{"a": 1}, {"a": 2}})
+	assert.Equal(t, []Data{{"a": 2}}, letters)
+	assert.Equal(t, int64(0), fts.backupQueue.Depth())
+	assert.NoError(t, fts.Close())
+}
+
+func TestFtSenderBreaker(t *testing.T) {
+	dir := "TestFtSenderBreaker"
+	defer os.RemoveAll(dir)
+	inner := &ftErrSender{err: errors.New("503 service unavailable")}
+	fts, err := newFtSender(inner, dir, 1, defaultWriteLimit, true, 1)
+	assert.NoError(t, err)
+	fts.SetRetryPolicy(utils.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 2}, utils.NewCircuitBreaker(2, time.Hour))
+
+	// 连续失败两次后熔断，backup queue中的数据不再重试
+	fts.Send([]Data{{"a": 1}})
+	time.Sleep(time.Second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&inner.calls))
+	_, breaker := fts.retryPolicy()
+	assert.Equal(t, utils.BreakerOpen, breaker.State())
+
+	// 熔断期间关闭时数据放回队列
+	assert.NoError(t, fts.Close())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&inner.calls))
+}
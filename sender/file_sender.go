@@ -1,71 +1,403 @@
 package sender
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
-	"os"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/qiniu/log"
 	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/utils"
 )
 
-// FileSender write datas into local file
-// only for test
+// FileSender 把数据写入本地文件，文件路径可以引用数据的字段和时间，文件按大小或时间滚动，关闭的文件可以压缩
 type FileSender struct {
 	name        string
-	file        *os.File
+	path        *fieldTemplate
+	defaultPath string
+	timeKey     string
+	format      string
 	marshalFunc func([]Data) ([]byte, error)
+	template    *fieldTemplate
+	csvFields   []string
+	csvSplitter rune
+	rotate      utils.RotateOptions
+	gzip        bool
+	idleTimeout time.Duration
+	maxOpen     int
+	now         func() time.Time
+
+	mux     sync.Mutex
+	writers map[string]*fileWriter
+	stop    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// fileWriter 一个打开的文件
+type fileWriter struct {
+	w         *utils.RotateWriter
+	lastWrite time.Time
 }
 
 // 可选参数 当sender_type 为file 的时候
 const (
-	KeyFileSenderPath = "file_send_path"
+	KeyFileSenderPath           = "file_send_path"            // 文件路径，${field}引用数据中的字段，%Y%m%d%H%M%S引用时间
+	KeyFileSenderDefaultPath    = "file_send_default_path"    // file_send_path引用的字段不存在时使用的路径
+	KeyFileSenderTimestampKey   = "file_send_timestamp_key"   // 路径中的时间取自该字段，不配置时使用写入时的时间
+	KeyFileSenderFormat         = "file_send_format"          // json, jsonl, csv, raw
+	KeyFileSenderCSVFields      = "file_send_csv_fields"      // format为csv时依次写入的字段，逗号分隔
+	KeyFileSenderCSVSplitter    = "file_send_csv_splitter"    // format为csv时的分隔符，默认为逗号
+	KeyFileSenderCSVHeader      = "file_send_csv_header"      // format为csv时是否在每个新文件开头写入表头，默认为true
+	KeyFileSenderTemplate       = "file_send_template"        // format为raw时每条数据的格式，%{field}引用数据中的字段
+	KeyFileSenderMaxSize        = "file_send_max_size"        // 单个文件的最大大小，单位为MB，超过后滚动
+	KeyFileSenderRotateInterval = "file_send_rotate_interval" // 文件打开超过该时间后滚动，如"1h"
+	KeyFileSenderMaxBackups     = "file_send_max_backups"     // 最多保留的滚动文件数，默认全部保留
+	KeyFileSenderGzip           = "file_send_gzip"            // 是否gzip压缩滚动和关闭的文件
+	KeyFileSenderIdleTimeout    = "file_send_idle_timeout"    // 超过该时间没有写入的文件被关闭，默认为5m
+	KeyFileSenderMaxOpenFiles   = "file_send_max_open_files"  // 同时打开的最大文件数，超过后关闭最久没有写入的文件
+)
+
+const (
+	FileFormatJSON      = "json"  // 每个batch写为一行json数组
+	FileFormatJSONLines = "jsonl" // 每条数据写为一行json
+	FileFormatCSV       = "csv"
+	FileFormatRaw       = "raw"
+)
+
+const (
+	defaultFileIdleTimeout  = 5 * time.Minute
+	defaultFileMaxOpenFiles = 64
 )
 
 // NewFileSender construct
-func NewFileSender(conf conf.MapConf) (sender Sender, err error) {
-	var path string
-	path, err = conf.GetString(KeyFileSenderPath)
+func NewFileSender(c conf.MapConf) (sender Sender, err error) {
+	path, err := c.GetString(KeyFileSenderPath)
 	if err != nil {
 		return
 	}
-	name, _ := conf.GetStringOr(KeyName, "fileSender:"+path)
-	sender, err = newFileSender(name, path, JSONLineMarshalFunc)
-	if err != nil {
-		return
+	fs := &FileSender{
+		writers: make(map[string]*fileWriter),
+		stop:    make(chan struct{}),
+		now:     time.Now,
+	}
+	if fs.path, err = parseFieldTemplate(path, "${"); err != nil {
+		return nil, err
+	}
+	fs.name, _ = c.GetStringOr(KeyName, "fileSender:"+path)
+	fs.defaultPath, _ = c.GetStringOr(KeyFileSenderDefaultPath, "")
+	fs.timeKey, _ = c.GetStringOr(KeyFileSenderTimestampKey, "")
+	fs.format, _ = c.GetStringOr(KeyFileSenderFormat, FileFormatJSON)
+	switch fs.format {
+	case FileFormatJSON:
+		fs.marshalFunc = JSONLineMarshalFunc
+	case FileFormatJSONLines:
+	case FileFormatCSV:
+		if fs.csvFields, err = c.GetStringList(KeyFileSenderCSVFields); err != nil {
+			return nil, err
+		}
+		splitter, _ := c.GetStringOr(KeyFileSenderCSVSplitter, ",")
+		if utf8.RuneCountInString(splitter) != 1 {
+			return nil, fmt.Errorf("%v must be a single character", KeyFileSenderCSVSplitter)
+		}
+		fs.csvSplitter, _ = utf8.DecodeRuneInString(splitter)
+		if header, _ := c.GetBoolOr(KeyFileSenderCSVHeader, true); header {
+			if fs.rotate.Header, err = fs.csvLine(fs.csvFields); err != nil {
+				return nil, err
+			}
+		}
+	case FileFormatRaw:
+		tmpl, err := c.GetString(KeyFileSenderTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if fs.template, err = newFieldTemplate(tmpl); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%v %v not supported", KeyFileSenderFormat, fs.format)
+	}
+
+	maxSize, _ := c.GetIntOr(KeyFileSenderMaxSize, 0)
+	fs.rotate.MaxSize = int64(maxSize) * 1024 * 1024
+	fs.rotate.MaxBackups, _ = c.GetIntOr(KeyFileSenderMaxBackups, -1)
+	if v, _ := c.GetStringOr(KeyFileSenderRotateInterval, ""); v != "" {
+		if fs.rotate.Interval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("%v: %v", KeyFileSenderRotateInterval, err)
+		}
+	}
+	fs.gzip, _ = c.GetBoolOr(KeyFileSenderGzip, false)
+	fs.rotate.Compress = fs.gzip
+	fs.idleTimeout = defaultFileIdleTimeout
+	if v, _ := c.GetStringOr(KeyFileSenderIdleTimeout, ""); v != "" {
+		if fs.idleTimeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("%v: %v", KeyFileSenderIdleTimeout, err)
+		}
 	}
-	return
+	fs.maxOpen, _ = c.GetIntOr(KeyFileSenderMaxOpenFiles, defaultFileMaxOpenFiles)
+	if fs.maxOpen <= 0 {
+		return nil, fmt.Errorf("%v must be positive", KeyFileSenderMaxOpenFiles)
+	}
+	if fs.idleTimeout > 0 {
+		fs.wg.Add(1)
+		go fs.closeIdleLoop()
+	}
+	return fs, nil
+}
+
+func (fs *FileSender) Name() string {
+	return fs.name
+}
+
+// filePath 根据路径模板生成数据写入的文件，引用的字段不存在时返回false
+func (fs *FileSender) filePath(d Data, now time.Time) (string, bool) {
+	path, ok := fs.path.renderTime(d, recordTime(d, fs.timeKey, now), pathValue)
+	if !ok {
+		return "", false
+	}
+	return filepath.Clean(path), true
 }
 
-func newFileSender(name, path string, marshalFunc func([]Data) ([]byte, error)) (*FileSender, error) {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+var pathValueReplacer = strings.NewReplacer("/", "_", "\\", "_")
+
+// pathValue 字段的值作为路径的一部分，不允许通过字段的值跳出模板指定的目录
+func pathValue(v interface{}) string {
+	s := pathValueReplacer.Replace(fmt.Sprint(v))
+	if s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// encode 把一条数据编码为文件中的一行
+func (fs *FileSender) encode(d Data) ([]byte, error) {
+	switch fs.format {
+	case FileFormatCSV:
+		record := make([]string, len(fs.csvFields))
+		for i, f := range fs.csvFields {
+			record[i] = csvValue(d[f])
+		}
+		return fs.csvLine(record)
+	case FileFormatRaw:
+		line, ok := fs.template.Render(d)
+		if !ok {
+			return nil, fmt.Errorf("data %v does not have the fields in template %v", d, fs.template)
+		}
+		return []byte(line + "\n"), nil
+	}
+	line, err := json.Marshal(d)
 	if err != nil {
 		return nil, err
 	}
-	return &FileSender{
-		name:        name,
-		file:        f,
-		marshalFunc: marshalFunc,
-	}, nil
+	return append(line, '\n'), nil
 }
 
-// Send inherit from Sender
+func (fs *FileSender) csvLine(record []string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = fs.csvSplitter
+	w.Write(record)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// csvValue 嵌套的map和slice写为json
+func csvValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// Send 按路径分组后写入，无法确定路径或无法编码的数据重试也不会成功，写文件失败的数据可以重试
 func (fs *FileSender) Send(datas []Data) error {
-	bytes, err := fs.marshalFunc(datas)
-	if err != nil {
-		return NewSendError(fs.Name()+" Cannot marshal data into file, error is "+err.Error(), datas, TypeDefault)
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	now := fs.now()
+	var paths []string
+	groups := make(map[string][]Data)
+	var failDatas []Data
+	var lastErr error
+	permanent := true
+	for _, d := range datas {
+		path, ok := fs.filePath(d, now)
+		if !ok {
+			if fs.defaultPath == "" {
+				failDatas = append(failDatas, d)
+				lastErr = fmt.Errorf("data %v does not have the fields in path %v", d, fs.path)
+				continue
+			}
+			path = formatTime(fs.defaultPath, recordTime(d, fs.timeKey, now))
+		}
+		if _, ok := groups[path]; !ok {
+			paths = append(paths, path)
+		}
+		groups[path] = append(groups[path], d)
 	}
-	_, err = fs.file.Write(bytes)
-	if err != nil {
-		return NewSendError(fs.Name()+"Cannot write data into file, error is "+err.Error(), datas, TypeDefault)
+	for _, path := range paths {
+		group := groups[path]
+		var content []byte
+		if fs.marshalFunc != nil {
+			b, err := fs.marshalFunc(group)
+			if err != nil {
+				failDatas = append(failDatas, group...)
+				lastErr = err
+				continue
+			}
+			content = b
+		} else {
+			var written []Data
+			for _, d := range group {
+				line, err := fs.encode(d)
+				if err != nil {
+					failDatas = append(failDatas, d)
+					lastErr = err
+					continue
+				}
+				content = append(content, line...)
+				written = append(written, d)
+			}
+			group = written
+		}
+		if len(content) <= 0 {
+			continue
+		}
+		if err := fs.write(path, content, now); err != nil {
+			failDatas = append(failDatas, group...)
+			lastErr = err
+			permanent = false
+		}
+	}
+	if lastErr == nil {
+		return nil
+	}
+	log.Errorf("%v failed to write %v of %v datas, last error %v", fs.name, len(failDatas), len(datas), lastErr)
+	se := NewSendError(fmt.Sprintf("file sender failed to write %v of %v datas, last error %v", len(failDatas), len(datas), lastErr), failDatas, TypeDefault)
+	if permanent {
+		return NewPermanentError(se)
+	}
+	return se
+}
+
+// write 写入一个文件，写入失败时关闭该文件，下次写入时重新打开
+func (fs *FileSender) write(path string, content []byte, now time.Time) error {
+	fw, ok := fs.writers[path]
+	if !ok {
+		if len(fs.writers) >= fs.maxOpen {
+			fs.closeOldest()
+		}
+		w, err := utils.NewRotateWriterWithOptions(path, fs.rotate)
+		if err != nil {
+			return err
+		}
+		fw = &fileWriter{w: w}
+		fs.writers[path] = fw
+	}
+	fw.lastWrite = now
+	if _, err := fw.w.Write(content); err != nil {
+		fw.w.Close()
+		delete(fs.writers, path)
+		return err
 	}
 	return nil
 }
 
-func (fs *FileSender) Name() string {
-	return fs.name
+// closeWriter 关闭文件，配置了file_send_gzip时压缩为 <path>.gz
+func (fs *FileSender) closeWriter(path string) error {
+	fw := fs.writers[path]
+	delete(fs.writers, path)
+	if err := fw.w.Close(); err != nil {
+		return err
+	}
+	if fs.gzip {
+		return utils.GzipFile(path)
+	}
+	return nil
+}
+
+func (fs *FileSender) closeOldest() {
+	var oldest string
+	var oldestTime time.Time
+	for path, fw := range fs.writers {
+		if oldest == "" || fw.lastWrite.Before(oldestTime) {
+			oldest, oldestTime = path, fw.lastWrite
+		}
+	}
+	if oldest == "" {
+		return
+	}
+	if err := fs.closeWriter(oldest); err != nil {
+		log.Errorf("%v close file %v error %v", fs.name, oldest, err)
+	}
 }
 
+// closeIdle 关闭超过file_send_idle_timeout没有写入的文件，例如按小时生成路径时上一个小时的文件
+func (fs *FileSender) closeIdle(now time.Time) {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	for path, fw := range fs.writers {
+		if now.Sub(fw.lastWrite) < fs.idleTimeout {
+			continue
+		}
+		if err := fs.closeWriter(path); err != nil {
+			log.Errorf("%v close idle file %v error %v", fs.name, path, err)
+		}
+	}
+}
+
+func (fs *FileSender) closeIdleLoop() {
+	defer fs.wg.Done()
+	interval := fs.idleTimeout
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fs.stop:
+			return
+		case <-ticker.C:
+			fs.closeIdle(fs.now())
+		}
+	}
+}
+
+// Close 关闭所有文件，配置了file_send_gzip时同样会压缩，重新启动后写入新的文件
 func (fs *FileSender) Close() error {
-	return fs.file.Close()
+	fs.once.Do(func() { close(fs.stop) })
+	fs.wg.Wait()
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	var lastErr error
+	for path := range fs.writers {
+		if err := fs.closeWriter(path); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
 }
 
 // JSONLineMarshalFunc  将数据json并且按换行符分隔
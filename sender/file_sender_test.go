@@ -0,0 +1,145 @@
+package sender
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readFileContent(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	return string(content)
+}
+
+func TestFileSenderPathTemplate(t *testing.T) {
+	dir := "TestFileSenderPathTemplate"
+	defer os.RemoveAll(dir)
+	s, err := NewFileSender(conf.MapConf{
+		KeyFileSenderPath:         filepath.Join(dir, "${app}/%Y%m%d/%H.log"),
+		KeyFileSenderDefaultPath:  filepath.Join(dir, "unknown.log"),
+		KeyFileSenderTimestampKey: "time",
+		KeyFileSenderFormat:       FileFormatJSONLines,
+		KeyFileSenderIdleTimeout:  "0s",
+		KeyFileSenderMaxOpenFiles: "2",
+	})
+	assert.NoError(t, err)
+	fs := s.(*FileSender)
+	fs.now = func() time.Time { return time.Date(2017, 7, 1, 9, 30, 0, 0, time.UTC) }
+	ts := time.Date(2017, 6, 30, 23, 59, 0, 0, time.UTC)
+	assert.NoError(t, s.Send([]Data{
+		{"app": "nginx", "a": 1},
+		{"app": "nginx", "a": 2, "time": ts},
+		{"app": "../etc", "a": 3},
+		{"a": 4},
+	}))
+	assert.NoError(t, s.Close())
+
+	assert.Equal(t, "{\"a\":1,\"app\":\"nginx\"}\n", readFileContent(t, filepath.Join(dir, "nginx/20170701/09.log")))
+	assert.Equal(t, "{\"a\":2,\"app\":\"nginx\",\"time\":\"2017-06-30T23:59:00Z\"}\n", readFileContent(t, filepath.Join(dir, "nginx/20170630/23.log")))
+	// 字段的值不能跳出模板指定的目录
+	assert.Equal(t, "{\"a\":3,\"app\":\"../etc\"}\n", readFileContent(t, filepath.Join(dir, ".._etc/20170701/09.log")))
+	assert.Equal(t, "{\"a\":4}\n", readFileContent(t, filepath.Join(dir, "unknown.log")))
+}
+
+func TestFileSenderMissingField(t *testing.T) {
+	dir := "TestFileSenderMissingField"
+	defer os.RemoveAll(dir)
+	s, err := NewFileSender(conf.MapConf{
+		KeyFileSenderPath:     filepath.Join(dir, "${app}.log"),
+		KeyFileSenderFormat:   FileFormatRaw,
+		KeyFileSenderTemplate: "%{level} %{msg}",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	datas := []Data{{"app": "a", "level": "INFO", "msg": "ok"}, {"level": "INFO"}, {"app": "a", "level": "WARN"}}
+	err = s.Send(datas)
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, []Data{datas[1], datas[2]}, FailDatas(err, datas))
+	assert.NoError(t, s.Close())
+	assert.Equal(t, "INFO ok\n", readFileContent(t, filepath.Join(dir, "a.log")))
+}
+
+func TestFileSenderCSV(t *testing.T) {
+	dir := "TestFileSenderCSV"
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.csv")
+	c := conf.MapConf{
+		KeyFileSenderPath:      path,
+		KeyFileSenderFormat:    FileFormatCSV,
+		KeyFileSenderCSVFields: "a,b,c",
+	}
+	s, err := NewFileSender(c)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"a": 1, "b": "x,y", "c": map[string]interface{}{"k": 1}}, {"a": 2}}))
+	assert.NoError(t, s.Close())
+	// 重新打开时已有内容的文件不再写入表头
+	s, err = NewFileSender(c)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"b": "z"}}))
+	assert.NoError(t, s.Close())
+	assert.Equal(t, "a,b,c\n1,\"x,y\",\"{\"\"k\"\":1}\"\n2,,\n,z,\n", readFileContent(t, path))
+
+	c[KeyFileSenderCSVSplitter] = "||"
+	_, err = NewFileSender(c)
+	assert.Error(t, err)
+}
+
+func TestFileSenderRotateAndGzip(t *testing.T) {
+	dir := "TestFileSenderRotateAndGzip"
+	defer os.RemoveAll(dir)
+	s, err := NewFileSender(conf.MapConf{
+		KeyFileSenderPath:           filepath.Join(dir, "%H.log"),
+		KeyFileSenderFormat:         FileFormatJSONLines,
+		KeyFileSenderRotateInterval: "10m",
+		KeyFileSenderGzip:           "true",
+		KeyFileSenderIdleTimeout:    "30m",
+	})
+	assert.NoError(t, err)
+	fs := s.(*FileSender)
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 9, 0, 0, 0, now.Location())
+	fs.now = func() time.Time { return start }
+	assert.NoError(t, s.Send([]Data{{"a": 1}}))
+	assert.NoError(t, s.Send([]Data{{"a": 2}}))
+
+	// 下一个小时写入新的文件，上一个小时的文件空闲超时后关闭并压缩
+	fs.now = func() time.Time { return start.Add(time.Hour) }
+	assert.NoError(t, s.Send([]Data{{"a": 3}}))
+	fs.closeIdle(start.Add(time.Hour))
+	assert.Equal(t, 1, len(fs.writers))
+	assert.Equal(t, "{\"a\":1}\n{\"a\":2}\n", readGzipFile(t, filepath.Join(dir, "09.log.gz")))
+	_, err = os.Stat(filepath.Join(dir, "09.log"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, s.Close())
+	assert.Equal(t, "{\"a\":3}\n", readGzipFile(t, filepath.Join(dir, "10.log.gz")))
+}
+
+func readGzipFile(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	content, err := ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	return string(content)
+}
+
+func TestFormatTime(t *testing.T) {
+	tm := time.Date(2017, 7, 1, 9, 5, 3, 0, time.UTC)
+	assert.Equal(t, "2017/07/01 09:05:03 %q 100%", formatTime("%Y/%m/%d %H:%M:%S %q 100%%", tm))
+	assert.Equal(t, "a.log", formatTime("a.log", tm))
+}
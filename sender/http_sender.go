@@ -0,0 +1,274 @@
+package sender
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+)
+
+// HttpSender 把数据按批发送到任意的http接口
+type HttpSender struct {
+	name          string
+	url           string
+	method        string
+	format        string
+	template      *fieldTemplate
+	contentType   string
+	headers       map[string]string
+	auth          string
+	token         string
+	username      string
+	password      string
+	hmacSecret    []byte
+	hmacHeader    string
+	gzip          bool
+	maxBatchBytes int
+	retryStatus   []string
+	client        *http.Client
+}
+
+// Http sender 的可配置字段
+const (
+	KeyHttpSenderURL           = "http_sender_url"
+	KeyHttpSenderMethod        = "http_sender_method"          // 默认为POST
+	KeyHttpSenderFormat        = "http_sender_format"          // json, ndjson, template
+	KeyHttpSenderTemplate      = "http_sender_template"        // format为template时每条数据的格式，%{field}引用数据中的字段
+	KeyHttpSenderContentType   = "http_sender_content_type"    // 默认根据format确定
+	KeyHttpSenderHeaders       = "http_sender_headers"         // 额外的header，形如"X-Env:prod,X-Team:logs"
+	KeyHttpSenderAuth          = "http_sender_auth"            // none, bearer, basic, hmac
+	KeyHttpSenderToken         = "http_sender_token"           // bearer认证的token
+	KeyHttpSenderUsername      = "http_sender_username"        // basic认证的用户名
+	KeyHttpSenderPassword      = "http_sender_password"        // basic认证的密码
+	KeyHttpSenderHmacSecret    = "http_sender_hmac_secret"     // hmac签名的密钥
+	KeyHttpSenderHmacHeader    = "http_sender_hmac_header"     // hmac签名所在的header
+	KeyHttpSenderGzip          = "http_sender_gzip"            // 是否gzip压缩请求体
+	KeyHttpSenderMaxBatchBytes = "http_sender_max_batch_bytes" // 单个请求体的最大字节数，超过后拆分成多个请求
+	KeyHttpSenderRetryStatus   = "http_sender_retry_status"    // 需要重试的状态码，如"429,5xx"，其他非2xx状态码不再重试
+	KeyHttpSenderTimeout       = "http_sender_timeout"
+
+	KeyHttpSenderTLSCACert             = "http_sender_tls_ca_cert"
+	KeyHttpSenderTLSCert               = "http_sender_tls_cert"
+	KeyHttpSenderTLSKey                = "http_sender_tls_key"
+	KeyHttpSenderTLSInsecureSkipVerify = "http_sender_tls_insecure_skip_verify"
+)
+
+// http_sender_format 的取值
+const (
+	HttpFormatJSON     = "json"     // 一个请求发送一个json数组
+	HttpFormatNDJSON   = "ndjson"   // 每行一条json
+	HttpFormatTemplate = "template" // 每行一条按http_sender_template生成的数据
+)
+
+// http_sender_auth 的取值
+const (
+	HttpAuthNone   = "none"
+	HttpAuthBearer = "bearer"
+	HttpAuthBasic  = "basic"
+	HttpAuthHmac   = "hmac" // 请求体的HMAC-SHA256签名，header值为"sha256=<十六进制签名>"
+)
+
+const (
+	defaultHttpMaxBatchBytes = 2 * 1024 * 1024
+	defaultHttpRetryStatus   = "408,429,5xx"
+	defaultHttpHmacHeader    = "X-Logkit-Signature"
+	defaultHttpTimeout       = 30 * time.Second
+)
+
+// NewHttpSender 创建http的sender
+func NewHttpSender(c conf.MapConf) (s Sender, err error) {
+	url, err := c.GetString(KeyHttpSenderURL)
+	if err != nil {
+		return
+	}
+	hs := &HttpSender{url: url}
+	hs.name, _ = c.GetStringOr(KeyName, fmt.Sprintf("httpSender:(url:%s)", url))
+	hs.method, _ = c.GetStringOr(KeyHttpSenderMethod, http.MethodPost)
+	hs.format, _ = c.GetStringOr(KeyHttpSenderFormat, HttpFormatJSON)
+	deftContentType := "application/json"
+	switch hs.format {
+	case HttpFormatJSON:
+	case HttpFormatNDJSON:
+		deftContentType = "application/x-ndjson"
+	case HttpFormatTemplate:
+		deftContentType = "text/plain"
+		tmpl, err := c.GetString(KeyHttpSenderTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if hs.template, err = newFieldTemplate(tmpl); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%v %v not supported", KeyHttpSenderFormat, hs.format)
+	}
+	hs.contentType, _ = c.GetStringOr(KeyHttpSenderContentType, deftContentType)
+
+	headers, _ := c.GetStringListOr(KeyHttpSenderHeaders, []string{})
+	hs.headers = make(map[string]string)
+	for _, h := range headers {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("%v: header %v should be like Name:Value", KeyHttpSenderHeaders, h)
+		}
+		hs.headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	hs.auth, _ = c.GetStringOr(KeyHttpSenderAuth, HttpAuthNone)
+	switch hs.auth {
+	case HttpAuthNone:
+	case HttpAuthBearer:
+		if hs.token, err = c.GetString(KeyHttpSenderToken); err != nil {
+			return nil, err
+		}
+	case HttpAuthBasic:
+		if hs.username, err = c.GetString(KeyHttpSenderUsername); err != nil {
+			return nil, err
+		}
+		hs.password, _ = c.GetStringOr(KeyHttpSenderPassword, "")
+	case HttpAuthHmac:
+		secret, err := c.GetString(KeyHttpSenderHmacSecret)
+		if err != nil {
+			return nil, err
+		}
+		hs.hmacSecret = []byte(secret)
+		hs.hmacHeader, _ = c.GetStringOr(KeyHttpSenderHmacHeader, defaultHttpHmacHeader)
+	default:
+		return nil, fmt.Errorf("%v %v not supported", KeyHttpSenderAuth, hs.auth)
+	}
+
+	hs.gzip, _ = c.GetBoolOr(KeyHttpSenderGzip, false)
+	hs.maxBatchBytes, _ = c.GetIntOr(KeyHttpSenderMaxBatchBytes, defaultHttpMaxBatchBytes)
+	hs.retryStatus, _ = c.GetStringListOr(KeyHttpSenderRetryStatus, strings.Split(defaultHttpRetryStatus, ","))
+	for _, st := range hs.retryStatus {
+		if !validStatusPattern(st) {
+			return nil, fmt.Errorf("%v: %v is not a status code or class like 5xx", KeyHttpSenderRetryStatus, st)
+		}
+	}
+
+	timeout := defaultHttpTimeout
+	if v, _ := c.GetStringOr(KeyHttpSenderTimeout, ""); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("%v: %v", KeyHttpSenderTimeout, err)
+		}
+	}
+	ca, _ := c.GetStringOr(KeyHttpSenderTLSCACert, "")
+	cert, _ := c.GetStringOr(KeyHttpSenderTLSCert, "")
+	key, _ := c.GetStringOr(KeyHttpSenderTLSKey, "")
+	insecure, _ := c.GetBoolOr(KeyHttpSenderTLSInsecureSkipVerify, false)
+	tlsConfig, err := newTLSConfig(ca, cert, key, insecure)
+	if err != nil {
+		return nil, err
+	}
+	hs.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	return hs, nil
+}
+
+// validStatusPattern 状态码如"429"或者状态码的类别如"5xx"
+func validStatusPattern(p string) bool {
+	if len(p) != 3 {
+		return false
+	}
+	if strings.HasSuffix(p, "xx") {
+		return p[0] >= '1' && p[0] <= '5'
+	}
+	_, err := strconv.Atoi(p)
+	return err == nil
+}
+
+func (s *HttpSender) Name() string {
+	return s.name
+}
+
+// encode 把一条数据编码为请求体中的一行
+func (s *HttpSender) encode(d Data) ([]byte, error) {
+	if s.format == HttpFormatTemplate {
+		line, ok := s.template.Render(d)
+		if !ok {
+			return nil, fmt.Errorf("data %v does not have the fields in template %v", d, s.template)
+		}
+		return []byte(line), nil
+	}
+	return json.Marshal(d)
+}
+
+// body 生成请求体，json格式时是一个json数组，其他格式时每条数据一行
+func (s *HttpSender) body(b *lineBatch) []byte {
+	if s.format == HttpFormatJSON {
+		return append(append([]byte{'['}, b.join([]byte(","))...), ']')
+	}
+	return append(b.join([]byte("\n")), '\n')
+}
+
+// Send 按http_sender_max_batch_bytes拆分成多个请求依次发送，失败请求中的数据通过SendError返回
+func (s *HttpSender) Send(datas []Data) error {
+	result := newBatchResult(len(datas))
+	batcher := &lineBatcher{maxBytes: s.maxBatchBytes}
+	for i, d := range datas {
+		line, err := s.encode(d)
+		if err != nil {
+			result.addInvalid(d, err)
+			continue
+		}
+		batcher.add(i, d, line)
+	}
+	for _, b := range batcher.batches {
+		status, err := s.post(s.body(b))
+		if err != nil {
+			result.addFailed(b.datas, status, err, !s.retryable(status), status == http.StatusRequestEntityTooLarge)
+		}
+	}
+	return result.err(s.Name())
+}
+
+// retryable 状态码是否在需要重试的列表中
+func (s *HttpSender) retryable(status int) bool {
+	code := strconv.Itoa(status)
+	for _, p := range s.retryStatus {
+		if p == code || (strings.HasSuffix(p, "xx") && p[0] == code[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// post 发送一个请求，返回非2xx的状态码时返回错误，请求没有完成时状态码为0
+func (s *HttpSender) post(body []byte) (int, error) {
+	req, err := newBodyRequest(s.method, s.url, s.contentType, body, s.gzip)
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	switch s.auth {
+	case HttpAuthBearer:
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	case HttpAuthBasic:
+		req.SetBasicAuth(s.username, s.password)
+	case HttpAuthHmac:
+		// 对压缩前的请求体签名
+		mac := hmac.New(sha256.New, s.hmacSecret)
+		mac.Write(body)
+		req.Header.Set(s.hmacHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	status, _, msg, err := doRequest(s.client, req)
+	if err != nil || (status >= 200 && status < 300) {
+		return status, err
+	}
+	return status, fmt.Errorf("%v %v: %v %v %s", s.method, s.url, status, http.StatusText(status), msg)
+}
+
+func (s *HttpSender) Close() error {
+	return nil
+}
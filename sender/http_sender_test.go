@@ -0,0 +1,174 @@
+package sender
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// httpRecorder 记录收到的请求，按status返回状态码，同时返回header和response
+type httpRecorder struct {
+	mux      sync.Mutex
+	bodies   []string
+	reqs     []*http.Request
+	status   func(body string) int
+	header   http.Header
+	response string
+}
+
+// statusCode 所有请求都返回code
+func statusCode(code int) func(string) int {
+	return func(string) int { return code }
+}
+
+func (h *httpRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body []byte
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ = ioutil.ReadAll(zr)
+	} else {
+		body, _ = ioutil.ReadAll(req.Body)
+	}
+	h.mux.Lock()
+	h.bodies = append(h.bodies, string(body))
+	h.reqs = append(h.reqs, req)
+	h.mux.Unlock()
+	for k, v := range h.header {
+		w.Header()[k] = v
+	}
+	if h.status != nil {
+		w.WriteHeader(h.status(string(body)))
+	}
+	w.Write([]byte(h.response))
+}
+
+func TestHttpSenderFormats(t *testing.T) {
+	rec := &httpRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	datas := []Data{{"a": 1}, {"a": 2}}
+	s, err := NewHttpSender(conf.MapConf{KeyHttpSenderURL: srv.URL, KeyHttpSenderHeaders: "X-Env: prod,X-Team:logs"})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send(datas))
+	assert.Equal(t, `[{"a":1},{"a":2}]`, rec.bodies[0])
+	assert.Equal(t, "application/json", rec.reqs[0].Header.Get("Content-Type"))
+	assert.Equal(t, "prod", rec.reqs[0].Header.Get("X-Env"))
+	assert.Equal(t, "logs", rec.reqs[0].Header.Get("X-Team"))
+
+	s, err = NewHttpSender(conf.MapConf{
+		KeyHttpSenderURL:    srv.URL,
+		KeyHttpSenderFormat: HttpFormatNDJSON,
+		KeyHttpSenderGzip:   "true",
+		KeyHttpSenderAuth:   HttpAuthBearer,
+		KeyHttpSenderToken:  "secret",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send(datas))
+	assert.Equal(t, "{\"a\":1}\n{\"a\":2}\n", rec.bodies[1])
+	assert.Equal(t, "Bearer secret", rec.reqs[1].Header.Get("Authorization"))
+
+	s, err = NewHttpSender(conf.MapConf{
+		KeyHttpSenderURL:        srv.URL,
+		KeyHttpSenderFormat:     HttpFormatTemplate,
+		KeyHttpSenderTemplate:   "a=%{a}",
+		KeyHttpSenderAuth:       HttpAuthHmac,
+		KeyHttpSenderHmacSecret: "key",
+	})
+	assert.NoError(t, err)
+	// 缺少模板中字段的数据无法发送，其他数据正常发送
+	err = s.Send([]Data{{"a": 1}, {"b": 2}})
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, []Data{{"b": 2}}, FailDatas(err, nil))
+	assert.Equal(t, "a=1\n", rec.bodies[2])
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("a=1\n"))
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), rec.reqs[2].Header.Get(defaultHttpHmacHeader))
+
+	s, err = NewHttpSender(conf.MapConf{
+		KeyHttpSenderURL:      srv.URL,
+		KeyHttpSenderAuth:     HttpAuthBasic,
+		KeyHttpSenderUsername: "user",
+		KeyHttpSenderPassword: "pass",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send(datas))
+	user, pass, ok := rec.reqs[3].BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+
+	for _, c := range []conf.MapConf{
+		{},
+		{KeyHttpSenderURL: srv.URL, KeyHttpSenderFormat: "xml"},
+		{KeyHttpSenderURL: srv.URL, KeyHttpSenderFormat: HttpFormatTemplate},
+		{KeyHttpSenderURL: srv.URL, KeyHttpSenderAuth: HttpAuthBearer},
+		{KeyHttpSenderURL: srv.URL, KeyHttpSenderHeaders: "X-Env"},
+		{KeyHttpSenderURL: srv.URL, KeyHttpSenderRetryStatus: "5x"},
+		{KeyHttpSenderURL: srv.URL, KeyHttpSenderTLSCACert: "not_exist.pem"},
+	} {
+		_, err = NewHttpSender(c)
+		assert.Error(t, err, "%v", c)
+	}
+}
+
+func TestHttpSenderSplitAndStatus(t *testing.T) {
+	rec := &httpRecorder{status: func(body string) int {
+		switch {
+		case strings.Contains(body, `"bad"`):
+			return http.StatusBadRequest
+		case strings.Contains(body, `"busy"`):
+			return http.StatusServiceUnavailable
+		case strings.Contains(body, `"huge"`):
+			return http.StatusRequestEntityTooLarge
+		}
+		return http.StatusOK
+	}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	s, err := NewHttpSender(conf.MapConf{
+		KeyHttpSenderURL:           srv.URL,
+		KeyHttpSenderFormat:        HttpFormatNDJSON,
+		KeyHttpSenderMaxBatchBytes: "30",
+	})
+	assert.NoError(t, err)
+	// 每个请求最多30字节，两条数据一个请求
+	datas := []Data{{"k": "ok1"}, {"k": "ok2"}, {"k": "bad"}, {"k": "ok3"}, {"k": "ok4"}}
+	err = s.Send(datas)
+	assert.Equal(t, 3, len(rec.bodies))
+	assert.Equal(t, "{\"k\":\"ok1\"}\n{\"k\":\"ok2\"}\n", rec.bodies[0])
+	assert.Equal(t, "{\"k\":\"ok4\"}\n", rec.bodies[2])
+	// 400不在重试的状态码中
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, []Data{{"k": "bad"}, {"k": "ok3"}}, FailDatas(err, datas))
+
+	// 有请求可以重试时整体按可以重试处理
+	err = s.Send([]Data{{"k": "bad"}, {"k": "ok5"}, {"k": "busy"}})
+	assert.False(t, IsPermanentError(err))
+	assert.Equal(t, 3, len(FailDatas(err, nil)))
+
+	err = s.Send([]Data{{"k": "huge"}})
+	assert.True(t, NeedSplit(err))
+
+	// 服务不可用时可以重试
+	srv.Close()
+	err = s.Send(datas[:1])
+	assert.False(t, IsPermanentError(err))
+	assert.Equal(t, datas[:1], FailDatas(err, nil))
+}
@@ -3,14 +3,17 @@ package sender
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/qiniu/log"
 	"github.com/qiniu/logkit/conf"
@@ -27,11 +30,22 @@ type InfluxdbSender struct {
 	fields      map[string]string // key为field的列名，value为alias名
 	timestamp   string            // 时间戳列名
 	timePrec    int64
+	auto        bool                // 自动把数字和布尔值作为field，字符串作为tag
+	include     map[string]struct{} // 自动模式下只处理这些列，为空时处理所有列
+	exclude     map[string]struct{} // 自动模式下忽略的列
+	maxPoints   int
+	maxBytes    int
+	username    string
+	password    string
+	token       string
+	gzip        bool
+	client      *http.Client
+	udp         net.Conn // host为udp://地址时使用udp发送，udp没有写入结果
 }
 
 // Influxdb sender 的可配置字段
 const (
-	KeyInfluxdbHost               = "influxdb_host"
+	KeyInfluxdbHost               = "influxdb_host" // http地址，或者udp://host:port使用udp发送
 	KeyInfluxdbDB                 = "influxdb_db"
 	KeyInfluxdbRetetion           = "influxdb_retention"
 	KeyInfluxdbMeasurement        = "influxdb_measurement"
@@ -39,6 +53,23 @@ const (
 	KeyInfluxdbFields             = "influxdb_fields"              // influxdb
 	KeyInfluxdbTimestamp          = "influxdb_timestamp"           // 可选 nano时间戳字段
 	KeyInfluxdbTimestampPrecision = "influxdb_timestamp_precision" // 时间戳字段的精度，代表时间戳1个单位代表多少纳秒
+	KeyInfluxdbAutoFields         = "influxdb_auto_fields"         // 没有配置的列中，数字和布尔值作为field，字符串作为tag
+	KeyInfluxdbInclude            = "influxdb_include"             // 自动模式下只处理这些列，逗号分隔
+	KeyInfluxdbExclude            = "influxdb_exclude"             // 自动模式下忽略的列，逗号分隔
+	KeyInfluxdbMaxPoints          = "influxdb_max_points"          // 一次写入的最大点数，超过后拆分成多次写入
+	KeyInfluxdbMaxBytes           = "influxdb_max_bytes"           // 一次写入的最大字节数，udp时是单个包的最大字节数
+	KeyInfluxdbUsername           = "influxdb_username"
+	KeyInfluxdbPassword           = "influxdb_password"
+	KeyInfluxdbToken              = "influxdb_token" // 使用"Authorization: Token"认证，influxdb 1.8以上与2.x
+	KeyInfluxdbGzip               = "influxdb_gzip"  // 是否gzip压缩请求体
+	KeyInfluxdbTimeout            = "influxdb_timeout"
+)
+
+const (
+	defaultInfluxdbMaxPoints = 5000
+	defaultInfluxdbMaxBytes  = 4 * 1024 * 1024
+	defaultInfluxdbUDPBytes  = 512
+	defaultInfluxdbTimeout   = 30 * time.Second
 )
 
 // NewInfluxdbSender 创建Influxdb 的sender
@@ -55,9 +86,13 @@ func NewInfluxdbSender(c conf.MapConf) (s Sender, err error) {
 	if err != nil {
 		return
 	}
+	auto, _ := c.GetBoolOr(KeyInfluxdbAutoFields, false)
 	fields, err := c.GetAliasMap(KeyInfluxdbFields)
 	if err != nil {
-		return
+		if !auto {
+			return
+		}
+		fields, err = make(map[string]string), nil
 	}
 	tags, _ := c.GetAliasMapOr(KeyInfluxdbTags, make(map[string]string))
 	retention, _ := c.GetStringOr(KeyInfluxdbRetetion, "")
@@ -65,7 +100,7 @@ func NewInfluxdbSender(c conf.MapConf) (s Sender, err error) {
 	prec, _ := c.GetIntOr(KeyInfluxdbTimestampPrecision, 1)
 	name, _ := c.GetStringOr(KeyName, fmt.Sprintf("influxdbSender:(%v,db:%v,measurement:%v", host, db, measurement))
 
-	return &InfluxdbSender{
+	is := &InfluxdbSender{
 		name:        name,
 		host:        host,
 		db:          db,
@@ -75,7 +110,41 @@ func NewInfluxdbSender(c conf.MapConf) (s Sender, err error) {
 		fields:      fields,
 		timestamp:   timestamp,
 		timePrec:    int64(prec),
-	}, nil
+		auto:        auto,
+		include:     make(map[string]struct{}),
+		exclude:     make(map[string]struct{}),
+	}
+	include, _ := c.GetStringListOr(KeyInfluxdbInclude, []string{})
+	for _, k := range include {
+		is.include[k] = struct{}{}
+	}
+	exclude, _ := c.GetStringListOr(KeyInfluxdbExclude, []string{})
+	for _, k := range exclude {
+		is.exclude[k] = struct{}{}
+	}
+	is.maxPoints, _ = c.GetIntOr(KeyInfluxdbMaxPoints, defaultInfluxdbMaxPoints)
+	deftBytes := defaultInfluxdbMaxBytes
+	if strings.HasPrefix(host, "udp://") {
+		deftBytes = defaultInfluxdbUDPBytes
+	}
+	is.maxBytes, _ = c.GetIntOr(KeyInfluxdbMaxBytes, deftBytes)
+	is.username, _ = c.GetStringOr(KeyInfluxdbUsername, "")
+	is.password, _ = c.GetStringOr(KeyInfluxdbPassword, "")
+	is.token, _ = c.GetStringOr(KeyInfluxdbToken, "")
+	is.gzip, _ = c.GetBoolOr(KeyInfluxdbGzip, false)
+	timeout := defaultInfluxdbTimeout
+	if v, _ := c.GetStringOr(KeyInfluxdbTimeout, ""); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("%v: %v", KeyInfluxdbTimeout, err)
+		}
+	}
+	is.client = &http.Client{Timeout: timeout}
+	if strings.HasPrefix(host, "udp://") {
+		if is.udp, err = net.Dial("udp", strings.TrimPrefix(host, "udp://")); err != nil {
+			return nil, err
+		}
+	}
+	return is, nil
 }
 
 func (s *InfluxdbSender) Name() string {
@@ -83,65 +152,159 @@ func (s *InfluxdbSender) Name() string {
 }
 
 func (s *InfluxdbSender) Close() error {
+	if s.udp != nil {
+		return s.udp.Close()
+	}
 	return nil
 }
 
+// Send 按influxdb_max_points和influxdb_max_bytes拆分成多次写入，被influxdb拒绝的点通过SendError返回
 func (s *InfluxdbSender) Send(datas []Data) error {
-	ps := Points{}
-	for _, d := range datas {
+	result := newBatchResult(len(datas))
+	batcher := &lineBatcher{maxLines: s.maxPoints, maxBytes: s.maxBytes}
+	points := make([]Point, len(datas))
+	for i, d := range datas {
 		p, err := s.makePoint(d)
 		if err != nil {
-			log.Warnf("%s make point format err : %v", s.Name(), err)
+			result.addInvalid(d, err)
 			continue
 		}
-		ps = append(ps, p)
+		points[i] = p
+		batcher.add(i, d, []byte(p.String()))
 	}
-	err := s.sendPoints(ps)
-	if err != nil {
-		return NewSendError(s.Name()+" Cannot write data into influxdb, error is "+err.Error(), datas, TypeDefault)
+	for _, b := range batcher.batches {
+		status, msg, err := s.write(b.join([]byte("\n")))
+		if err == nil {
+			continue
+		}
+		// 部分写入时只有被拒绝的点失败，无法对应到具体数据的错误只记录日志
+		if status == http.StatusBadRequest {
+			if rejected := influxRejected(b, points, msg); len(rejected) > 0 || strings.Contains(msg, "partial write") {
+				if len(rejected) > 0 {
+					result.addFailed(rejected, status, err, true, false)
+				} else {
+					log.Warnf("%s partial write: %v", s.Name(), msg)
+				}
+				continue
+			}
+		}
+		result.addFailed(b.datas, status, err, IsPermanentStatus(status), status == http.StatusRequestEntityTooLarge)
 	}
-	return nil
+	return result.err(s.Name())
 }
 
-func (s *InfluxdbSender) sendPoints(ps Points) (err error) {
+var influxConflictRe = regexp.MustCompile(`input field "(.+?)" on measurement "(.+?)" is type (\w+)`)
+
+// influxRejected 从influxdb的错误信息中找出被拒绝的数据：无法解析的行，以及字段类型冲突的点，points是所有数据对应的点
+func influxRejected(b *lineBatch, points []Point, msg string) []Data {
+	bad := make(map[int]bool)
+	rest := msg
+	for {
+		start := strings.Index(rest, "unable to parse '")
+		if start < 0 {
+			break
+		}
+		rest = rest[start+len("unable to parse '"):]
+		end := strings.Index(rest, "': ")
+		if end < 0 {
+			break
+		}
+		line := rest[:end]
+		for i, l := range b.lines {
+			if string(l) == line {
+				bad[i] = true
+			}
+		}
+		rest = rest[end:]
+	}
+	for _, m := range influxConflictRe.FindAllStringSubmatch(msg, -1) {
+		for i, idx := range b.index {
+			p := points[idx]
+			if v, ok := p.Fields[m[1]]; ok && p.Measurement == m[2] && influxFieldType(v) == m[3] {
+				bad[i] = true
+			}
+		}
+	}
+	rejected := make([]Data, 0, len(bad))
+	for i, d := range b.datas {
+		if bad[i] {
+			rejected = append(rejected, d)
+		}
+	}
+	return rejected
+}
+
+// influxFieldType 字段值在influxdb中的类型名，与GetFields的编码方式一致
+func influxFieldType(v interface{}) string {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32:
+		return "integer"
+	case uint64, float32, float64:
+		return "float"
+	case bool:
+		return "boolean"
+	}
+	return "string"
+}
+
+// write 写入一批点，返回非2xx的状态码和influxdb的错误信息，请求没有完成时状态码为0
+func (s *InfluxdbSender) write(body []byte) (status int, msg string, err error) {
+	if s.udp != nil {
+		if _, err = s.udp.Write(body); err != nil {
+			log.Errorf("%s write udp error: %v", s.Name(), err)
+		}
+		return
+	}
 	host := s.host
-	if !strings.HasPrefix(host, "http://") {
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
 		host = "http://" + host
 	}
-	db := s.db
-	u := host + "/write?db=" + db
+	u := host + "/write?db=" + url.QueryEscape(s.db)
 	if s.retention != "" {
-		u = u + "&rp=" + s.retention
+		u = u + "&rp=" + url.QueryEscape(s.retention)
 	}
-	req, err := http.NewRequest("POST", u, bytes.NewReader(ps.Buffer()))
+	req, err := newBodyRequest(http.MethodPost, u, "text/plain", body, s.gzip)
 	if err != nil {
 		log.Errorf("%s writePoints NewRequest error: %v", s.Name(), err)
 		return
 	}
-	req.Header.Set("Content-Type", "text/plain")
-
-	resp, err := http.DefaultClient.Do(req)
-	if resp != nil {
-		defer func() {
-			io.Copy(ioutil.Discard, resp.Body)
-			resp.Body.Close()
-		}()
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	} else if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
 	}
+	status, _, b, err := doRequest(s.client, req)
 	if err != nil {
 		log.Errorf("%s request influxdb error: %v", s.Name(), err)
 		return
 	}
+	if status >= 200 && status < 300 {
+		return status, "", nil
+	}
+	var ret struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(b, &ret) == nil && ret.Error != "" {
+		msg = ret.Error
+	} else {
+		msg = string(b)
+	}
+	return status, msg, fmt.Errorf("influxdb %v %v: %v", status, http.StatusText(status), msg)
+}
 
-	var b []byte
-	if b, err = ioutil.ReadAll(resp.Body); err != nil {
-		log.Errorf("%s read resp body error: %v", s.Name(), err)
-		return
+// skip 自动模式下是否忽略该列
+func (s *InfluxdbSender) skip(k string) bool {
+	if k == s.timestamp {
+		return true
 	}
-	if resp.StatusCode != 204 {
-		err = fmt.Errorf(strings.Replace(string(b), "\\", "", -1))
-		return
+	if _, ok := s.exclude[k]; ok {
+		return true
 	}
-	return
+	if len(s.include) > 0 {
+		_, ok := s.include[k]
+		return !ok
+	}
+	return false
 }
 
 func (s *InfluxdbSender) makePoint(d Data) (p Point, err error) {
@@ -155,7 +318,6 @@ func (s *InfluxdbSender) makePoint(d Data) (p Point, err error) {
 		}
 		tags[t] = fmt.Sprintf("%v", v)
 	}
-	p.Tags = tags
 	fields := map[string]interface{}{}
 	for k, f := range s.fields {
 		v, exist := d[k]
@@ -165,15 +327,46 @@ func (s *InfluxdbSender) makePoint(d Data) (p Point, err error) {
 		}
 		fields[f] = v
 	}
+	if s.auto {
+		for k, v := range d {
+			if _, ok := s.tags[k]; ok {
+				continue
+			}
+			if _, ok := s.fields[k]; ok {
+				continue
+			}
+			if s.skip(k) {
+				continue
+			}
+			switch v.(type) {
+			case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
+				fields[k] = v
+			case json.Number:
+				if f, err := v.(json.Number).Float64(); err == nil {
+					fields[k] = f
+				}
+			case string:
+				if v != "" {
+					tags[k] = v.(string)
+				}
+			}
+		}
+	}
+	p.Tags = tags
 	if len(fields) <= 0 {
 		return p, errors.New("must contain at least 1 field ")
 	}
 	p.Fields = fields
 
-	t, exist := d[s.timestamp]
-	t1, succ := t.(int64)
-	if exist && succ {
-		p.Time = t1 * s.timePrec
+	switch t := d[s.timestamp].(type) {
+	case int64:
+		p.Time = t * s.timePrec
+	case time.Time:
+		p.Time = t.UnixNano()
+	case string:
+		if ts, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			p.Time = ts.UnixNano()
+		}
 	}
 
 	return
@@ -0,0 +1,166 @@
+package sender
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfluxdbSenderAutoFields(t *testing.T) {
+	rec := &httpRecorder{status: statusCode(http.StatusNoContent)}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	s, err := NewInfluxdbSender(conf.MapConf{
+		KeyInfluxdbHost:        srv.URL,
+		KeyInfluxdbDB:          "test",
+		KeyInfluxdbRetetion:    "week",
+		KeyInfluxdbMeasurement: "cpu",
+		KeyInfluxdbAutoFields:  "true",
+		KeyInfluxdbFields:      "usage idle",
+		KeyInfluxdbExclude:     "ignore",
+		KeyInfluxdbTimestamp:   "ts",
+		KeyInfluxdbUsername:    "user",
+		KeyInfluxdbPassword:    "pass",
+		KeyInfluxdbGzip:        "true",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	ts := time.Unix(1500000000, 0)
+	assert.NoError(t, s.Send([]Data{
+		{"host": "a", "usage": 0.5, "load": int64(3), "ok": true, "ignore": "x", "ts": ts},
+	}))
+	assert.Equal(t, []string{"cpu,host=a idle=0.5,load=3i,ok=true 1500000000000000000"}, rec.bodies)
+	assert.Equal(t, "db=test&rp=week", rec.reqs[0].URL.RawQuery)
+	user, pass, ok := rec.reqs[0].BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+
+	// 没有field的数据无法写入
+	datas := []Data{{"host": "b"}}
+	err = s.Send(datas)
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, datas, FailDatas(err, datas))
+}
+
+func TestInfluxdbSenderInclude(t *testing.T) {
+	rec := &httpRecorder{status: statusCode(http.StatusNoContent)}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	s, err := NewInfluxdbSender(conf.MapConf{
+		KeyInfluxdbHost:        srv.URL,
+		KeyInfluxdbDB:          "test",
+		KeyInfluxdbMeasurement: "cpu",
+		KeyInfluxdbAutoFields:  "true",
+		KeyInfluxdbInclude:     "host,usage",
+		KeyInfluxdbToken:       "secret",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.NoError(t, s.Send([]Data{{"host": "a", "usage": 1, "other": 2, "region": "x"}}))
+	assert.Equal(t, []string{"cpu,host=a usage=1i"}, rec.bodies)
+	assert.Equal(t, "Token secret", rec.reqs[0].Header.Get("Authorization"))
+}
+
+func TestInfluxdbSenderFieldsRequired(t *testing.T) {
+	_, err := NewInfluxdbSender(conf.MapConf{
+		KeyInfluxdbHost:        "127.0.0.1:8086",
+		KeyInfluxdbDB:          "test",
+		KeyInfluxdbMeasurement: "cpu",
+	})
+	assert.Error(t, err)
+}
+
+func TestInfluxdbSenderSplit(t *testing.T) {
+	rec := &httpRecorder{status: statusCode(http.StatusNoContent)}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	s, err := NewInfluxdbSender(conf.MapConf{
+		KeyInfluxdbHost:        srv.URL,
+		KeyInfluxdbDB:          "test",
+		KeyInfluxdbMeasurement: "m",
+		KeyInfluxdbFields:      "v",
+		KeyInfluxdbMaxPoints:   "2",
+		KeyInfluxdbMaxBytes:    "20",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.NoError(t, s.Send([]Data{{"v": 1}, {"v": 2}, {"v": 3}, {"v": 10000000}}))
+	assert.Equal(t, []string{"m v=1i\nm v=2i", "m v=3i", "m v=10000000i"}, rec.bodies)
+}
+
+func TestInfluxdbSenderPartialWrite(t *testing.T) {
+	rec := &httpRecorder{
+		status:   statusCode(http.StatusBadRequest),
+		response: `{"error":"partial write: field type conflict: input field \"v\" on measurement \"m\" is type float, already exists as type integer dropped=1"}`,
+	}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	s, err := NewInfluxdbSender(conf.MapConf{
+		KeyInfluxdbHost:        srv.URL,
+		KeyInfluxdbDB:          "test",
+		KeyInfluxdbMeasurement: "m",
+		KeyInfluxdbFields:      "v",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	datas := []Data{{"v": 1}, {"v": 1.5}, {"v": 2}}
+	err = s.Send(datas)
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, []Data{datas[1]}, FailDatas(err, datas))
+
+	rec.response = `{"error":"unable to parse 'm v=2i': bad timestamp"}`
+	err = s.Send(datas)
+	assert.Equal(t, []Data{datas[2]}, FailDatas(err, datas))
+
+	// 5xx 可以重试
+	rec.status = statusCode(http.StatusServiceUnavailable)
+	rec.response = `{"error":"timeout"}`
+	err = s.Send(datas)
+	assert.False(t, IsPermanentError(err))
+	assert.Equal(t, datas, FailDatas(err, datas))
+	// 无法生成点的数据不随可以重试的数据一起重试
+	err = s.Send(append([]Data{{"x": 1}}, datas...))
+	assert.Equal(t, datas, FailDatas(err, nil))
+	assert.Equal(t, []Data{{"x": 1}}, PermanentDatas(err))
+
+	rec.status = statusCode(http.StatusRequestEntityTooLarge)
+	err = s.Send(datas)
+	se, ok := err.(*SendError)
+	assert.True(t, ok)
+	assert.Equal(t, TypeBinaryUnpack, se.ErrorType)
+}
+
+func TestInfluxdbSenderUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	s, err := NewInfluxdbSender(conf.MapConf{
+		KeyInfluxdbHost:        "udp://" + conn.LocalAddr().String(),
+		KeyInfluxdbDB:          "test",
+		KeyInfluxdbMeasurement: "m",
+		KeyInfluxdbFields:      "v",
+		KeyInfluxdbMaxBytes:    "10",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.NoError(t, s.Send([]Data{{"v": 1}, {"v": 2}}))
+	var got []string
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 2; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			break
+		}
+		got = append(got, string(buf[:n]))
+	}
+	assert.Equal(t, "m v=1i\nm v=2i", strings.Join(got, "\n"))
+}
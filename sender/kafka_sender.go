@@ -0,0 +1,386 @@
+package sender
+
+import (
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	"github.com/xdg/scram"
+)
+
+// KafkaSender 把每条数据序列化成json发送到kafka
+type KafkaSender struct {
+	name         string
+	topic        *fieldTemplate
+	defaultTopic string
+	keys         []string
+
+	mux      sync.Mutex // 同一时间只有一个Send在等待producer的返回
+	producer sarama.AsyncProducer
+}
+
+// Kafka sender 的可配置字段
+const (
+	KeyKafkaHost          = "kafka_host"           // broker地址，逗号分隔
+	KeyKafkaTopic         = "kafka_topic"          // topic，可以用%{field}引用数据中的字段
+	KeyKafkaDefaultTopic  = "kafka_default_topic"  // kafka_topic引用的字段不存在时使用的topic
+	KeyKafkaPartitionKeys = "kafka_partition_keys" // 作为消息key的字段，逗号分隔，相同key的数据发到同一个partition
+	KeyKafkaClientID      = "kafka_client_id"
+	KeyKafkaVersion       = "kafka_version"       // kafka集群的版本，如0.10.2.0
+	KeyKafkaCompression   = "kafka_compression"   // none, gzip, snappy, lz4
+	KeyKafkaRequiredAcks  = "kafka_required_acks" // none, leader, all
+	KeyKafkaIdempotent    = "kafka_idempotent"    // 幂等发送，需要kafka 0.11以上，且required_acks为all
+	KeyKafkaTimeout       = "kafka_timeout"       // 连接和等待broker确认的超时时间
+
+	KeyKafkaTLSEnable             = "kafka_tls_enable"
+	KeyKafkaTLSCACert             = "kafka_tls_ca_cert"              // 验证broker证书的CA证书文件
+	KeyKafkaTLSCert               = "kafka_tls_cert"                 // 客户端证书文件
+	KeyKafkaTLSKey                = "kafka_tls_key"                  // 客户端私钥文件
+	KeyKafkaTLSInsecureSkipVerify = "kafka_tls_insecure_skip_verify" // 不验证broker证书
+	KeyKafkaSASLUsername          = "kafka_sasl_username"            // 配置后使用SASL认证
+	KeyKafkaSASLMechanism         = "kafka_sasl_mechanism"           // PLAIN, SCRAM-SHA-256, SCRAM-SHA-512，默认为PLAIN
+	KeyKafkaSASLPassword          = "kafka_sasl_password"
+)
+
+// kafka_compression 的取值
+const (
+	KafkaCompressionNone   = "none"
+	KafkaCompressionGzip   = "gzip"
+	KafkaCompressionSnappy = "snappy"
+	KafkaCompressionLZ4    = "lz4"
+)
+
+// kafka_required_acks 的取值
+const (
+	KafkaAcksNone   = "none"   // 不等待broker确认
+	KafkaAcksLeader = "leader" // leader写入后确认
+	KafkaAcksAll    = "all"    // 所有同步副本写入后确认
+)
+
+const defaultKafkaTimeout = 10 * time.Second
+
+// kafka_sasl_mechanism 的取值
+const (
+	KafkaSASLPlain       = "PLAIN"
+	KafkaSASLSCRAMSHA256 = "SCRAM-SHA-256"
+	KafkaSASLSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// NewKafkaSender 创建kafka的sender
+func NewKafkaSender(c conf.MapConf) (s Sender, err error) {
+	hosts, err := c.GetStringList(KeyKafkaHost)
+	if err != nil {
+		return
+	}
+	topic, err := c.GetString(KeyKafkaTopic)
+	if err != nil {
+		return
+	}
+	defaultTopic, _ := c.GetStringOr(KeyKafkaDefaultTopic, "")
+	keys, _ := c.GetStringListOr(KeyKafkaPartitionKeys, []string{})
+	name, _ := c.GetStringOr(KeyName, fmt.Sprintf("kafkaSender:(hosts:%v,topic:%s)", hosts, topic))
+	cfg, err := newKafkaConfig(c)
+	if err != nil {
+		return
+	}
+	producer, err := sarama.NewAsyncProducer(hosts, cfg)
+	if err != nil {
+		return
+	}
+	ks, err := newKafkaSender(name, topic, defaultTopic, keys, producer)
+	if err != nil {
+		producer.Close()
+		return
+	}
+	return ks, nil
+}
+
+func newKafkaSender(name, topic, defaultTopic string, keys []string, producer sarama.AsyncProducer) (*KafkaSender, error) {
+	t, err := newFieldTemplate(topic)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaSender{
+		name:         name,
+		topic:        t,
+		defaultTopic: defaultTopic,
+		keys:         keys,
+		producer:     producer,
+	}, nil
+}
+
+// newKafkaConfig 根据配置生成producer的配置，Send需要等待每条消息的结果，Successes和Errors都会返回
+func newKafkaConfig(c conf.MapConf) (*sarama.Config, error) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+	cfg.ClientID, _ = c.GetStringOr(KeyKafkaClientID, "logkit")
+
+	if v, _ := c.GetStringOr(KeyKafkaVersion, ""); v != "" {
+		version, err := sarama.ParseKafkaVersion(v)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Version = version
+	}
+
+	compression, _ := c.GetStringOr(KeyKafkaCompression, KafkaCompressionNone)
+	switch compression {
+	case KafkaCompressionNone:
+		cfg.Producer.Compression = sarama.CompressionNone
+	case KafkaCompressionGzip:
+		cfg.Producer.Compression = sarama.CompressionGZIP
+	case KafkaCompressionSnappy:
+		cfg.Producer.Compression = sarama.CompressionSnappy
+	case KafkaCompressionLZ4:
+		cfg.Producer.Compression = sarama.CompressionLZ4
+	default:
+		return nil, fmt.Errorf("%v %v not supported", KeyKafkaCompression, compression)
+	}
+
+	idempotent, _ := c.GetBoolOr(KeyKafkaIdempotent, false)
+	deftAcks := KafkaAcksLeader
+	if idempotent {
+		deftAcks = KafkaAcksAll
+	}
+	acks, _ := c.GetStringOr(KeyKafkaRequiredAcks, deftAcks)
+	switch acks {
+	case KafkaAcksNone:
+		cfg.Producer.RequiredAcks = sarama.NoResponse
+	case KafkaAcksLeader:
+		cfg.Producer.RequiredAcks = sarama.WaitForLocal
+	case KafkaAcksAll:
+		cfg.Producer.RequiredAcks = sarama.WaitForAll
+	default:
+		return nil, fmt.Errorf("%v %v not supported", KeyKafkaRequiredAcks, acks)
+	}
+	if idempotent {
+		if cfg.Producer.RequiredAcks != sarama.WaitForAll {
+			return nil, fmt.Errorf("%v requires %v to be %v", KeyKafkaIdempotent, KeyKafkaRequiredAcks, KafkaAcksAll)
+		}
+		cfg.Producer.Idempotent = true
+		cfg.Net.MaxOpenRequests = 1
+		if !cfg.Version.IsAtLeast(sarama.V0_11_0_0) {
+			cfg.Version = sarama.V0_11_0_0
+		}
+	}
+
+	timeout, _ := c.GetStringOr(KeyKafkaTimeout, "")
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", KeyKafkaTimeout, err)
+		}
+		cfg.Net.DialTimeout = d
+		cfg.Net.WriteTimeout = d
+		cfg.Net.ReadTimeout = d
+		cfg.Producer.Timeout = d
+	} else {
+		cfg.Producer.Timeout = defaultKafkaTimeout
+	}
+
+	if enable, _ := c.GetBoolOr(KeyKafkaTLSEnable, false); enable {
+		ca, _ := c.GetStringOr(KeyKafkaTLSCACert, "")
+		cert, _ := c.GetStringOr(KeyKafkaTLSCert, "")
+		key, _ := c.GetStringOr(KeyKafkaTLSKey, "")
+		insecure, _ := c.GetBoolOr(KeyKafkaTLSInsecureSkipVerify, false)
+		tlsConfig, err := newTLSConfig(ca, cert, key, insecure)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+	if user, _ := c.GetStringOr(KeyKafkaSASLUsername, ""); user != "" {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.User = user
+		cfg.Net.SASL.Password, _ = c.GetStringOr(KeyKafkaSASLPassword, "")
+		mechanism, _ := c.GetStringOr(KeyKafkaSASLMechanism, KafkaSASLPlain)
+		switch mechanism {
+		case KafkaSASLPlain:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case KafkaSASLSCRAMSHA256:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &kafkaSCRAMClient{hash: scram.SHA256}
+			}
+		case KafkaSASLSCRAMSHA512:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &kafkaSCRAMClient{hash: scram.HashGeneratorFcn(sha512.New)}
+			}
+		default:
+			return nil, fmt.Errorf("%v %v not supported", KeyKafkaSASLMechanism, mechanism)
+		}
+		// SCRAM使用v1的SASL握手，需要kafka 1.0以上
+		if mechanism != KafkaSASLPlain && !cfg.Version.IsAtLeast(sarama.V1_0_0_0) {
+			cfg.Version = sarama.V1_0_0_0
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// kafkaSCRAMClient 使用xdg/scram实现sarama的SCRAM认证
+type kafkaSCRAMClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *kafkaSCRAMClient) Begin(user, password, authzID string) error {
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *kafkaSCRAMClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *kafkaSCRAMClient) Done() bool {
+	return c.conv.Done()
+}
+
+// newTLSConfig 加载CA证书与客户端证书，ca为空时使用系统的CA，cert为空时不使用客户端证书
+func newTLSConfig(ca, cert, key string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %v", ca)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return tlsConfig, nil
+}
+
+func (s *KafkaSender) Name() string {
+	return s.name
+}
+
+// message 生成一条消息，无法确定topic或者无法序列化时返回的错误重试也不会成功
+func (s *KafkaSender) message(d Data) (*sarama.ProducerMessage, error) {
+	topic, ok := s.topic.Render(d)
+	if !ok {
+		if s.defaultTopic == "" {
+			return nil, fmt.Errorf("data %v does not have the fields in topic %v", d, s.topic)
+		}
+		topic = s.defaultTopic
+	}
+	value, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(value)}
+	if len(s.keys) > 0 {
+		parts := make([]string, 0, len(s.keys))
+		for _, k := range s.keys {
+			v, ok := d[k]
+			if !ok || v == nil {
+				parts = append(parts, "")
+				continue
+			}
+			parts = append(parts, fmt.Sprint(v))
+		}
+		msg.Key = sarama.StringEncoder(strings.Join(parts, ","))
+	}
+	return msg, nil
+}
+
+// Send 发送所有数据并等待每条消息的结果，失败的数据通过SendError返回，只有全部失败都无法重试时返回PermanentError
+func (s *KafkaSender) Send(datas []Data) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	failed := make([]bool, len(datas))
+	var lastErr error
+	permanent := true
+	msgs := make([]*sarama.ProducerMessage, 0, len(datas))
+	for i, d := range datas {
+		msg, err := s.message(d)
+		if err != nil {
+			failed[i] = true
+			lastErr = err
+			continue
+		}
+		msg.Metadata = i
+		msgs = append(msgs, msg)
+	}
+	// 写入和读取结果同时进行，避免channel的缓冲区满了之后互相等待
+	go func() {
+		for _, msg := range msgs {
+			s.producer.Input() <- msg
+		}
+	}()
+	for range msgs {
+		select {
+		case <-s.producer.Successes():
+		case pe := <-s.producer.Errors():
+			if i, ok := pe.Msg.Metadata.(int); ok && i < len(failed) {
+				failed[i] = true
+			}
+			lastErr = pe.Err
+			permanent = permanent && isPermanentKafkaError(pe.Err)
+		}
+	}
+	if lastErr == nil {
+		return nil
+	}
+	var failDatas []Data
+	for i, f := range failed {
+		if f {
+			failDatas = append(failDatas, datas[i])
+		}
+	}
+	log.Errorf("%v failed to send %v of %v datas, last error %v", s.name, len(failDatas), len(datas), lastErr)
+	se := NewSendError(fmt.Sprintf("kafka sender failed to send %v of %v datas, last error %v", len(failDatas), len(datas), lastErr), failDatas, TypeDefault)
+	if permanent {
+		return NewPermanentError(se)
+	}
+	return se
+}
+
+// isPermanentKafkaError 消息本身不合法，重试也不会成功
+func isPermanentKafkaError(err error) bool {
+	if _, ok := err.(sarama.ConfigurationError); ok {
+		return true
+	}
+	kerr, ok := err.(sarama.KError)
+	if !ok {
+		return false
+	}
+	switch kerr {
+	case sarama.ErrMessageSizeTooLarge, sarama.ErrInvalidMessage, sarama.ErrInvalidMessageSize, sarama.ErrInvalidTopic:
+		return true
+	}
+	return false
+}
+
+func (s *KafkaSender) Close() error {
+	return s.producer.Close()
+}
@@ -0,0 +1,143 @@
+package sender
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaSender(t *testing.T) {
+	cfg, err := newKafkaConfig(conf.MapConf{})
+	assert.NoError(t, err)
+	producer := mocks.NewAsyncProducer(t, cfg)
+	s, err := newKafkaSender("kafka", "logs_%{app}", "", []string{"host", "app"}, producer)
+	assert.NoError(t, err)
+
+	datas := []Data{
+		{"app": "nginx", "host": "h1"},
+		{"app": "nginx", "host": "h2"},
+		{"app": "mysql"},
+		{"host": "h3"}, // 没有app字段，无法确定topic
+	}
+	msg, err := s.message(datas[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "logs_nginx", msg.Topic)
+	key, _ := msg.Key.Encode()
+	assert.Equal(t, "h1,nginx", string(key))
+	msg, err = s.message(datas[2])
+	assert.NoError(t, err)
+	assert.Equal(t, "logs_mysql", msg.Topic)
+	key, _ = msg.Key.Encode()
+	assert.Equal(t, ",mysql", string(key))
+
+	producer.ExpectInputWithCheckerFunctionAndSucceed(func(value []byte) error {
+		var d map[string]interface{}
+		if err := json.Unmarshal(value, &d); err != nil {
+			return err
+		}
+		if d["host"] != "h1" {
+			return fmt.Errorf("unexpected message %s", value)
+		}
+		return nil
+	})
+	producer.ExpectInputAndFail(sarama.ErrNotLeaderForPartition)
+	producer.ExpectInputAndSucceed()
+	err = s.Send(datas)
+	// 每条数据的错误对应到failDatas，有可以重试的错误
+	assert.False(t, IsPermanentError(err))
+	assert.Equal(t, []Data{datas[1], datas[3]}, FailDatas(err, datas))
+
+	producer.ExpectInputAndFail(sarama.ErrMessageSizeTooLarge)
+	err = s.Send(datas[1:2])
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, datas[1:2], FailDatas(err, datas))
+
+	producer.ExpectInputAndSucceed()
+	assert.NoError(t, s.Send(datas[:1]))
+	assert.NoError(t, s.Close())
+}
+
+func TestKafkaConfig(t *testing.T) {
+	cfg, err := newKafkaConfig(conf.MapConf{
+		KeyKafkaCompression:  KafkaCompressionLZ4,
+		KeyKafkaVersion:      "0.10.2.0",
+		KeyKafkaRequiredAcks: KafkaAcksAll,
+		KeyKafkaTimeout:      "3s",
+		KeyKafkaSASLUsername: "user",
+		KeyKafkaSASLPassword: "pass",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.CompressionLZ4, cfg.Producer.Compression)
+	assert.Equal(t, sarama.WaitForAll, cfg.Producer.RequiredAcks)
+	assert.Equal(t, sarama.V0_10_2_0, cfg.Version)
+	assert.False(t, cfg.Producer.Idempotent)
+	assert.True(t, cfg.Net.SASL.Enable)
+	assert.Equal(t, "user", cfg.Net.SASL.User)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypePlaintext), cfg.Net.SASL.Mechanism)
+
+	// 幂等发送默认等待所有副本确认，并且至少使用0.11的协议
+	cfg, err = newKafkaConfig(conf.MapConf{KeyKafkaIdempotent: "true"})
+	assert.NoError(t, err)
+	assert.True(t, cfg.Producer.Idempotent)
+	assert.Equal(t, sarama.WaitForAll, cfg.Producer.RequiredAcks)
+	assert.Equal(t, 1, cfg.Net.MaxOpenRequests)
+	assert.Equal(t, sarama.V0_11_0_0, cfg.Version)
+
+	cfg, err = newKafkaConfig(conf.MapConf{
+		KeyKafkaSASLUsername:  "user",
+		KeyKafkaSASLPassword:  "pass",
+		KeyKafkaSASLMechanism: KafkaSASLSCRAMSHA512,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), cfg.Net.SASL.Mechanism)
+	assert.Equal(t, sarama.V1_0_0_0, cfg.Version)
+	client := cfg.Net.SASL.SCRAMClientGeneratorFunc()
+	assert.NoError(t, client.Begin("user", "pass", ""))
+	first, err := client.Step("")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(first, "n,,n=user,r="))
+	assert.False(t, client.Done())
+
+	for _, c := range []conf.MapConf{
+		{KeyKafkaCompression: "zstd2"},
+		{KeyKafkaRequiredAcks: "some"},
+		{KeyKafkaVersion: "0.11"},
+		{KeyKafkaIdempotent: "true", KeyKafkaRequiredAcks: KafkaAcksLeader},
+		{KeyKafkaSASLUsername: "user", KeyKafkaSASLPassword: "pass", KeyKafkaSASLMechanism: "GSSAPI"},
+		{KeyKafkaTimeout: "3"},
+		{KeyKafkaTLSEnable: "true", KeyKafkaTLSCACert: "not_exist.pem"},
+	} {
+		_, err = newKafkaConfig(c)
+		assert.Error(t, err, "%v", c)
+	}
+}
+
+func TestFieldTemplate(t *testing.T) {
+	tp, err := newFieldTemplate("logs_%{app}-%{level}")
+	assert.NoError(t, err)
+	assert.False(t, tp.Static())
+	s, ok := tp.Render(Data{"app": "nginx", "level": 3})
+	assert.True(t, ok)
+	assert.Equal(t, "logs_nginx-3", s)
+	_, ok = tp.Render(Data{"app": "nginx"})
+	assert.False(t, ok)
+
+	tp, err = newFieldTemplate("logs")
+	assert.NoError(t, err)
+	assert.True(t, tp.Static())
+	s, ok = tp.Render(Data{})
+	assert.True(t, ok)
+	assert.Equal(t, "logs", s)
+
+	_, err = newFieldTemplate("logs_%{app")
+	assert.Error(t, err)
+	_, err = newFieldTemplate("logs_%{}")
+	assert.Error(t, err)
+}
@@ -0,0 +1,330 @@
+package sender
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/utils"
+
+	"github.com/qiniu/log"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongodbSender 把数据原样写入mongodb，支持插入、按key更新以及按key替换
+type MongodbSender struct {
+	sync.RWMutex
+
+	name       string
+	host       string
+	dbName     string
+	collection *fieldTemplate
+	mode       string
+	keys       []conf.AliasKey
+	timeKey    string
+	ttl        time.Duration
+	ttlKey     string
+	stopped    bool
+	session    *mgo.Session
+	ensured    map[string]bool // 已经创建过TTL索引的collection
+	now        func() time.Time
+}
+
+// 可选参数 当sender_type 为mongodb 的时候
+const (
+	KeyMongodbMode         = "mongodb_mode"          // 写入方式，insert、upsert或者replace，默认为insert
+	KeyMongodbKey          = "mongodb_key"           // upsert和replace时用于查找文档的列，支持别名
+	KeyMongodbTimestampKey = "mongodb_timestamp_key" // collection名中的时间取自该列，RFC3339格式的字符串会转换为时间类型写入
+	KeyMongodbTTL          = "mongodb_ttl"           // 创建TTL索引，文档在该时间后过期，如"168h"
+	KeyMongodbTTLKey       = "mongodb_ttl_key"       // TTL索引的列，默认为mongodb_timestamp_key
+)
+
+// mongodb sender 的写入方式
+const (
+	MongodbModeInsert  = "insert"
+	MongodbModeUpsert  = "upsert"
+	MongodbModeReplace = "replace"
+)
+
+// mongodbBulkSize 一次bulk写入的最大操作数
+const mongodbBulkSize = 1000
+
+// NewMongodbSender mongodb sender constructor
+func NewMongodbSender(c conf.MapConf) (Sender, error) {
+	host, err := c.GetString(KeyMongodbHost)
+	if err != nil {
+		return nil, err
+	}
+	dbName, err := c.GetString(KeyMongodbDB)
+	if err != nil {
+		return nil, err
+	}
+	collectionName, err := c.GetString(KeyMongodbCollection)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := newFieldTemplate(collectionName)
+	if err != nil {
+		return nil, err
+	}
+	mode, _ := c.GetStringOr(KeyMongodbMode, MongodbModeInsert)
+	keys, _ := c.GetAliasListOr(KeyMongodbKey, []conf.AliasKey{})
+	switch mode {
+	case MongodbModeInsert:
+	case MongodbModeUpsert, MongodbModeReplace:
+		if len(keys) <= 0 {
+			return nil, fmt.Errorf("%v is required when %v is %v", KeyMongodbKey, KeyMongodbMode, mode)
+		}
+	default:
+		return nil, fmt.Errorf("%v: unsupported mode %v", KeyMongodbMode, mode)
+	}
+	timeKey, _ := c.GetStringOr(KeyMongodbTimestampKey, "")
+	ttlKey, _ := c.GetStringOr(KeyMongodbTTLKey, timeKey)
+	var ttl time.Duration
+	if v, _ := c.GetStringOr(KeyMongodbTTL, ""); v != "" {
+		if ttl, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("%v: %v", KeyMongodbTTL, err)
+		}
+		if ttlKey == "" {
+			return nil, fmt.Errorf("%v or %v is required when %v is set", KeyMongodbTTLKey, KeyMongodbTimestampKey, KeyMongodbTTL)
+		}
+	}
+	name, _ := c.GetStringOr(KeyName, fmt.Sprintf("mongodb:(%v,db:%v,collection:%v)", host, dbName, collectionName))
+
+	session, err := utils.MongoDail(host, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &MongodbSender{
+		name:       name,
+		host:       host,
+		dbName:     dbName,
+		collection: coll,
+		mode:       mode,
+		keys:       keys,
+		timeKey:    timeKey,
+		ttl:        ttl,
+		ttlKey:     ttlKey,
+		session:    session,
+		ensured:    make(map[string]bool),
+		now:        time.Now,
+	}
+	go mongoSessionKeeper(session, s.isStopped)
+	return s, nil
+}
+
+func (s *MongodbSender) Name() string {
+	return s.name
+}
+
+func (s *MongodbSender) Close() error {
+	s.Lock()
+	s.stopped = true
+	s.Unlock()
+	s.session.Close()
+	return nil
+}
+
+func (s *MongodbSender) isStopped() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.stopped
+}
+
+// mongoOp 一条数据对应的写入操作
+type mongoOp struct {
+	index    int // 在datas中的位置
+	selector bson.D
+	doc      interface{}
+}
+
+// mongoCollValue collection名中不能包含$和空字符，字段值中的这些字符替换为_
+func mongoCollValue(v interface{}) string {
+	return strings.NewReplacer("$", "_", "\x00", "_").Replace(fmt.Sprint(v))
+}
+
+// makeOp 生成数据的写入操作，数据缺少collection名或者key中的字段时返回错误
+func (s *MongodbSender) makeOp(d Data, now time.Time) (coll string, op mongoOp, err error) {
+	ts := recordTime(d, s.timeKey, now)
+	coll, ok := s.collection.renderTime(d, ts, mongoCollValue)
+	if !ok {
+		return "", op, fmt.Errorf("collection %v: missing field", s.collection)
+	}
+	doc := bson.M{}
+	for k, v := range d {
+		doc[k] = v
+	}
+	// TTL索引和按时间查询需要时间类型
+	for _, k := range []string{s.timeKey, s.ttlKey} {
+		if str, ok := doc[k].(string); ok && k != "" {
+			if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+				doc[k] = t
+			}
+		}
+	}
+	if s.mode == MongodbModeInsert {
+		op.doc = doc
+		return coll, op, nil
+	}
+	for _, key := range s.keys {
+		v, exist := d[key.Key]
+		if !exist {
+			return "", op, fmt.Errorf("cannot find out key %v", key.Key)
+		}
+		op.selector = append(op.selector, bson.DocElem{Name: key.Alias, Value: v})
+	}
+	if s.mode == MongodbModeUpsert {
+		op.doc = bson.M{"$set": doc}
+	} else {
+		op.doc = doc
+	}
+	return coll, op, nil
+}
+
+// ensureTTL 第一次写入collection时创建TTL索引
+func (s *MongodbSender) ensureTTL(c *mgo.Collection) {
+	if s.ttl <= 0 {
+		return
+	}
+	s.RLock()
+	ensured := s.ensured[c.Name]
+	s.RUnlock()
+	if ensured {
+		return
+	}
+	err := c.EnsureIndex(mgo.Index{Key: []string{s.ttlKey}, ExpireAfter: s.ttl, Background: true})
+	if err != nil {
+		log.Errorf("%v ensure ttl index on %v error: %v", s.Name(), c.Name, err)
+		return
+	}
+	s.Lock()
+	s.ensured[c.Name] = true
+	s.Unlock()
+}
+
+// Send 按collection分组后使用unordered bulk写入，返回错误中包含所有写失败的数据
+func (s *MongodbSender) Send(datas []Data) error {
+	failed := make([]bool, len(datas))
+	permanent := true
+	var lastErr error
+	now := s.now()
+	groups := make(map[string][]mongoOp)
+	var colls []string
+	for i, d := range datas {
+		coll, op, err := s.makeOp(d, now)
+		if err != nil {
+			failed[i] = true
+			lastErr = err
+			continue
+		}
+		op.index = i
+		if _, ok := groups[coll]; !ok {
+			colls = append(colls, coll)
+		}
+		groups[coll] = append(groups[coll], op)
+	}
+
+	session := s.session.Copy()
+	defer session.Close()
+	db := session.DB(s.dbName)
+	for _, coll := range colls {
+		c := db.C(coll)
+		s.ensureTTL(c)
+		ops := groups[coll]
+		for start := 0; start < len(ops); start += mongodbBulkSize {
+			end := start + mongodbBulkSize
+			if end > len(ops) {
+				end = len(ops)
+			}
+			batch := ops[start:end]
+			bulk := c.Bulk()
+			bulk.Unordered()
+			for _, op := range batch {
+				switch s.mode {
+				case MongodbModeInsert:
+					bulk.Insert(op.doc)
+				default:
+					bulk.Upsert(op.selector, op.doc)
+				}
+			}
+			_, err := bulk.Run()
+			if err == nil {
+				continue
+			}
+			lastErr = err
+			be, ok := err.(*mgo.BulkError)
+			if !ok {
+				for _, op := range batch {
+					failed[op.index] = true
+				}
+				permanent = false
+				continue
+			}
+			idx, perm := mongoBulkFailures(be.Cases(), len(batch))
+			for _, i := range idx {
+				failed[batch[i].index] = true
+			}
+			permanent = permanent && perm
+		}
+	}
+	if lastErr == nil {
+		return nil
+	}
+	var failDatas []Data
+	for i, f := range failed {
+		if f {
+			failDatas = append(failDatas, datas[i])
+		}
+	}
+	log.Errorf("%v failed to write %v of %v datas, last error %v", s.Name(), len(failDatas), len(datas), lastErr)
+	se := NewSendError(fmt.Sprintf("mongodb sender failed to write %v of %v datas, last error %v", len(failDatas), len(datas), lastErr), failDatas, TypeDefault)
+	if permanent {
+		return NewPermanentError(se)
+	}
+	return se
+}
+
+// mongoBulkFailures 把bulk的错误对应到批次中的位置，服务端拒绝的文档(如重复key、校验失败)不再重试，
+// 无法确定位置的错误认为整个批次失败并且可以重试
+func mongoBulkFailures(cases []mgo.BulkErrorCase, size int) (idx []int, permanent bool) {
+	permanent = true
+	failed := make([]bool, size)
+	for _, c := range cases {
+		if c.Index < 0 || c.Index >= size {
+			for i := range failed {
+				failed[i] = true
+			}
+			permanent = false
+			continue
+		}
+		failed[c.Index] = true
+		if _, ok := c.Err.(*mgo.QueryError); !ok {
+			permanent = false
+		}
+	}
+	for i, f := range failed {
+		if f {
+			idx = append(idx, i)
+		}
+	}
+	return
+}
+
+// mongoSessionKeeper 定期ping mongodb，连接断开后刷新session，stopped返回true时退出
+func mongoSessionKeeper(session *mgo.Session, stopped func() bool) {
+	session.SetSocketTimeout(time.Second * 5)
+	session.SetSyncTimeout(time.Second * 5)
+	for !stopped() {
+		err := session.Ping()
+		if err != nil {
+			session.Refresh()
+			session.SetSocketTimeout(time.Second * 5)
+			session.SetSyncTimeout(time.Second * 5)
+		} else {
+			time.Sleep(time.Second * 5)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package sender
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMongodbSenderMakeOp(t *testing.T) {
+	coll, err := newFieldTemplate("logs_%{app}_%Y%m%d")
+	assert.NoError(t, err)
+	s := &MongodbSender{
+		collection: coll,
+		mode:       MongodbModeInsert,
+		timeKey:    "time",
+	}
+	now := time.Date(2017, 7, 1, 9, 0, 0, 0, time.UTC)
+	name, op, err := s.makeOp(Data{"app": "a$b", "time": "2017-06-30T23:00:00Z", "v": 1}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "logs_a_b_20170630", name)
+	assert.Equal(t, bson.M{"app": "a$b", "time": time.Date(2017, 6, 30, 23, 0, 0, 0, time.UTC), "v": 1}, op.doc)
+
+	_, _, err = s.makeOp(Data{"v": 1}, now)
+	assert.Error(t, err)
+
+	s.mode = MongodbModeUpsert
+	s.keys = []conf.AliasKey{{Key: "id", Alias: "_id"}}
+	name, op, err = s.makeOp(Data{"app": "a", "id": 3}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "logs_a_20170701", name)
+	assert.Equal(t, bson.D{{Name: "_id", Value: 3}}, op.selector)
+	assert.Equal(t, bson.M{"$set": bson.M{"app": "a", "id": 3}}, op.doc)
+
+	s.mode = MongodbModeReplace
+	_, op, err = s.makeOp(Data{"app": "a", "id": 3}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"app": "a", "id": 3}, op.doc)
+	_, _, err = s.makeOp(Data{"app": "a"}, now)
+	assert.Error(t, err)
+}
+
+func TestMongodbSenderConf(t *testing.T) {
+	_, err := NewMongodbSender(conf.MapConf{
+		KeyMongodbHost:       "127.0.0.1:27017",
+		KeyMongodbDB:         "db",
+		KeyMongodbCollection: "c",
+		KeyMongodbMode:       MongodbModeUpsert,
+	})
+	assert.Error(t, err)
+	_, err = NewMongodbSender(conf.MapConf{
+		KeyMongodbHost:       "127.0.0.1:27017",
+		KeyMongodbDB:         "db",
+		KeyMongodbCollection: "c",
+		KeyMongodbTTL:        "24h",
+	})
+	assert.Error(t, err)
+}
+
+func TestMongoBulkFailures(t *testing.T) {
+	dup := &mgo.QueryError{Code: 11000, Message: "duplicate key"}
+	idx, permanent := mongoBulkFailures([]mgo.BulkErrorCase{{Index: 0, Err: dup}, {Index: 2, Err: dup}}, 3)
+	assert.Equal(t, []int{0, 2}, idx)
+	assert.True(t, permanent)
+
+	idx, permanent = mongoBulkFailures([]mgo.BulkErrorCase{{Index: 1, Err: errors.New("EOF")}}, 3)
+	assert.Equal(t, []int{1}, idx)
+	assert.False(t, permanent)
+
+	// 旧版本mongodb无法给出位置
+	idx, permanent = mongoBulkFailures([]mgo.BulkErrorCase{{Index: -1, Err: dup}}, 3)
+	assert.Equal(t, []int{0, 1, 2}, idx)
+	assert.False(t, permanent)
+}
@@ -1,8 +1,11 @@
 package sender
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,7 +17,8 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
-// MongoAccSender Mongodb 根据UpdateKey 做对AccumulateKey $inc 累加的Sender
+// MongoAccSender Mongodb 根据UpdateKey 做对AccumulateKey $inc 累加的Sender，
+// 同时支持$max、$min、$set、$setOnInsert、$addToSet，以及按时间分桶聚合
 type MongoAccSender struct {
 	sync.RWMutex
 
@@ -26,6 +30,16 @@ type MongoAccSender struct {
 	collection     utils.Collection
 	updateKey      []conf.AliasKey
 	accumulateKey  []conf.AliasKey
+	operators      []mongoAccOperator
+	bucketKey      *conf.AliasKey // 分桶的时间列，Alias为写入mongodb的列名，会加入到聚合条件中
+	bucket         time.Duration
+	preAggregate   bool
+}
+
+// mongoAccOperator 一个更新操作符以及作用的列
+type mongoAccOperator struct {
+	op   string
+	keys []conf.AliasKey
 }
 
 // 可选参数 当sender_type 为mongodb_* 的时候，需要必填的字段
@@ -41,6 +55,18 @@ const (
 	KeyMongodbAccKey    = "mongodb_acc_acckey"
 )
 
+// 可选参数 当sender_type 为mongodb_acc 的时候
+const (
+	KeyMongodbMaxKey         = "mongodb_acc_max"          // 使用$max更新的列
+	KeyMongodbMinKey         = "mongodb_acc_min"          // 使用$min更新的列
+	KeyMongodbSetKey         = "mongodb_acc_set"          // 使用$set更新的列
+	KeyMongodbSetOnInsertKey = "mongodb_acc_setoninsert"  // 使用$setOnInsert更新的列
+	KeyMongodbAddToSetKey    = "mongodb_acc_addtoset"     // 使用$addToSet更新的列
+	KeyMongodbBucketKey      = "mongodb_acc_bucket_key"   // 分桶的时间列，支持别名，如"time minute"
+	KeyMongodbBucket         = "mongodb_acc_bucket"       // 分桶的时间间隔，如"1m"、"1h"
+	KeyMongodbPreAggregate   = "mongodb_acc_preaggregate" // 发送前先在每批数据内按聚合条件合并，默认为true
+)
+
 // NewMongodbAccSender mongodb accumulate sender constructor
 func NewMongodbAccSender(conf conf.MapConf) (sender Sender, err error) {
 	host, err := conf.GetString(KeyMongodbHost)
@@ -51,27 +77,94 @@ func NewMongodbAccSender(conf conf.MapConf) (sender Sender, err error) {
 	if err != nil {
 		return
 	}
-	updKey, err := conf.GetAliasList(KeyMongodbUpdateKey)
+	collectionName, err := conf.GetString(KeyMongodbCollection)
 	if err != nil {
 		return
 	}
-	accKey, err := conf.GetAliasList(KeyMongodbAccKey)
+	s, err := newMongoAccSenderConf(conf)
 	if err != nil {
 		return
 	}
-	collectionName, err := conf.GetString(KeyMongodbCollection)
+	s.name, _ = conf.GetStringOr(KeyName, fmt.Sprintf("mongodb_acc:(%v,db:%v,collection:%v)", host, dbName, collectionName))
+	s.host = host
+	s.dbName = dbName
+	s.collectionName = collectionName
+	if err = s.connect(); err != nil {
+		return
+	}
+	return s, nil
+}
+
+// newMongoAccSenderConf 解析聚合相关的配置
+func newMongoAccSenderConf(c conf.MapConf) (s *MongoAccSender, err error) {
+	updKey, err := c.GetAliasList(KeyMongodbUpdateKey)
 	if err != nil {
 		return
 	}
-	name, _ := conf.GetStringOr(KeyName, fmt.Sprintf("mongodb_acc:(%v,db:%v,collection:%v)", host, dbName, collectionName))
-	return newMongoAccSender(name, host, dbName, collectionName, updKey, accKey)
+	s = &MongoAccSender{updateKey: updKey}
+	s.accumulateKey, _ = c.GetAliasListOr(KeyMongodbAccKey, []conf.AliasKey{})
+	for _, o := range []struct{ key, op string }{
+		{KeyMongodbAccKey, "$inc"},
+		{KeyMongodbMaxKey, "$max"},
+		{KeyMongodbMinKey, "$min"},
+		{KeyMongodbSetKey, "$set"},
+		{KeyMongodbSetOnInsertKey, "$setOnInsert"},
+		{KeyMongodbAddToSetKey, "$addToSet"},
+	} {
+		keys, _ := c.GetAliasListOr(o.key, []conf.AliasKey{})
+		if len(keys) > 0 {
+			s.operators = append(s.operators, mongoAccOperator{op: o.op, keys: keys})
+		}
+	}
+	if len(s.operators) <= 0 {
+		return nil, errors.New("The updateKey and accumulateKey should not be empty")
+	}
+	if bucket, _ := c.GetStringOr(KeyMongodbBucket, ""); bucket != "" {
+		if s.bucket, err = time.ParseDuration(bucket); err != nil || s.bucket <= 0 {
+			return nil, fmt.Errorf("%v: invalid duration %v", KeyMongodbBucket, bucket)
+		}
+		keys, err := c.GetAliasList(KeyMongodbBucketKey)
+		if err != nil {
+			return nil, err
+		}
+		s.bucketKey = &keys[0]
+	}
+	s.preAggregate, _ = c.GetBoolOr(KeyMongodbPreAggregate, true)
+
+	// 同一列只能出现在一个操作符中，否则mongodb会拒绝更新
+	used := make(map[string]string)
+	check := func(alias, op string) error {
+		if prev, ok := used[alias]; ok {
+			return fmt.Errorf("mongodb field %v is used by both %v and %v", alias, prev, op)
+		}
+		used[alias] = op
+		return nil
+	}
+	for _, k := range s.updateKey {
+		if err = check(k.Alias, KeyMongodbUpdateKey); err != nil {
+			return nil, err
+		}
+	}
+	if s.bucketKey != nil {
+		if err = check(s.bucketKey.Alias, KeyMongodbBucketKey); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range s.operators {
+		for _, k := range o.keys {
+			if err = check(k.Alias, o.op); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
 }
 
-func newMongoAccSender(name, host, dbName, collectionName string, updKey, accKey []conf.AliasKey) (s *MongoAccSender, err error) {
+func (s *MongoAccSender) connect() (err error) {
 	// init mongodb collection
 	cfg := utils.MongoConfig{
-		Host: host,
-		DB:   dbName,
+		Host: s.host,
+		DB:   s.dbName,
 	}
 	var session *mgo.Session
 	session, err = utils.MongoDail(cfg.Host, cfg.Mode, cfg.SyncTimeoutInS)
@@ -79,64 +172,319 @@ func newMongoAccSender(name, host, dbName, collectionName string, updKey, accKey
 		return
 	}
 	db := session.DB(cfg.DB)
-	coll := db.C(collectionName)
-	if err != nil {
-		session.Close()
-		session = nil
-		return
-	}
-	collection := utils.Collection{coll}
+	s.collection = utils.Collection{Collection: db.C(s.collectionName)}
+	go s.mongoSesssionKeeper(s.collection.Database.Session)
+	return nil
+}
 
-	if len(updKey) <= 0 || len(accKey) <= 0 {
-		return nil, errors.New("The updateKey and accumulateKey should not be empty")
+// accUpdate 一次upsert，预聚合时由多条数据合并而成
+type accUpdate struct {
+	selector bson.D
+	key      string
+	ops      map[string]bson.M
+	datas    []int // 合并进来的数据在datas中的位置
+}
+
+func (u *accUpdate) update() bson.M {
+	ret := bson.M{}
+	for op, fields := range u.ops {
+		if op != "$addToSet" {
+			ret[op] = fields
+			continue
+		}
+		each := bson.M{}
+		for k, v := range fields {
+			each[k] = bson.M{"$each": v}
+		}
+		ret[op] = each
 	}
-	s = &MongoAccSender{
-		name:           name,
-		host:           host,
-		dbName:         dbName,
-		collectionName: collectionName,
-		collection:     collection,
-		updateKey:      updKey,
-		accumulateKey:  accKey,
+	return ret
+}
+
+// bucketTime 数据中分桶列的时间，列为时间类型或者RFC3339格式的字符串
+func bucketTime(v interface{}, bucket time.Duration) (time.Time, bool) {
+	var t time.Time
+	switch tv := v.(type) {
+	case time.Time:
+		t = tv
+	case string:
+		var err error
+		if t, err = time.Parse(time.RFC3339Nano, tv); err != nil {
+			return t, false
+		}
+	default:
+		return t, false
 	}
-	go s.mongoSesssionKeeper(s.collection.Database.Session)
-	return s, nil
+	return t.Truncate(bucket).UTC(), true
 }
 
-// Send 依次尝试发送数据到mongodb，返回错误中包含所有写失败的数据
-// 如果要保证每次send的原子性，必须保证datas长度为1，否则当程序宕机
-// 总会出现丢失数据的问题
-func (s *MongoAccSender) Send(datas []Data) (se error) {
-	failure := []Data{}
-	var err error
-	var lastErr error
-	ss := &utils.StatsError{}
-	for _, d := range datas {
-		selector := bson.D{}
-		for _, key := range s.updateKey {
+// makeUpdate 生成一条数据的upsert，缺少的列只记录日志，所有更新的列都缺少时返回错误
+func (s *MongoAccSender) makeUpdate(d Data) (*accUpdate, error) {
+	u := &accUpdate{ops: make(map[string]bson.M)}
+	var parts []string
+	for _, key := range s.updateKey {
+		v, exist := d[key.Key]
+		if !exist {
+			log.Errorf("Cannot find out key %v", key)
+			continue
+		}
+		u.selector = append(u.selector, bson.DocElem{Name: key.Alias, Value: v})
+		parts = append(parts, fmt.Sprintf("%v=%T:%v", key.Alias, v, v))
+	}
+	if s.bucketKey != nil {
+		t, ok := bucketTime(d[s.bucketKey.Key], s.bucket)
+		if !ok {
+			return nil, fmt.Errorf("bucket key %v is not a valid time: %v", s.bucketKey.Key, d[s.bucketKey.Key])
+		}
+		u.selector = append(u.selector, bson.DocElem{Name: s.bucketKey.Alias, Value: t})
+		parts = append(parts, fmt.Sprintf("%v=%v", s.bucketKey.Alias, t.UnixNano()))
+	}
+	u.key = strings.Join(parts, "\x00")
+	for _, o := range s.operators {
+		fields := bson.M{}
+		for _, key := range o.keys {
 			v, exist := d[key.Key]
 			if !exist {
 				log.Errorf("Cannot find out key %v", key)
 				continue
 			}
-			selector = append(selector, bson.DocElem{Name: key.Alias, Value: v})
+			if o.op == "$addToSet" {
+				v = []interface{}{v}
+			}
+			fields[key.Alias] = v
 		}
-		updator := bson.M{}
-		for _, key := range s.accumulateKey {
-			v, exist := d[key.Key]
-			if !exist {
-				log.Errorf("Cannot find out key %v", key)
+		if len(fields) > 0 {
+			u.ops[o.op] = fields
+		}
+	}
+	if len(u.ops) <= 0 {
+		return nil, errors.New("none of the accumulate keys exist")
+	}
+	return u, nil
+}
+
+// merge 把next合并到u中，无法合并时(如$inc的值不是数字)返回false，u不变
+func (u *accUpdate) merge(next *accUpdate) bool {
+	merged := make(map[string]bson.M, len(u.ops))
+	for op, fields := range u.ops {
+		m := bson.M{}
+		for k, v := range fields {
+			m[k] = v
+		}
+		merged[op] = m
+	}
+	for op, fields := range next.ops {
+		m, ok := merged[op]
+		if !ok {
+			merged[op] = fields
+			continue
+		}
+		for k, v := range fields {
+			old, ok := m[k]
+			if !ok {
+				m[k] = v
+				continue
+			}
+			switch op {
+			case "$inc":
+				sum, ok := addNumber(old, v)
+				if !ok {
+					return false
+				}
+				m[k] = sum
+			case "$max", "$min":
+				c, ok := compareValue(old, v)
+				if !ok {
+					return false
+				}
+				if (op == "$max" && c < 0) || (op == "$min" && c > 0) {
+					m[k] = v
+				}
+			case "$set":
+				m[k] = v
+			case "$setOnInsert":
+				// 保留第一次的值
+			case "$addToSet":
+				m[k] = appendUnique(old.([]interface{}), v.([]interface{}))
+			}
+		}
+	}
+	u.ops = merged
+	u.datas = append(u.datas, next.datas...)
+	return true
+}
+
+// toNumber 把数字转换为int64或者float64
+func toNumber(v interface{}) (i int64, f float64, isInt bool, ok bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), 0, true, true
+	case int8:
+		return int64(n), 0, true, true
+	case int16:
+		return int64(n), 0, true, true
+	case int32:
+		return int64(n), 0, true, true
+	case int64:
+		return n, 0, true, true
+	case uint8:
+		return int64(n), 0, true, true
+	case uint16:
+		return int64(n), 0, true, true
+	case uint32:
+		return int64(n), 0, true, true
+	case float32:
+		return 0, float64(n), false, true
+	case float64:
+		return 0, n, false, true
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, 0, true, true
+		}
+		if f, err := n.Float64(); err == nil {
+			return 0, f, false, true
+		}
+	}
+	return 0, 0, false, false
+}
+
+func addNumber(a, b interface{}) (interface{}, bool) {
+	ai, af, aInt, ok := toNumber(a)
+	if !ok {
+		return nil, false
+	}
+	bi, bf, bInt, ok := toNumber(b)
+	if !ok {
+		return nil, false
+	}
+	if aInt && bInt {
+		if (bi > 0 && ai > math.MaxInt64-bi) || (bi < 0 && ai < math.MinInt64-bi) {
+			return nil, false
+		}
+		return ai + bi, true
+	}
+	if aInt {
+		af = float64(ai)
+	}
+	if bInt {
+		bf = float64(bi)
+	}
+	return af + bf, true
+}
+
+// compareValue 比较两个数字、时间或者字符串，类型不同时返回false
+func compareValue(a, b interface{}) (int, bool) {
+	if ai, af, aInt, ok := toNumber(a); ok {
+		bi, bf, bInt, ok := toNumber(b)
+		if !ok {
+			return 0, false
+		}
+		if aInt && bInt {
+			return compareInt(ai, bi), true
+		}
+		if aInt {
+			af = float64(ai)
+		}
+		if bInt {
+			bf = float64(bi)
+		}
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch av := a.(type) {
+	case time.Time:
+		bv, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		return compareInt(av.UnixNano(), bv.UnixNano()), true
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	}
+	return 0, false
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func appendUnique(list, values []interface{}) []interface{} {
+	for _, v := range values {
+		exist := false
+		for _, l := range list {
+			if fmt.Sprintf("%T:%v", l, l) == fmt.Sprintf("%T:%v", v, v) {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// aggregate 生成每条数据的upsert，开启预聚合时把聚合条件相同的数据合并，
+// 无法合并的数据作为同一条件下新的upsert，保证与逐条更新的结果一致
+func (s *MongoAccSender) aggregate(datas []Data) (updates []*accUpdate, failed []int, lastErr error) {
+	latest := make(map[string]*accUpdate)
+	for i, d := range datas {
+		u, err := s.makeUpdate(d)
+		if err != nil {
+			failed = append(failed, i)
+			lastErr = err
+			continue
+		}
+		u.datas = []int{i}
+		if s.preAggregate {
+			if prev, ok := latest[u.key]; ok && prev.merge(u) {
 				continue
 			}
-			updator[key.Alias] = v
+			latest[u.key] = u
 		}
-		_, err = s.collection.Upsert(selector, bson.M{"$inc": updator})
+		updates = append(updates, u)
+	}
+	return
+}
+
+// Send 依次尝试发送数据到mongodb，返回错误中包含所有写失败的数据
+// 如果要保证每次send的原子性，必须保证datas长度为1，否则当程序宕机
+// 总会出现丢失数据的问题
+func (s *MongoAccSender) Send(datas []Data) (se error) {
+	failure := []Data{}
+	ss := &utils.StatsError{}
+	updates, failed, lastErr := s.aggregate(datas)
+	for _, i := range failed {
+		ss.AddErrors()
+		failure = append(failure, datas[i])
+	}
+	for _, u := range updates {
+		_, err := s.collection.Upsert(u.selector, u.update())
 		if err != nil {
-			ss.AddErrors()
 			lastErr = err
-			failure = append(failure, d)
+			for _, i := range u.datas {
+				ss.AddErrors()
+				failure = append(failure, datas[i])
+			}
 		} else {
-			ss.AddSuccess()
+			for range u.datas {
+				ss.AddSuccess()
+			}
 		}
 	}
 	if len(failure) > 0 && lastErr != nil {
@@ -160,23 +508,9 @@ func (s *MongoAccSender) Close() error {
 }
 
 func (s *MongoAccSender) mongoSesssionKeeper(session *mgo.Session) {
-	session.SetSocketTimeout(time.Second * 5)
-	session.SetSyncTimeout(time.Second * 5)
-	for {
+	mongoSessionKeeper(session, func() bool {
 		s.RLock()
-		if s.stopped {
-			s.RUnlock()
-			break
-		}
-		s.RUnlock()
-
-		err := session.Ping()
-		if err != nil {
-			session.Refresh()
-			session.SetSocketTimeout(time.Second * 5)
-			session.SetSyncTimeout(time.Second * 5)
-		} else {
-			time.Sleep(time.Second * 5)
-		}
-	}
+		defer s.RUnlock()
+		return s.stopped
+	})
 }
@@ -0,0 +1,76 @@
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMongoAccSenderAggregate(t *testing.T) {
+	s, err := newMongoAccSenderConf(conf.MapConf{
+		KeyMongodbUpdateKey:      "domain",
+		KeyMongodbAccKey:         "flow,hits",
+		KeyMongodbMaxKey:         "cost max_cost",
+		KeyMongodbMinKey:         "cost2 min_cost",
+		KeyMongodbSetKey:         "status",
+		KeyMongodbSetOnInsertKey: "first",
+		KeyMongodbAddToSetKey:    "ip ips",
+		KeyMongodbBucketKey:      "time minute",
+		KeyMongodbBucket:         "1m",
+	})
+	assert.NoError(t, err)
+	datas := []Data{
+		{"domain": "a", "time": "2017-07-01T09:00:10Z", "flow": 1, "hits": int64(1), "cost": 3, "cost2": 3, "status": "ok", "first": 1, "ip": "1.1.1.1"},
+		{"domain": "a", "time": "2017-07-01T09:00:50Z", "flow": 2.5, "hits": int64(1), "cost": 5, "cost2": 5, "status": "fail", "first": 2, "ip": "1.1.1.1"},
+		{"domain": "b", "time": "2017-07-01T09:00:20Z", "flow": 1},
+		{"domain": "a", "time": "2017-07-01T09:01:00Z", "flow": 1},
+		{"domain": "a", "time": "2017-07-01T09:00:30Z", "flow": 1, "ip": "2.2.2.2"},
+		{"domain": "a", "time": "bad", "flow": 1},
+		{"domain": "a", "time": "2017-07-01T09:00:40Z"},
+	}
+	updates, failed, lastErr := s.aggregate(datas)
+	assert.Error(t, lastErr)
+	assert.Equal(t, []int{5, 6}, failed)
+	assert.Equal(t, 3, len(updates))
+
+	minute := time.Date(2017, 7, 1, 9, 0, 0, 0, time.UTC)
+	assert.Equal(t, bson.D{{Name: "domain", Value: "a"}, {Name: "minute", Value: minute}}, updates[0].selector)
+	assert.Equal(t, []int{0, 1, 4}, updates[0].datas)
+	assert.Equal(t, bson.M{
+		"$inc":         bson.M{"flow": 4.5, "hits": int64(2)},
+		"$max":         bson.M{"max_cost": 5},
+		"$min":         bson.M{"min_cost": 3},
+		"$set":         bson.M{"status": "fail"},
+		"$setOnInsert": bson.M{"first": 1},
+		"$addToSet":    bson.M{"ips": bson.M{"$each": []interface{}{"1.1.1.1", "2.2.2.2"}}},
+	}, updates[0].update())
+	assert.Equal(t, []int{2}, updates[1].datas)
+	assert.Equal(t, bson.D{{Name: "domain", Value: "a"}, {Name: "minute", Value: minute.Add(time.Minute)}}, updates[2].selector)
+
+	// 无法合并的数据作为新的upsert
+	s.bucketKey = nil
+	updates, _, _ = s.aggregate([]Data{{"domain": "a", "flow": 1}, {"domain": "a", "flow": "x"}, {"domain": "a", "status": "ok"}})
+	assert.Equal(t, 2, len(updates))
+	assert.Equal(t, []int{0}, updates[0].datas)
+	assert.Equal(t, []int{1, 2}, updates[1].datas)
+
+	s.preAggregate = false
+	updates, _, _ = s.aggregate([]Data{{"domain": "a", "flow": 1}, {"domain": "a", "flow": 2}})
+	assert.Equal(t, 2, len(updates))
+}
+
+func TestMongoAccSenderConf(t *testing.T) {
+	_, err := newMongoAccSenderConf(conf.MapConf{KeyMongodbUpdateKey: "domain"})
+	assert.Error(t, err)
+	s, err := newMongoAccSenderConf(conf.MapConf{KeyMongodbUpdateKey: "domain", KeyMongodbMaxKey: "cost"})
+	assert.NoError(t, err)
+	assert.True(t, s.preAggregate)
+	_, err = newMongoAccSenderConf(conf.MapConf{KeyMongodbUpdateKey: "domain", KeyMongodbAccKey: "flow", KeyMongodbMaxKey: "flow"})
+	assert.Error(t, err)
+	_, err = newMongoAccSenderConf(conf.MapConf{KeyMongodbUpdateKey: "domain", KeyMongodbAccKey: "flow", KeyMongodbBucket: "1m"})
+	assert.Error(t, err)
+}
@@ -364,6 +364,8 @@ func (s *PandoraSender) Send(datas []Data) (se error) {
 	contexts := s.unpack(datas)
 	var failDatas = []Data{}
 	errType := TypeDefault
+	// 所有失败都是请求本身的问题（如参数错误、没有权限）时重试也不会成功
+	permanent := true
 	for _, pContext := range contexts {
 		err := s.client.PostDataFromBytes(pContext.inputs)
 		if err != nil {
@@ -374,6 +376,7 @@ func (s *PandoraSender) Send(datas []Data) (se error) {
 					errType = TypeBinaryUnpack
 				}
 			}
+			permanent = permanent && ok && reqErr.ErrorType == reqerr.DefaultRequestError && IsPermanentStatus(reqErr.StatusCode)
 			failDatas = append(failDatas, pContext.datas...)
 			lastErr = err
 			log.Error(datas[0], reqErr)
@@ -381,6 +384,9 @@ func (s *PandoraSender) Send(datas []Data) (se error) {
 	}
 	if len(failDatas) > 0 {
 		se = NewSendError("Cannot send data to pandora, "+lastErr.Error(), failDatas, errType)
+		if permanent {
+			se = NewPermanentError(se)
+		}
 	}
 	return
 }
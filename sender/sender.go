@@ -3,8 +3,11 @@ package sender
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/utils"
 )
 
 // Data store as use key/value map
@@ -26,6 +29,7 @@ type SendError struct {
 	failDatas []Data
 	msg       string
 	ErrorType SendErrorType
+	permanent []Data // 同一批中重试也不会成功的数据，不在failDatas中
 }
 
 func NewSendError(msg string, failDatas []Data, eType SendErrorType) *SendError {
@@ -41,6 +45,76 @@ func (e *SendError) Error() string {
 	return fmt.Sprintf("SendError: %v, failDatas size : %v", e.msg, len(e.failDatas))
 }
 
+// WithPermanent 附带同一批中重试也不会成功的数据，这些数据交给dead letter，failDatas仍然按原来的方式重试
+func (e *SendError) WithPermanent(datas []Data) *SendError {
+	e.permanent = datas
+	return e
+}
+
+// FailDatas 返回发送失败的数据，err不是SendError时认为datas全部发送失败
+func FailDatas(err error, datas []Data) []Data {
+	switch e := err.(type) {
+	case *SendError:
+		return e.failDatas
+	case *PermanentError:
+		return FailDatas(e.Err, datas)
+	case *utils.StatsError:
+		return FailDatas(e.ErrorDetail, datas)
+	}
+	return datas
+}
+
+// PermanentDatas 返回可以重试的SendError中附带的重试也不会成功的数据
+func PermanentDatas(err error) []Data {
+	switch e := err.(type) {
+	case *SendError:
+		return e.permanent
+	case *utils.StatsError:
+		return PermanentDatas(e.ErrorDetail)
+	}
+	return nil
+}
+
+// NeedSplit 数据因为过大或者个别数据不合法被整体拒绝，需要拆分后重新发送以找出有问题的数据
+func NeedSplit(err error) bool {
+	switch e := err.(type) {
+	case *SendError:
+		return e.ErrorType == TypeBinaryUnpack
+	case *utils.StatsError:
+		return NeedSplit(e.ErrorDetail)
+	}
+	return false
+}
+
+// PermanentError 重试也不会成功的错误，例如数据不符合schema。runner不会重试，数据交给dead letter
+type PermanentError struct {
+	Err error
+}
+
+func NewPermanentError(err error) *PermanentError {
+	return &PermanentError{Err: err}
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// IsPermanentError 判断发送错误是否为永久错误，其他错误都认为可以重试
+func IsPermanentError(err error) bool {
+	switch e := err.(type) {
+	case *PermanentError:
+		return true
+	case *utils.StatsError:
+		return IsPermanentError(e.ErrorDetail)
+	}
+	return false
+}
+
+// IsPermanentStatus HTTP状态码是否表示永久错误，4xx中除了请求超时和限流之外都无法通过重试解决
+func IsPermanentStatus(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
 // Sender send data to pandora, prometheus such different destinations
 type Sender interface {
 	Name() string
@@ -54,6 +128,37 @@ const (
 	KeySenderType    = "sender_type"
 	KeyFaultTolerant = "fault_tolerant"
 	KeyName          = "name"
+	KeyRouteIf       = "route_if"           // 条件表达式，runner只把满足条件的数据交给该sender
+	KeyPolicy        = "sender_policy"      // sender无法发送时runner的处理策略
+	KeyBufferSize    = "sender_buffer_size" // runner为该sender在内存中缓存的batch数
+	KeyParserSchema  = "parser_schema"      // runner根据parser填写的字段类型，形如"status long,ts date"
+
+	KeyRetryInterval        = "retry_interval"         // 第一次重试前的等待时间，之后按retry_multiplier指数增长
+	KeyRetryMaxInterval     = "retry_max_interval"     // 重试等待时间的上限
+	KeyRetryMultiplier      = "retry_multiplier"       // 每次重试等待时间的增长倍数
+	KeyRetryJitter          = "retry_jitter"           // 等待时间随机抖动的比例，0到1之间
+	KeyBreakerThreshold     = "breaker_threshold"      // 连续失败多少次后熔断，小于等于0表示不熔断
+	KeyBreakerProbeInterval = "breaker_probe_interval" // 熔断后每隔多久尝试发送一次
+)
+
+// sender_policy 的取值
+const (
+	PolicyBlock = "block" // 缓存满后等待，读取会在该sender恢复前停止，默认策略
+	PolicyDisk  = "disk"  // 数据先写入本地磁盘队列再发送，等价于开启fault_tolerant
+	PolicyDrop  = "drop"  // 缓存满后丢弃该sender的数据，不影响读取与其他sender
+)
+
+// DefaultBufferSize 每个sender默认在内存中缓存的batch数
+const DefaultBufferSize = 10
+
+// 重试与熔断的默认配置
+const (
+	DefaultRetryInterval        = time.Second
+	DefaultRetryMaxInterval     = 30 * time.Second
+	DefaultRetryMultiplier      = 2.0
+	DefaultRetryJitter          = 0.2
+	DefaultBreakerThreshold     = 10
+	DefaultBreakerProbeInterval = 30 * time.Second
 )
 
 // SenderType 发送类型
@@ -65,7 +170,11 @@ const (
 	TypeMock              = "mock"          // mock sender
 	TypeDiscard           = "discard"       // discard sender
 	TypeElastic           = "elasticsearch" // elastic
-
+	TypeKafka             = "kafka"         // kafka
+	TypeHttp              = "http"          // 任意http接口
+	TypeMongodb           = "mongodb"       // mongodb 原样写入
+	TypeSql               = "sql"           // 关系型数据库
+	TypeClickhouse        = "clickhouse"    // clickhouse
 )
 
 // Ft sender默认同步一次meta信息的数据次数
@@ -83,8 +192,13 @@ func NewSenderRegistry() *SenderRegistry {
 	ret.RegisterSender(TypeFile, NewFileSender)
 	ret.RegisterSender(TypePandora, NewPandoraSender)
 	ret.RegisterSender(TypeMongodbAccumulate, NewMongodbAccSender)
+	ret.RegisterSender(TypeMongodb, NewMongodbSender)
 	ret.RegisterSender(TypeInfluxdb, NewInfluxdbSender)
 	ret.RegisterSender(TypeElastic, NewElasticSender)
+	ret.RegisterSender(TypeKafka, NewKafkaSender)
+	ret.RegisterSender(TypeHttp, NewHttpSender)
+	ret.RegisterSender(TypeSql, NewSqlSender)
+	ret.RegisterSender(TypeClickhouse, NewClickhouseSender)
 	ret.RegisterSender(TypeMock, NewMockSender)
 	ret.RegisterSender(TypeDiscard, NewDiscardSender)
 	return ret
@@ -99,6 +213,12 @@ func (registry *SenderRegistry) RegisterSender(senderType string, constructor fu
 	return nil
 }
 
+// HasType 判断senderType是否已经注册
+func (r *SenderRegistry) HasType(senderType string) bool {
+	_, exist := r.senderTypeMap[senderType]
+	return exist
+}
+
 func (r *SenderRegistry) NewSender(conf conf.MapConf) (sender Sender, err error) {
 	sendType, err := conf.GetString(KeySenderType)
 	if err != nil {
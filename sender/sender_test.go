@@ -0,0 +1,40 @@
+package sender
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/qiniu/logkit/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermanentError(t *testing.T) {
+	err := NewPermanentError(NewSendError("schema mismatch", []Data{{"a": 1}}, TypeDefault))
+	assert.True(t, IsPermanentError(err))
+	assert.True(t, IsPermanentError(&utils.StatsError{ErrorDetail: err}))
+	assert.Equal(t, "SendError: schema mismatch, failDatas size : 1", err.Error())
+	assert.False(t, IsPermanentError(errors.New("timeout")))
+	assert.False(t, IsPermanentError(&utils.StatsError{}))
+	assert.False(t, IsPermanentError(nil))
+
+	assert.True(t, IsPermanentStatus(http.StatusBadRequest))
+	assert.True(t, IsPermanentStatus(http.StatusUnauthorized))
+	assert.False(t, IsPermanentStatus(http.StatusTooManyRequests))
+	assert.False(t, IsPermanentStatus(http.StatusRequestTimeout))
+	assert.False(t, IsPermanentStatus(http.StatusServiceUnavailable))
+	assert.False(t, IsPermanentStatus(http.StatusOK))
+}
+
+func TestFailDatas(t *testing.T) {
+	datas := []Data{{"a": 1}, {"a": 2}}
+	assert.Equal(t, datas, FailDatas(errors.New("timeout"), datas))
+	se := NewSendError("partial", datas[1:], TypeBinaryUnpack)
+	assert.Equal(t, datas[1:], FailDatas(se, datas))
+	assert.Equal(t, datas[1:], FailDatas(&utils.StatsError{ErrorDetail: NewPermanentError(se)}, datas))
+	assert.True(t, NeedSplit(se))
+	assert.True(t, NeedSplit(&utils.StatsError{ErrorDetail: se}))
+	assert.False(t, NeedSplit(NewSendError("all", datas, TypeDefault)))
+	assert.False(t, NeedSplit(errors.New("timeout")))
+}
@@ -0,0 +1,629 @@
+package sender
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/qiniu/log"
+
+	_ "github.com/denisenkom/go-mssqldb" //mssql 驱动
+	_ "github.com/mattn/go-sqlite3"      //sqlite3 驱动，需要cgo
+)
+
+// SqlSender 把数据批量写入关系型数据库的表中，每批数据在一个事务中写入
+type SqlSender struct {
+	name      string
+	db        *sql.DB
+	dialect   string
+	table     string
+	columns   []sqlColumn // 为空时按每批数据中出现的字段自动映射
+	types     map[string]string
+	mode      string
+	keys      []string // upsert时冲突判断的列
+	batchSize int
+}
+
+// sqlColumn 数据字段与表中列的对应关系，typ为空时不做类型转换
+type sqlColumn struct {
+	field string
+	name  string
+	typ   string
+}
+
+// 可选参数 当sender_type 为sql 的时候
+const (
+	KeySqlDriver      = "sql_driver"       // database/sql的驱动名，如mysql、mssql、postgres、sqlite3
+	KeySqlDialect     = "sql_dialect"      // sql方言，mysql、postgres、sqlite或mssql，默认根据驱动名判断
+	KeySqlDataSource  = "sql_datasource"   // 连接串，格式由驱动决定
+	KeySqlTable       = "sql_table"        // 写入的表名
+	KeySqlColumns     = "sql_columns"      // 字段与列的对应关系，支持别名，如"status,ts time"，不填时按字段名自动映射
+	KeySqlSchema      = "sql_schema"       // 列的类型，如"status long,time date"，不填时使用parser_schema
+	KeySqlCreateTable = "sql_create_table" // 表不存在时根据sql_schema创建，默认为false
+	KeySqlMode        = "sql_mode"         // 写入方式，insert或者upsert，默认为insert
+	KeySqlUpsertKeys  = "sql_upsert_keys"  // upsert时判断冲突的列，需要是主键或者唯一索引
+	KeySqlBatchSize   = "sql_batch_size"   // 一条insert语句最多写入的行数，默认为500
+)
+
+// sql sender 的方言
+const (
+	SqlDialectMysql    = "mysql"
+	SqlDialectPostgres = "postgres"
+	SqlDialectSqlite   = "sqlite"
+	SqlDialectMssql    = "mssql"
+)
+
+// sql sender 的写入方式
+const (
+	SqlModeInsert = "insert"
+	SqlModeUpsert = "upsert"
+)
+
+const defaultSqlBatchSize = 500
+
+// sqlMaxParams 各数据库一条语句中参数个数的上限
+var sqlMaxParams = map[string]int{
+	SqlDialectMysql:    65535,
+	SqlDialectPostgres: 65535,
+	SqlDialectSqlite:   999,
+	SqlDialectMssql:    2100,
+}
+
+// sqlColumnTypes 建表时各类型在不同数据库中对应的列类型
+var sqlColumnTypes = map[string]map[string]string{
+	SqlDialectMysql:    {"long": "BIGINT", "float": "DOUBLE", "bool": "BOOLEAN", "date": "DATETIME(6)", "string": "TEXT"},
+	SqlDialectPostgres: {"long": "BIGINT", "float": "DOUBLE PRECISION", "bool": "BOOLEAN", "date": "TIMESTAMP", "string": "TEXT"},
+	SqlDialectSqlite:   {"long": "INTEGER", "float": "REAL", "bool": "INTEGER", "date": "DATETIME", "string": "TEXT"},
+	SqlDialectMssql:    {"long": "BIGINT", "float": "FLOAT", "bool": "BIT", "date": "DATETIME2", "string": "NVARCHAR(MAX)"},
+}
+
+// sqlKeyColumnTypes 作为主键的字符串列不能是TEXT
+var sqlKeyColumnTypes = map[string]string{
+	SqlDialectMysql: "VARCHAR(255)",
+	SqlDialectMssql: "NVARCHAR(255)",
+}
+
+// sqlDialect 根据驱动名判断方言
+func sqlDialect(driverName string) string {
+	switch driverName {
+	case "mysql":
+		return SqlDialectMysql
+	case "postgres", "pgx":
+		return SqlDialectPostgres
+	case "sqlite3", "sqlite":
+		return SqlDialectSqlite
+	case "mssql", "sqlserver":
+		return SqlDialectMssql
+	}
+	return ""
+}
+
+// parseSqlSchema 解析"name type,name type"形式的列类型，保持配置的顺序
+func parseSqlSchema(key string, list []string) (names []string, types map[string]string, err error) {
+	types = make(map[string]string)
+	for _, f := range list {
+		parts := strings.Fields(f)
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("%v: %v should be like \"name type\"", key, f)
+		}
+		switch parts[1] {
+		case "long", "float", "bool", "date", "string":
+		default:
+			return nil, nil, fmt.Errorf("%v: unsupported type %v", key, parts[1])
+		}
+		if _, ok := types[parts[0]]; !ok {
+			names = append(names, parts[0])
+		}
+		types[parts[0]] = parts[1]
+	}
+	return
+}
+
+// NewSqlSender 创建sql sender
+func NewSqlSender(c conf.MapConf) (Sender, error) {
+	driverName, err := c.GetString(KeySqlDriver)
+	if err != nil {
+		return nil, err
+	}
+	dataSource, err := c.GetString(KeySqlDataSource)
+	if err != nil {
+		return nil, err
+	}
+	table, err := c.GetString(KeySqlTable)
+	if err != nil {
+		return nil, err
+	}
+	dialect, _ := c.GetStringOr(KeySqlDialect, sqlDialect(driverName))
+	if _, ok := sqlMaxParams[dialect]; !ok {
+		return nil, fmt.Errorf("%v: unsupported sql dialect %q, set %v to one of mysql, postgres, sqlite, mssql", driverName, dialect, KeySqlDialect)
+	}
+	s := &SqlSender{
+		dialect: dialect,
+		table:   table,
+		types:   make(map[string]string),
+	}
+	s.name, _ = c.GetStringOr(KeyName, fmt.Sprintf("sqlSender:(%v,table:%v)", driverName, table))
+	s.mode, _ = c.GetStringOr(KeySqlMode, SqlModeInsert)
+	s.batchSize, _ = c.GetIntOr(KeySqlBatchSize, defaultSqlBatchSize)
+	if s.batchSize <= 0 {
+		s.batchSize = defaultSqlBatchSize
+	}
+
+	// sql_schema 中是列名，parser_schema 中是字段名
+	schemaKey := KeySqlSchema
+	schemaList, _ := c.GetStringListOr(KeySqlSchema, []string{})
+	if len(schemaList) <= 0 {
+		schemaKey = KeyParserSchema
+		schemaList, _ = c.GetStringListOr(KeyParserSchema, []string{})
+	}
+	names, types, err := parseSqlSchema(schemaKey, schemaList)
+	if err != nil {
+		return nil, err
+	}
+	aliases, _ := c.GetAliasListOr(KeySqlColumns, []conf.AliasKey{})
+	for _, a := range aliases {
+		col := sqlColumn{field: a.Key, name: a.Alias, typ: types[a.Alias]}
+		if schemaKey == KeyParserSchema {
+			col.typ = types[a.Key]
+		}
+		s.columns = append(s.columns, col)
+	}
+	if len(s.columns) <= 0 {
+		for _, n := range names {
+			s.columns = append(s.columns, sqlColumn{field: n, name: n, typ: types[n]})
+		}
+	}
+	for _, col := range s.columns {
+		if col.typ != "" {
+			s.types[col.name] = col.typ
+		}
+	}
+	// 自动映射时字段名即列名
+	if len(s.columns) <= 0 {
+		s.types = types
+	}
+
+	switch s.mode {
+	case SqlModeInsert:
+	case SqlModeUpsert:
+		if dialect == SqlDialectMssql {
+			return nil, fmt.Errorf("%v: upsert is not supported for mssql", KeySqlMode)
+		}
+		if s.keys, err = c.GetStringList(KeySqlUpsertKeys); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%v: unsupported mode %v", KeySqlMode, s.mode)
+	}
+	createTable, _ := c.GetBoolOr(KeySqlCreateTable, false)
+	if createTable && len(s.columns) <= 0 {
+		return nil, fmt.Errorf("%v requires %v or %v", KeySqlCreateTable, KeySqlSchema, KeySqlColumns)
+	}
+
+	if s.db, err = sql.Open(driverName, dataSource); err != nil {
+		return nil, fmt.Errorf("%v open %v failed: %v", s.name, driverName, err)
+	}
+	if createTable {
+		if _, err = s.db.Exec(s.createTableSQL()); err != nil {
+			s.db.Close()
+			return nil, fmt.Errorf("%v create table %v failed: %v", s.name, table, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *SqlSender) Name() string {
+	return s.name
+}
+
+func (s *SqlSender) Close() error {
+	return s.db.Close()
+}
+
+// quote 按方言给表名、列名加上引号，表名中的"."作为schema分隔符
+func (s *SqlSender) quote(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		switch s.dialect {
+		case SqlDialectMysql:
+			parts[i] = "`" + strings.Replace(p, "`", "``", -1) + "`"
+		case SqlDialectMssql:
+			parts[i] = "[" + strings.Replace(p, "]", "]]", -1) + "]"
+		default:
+			parts[i] = `"` + strings.Replace(p, `"`, `""`, -1) + `"`
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func (s *SqlSender) placeholder(i int) string {
+	switch s.dialect {
+	case SqlDialectPostgres:
+		return "$" + strconv.Itoa(i)
+	case SqlDialectMssql:
+		return "@p" + strconv.Itoa(i)
+	}
+	return "?"
+}
+
+func (s *SqlSender) isKey(name string) bool {
+	for _, k := range s.keys {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
+
+// createTableSQL 根据配置的列生成建表语句，没有类型的列作为字符串
+func (s *SqlSender) createTableSQL() string {
+	var defs []string
+	for _, col := range s.columns {
+		typ := col.typ
+		if typ == "" {
+			typ = "string"
+		}
+		colType := sqlColumnTypes[s.dialect][typ]
+		if typ == "string" && s.isKey(col.name) && sqlKeyColumnTypes[s.dialect] != "" {
+			colType = sqlKeyColumnTypes[s.dialect]
+		}
+		defs = append(defs, s.quote(col.name)+" "+colType)
+	}
+	if len(s.keys) > 0 {
+		keys := make([]string, len(s.keys))
+		for i, k := range s.keys {
+			keys[i] = s.quote(k)
+		}
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	body := s.quote(s.table) + " (" + strings.Join(defs, ", ") + ")"
+	if s.dialect == SqlDialectMssql {
+		return fmt.Sprintf("IF OBJECT_ID(N'%v', N'U') IS NULL CREATE TABLE %v", strings.Replace(s.table, "'", "''", -1), body)
+	}
+	return "CREATE TABLE IF NOT EXISTS " + body
+}
+
+// insertSQL 生成rows行数据的insert语句，参数按行依次排列
+func (s *SqlSender) insertSQL(columns []string, rows int) string {
+	var buf bytes.Buffer
+	buf.WriteString("INSERT INTO ")
+	buf.WriteString(s.quote(s.table))
+	buf.WriteString(" (")
+	for i, c := range columns {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(s.quote(c))
+	}
+	buf.WriteString(") VALUES ")
+	n := 0
+	for r := 0; r < rows; r++ {
+		if r > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("(")
+		for i := range columns {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			n++
+			buf.WriteString(s.placeholder(n))
+		}
+		buf.WriteString(")")
+	}
+	if s.mode != SqlModeUpsert {
+		return buf.String()
+	}
+	var updates []string
+	for _, c := range columns {
+		if s.isKey(c) {
+			continue
+		}
+		if s.dialect == SqlDialectMysql {
+			updates = append(updates, fmt.Sprintf("%v = VALUES(%v)", s.quote(c), s.quote(c)))
+		} else {
+			updates = append(updates, fmt.Sprintf("%v = excluded.%v", s.quote(c), s.quote(c)))
+		}
+	}
+	if s.dialect == SqlDialectMysql {
+		if len(updates) <= 0 {
+			// 只有主键列时重复的行保持不变
+			k := s.quote(s.keys[0])
+			updates = append(updates, fmt.Sprintf("%v = %v", k, k))
+		}
+		buf.WriteString(" ON DUPLICATE KEY UPDATE ")
+		buf.WriteString(strings.Join(updates, ", "))
+		return buf.String()
+	}
+	keys := make([]string, len(s.keys))
+	for i, k := range s.keys {
+		keys[i] = s.quote(k)
+	}
+	buf.WriteString(" ON CONFLICT (" + strings.Join(keys, ", ") + ")")
+	if len(updates) <= 0 {
+		buf.WriteString(" DO NOTHING")
+	} else {
+		buf.WriteString(" DO UPDATE SET " + strings.Join(updates, ", "))
+	}
+	return buf.String()
+}
+
+// convertSqlValue 把字段的值转换为驱动支持的类型，typ为空时按值本身的类型转换
+func convertSqlValue(v interface{}, typ string) (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch typ {
+	case "long":
+		switch n := v.(type) {
+		case float32:
+			return int64(n), nil
+		case float64:
+			return int64(n), nil
+		case string:
+			return strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+		case json.Number:
+			return n.Int64()
+		}
+	case "float":
+		switch n := v.(type) {
+		case string:
+			return strconv.ParseFloat(strings.TrimSpace(n), 64)
+		case json.Number:
+			return n.Float64()
+		}
+	case "bool":
+		if b, ok := v.(string); ok {
+			return strconv.ParseBool(strings.TrimSpace(b))
+		}
+	case "date":
+		switch t := v.(type) {
+		case string:
+			return time.Parse(time.RFC3339Nano, t)
+		case int64:
+			return time.Unix(t, 0), nil
+		}
+	case "string":
+		switch v.(type) {
+		case string, []byte, map[string]interface{}, []interface{}:
+		default:
+			return fmt.Sprint(v), nil
+		}
+	}
+	value, err := sqlValue(v)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "long":
+		switch n := value.(type) {
+		case int64:
+			return n, nil
+		case float64:
+			return int64(n), nil
+		}
+	case "float":
+		switch n := value.(type) {
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case "bool":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "date":
+		if t, ok := value.(time.Time); ok {
+			return t, nil
+		}
+	case "", "string":
+		return value, nil
+	}
+	return nil, fmt.Errorf("cannot convert %v(%T) to %v", v, v, typ)
+}
+
+// sqlValue 把Go的值转换为driver.Value，map和slice转换为json字符串
+func sqlValue(v interface{}) (driver.Value, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int8:
+		return int64(n), nil
+	case int16:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint:
+		return int64(n), nil
+	case uint8:
+		return int64(n), nil
+	case uint16:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case float32:
+		return float64(n), nil
+	case float64, bool, string, []byte, time.Time:
+		return n, nil
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		return n.Float64()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// batchColumns 本批数据写入的列，自动映射时为所有数据中出现过的字段
+func (s *SqlSender) batchColumns(datas []Data) []sqlColumn {
+	if len(s.columns) > 0 {
+		return s.columns
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, d := range datas {
+		for k := range d {
+			if !seen[k] {
+				seen[k] = true
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Strings(names)
+	cols := make([]sqlColumn, len(names))
+	for i, n := range names {
+		cols[i] = sqlColumn{field: n, name: n, typ: s.types[n]}
+	}
+	return cols
+}
+
+// isPermanentSqlError 数据本身有问题(如类型不匹配、重复的主键)时重试也不会成功，
+// 目前可以识别mysql、postgres与sqlite的错误码，其他驱动的错误都认为可以重试
+func isPermanentSqlError(err error) bool {
+	if err == driver.ErrBadConn {
+		return false
+	}
+	if _, ok := err.(net.Error); ok {
+		return false
+	}
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		switch e.Number {
+		case 1048, // 列不能为null
+			1054, // 列不存在
+			1062, // 重复的主键
+			1136, // 列数不匹配
+			1146, // 表不存在
+			1264, // 超出范围
+			1292, // 日期格式错误
+			1366, // 数值格式错误
+			1406: // 数据太长
+			return true
+		}
+	case *pq.Error:
+		switch e.Code.Class() {
+		case "22", // 数据格式错误、超出范围
+			"23": // 违反约束，如重复的主键、列不能为null
+			return true
+		}
+		switch e.Code {
+		case "42P01", // 表不存在
+			"42703": // 列不存在
+			return true
+		}
+	}
+	return isPermanentSqliteError(err)
+}
+
+// Send 把数据在一个事务中写入，数据被数据库拒绝时整批回滚，
+// 多条数据时返回TypeBinaryUnpack由fault tolerant sender拆分后重试以找出有问题的数据。
+// 无法转换类型的数据不会写入也不再重试，写入失败时通过WithPermanent单独返回
+func (s *SqlSender) Send(datas []Data) error {
+	cols := s.batchColumns(datas)
+	if len(cols) <= 0 {
+		return nil
+	}
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = col.name
+	}
+	var failDatas, sendDatas []Data
+	var rows [][]interface{}
+	var lastErr error
+	for _, d := range datas {
+		row := make([]interface{}, len(cols))
+		var err error
+		for i, col := range cols {
+			if row[i], err = convertSqlValue(d[col.field], col.typ); err != nil {
+				err = fmt.Errorf("column %v: %v", col.name, err)
+				break
+			}
+		}
+		if err != nil {
+			failDatas = append(failDatas, d)
+			lastErr = err
+			continue
+		}
+		sendDatas = append(sendDatas, d)
+		rows = append(rows, row)
+	}
+	if len(rows) > 0 {
+		if err := s.write(names, rows); err != nil {
+			msg := fmt.Sprintf("%v failed to write %v datas into %v: %v", s.Name(), len(sendDatas), s.table, err)
+			if lastErr != nil {
+				msg += fmt.Sprintf(", failed to convert %v datas, last error %v", len(failDatas), lastErr)
+			}
+			log.Error(msg)
+			// 只重试写入的数据，无法转换的数据重试也不会成功
+			eType := TypeDefault
+			if isPermanentSqlError(err) {
+				eType = TypeBinaryUnpack
+			}
+			return NewSendError(msg, sendDatas, eType).WithPermanent(failDatas)
+		}
+	}
+	if lastErr == nil {
+		return nil
+	}
+	msg := fmt.Sprintf("%v failed to convert %v of %v datas, last error %v", s.Name(), len(failDatas), len(datas), lastErr)
+	log.Error(msg)
+	return NewPermanentError(NewSendError(msg, failDatas, TypeDefault))
+}
+
+// write 在一个事务中按sql_batch_size和参数个数上限拆分成多条insert语句
+func (s *SqlSender) write(columns []string, rows [][]interface{}) (err error) {
+	per := s.batchSize
+	if max := sqlMaxParams[s.dialect] / len(columns); max < per {
+		per = max
+	}
+	if per <= 0 {
+		return errors.New("too many columns")
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	for start := 0; start < len(rows); start += per {
+		end := start + per
+		if end > len(rows) {
+			end = len(rows)
+		}
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			args = append(args, row...)
+		}
+		if _, err = tx.Exec(s.insertSQL(columns, end-start), args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,21 @@
+//go:build cgo
+// +build cgo
+
+package sender
+
+import "github.com/mattn/go-sqlite3"
+
+// isPermanentSqliteError sqlite3驱动需要cgo，错误类型也只在开启cgo时存在
+func isPermanentSqliteError(err error) bool {
+	e, ok := err.(sqlite3.Error)
+	if !ok {
+		return false
+	}
+	switch e.Code {
+	case sqlite3.ErrConstraint, // 违反约束，如重复的主键、列不能为null
+		sqlite3.ErrMismatch, // 类型不匹配
+		sqlite3.ErrTooBig:   // 数据太长
+		return true
+	}
+	return false
+}
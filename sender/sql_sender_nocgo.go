@@ -0,0 +1,9 @@
+//go:build !cgo
+// +build !cgo
+
+package sender
+
+// isPermanentSqliteError 没有开启cgo时sqlite3驱动无法使用
+func isPermanentSqliteError(err error) bool {
+	return false
+}
@@ -0,0 +1,189 @@
+package sender
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// sqliteDataSource 同名的内存数据库在连接之间共享，sender关闭后数据库才会被删除
+func sqliteDataSource(name string) string {
+	return "file:" + name + "?mode=memory&cache=shared"
+}
+
+func TestSqlSenderInsert(t *testing.T) {
+	s, err := NewSqlSender(conf.MapConf{
+		KeySqlDriver:      "sqlite3",
+		KeySqlDataSource:  sqliteDataSource("TestSqlSenderInsert"),
+		KeySqlTable:       "main.logs",
+		KeySqlColumns:     "status,ts time,msg",
+		KeyParserSchema:   "status long,ts date",
+		KeySqlCreateTable: "true",
+		KeySqlBatchSize:   "2",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, SqlDialectSqlite, s.(*SqlSender).dialect)
+	ts := time.Date(2017, 7, 1, 9, 0, 0, 0, time.UTC)
+	datas := []Data{
+		{"status": "200", "ts": "2017-07-01T09:00:00Z", "msg": "ok"},
+		{"status": 404.0, "ts": ts, "msg": map[string]interface{}{"a": 1}},
+		{"status": "x"},
+		{"status": 500},
+	}
+	err = s.Send(datas)
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, []Data{datas[2]}, FailDatas(err, datas))
+
+	rows, err := s.(*SqlSender).db.Query(`SELECT "status", "time", "msg" FROM "logs" ORDER BY rowid`)
+	assert.NoError(t, err)
+	defer rows.Close()
+	var got [][]interface{}
+	for rows.Next() {
+		var status int64
+		var tm *time.Time
+		var msg sql.NullString
+		assert.NoError(t, rows.Scan(&status, &tm, &msg))
+		row := []interface{}{status, nil, nil}
+		if tm != nil {
+			row[1] = tm.UTC()
+		}
+		if msg.Valid {
+			row[2] = msg.String
+		}
+		got = append(got, row)
+	}
+	assert.NoError(t, rows.Err())
+	assert.Equal(t, [][]interface{}{{int64(200), ts, "ok"}, {int64(404), ts, `{"a":1}`}, {int64(500), nil, nil}}, got)
+}
+
+func TestSqlSenderAutoUpsert(t *testing.T) {
+	dsn := sqliteDataSource("TestSqlSenderAutoUpsert")
+	s, err := NewSqlSender(conf.MapConf{
+		KeySqlDriver:     "sqlite3",
+		KeySqlDataSource: dsn,
+		KeySqlTable:      "logs",
+		KeySqlMode:       SqlModeUpsert,
+		KeySqlUpsertKeys: "id",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	db := s.(*SqlSender).db
+	_, err = db.Exec(`CREATE TABLE logs (id INTEGER PRIMARY KEY, a TEXT, b INTEGER)`)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"id": 1, "b": true}, {"id": 2, "a": "x"}}))
+	// 自动映射时只更新本批数据中出现的列
+	assert.NoError(t, s.Send([]Data{{"id": 1, "a": "y"}}))
+	assertSqliteRows := func(expect [][]interface{}) {
+		rows, err := db.Query(`SELECT id, a, b FROM logs ORDER BY id`)
+		assert.NoError(t, err)
+		defer rows.Close()
+		var got [][]interface{}
+		for rows.Next() {
+			var id int64
+			var a sql.NullString
+			var b sql.NullInt64
+			assert.NoError(t, rows.Scan(&id, &a, &b))
+			row := []interface{}{id, nil, nil}
+			if a.Valid {
+				row[1] = a.String
+			}
+			if b.Valid {
+				row[2] = b.Int64
+			}
+			got = append(got, row)
+		}
+		assert.NoError(t, rows.Err())
+		assert.Equal(t, expect, got)
+	}
+	assertSqliteRows([][]interface{}{{int64(1), "y", int64(1)}, {int64(2), "x", nil}})
+
+	// 数据被拒绝时整批回滚，由fault tolerant sender拆分重试
+	insert, err := NewSqlSender(conf.MapConf{
+		KeySqlDriver:     "sqlite3",
+		KeySqlDataSource: dsn,
+		KeySqlTable:      "logs",
+	})
+	assert.NoError(t, err)
+	defer insert.Close()
+	datas := []Data{{"id": 3}, {"id": 1}}
+	err = insert.Send(datas)
+	se, ok := err.(*SendError)
+	assert.True(t, ok)
+	assert.Equal(t, TypeBinaryUnpack, se.ErrorType)
+	assert.Equal(t, datas, FailDatas(err, datas))
+	assertSqliteRows([][]interface{}{{int64(1), "y", int64(1)}, {int64(2), "x", nil}})
+
+	// 只重试写入的数据，无法转换的数据单独返回
+	insert.(*SqlSender).types["id"] = "long"
+	datas = []Data{{"id": 1}, {"id": "x"}}
+	err = insert.Send(datas)
+	assert.False(t, IsPermanentError(err))
+	assert.Equal(t, datas[:1], FailDatas(err, datas))
+	assert.Equal(t, datas[1:], PermanentDatas(err))
+
+	// 连接不可用时可以重试
+	assert.NoError(t, insert.Close())
+	err = insert.Send([]Data{{"id": 3}})
+	assert.False(t, IsPermanentError(err))
+	assert.False(t, NeedSplit(err))
+}
+
+func TestIsPermanentSqlError(t *testing.T) {
+	assert.True(t, isPermanentSqlError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}))
+	assert.False(t, isPermanentSqlError(&mysql.MySQLError{Number: 1040, Message: "Too many connections"}))
+	assert.True(t, isPermanentSqlError(&pq.Error{Code: "23505"}))
+	assert.True(t, isPermanentSqlError(&pq.Error{Code: "42P01"}))
+	assert.False(t, isPermanentSqlError(&pq.Error{Code: "53300"}))
+	assert.False(t, isPermanentSqlError(driver.ErrBadConn))
+}
+
+func TestSqlSenderSQL(t *testing.T) {
+	s := &SqlSender{dialect: SqlDialectSqlite, table: "logs", mode: SqlModeUpsert, keys: []string{"id"}}
+	assert.Equal(t, `INSERT INTO "logs" ("id", "v") VALUES (?, ?) ON CONFLICT ("id") DO UPDATE SET "v" = excluded."v"`, s.insertSQL([]string{"id", "v"}, 1))
+	assert.Equal(t, `INSERT INTO "logs" ("id") VALUES (?) ON CONFLICT ("id") DO NOTHING`, s.insertSQL([]string{"id"}, 1))
+
+	s = &SqlSender{dialect: SqlDialectMysql, table: "logs", mode: SqlModeUpsert, keys: []string{"id"}}
+	assert.Equal(t, "INSERT INTO `logs` (`id`, `v`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `v` = VALUES(`v`)", s.insertSQL([]string{"id", "v"}, 1))
+	assert.Equal(t, "INSERT INTO `logs` (`id`) VALUES (?) ON DUPLICATE KEY UPDATE `id` = `id`", s.insertSQL([]string{"id"}, 1))
+
+	s = &SqlSender{dialect: SqlDialectPostgres, table: "public.logs", columns: []sqlColumn{{name: "status", typ: "long"}, {name: "time", typ: "date"}}}
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "public"."logs" ("status" BIGINT, "time" TIMESTAMP)`, s.createTableSQL())
+	assert.Equal(t, `INSERT INTO "public"."logs" ("status", "time") VALUES ($1, $2), ($3, $4)`, s.insertSQL([]string{"status", "time"}, 2))
+
+	s = &SqlSender{dialect: SqlDialectMssql, table: "dbo.logs", columns: []sqlColumn{{name: "id", typ: "long"}, {name: "msg"}}}
+	assert.Equal(t, `IF OBJECT_ID(N'dbo.logs', N'U') IS NULL CREATE TABLE [dbo].[logs] ([id] BIGINT, [msg] NVARCHAR(MAX))`, s.createTableSQL())
+	assert.Equal(t, `INSERT INTO [dbo].[logs] ([id], [msg]) VALUES (@p1, @p2), (@p3, @p4)`, s.insertSQL([]string{"id", "msg"}, 2))
+
+	s = &SqlSender{dialect: SqlDialectMysql, table: "logs", keys: []string{"id"}, columns: []sqlColumn{{name: "id", typ: "string"}, {name: "ok", typ: "bool"}}}
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS `logs` (`id` VARCHAR(255), `ok` BOOLEAN, PRIMARY KEY (`id`))", s.createTableSQL())
+}
+
+func TestSqlSenderConf(t *testing.T) {
+	base := func() conf.MapConf {
+		return conf.MapConf{KeySqlDriver: "sqlite3", KeySqlDataSource: sqliteDataSource("TestSqlSenderConf"), KeySqlTable: "t"}
+	}
+	c := base()
+	c[KeySqlDriver] = "odbc"
+	_, err := NewSqlSender(c)
+	assert.Error(t, err)
+	c = base()
+	c[KeySqlMode] = SqlModeUpsert
+	_, err = NewSqlSender(c)
+	assert.Error(t, err)
+	c = base()
+	c[KeySqlCreateTable] = "true"
+	_, err = NewSqlSender(c)
+	assert.Error(t, err)
+	c = base()
+	c[KeySqlSchema] = "a int"
+	_, err = NewSqlSender(c)
+	assert.Error(t, err)
+}
@@ -0,0 +1,139 @@
+package sender
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// fieldTemplate 形如"logs_%{service}"的模板，%{field}替换为数据中对应字段的值
+type fieldTemplate struct {
+	raw    string
+	texts  []string // 比fields多一个，依次与fields交替拼接
+	fields []string
+}
+
+func newFieldTemplate(raw string) (*fieldTemplate, error) {
+	return parseFieldTemplate(raw, "%{")
+}
+
+// parseFieldTemplate 以open作为字段的起始标记解析模板，字段以"}"结束
+func parseFieldTemplate(raw, open string) (*fieldTemplate, error) {
+	t := &fieldTemplate{raw: raw}
+	rest := raw
+	for {
+		start := strings.Index(rest, open)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("template %v: missing } after %v", raw, open)
+		}
+		field := rest[start+len(open) : start+end]
+		if field == "" {
+			return nil, fmt.Errorf("template %v: empty field name", raw)
+		}
+		t.texts = append(t.texts, rest[:start])
+		t.fields = append(t.fields, field)
+		rest = rest[start+end+1:]
+	}
+	t.texts = append(t.texts, rest)
+	return t, nil
+}
+
+// Static 模板中没有引用任何字段
+func (t *fieldTemplate) Static() bool {
+	return len(t.fields) <= 0
+}
+
+// Render 使用数据的字段生成字符串，引用的字段不存在时返回false
+func (t *fieldTemplate) Render(d Data) (string, bool) {
+	if t.Static() {
+		return t.raw, true
+	}
+	var buf []byte
+	for i, f := range t.fields {
+		v, ok := d[f]
+		if !ok || v == nil {
+			return "", false
+		}
+		buf = append(buf, t.texts[i]...)
+		buf = append(buf, fmt.Sprint(v)...)
+	}
+	buf = append(buf, t.texts[len(t.texts)-1]...)
+	return string(buf), true
+}
+
+// renderTime 同Render，同时替换模板中的时间格式，字段的值由value转换为字符串，转换结果为空时同样返回false
+func (t *fieldTemplate) renderTime(d Data, ts time.Time, value func(interface{}) string) (string, bool) {
+	var buf []byte
+	for i, f := range t.fields {
+		v, ok := d[f]
+		if !ok || v == nil {
+			return "", false
+		}
+		s := value(v)
+		if s == "" {
+			return "", false
+		}
+		buf = append(buf, formatTime(t.texts[i], ts)...)
+		buf = append(buf, s...)
+	}
+	buf = append(buf, formatTime(t.texts[len(t.texts)-1], ts)...)
+	return string(buf), true
+}
+
+func (t *fieldTemplate) String() string {
+	return t.raw
+}
+
+// recordTime 数据中key字段的时间，字段为time.Time或者RFC3339格式的字符串，key为空或者字段不合法时返回now
+func recordTime(d Data, key string, now time.Time) time.Time {
+	if key == "" {
+		return now
+	}
+	switch v := d[key].(type) {
+	case time.Time:
+		return v
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+	}
+	return now
+}
+
+// formatTime 替换layout中的%Y、%m、%d、%H、%M、%S，%%表示%本身，其他内容不变
+func formatTime(layout string, t time.Time) string {
+	if !strings.Contains(layout, "%") {
+		return layout
+	}
+	var buf []byte
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' || i+1 >= len(layout) {
+			buf = append(buf, layout[i])
+			continue
+		}
+		i++
+		switch layout[i] {
+		case 'Y':
+			buf = append(buf, fmt.Sprintf("%04d", t.Year())...)
+		case 'm':
+			buf = append(buf, fmt.Sprintf("%02d", int(t.Month()))...)
+		case 'd':
+			buf = append(buf, fmt.Sprintf("%02d", t.Day())...)
+		case 'H':
+			buf = append(buf, fmt.Sprintf("%02d", t.Hour())...)
+		case 'M':
+			buf = append(buf, fmt.Sprintf("%02d", t.Minute())...)
+		case 'S':
+			buf = append(buf, fmt.Sprintf("%02d", t.Second())...)
+		case '%':
+			buf = append(buf, '%')
+		default:
+			buf = append(buf, '%', layout[i])
+		}
+	}
+	return string(buf)
+}
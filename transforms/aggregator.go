@@ -0,0 +1,292 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/times"
+	"github.com/qiniu/logkit/utils"
+)
+
+// aggregator 的配置
+const (
+	KeyAggInterval    = "agg_interval"    // 窗口大小，单位秒，默认60
+	KeyAggDelay       = "agg_delay"       // 窗口结束后继续等待迟到数据的时间，单位秒，默认0
+	KeyAggGroupBy     = "agg_group_by"    // 分组的tag字段，多个用逗号分隔
+	KeyAggFields      = "agg_fields"      // 需要统计的数值字段，多个用逗号分隔
+	KeyAggPercentiles = "agg_percentiles" // 需要计算的百分位数，默认 50,90,99
+	KeyAggTimeKey     = "agg_time_key"    // 数据的时间字段，不填则使用处理数据时的时间
+	KeyAggCompression = "agg_compression" // t-digest 的压缩参数，默认100
+)
+
+// aggregator 输出的字段，统计字段为 <field>_sum, <field>_min, <field>_max, <field>_p<percentile>
+const (
+	AggFieldTimestamp   = "timestamp"    // 窗口的开始时间，纳秒时间戳
+	AggFieldWindowStart = "window_start" // 窗口的开始时间，RFC3339格式
+	AggFieldCount       = "count"        // 窗口内的数据条数
+)
+
+const aggStateFile = "aggregator.meta"
+
+type aggField struct {
+	Sum    float64        `json:"sum"`
+	Digest *utils.TDigest `json:"digest"`
+}
+
+type aggWindow struct {
+	Start  int64                  `json:"start"` // 窗口开始时间，unix秒
+	Tags   map[string]interface{} `json:"tags"`
+	Count  int64                  `json:"count"`
+	Fields map[string]*aggField   `json:"fields"`
+}
+
+// Aggregator 将数据按 agg_group_by 分组，在固定大小的滚动窗口内统计条数以及数值字段的和、最小值、最大值和百分位数
+// 窗口结束后输出统计结果交给senders发送。未结束的窗口保存在meta目录下，重启后可以继续统计
+type Aggregator struct {
+	interval    int64
+	delay       int64
+	groupBy     []string
+	fields      []string
+	percentiles []float64
+	timeKey     string
+	compression float64
+	statePath   string
+
+	windows map[string]*aggWindow
+	changed bool
+}
+
+// NewAggregator 创建aggregator，metaDir为保存未结束窗口的目录
+func NewAggregator(c conf.MapConf, metaDir string) (*Aggregator, error) {
+	interval, _ := c.GetIntOr(KeyAggInterval, 60)
+	if interval <= 0 {
+		return nil, fmt.Errorf("%v must be positive", KeyAggInterval)
+	}
+	delay, _ := c.GetIntOr(KeyAggDelay, 0)
+	groupBy, _ := c.GetStringListOr(KeyAggGroupBy, []string{})
+	fields, _ := c.GetStringListOr(KeyAggFields, []string{})
+	pstrs, _ := c.GetStringListOr(KeyAggPercentiles, []string{"50", "90", "99"})
+	var percentiles []float64
+	for _, p := range pstrs {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f <= 0 || f >= 100 {
+			return nil, fmt.Errorf("invalid percentile %v, must between 0 and 100", p)
+		}
+		percentiles = append(percentiles, f)
+	}
+	timeKey, _ := c.GetStringOr(KeyAggTimeKey, "")
+	compression, _ := c.GetIntOr(KeyAggCompression, utils.DefaultTDigestCompression)
+	a := &Aggregator{
+		interval:    int64(interval),
+		delay:       int64(delay),
+		groupBy:     groupBy,
+		fields:      fields,
+		percentiles: percentiles,
+		timeKey:     timeKey,
+		compression: float64(compression),
+		windows:     make(map[string]*aggWindow),
+	}
+	if metaDir != "" {
+		a.statePath = filepath.Join(metaDir, aggStateFile)
+		if err := a.load(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *Aggregator) load() error {
+	content, err := ioutil.ReadFile(a.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var windows []*aggWindow
+	if err = json.Unmarshal(content, &windows); err != nil {
+		return fmt.Errorf("load aggregator windows from %v error %v", a.statePath, err)
+	}
+	for _, w := range windows {
+		a.windows[a.windowKey(w.Start, w.Tags)] = w
+	}
+	return nil
+}
+
+// Save 将未结束的窗口写入meta目录，应当与reader的SyncMeta一起调用，保证重启后数据不会重复统计
+func (a *Aggregator) Save() error {
+	if a.statePath == "" || !a.changed {
+		return nil
+	}
+	windows := make([]*aggWindow, 0, len(a.windows))
+	for _, w := range a.windows {
+		windows = append(windows, w)
+	}
+	content, err := json.Marshal(windows)
+	if err != nil {
+		return err
+	}
+	tmp := a.statePath + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, a.statePath); err != nil {
+		return err
+	}
+	a.changed = false
+	return nil
+}
+
+func (a *Aggregator) windowKey(start int64, tags map[string]interface{}) string {
+	parts := []string{strconv.FormatInt(start, 10)}
+	for _, k := range a.groupBy {
+		parts = append(parts, fmt.Sprintf("%v", tags[k]))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func (a *Aggregator) eventTime(d sender.Data, now time.Time) (time.Time, error) {
+	if a.timeKey == "" {
+		return now, nil
+	}
+	v, exist := d[a.timeKey]
+	if !exist {
+		return now, fmt.Errorf("time key %v not exist", a.timeKey)
+	}
+	t, err := ToTime(v)
+	if err != nil {
+		return now, fmt.Errorf("time key %v %v", a.timeKey, err)
+	}
+	return t, nil
+}
+
+// ToTime 将字段的值转换为时间，支持时间类型、时间字符串以及unix时间戳(秒)
+func ToTime(v interface{}) (time.Time, error) {
+	switch tv := v.(type) {
+	case time.Time:
+		return tv, nil
+	case string:
+		return times.StrToTime(tv)
+	}
+	f, ok := exprNumber(v)
+	if !ok {
+		return time.Time{}, fmt.Errorf("value %v can not be parsed as time", v)
+	}
+	return time.Unix(int64(f), 0), nil
+}
+
+// Aggregate 统计数据，并返回在now时已经结束的窗口的统计结果
+func (a *Aggregator) Aggregate(datas []sender.Data, now time.Time) ([]sender.Data, error) {
+	se := &utils.StatsError{}
+	for _, d := range datas {
+		t, err := a.eventTime(d, now)
+		if err != nil {
+			se.AddErrors()
+			se.ErrorDetail = err
+			continue
+		}
+		start := t.Unix() - t.Unix()%a.interval
+		if start+a.interval+a.delay <= now.Unix() {
+			// 窗口已经输出，迟到的数据直接丢弃，避免同一个窗口被重复输出
+			se.AddErrors()
+			se.ErrorDetail = fmt.Errorf("data of window %v arrived after the window was flushed", time.Unix(start, 0).Format(time.RFC3339))
+			continue
+		}
+		tags := make(map[string]interface{}, len(a.groupBy))
+		for _, k := range a.groupBy {
+			if v, ok := d[k]; ok {
+				tags[k] = v
+			}
+		}
+		key := a.windowKey(start, tags)
+		w, ok := a.windows[key]
+		if !ok {
+			w = &aggWindow{Start: start, Tags: tags, Fields: make(map[string]*aggField)}
+			a.windows[key] = w
+		}
+		w.Count++
+		invalid := false
+		for _, k := range a.fields {
+			v, exist := d[k]
+			if !exist {
+				continue
+			}
+			f, ok := exprNumber(v)
+			if !ok {
+				se.ErrorDetail = fmt.Errorf("aggregate field %v value %v is not a number", k, v)
+				invalid = true
+				continue
+			}
+			af, ok := w.Fields[k]
+			if !ok {
+				af = &aggField{Digest: utils.NewTDigest(a.compression)}
+				w.Fields[k] = af
+			}
+			af.Sum += f
+			af.Digest.Add(f)
+		}
+		a.changed = true
+		if invalid {
+			se.AddErrors()
+		} else {
+			se.AddSuccess()
+		}
+	}
+	return a.Flush(now), se
+}
+
+// Flush 返回在now时已经结束的窗口的统计结果，并删除这些窗口
+func (a *Aggregator) Flush(now time.Time) []sender.Data {
+	var closed []*aggWindow
+	for key, w := range a.windows {
+		if w.Start+a.interval+a.delay <= now.Unix() {
+			closed = append(closed, w)
+			delete(a.windows, key)
+		}
+	}
+	if len(closed) <= 0 {
+		return nil
+	}
+	a.changed = true
+	sort.Slice(closed, func(i, j int) bool {
+		if closed[i].Start != closed[j].Start {
+			return closed[i].Start < closed[j].Start
+		}
+		return a.windowKey(closed[i].Start, closed[i].Tags) < a.windowKey(closed[j].Start, closed[j].Tags)
+	})
+	datas := make([]sender.Data, 0, len(closed))
+	for _, w := range closed {
+		datas = append(datas, a.result(w))
+	}
+	return datas
+}
+
+func (a *Aggregator) result(w *aggWindow) sender.Data {
+	start := time.Unix(w.Start, 0)
+	d := sender.Data{
+		AggFieldTimestamp:   start.UnixNano(),
+		AggFieldWindowStart: start.Format(time.RFC3339),
+		AggFieldCount:       w.Count,
+	}
+	for k, v := range w.Tags {
+		d[k] = v
+	}
+	for k, af := range w.Fields {
+		d[k+"_sum"] = af.Sum
+		d[k+"_min"] = af.Digest.Min
+		d[k+"_max"] = af.Digest.Max
+		for _, p := range a.percentiles {
+			d[k+"_p"+strconv.FormatFloat(p, 'f', -1, 64)] = af.Digest.Quantile(p / 100)
+		}
+	}
+	return d
+}
@@ -0,0 +1,97 @@
+package transforms
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AggregatorConf(t *testing.T) {
+	_, err := NewAggregator(conf.MapConf{"agg_interval": "0"}, "")
+	assert.Error(t, err)
+	_, err = NewAggregator(conf.MapConf{"agg_percentiles": "50,100"}, "")
+	assert.Error(t, err)
+	_, err = NewAggregator(conf.MapConf{"agg_percentiles": "x"}, "")
+	assert.Error(t, err)
+}
+
+func Test_Aggregator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_Aggregator")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := conf.MapConf{
+		"agg_interval":    "60",
+		"agg_group_by":    "host,code",
+		"agg_fields":      "latency",
+		"agg_percentiles": "50,99.9",
+		"agg_time_key":    "time",
+	}
+	agg, err := NewAggregator(c, dir)
+	assert.NoError(t, err)
+
+	now := time.Unix(1500000000, 0)
+	datas, err := agg.Aggregate([]sender.Data{
+		{"time": int64(1500000030), "host": "a", "code": 200, "latency": 10},
+		{"time": int64(1500000031), "host": "a", "code": 200, "latency": "30"},
+		{"time": int64(1500000032), "host": "b", "code": 500, "latency": 5},
+		{"time": int64(1500000033), "host": "b", "code": 500},
+		{"time": int64(1500000035), "host": "b", "code": 500, "latency": "x"},
+		{"time": int64(1499999999), "host": "a", "code": 200, "latency": 10},
+		{"host": "b"},
+	}, now)
+	assert.Nil(t, datas)
+	se, ok := err.(*utils.StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), se.Success)
+	assert.Equal(t, int64(3), se.Errors)
+	assert.NoError(t, agg.Save())
+
+	// 重启后从meta目录恢复未结束的窗口
+	agg, err = NewAggregator(c, dir)
+	assert.NoError(t, err)
+	datas, _ = agg.Aggregate([]sender.Data{
+		{"time": int64(1500000034), "host": "a", "code": 200, "latency": 20},
+	}, now)
+	assert.Nil(t, datas)
+	assert.Nil(t, agg.Flush(now.Add(20*time.Second)))
+
+	start := time.Unix(1500000000, 0)
+	datas = agg.Flush(start.Add(time.Minute))
+	assert.Equal(t, []sender.Data{
+		{
+			"timestamp":     start.UnixNano(),
+			"window_start":  start.Format(time.RFC3339),
+			"count":         int64(3),
+			"host":          "a",
+			"code":          float64(200),
+			"latency_sum":   float64(60),
+			"latency_min":   float64(10),
+			"latency_max":   float64(30),
+			"latency_p50":   float64(20),
+			"latency_p99.9": float64(30),
+		},
+		{
+			"timestamp":     start.UnixNano(),
+			"window_start":  start.Format(time.RFC3339),
+			"count":         int64(3),
+			"host":          "b",
+			"code":          float64(500),
+			"latency_sum":   float64(5),
+			"latency_min":   float64(5),
+			"latency_max":   float64(5),
+			"latency_p50":   float64(5),
+			"latency_p99.9": float64(5),
+		},
+	}, datas)
+	assert.NoError(t, agg.Save())
+	agg, err = NewAggregator(c, dir)
+	assert.NoError(t, err)
+	assert.Nil(t, agg.Flush(start.Add(time.Hour)))
+}
@@ -0,0 +1,140 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/logkit/conf"
+)
+
+const (
+	KeyConvertTo = "convert_to" // 转换的目标类型 long/float/string/bool
+)
+
+// convert 支持的目标类型
+const (
+	ConvertLong   = "long"
+	ConvertFloat  = "float"
+	ConvertString = "string"
+	ConvertBool   = "bool"
+)
+
+// NewConvertTransformer 将字段key的值转换为convert_to指定的类型
+func NewConvertTransformer(c conf.MapConf) (Transformer, error) {
+	to, err := c.GetString(KeyConvertTo)
+	if err != nil {
+		return nil, err
+	}
+	var fn func(interface{}) (interface{}, error)
+	switch to {
+	case ConvertLong:
+		fn = toLong
+	case ConvertFloat:
+		fn = toFloat
+	case ConvertString:
+		fn = toString
+	case ConvertBool:
+		fn = toBool
+	default:
+		return nil, fmt.Errorf("convert type %v not supported, only support %v, %v, %v and %v", to, ConvertLong, ConvertFloat, ConvertString, ConvertBool)
+	}
+	return newFieldTransformer(c, TypeConvert, fn)
+}
+
+func toLong(v interface{}) (interface{}, error) {
+	switch nv := v.(type) {
+	case int64:
+		return nv, nil
+	case int:
+		return int64(nv), nil
+	case int32:
+		return int64(nv), nil
+	case float64:
+		return int64(nv), nil
+	case float32:
+		return int64(nv), nil
+	case bool:
+		if nv {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case json.Number:
+		if i, err := nv.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := nv.Float64()
+		if err != nil {
+			return v, err
+		}
+		return int64(f), nil
+	case string:
+		s := strings.TrimSpace(nv)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return v, err
+		}
+		return int64(f), nil
+	}
+	return v, fmt.Errorf("can not convert %v type %v to long", v, reflect.TypeOf(v))
+}
+
+func toFloat(v interface{}) (interface{}, error) {
+	switch nv := v.(type) {
+	case float64:
+		return nv, nil
+	case float32:
+		return float64(nv), nil
+	case int64:
+		return float64(nv), nil
+	case int:
+		return float64(nv), nil
+	case int32:
+		return float64(nv), nil
+	case bool:
+		if nv {
+			return float64(1), nil
+		}
+		return float64(0), nil
+	case json.Number:
+		return nv.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(nv), 64)
+	}
+	return v, fmt.Errorf("can not convert %v type %v to float", v, reflect.TypeOf(v))
+}
+
+func toString(v interface{}) (interface{}, error) {
+	switch nv := v.(type) {
+	case string:
+		return nv, nil
+	case json.Number:
+		return nv.String(), nil
+	case map[string]interface{}, []interface{}:
+		bs, err := json.Marshal(nv)
+		if err != nil {
+			return v, err
+		}
+		return string(bs), nil
+	}
+	return fmt.Sprintf("%v", v), nil
+}
+
+func toBool(v interface{}) (interface{}, error) {
+	switch nv := v.(type) {
+	case bool:
+		return nv, nil
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(nv))
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return v, fmt.Errorf("can not convert %v type %v to bool", v, reflect.TypeOf(v))
+	}
+	return f.(float64) != 0, nil
+}
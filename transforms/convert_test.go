@@ -0,0 +1,48 @@
+package transforms
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ConvertTransformer(t *testing.T) {
+	_, err := NewConvertTransformer(conf.MapConf{KeyTransformKey: "a", KeyConvertTo: "date"})
+	assert.Error(t, err)
+
+	tests := []struct {
+		to  string
+		in  interface{}
+		exp interface{}
+	}{
+		{ConvertLong, "12", int64(12)},
+		{ConvertLong, " 12.7", int64(12)},
+		{ConvertLong, json.Number("34"), int64(34)},
+		{ConvertLong, 5.5, int64(5)},
+		{ConvertLong, true, int64(1)},
+		{ConvertFloat, "1.5", 1.5},
+		{ConvertFloat, int64(3), float64(3)},
+		{ConvertFloat, json.Number("2.25"), 2.25},
+		{ConvertString, int64(200), "200"},
+		{ConvertString, json.Number("1.10"), "1.10"},
+		{ConvertString, map[string]interface{}{"x": 1}, `{"x":1}`},
+		{ConvertBool, "true", true},
+		{ConvertBool, int64(0), false},
+		{ConvertBool, json.Number("3"), true},
+	}
+	for _, ti := range tests {
+		trans, err := NewConvertTransformer(conf.MapConf{KeyTransformKey: "a", KeyConvertTo: ti.to})
+		assert.NoError(t, err)
+		datas, _ := trans.Transform([]sender.Data{{"a": ti.in}})
+		assert.Equal(t, ti.exp, datas[0]["a"], "convert %v to %v", ti.in, ti.to)
+	}
+
+	trans, err := NewConvertTransformer(conf.MapConf{KeyTransformKey: "a", KeyTransformNew: "b", KeyConvertTo: ConvertFloat})
+	assert.NoError(t, err)
+	datas, _ := trans.Transform([]sender.Data{{"a": "1.5"}, {"a": "abc"}})
+	assert.Equal(t, []sender.Data{{"a": "1.5", "b": 1.5}, {"a": "abc"}}, datas)
+}
@@ -0,0 +1,88 @@
+package transforms
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+const (
+	KeyDropIfValue = "drop_if_value" // 字段值等于其中任意一个时丢弃该条数据，多个值用逗号分隔
+	KeyDropIfRegex = "drop_if_regex" // 字段值匹配该正则表达式时丢弃该条数据
+)
+
+// DropIfTransformer 按条件丢弃整条数据
+// drop_if_value 和 drop_if_regex 都不填时，只要数据中存在字段key就丢弃
+type DropIfTransformer struct {
+	name   string
+	key    string
+	values map[string]struct{}
+	regex  *regexp.Regexp
+}
+
+func NewDropIfTransformer(c conf.MapConf) (Transformer, error) {
+	key, err := c.GetString(KeyTransformKey)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := c.GetStringOr(KeyTransformName, TypeDropIf+":"+key)
+	t := &DropIfTransformer{
+		name: name,
+		key:  key,
+	}
+	values, _ := c.GetStringListOr(KeyDropIfValue, []string{})
+	if len(values) > 0 {
+		t.values = make(map[string]struct{})
+		for _, v := range values {
+			t.values[v] = struct{}{}
+		}
+	}
+	expr, _ := c.GetStringOr(KeyDropIfRegex, "")
+	if expr != "" {
+		t.regex, err = regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *DropIfTransformer) Name() string {
+	return t.name
+}
+
+func (t *DropIfTransformer) shouldDrop(d sender.Data) bool {
+	v, exist := d[t.key]
+	if !exist {
+		return false
+	}
+	if t.values == nil && t.regex == nil {
+		return true
+	}
+	s := fmt.Sprintf("%v", v)
+	if t.values != nil {
+		if _, ok := t.values[s]; ok {
+			return true
+		}
+	}
+	if t.regex != nil && t.regex.MatchString(s) {
+		return true
+	}
+	return false
+}
+
+func (t *DropIfTransformer) Transform(datas []sender.Data) ([]sender.Data, error) {
+	se := &utils.StatsError{}
+	newDatas := make([]sender.Data, 0, len(datas))
+	for _, d := range datas {
+		se.AddSuccess()
+		if t.shouldDrop(d) {
+			continue
+		}
+		newDatas = append(newDatas, d)
+	}
+	return newDatas, se
+}
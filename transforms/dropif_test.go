@@ -0,0 +1,34 @@
+package transforms
+
+import (
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DropIfTransformer(t *testing.T) {
+	_, err := NewDropIfTransformer(conf.MapConf{KeyTransformKey: "a", KeyDropIfRegex: "["})
+	assert.Error(t, err)
+
+	trans, err := NewDropIfTransformer(conf.MapConf{KeyTransformKey: "debug"})
+	assert.NoError(t, err)
+	datas, err := trans.Transform([]sender.Data{{"debug": true}, {"a": 1}})
+	assert.Equal(t, []sender.Data{{"a": 1}}, datas)
+	se, ok := err.(*utils.StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), se.Success)
+
+	trans, err = NewDropIfTransformer(conf.MapConf{KeyTransformKey: "level", KeyDropIfValue: "DEBUG, TRACE"})
+	assert.NoError(t, err)
+	datas, _ = trans.Transform([]sender.Data{{"level": "DEBUG"}, {"level": "INFO"}, {"level": "TRACE"}, {"a": 1}})
+	assert.Equal(t, []sender.Data{{"level": "INFO"}, {"a": 1}}, datas)
+
+	trans, err = NewDropIfTransformer(conf.MapConf{KeyTransformKey: "path", KeyDropIfRegex: "^/health"})
+	assert.NoError(t, err)
+	datas, _ = trans.Transform([]sender.Data{{"path": "/healthz"}, {"path": "/api"}})
+	assert.Equal(t, []sender.Data{{"path": "/api"}}, datas)
+}
@@ -0,0 +1,560 @@
+package transforms
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/qiniu/logkit/sender"
+)
+
+// Expr 是作用在一条数据上的条件表达式，用于runner的filter、sender的route_if以及filter transformer
+//
+// 支持的语法：
+//
+//	比较     status >= 500, level == "DEBUG", host != 'a'
+//	正则     path =~ "^/api/", ua !~ "(?i)bot"
+//	集合     method in ["GET", "HEAD"], code not in [200, 304]
+//	逻辑     a && b, a || b, !a，也可以写作 and, or, not，支持括号
+//	函数     exists(field) 字段是否存在，sample(0.1) 以10%的概率为真
+//	字面量   数字、单引号或双引号字符串、true、false、null
+//
+// 字段名中的点号表示嵌套字段，如 req.method；字段名包含特殊字符时可以用反引号括起来
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+type exprNode interface {
+	eval(d sender.Data) interface{}
+}
+
+// NewExpr 解析表达式
+func NewExpr(src string) (*Expr, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("parse expression %q error: %v", src, err)
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("parse expression %q error: unexpected %q", src, p.peek().text)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Match 返回数据是否满足表达式
+func (e *Expr) Match(d sender.Data) bool {
+	return truthy(e.root.eval(d))
+}
+
+// Filter 返回满足表达式的数据
+func (e *Expr) Filter(datas []sender.Data) []sender.Data {
+	ret := make([]sender.Data, 0, len(datas))
+	for _, d := range datas {
+		if e.Match(d) {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokField // 反引号括起来的字段名
+	tokOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+}
+
+func isIdentChar(r rune, first bool) bool {
+	if r == '_' || unicode.IsLetter(r) {
+		return true
+	}
+	return !first && (r == '.' || unicode.IsDigit(r))
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'' || r == '`':
+			var buf []rune
+			j := i + 1
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && r != '`' && j+1 < len(rs) {
+					j++
+				}
+				buf = append(buf, rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string in expression %q", src)
+			}
+			if r == '`' {
+				tokens = append(tokens, exprToken{kind: tokField, text: string(buf)})
+			} else {
+				tokens = append(tokens, exprToken{kind: tokString, text: string(buf)})
+			}
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.' || rs[j] == 'e' || rs[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: tokNumber, text: string(rs[i:j])})
+			i = j
+		case isIdentChar(r, true):
+			j := i + 1
+			for j < len(rs) && isIdentChar(rs[j], false) {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: tokIdent, text: string(rs[i:j])})
+			i = j
+		default:
+			if i+1 < len(rs) {
+				two := string(rs[i : i+2])
+				switch two {
+				case "==", "!=", ">=", "<=", "=~", "!~", "&&", "||":
+					tokens = append(tokens, exprToken{kind: tokOp, text: two})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("<>!()[],", r) {
+				return nil, fmt.Errorf("unexpected character %q in expression %q", r, src)
+			}
+			tokens = append(tokens, exprToken{kind: tokOp, text: string(r)})
+			i++
+		}
+	}
+	return append(tokens, exprToken{kind: tokEOF}), nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept 如果下一个token是op或者关键字中的一个，则消费掉并返回true
+func (p *exprParser) accept(texts ...string) bool {
+	t := p.peek()
+	if t.kind != tokOp && t.kind != tokIdent {
+		return false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expect %q but got %q", text, p.peek().text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&", "and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.accept("!", "not") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokOp && (t.text == "==" || t.text == "!=" || t.text == ">" || t.text == ">=" || t.text == "<" || t.text == "<="):
+		p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &compareNode{op: t.text, left: left, right: right}, nil
+	case t.kind == tokOp && (t.text == "=~" || t.text == "!~"):
+		p.next()
+		rt := p.next()
+		if rt.kind != tokString {
+			return nil, fmt.Errorf("right side of %v must be a string regex", t.text)
+		}
+		re, err := regexp.Compile(rt.text)
+		if err != nil {
+			return nil, err
+		}
+		return &matchNode{left: left, regex: re, negate: t.text == "!~"}, nil
+	case t.kind == tokIdent && (t.text == "in" || t.text == "not"):
+		p.next()
+		negate := t.text == "not"
+		if negate {
+			if err = p.expect("in"); err != nil {
+				return nil, err
+			}
+		}
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{left: left, list: list, negate: negate}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseList() ([]exprNode, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	var list []exprNode
+	for !p.accept("]") {
+		if len(list) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		item, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %v", t.text)
+		}
+		return &literalNode{f}, nil
+	case tokString:
+		return &literalNode{t.text}, nil
+	case tokField:
+		return &fieldNode{path: t.text}, nil
+	case tokOp:
+		if t.text != "(" {
+			break
+		}
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{true}, nil
+		case "false":
+			return &literalNode{false}, nil
+		case "null":
+			return &literalNode{nil}, nil
+		}
+		if !p.accept("(") {
+			return &fieldNode{path: t.text}, nil
+		}
+		return p.parseFunc(t.text)
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func (p *exprParser) parseFunc(name string) (exprNode, error) {
+	arg := p.next()
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	switch name {
+	case "exists":
+		if arg.kind != tokIdent && arg.kind != tokField {
+			return nil, fmt.Errorf("argument of exists must be a field name")
+		}
+		return &existsNode{fieldNode{path: arg.text}}, nil
+	case "sample":
+		rate, err := strconv.ParseFloat(arg.text, 64)
+		if arg.kind != tokNumber || err != nil {
+			return nil, fmt.Errorf("argument of sample must be a number")
+		}
+		return &sampleNode{rate}, nil
+	}
+	return nil, fmt.Errorf("function %v not supported", name)
+}
+
+type literalNode struct {
+	v interface{}
+}
+
+func (n *literalNode) eval(sender.Data) interface{} {
+	return n.v
+}
+
+type fieldNode struct {
+	path string
+}
+
+func (n *fieldNode) lookup(d sender.Data) (interface{}, bool) {
+	if v, ok := d[n.path]; ok {
+		return v, true
+	}
+	var cur interface{} = map[string]interface{}(d)
+	for _, part := range strings.Split(n.path, ".") {
+		var m map[string]interface{}
+		switch mv := cur.(type) {
+		case map[string]interface{}:
+			m = mv
+		case sender.Data:
+			m = mv
+		default:
+			return nil, false
+		}
+		v, ok := m[part]
+		if !ok {
+			return nil, false
+		}
+		cur = v
+	}
+	return cur, true
+}
+
+func (n *fieldNode) eval(d sender.Data) interface{} {
+	v, _ := n.lookup(d)
+	return v
+}
+
+type existsNode struct {
+	field fieldNode
+}
+
+func (n *existsNode) eval(d sender.Data) interface{} {
+	_, ok := n.field.lookup(d)
+	return ok
+}
+
+type sampleNode struct {
+	rate float64
+}
+
+func (n *sampleNode) eval(sender.Data) interface{} {
+	return rand.Float64() < n.rate
+}
+
+type notNode struct {
+	x exprNode
+}
+
+func (n *notNode) eval(d sender.Data) interface{} {
+	return !truthy(n.x.eval(d))
+}
+
+type andNode struct {
+	left, right exprNode
+}
+
+func (n *andNode) eval(d sender.Data) interface{} {
+	return truthy(n.left.eval(d)) && truthy(n.right.eval(d))
+}
+
+type orNode struct {
+	left, right exprNode
+}
+
+func (n *orNode) eval(d sender.Data) interface{} {
+	return truthy(n.left.eval(d)) || truthy(n.right.eval(d))
+}
+
+type compareNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *compareNode) eval(d sender.Data) interface{} {
+	l, r := n.left.eval(d), n.right.eval(d)
+	switch n.op {
+	case "==":
+		return valueEqual(l, r)
+	case "!=":
+		return !valueEqual(l, r)
+	}
+	c, ok := valueCompare(l, r)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+type matchNode struct {
+	left   exprNode
+	regex  *regexp.Regexp
+	negate bool
+}
+
+func (n *matchNode) eval(d sender.Data) interface{} {
+	v := n.left.eval(d)
+	if v == nil {
+		return n.negate
+	}
+	s, _ := toString(v)
+	return n.regex.MatchString(s.(string)) != n.negate
+}
+
+type inNode struct {
+	left   exprNode
+	list   []exprNode
+	negate bool
+}
+
+func (n *inNode) eval(d sender.Data) interface{} {
+	v := n.left.eval(d)
+	for _, item := range n.list {
+		if valueEqual(v, item.eval(d)) {
+			return !n.negate
+		}
+	}
+	return n.negate
+}
+
+func truthy(v interface{}) bool {
+	switch nv := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return nv
+	case string:
+		return nv != ""
+	}
+	return true
+}
+
+// exprNumber 将数字以及数字字符串转换为float64，bool不视为数字
+func exprNumber(v interface{}) (float64, bool) {
+	if _, ok := v.(bool); ok {
+		return 0, false
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return 0, false
+	}
+	return f.(float64), true
+}
+
+func valueEqual(l, r interface{}) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	if lb, ok := l.(bool); ok {
+		rb, ok := r.(bool)
+		return ok && lb == rb
+	}
+	if c, ok := valueCompare(l, r); ok {
+		return c == 0
+	}
+	ls, _ := toString(l)
+	rs, _ := toString(r)
+	return ls == rs
+}
+
+// valueCompare 两边都是字符串时按字符串比较，否则按数字比较，无法比较时返回false
+func valueCompare(l, r interface{}) (int, bool) {
+	ls, lok := l.(string)
+	rs, rok := r.(string)
+	if lok && rok {
+		return strings.Compare(ls, rs), true
+	}
+	lf, lok := exprNumber(l)
+	rf, rok := exprNumber(r)
+	if !lok || !rok {
+		return 0, false
+	}
+	switch {
+	case lf < rf:
+		return -1, true
+	case lf > rf:
+		return 1, true
+	}
+	return 0, true
+}
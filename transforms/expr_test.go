@@ -0,0 +1,86 @@
+package transforms
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Expr(t *testing.T) {
+	d := sender.Data{
+		"level":   "ERROR",
+		"status":  "502",
+		"latency": json.Number("1.5"),
+		"path":    "/api/v1/repos",
+		"method":  "POST",
+		"debug":   false,
+		"req": map[string]interface{}{
+			"host": "logkit.qiniu.com",
+		},
+		"my field": 1,
+	}
+	tests := []struct {
+		expr string
+		exp  bool
+	}{
+		{`status >= 500`, true},
+		{`status < 500`, false},
+		{`status == 502 && level == "ERROR"`, true},
+		{`level != 'ERROR' || latency > 1`, true},
+		{`latency <= 1.5 and not debug`, true},
+		{`!(status >= 500)`, false},
+		{`path =~ "^/api/"`, true},
+		{`path !~ "^/api/"`, false},
+		{`method in ["GET", "HEAD"]`, false},
+		{`method not in ["GET", "HEAD"]`, true},
+		{`status in [500, 502, 504]`, true},
+		{`exists(level) && !exists(user)`, true},
+		{`user == null`, true},
+		{`user > 1`, false},
+		{`user =~ "x"`, false},
+		{`req.host == "logkit.qiniu.com"`, true},
+		{"`my field` == 1", true},
+		{`debug`, false},
+		{`sample(1)`, true},
+		{`sample(0)`, false},
+		{`level == "DEBUG" or (status >= 500 and method == "POST")`, true},
+	}
+	for _, ti := range tests {
+		e, err := NewExpr(ti.expr)
+		if !assert.NoError(t, err, ti.expr) {
+			continue
+		}
+		assert.Equal(t, ti.exp, e.Match(d), ti.expr)
+	}
+
+	for _, bad := range []string{
+		``,
+		`status >=`,
+		`status == "502`,
+		`(status == 1`,
+		`path =~ abc`,
+		`path =~ "("`,
+		`method in "GET"`,
+		`unknown(x)`,
+		`sample(x)`,
+		`status = 1`,
+		`a b`,
+	} {
+		_, err := NewExpr(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func Test_FilterTransformer(t *testing.T) {
+	_, err := NewFilterTransformer(conf.MapConf{})
+	assert.Error(t, err)
+	trans, err := NewFilterTransformer(conf.MapConf{"filter_expr": `level != "DEBUG"`})
+	assert.NoError(t, err)
+	assert.Equal(t, "filter", trans.Name())
+	datas, _ := trans.Transform([]sender.Data{{"level": "DEBUG"}, {"level": "INFO"}, {"msg": "x"}})
+	assert.Equal(t, []sender.Data{{"level": "INFO"}, {"msg": "x"}}, datas)
+}
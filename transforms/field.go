@@ -0,0 +1,127 @@
+package transforms
+
+import (
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+// RenameTransformer 将字段key重命名为new
+type RenameTransformer struct {
+	name   string
+	key    string
+	newKey string
+}
+
+func NewRenameTransformer(c conf.MapConf) (Transformer, error) {
+	key, err := c.GetString(KeyTransformKey)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := c.GetString(KeyTransformNew)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := c.GetStringOr(KeyTransformName, TypeRename+":"+key)
+	return &RenameTransformer{
+		name:   name,
+		key:    key,
+		newKey: newKey,
+	}, nil
+}
+
+func (t *RenameTransformer) Name() string {
+	return t.name
+}
+
+func (t *RenameTransformer) Transform(datas []sender.Data) ([]sender.Data, error) {
+	se := &utils.StatsError{}
+	for _, d := range datas {
+		if v, ok := d[t.key]; ok {
+			delete(d, t.key)
+			d[t.newKey] = v
+		}
+		se.AddSuccess()
+	}
+	return datas, se
+}
+
+// DropTransformer 删除key中列出的字段，多个字段用逗号分隔
+type DropTransformer struct {
+	name string
+	keys []string
+}
+
+func NewDropTransformer(c conf.MapConf) (Transformer, error) {
+	keys, err := c.GetStringList(KeyTransformKey)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := c.GetStringOr(KeyTransformName, TypeDrop+":"+c[KeyTransformKey])
+	return &DropTransformer{
+		name: name,
+		keys: keys,
+	}, nil
+}
+
+func (t *DropTransformer) Name() string {
+	return t.name
+}
+
+func (t *DropTransformer) Transform(datas []sender.Data) ([]sender.Data, error) {
+	se := &utils.StatsError{}
+	for _, d := range datas {
+		for _, k := range t.keys {
+			delete(d, k)
+		}
+		se.AddSuccess()
+	}
+	return datas, se
+}
+
+// NewCopyTransformer 将字段key的值复制到new字段
+func NewCopyTransformer(c conf.MapConf) (Transformer, error) {
+	if _, err := c.GetString(KeyTransformNew); err != nil {
+		return nil, err
+	}
+	return newFieldTransformer(c, TypeCopy, func(v interface{}) (interface{}, error) {
+		return v, nil
+	})
+}
+
+// SetTransformer 将字段key设置为常量value，字段不存在时新增
+type SetTransformer struct {
+	name  string
+	key   string
+	value string
+}
+
+func NewSetTransformer(c conf.MapConf) (Transformer, error) {
+	key, err := c.GetString(KeyTransformKey)
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.GetString(KeyTransformValue)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := c.GetStringOr(KeyTransformName, TypeSet+":"+key)
+	return &SetTransformer{
+		name:  name,
+		key:   key,
+		value: value,
+	}, nil
+}
+
+func (t *SetTransformer) Name() string {
+	return t.name
+}
+
+func (t *SetTransformer) Transform(datas []sender.Data) ([]sender.Data, error) {
+	se := &utils.StatsError{}
+	for _, d := range datas {
+		d[t.key] = t.value
+		se.AddSuccess()
+	}
+	return datas, se
+}
@@ -0,0 +1,45 @@
+package transforms
+
+import (
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RenameTransformer(t *testing.T) {
+	_, err := NewRenameTransformer(conf.MapConf{KeyTransformKey: "a"})
+	assert.Error(t, err)
+	trans, err := NewRenameTransformer(conf.MapConf{KeyTransformKey: "a", KeyTransformNew: "b"})
+	assert.NoError(t, err)
+	datas, _ := trans.Transform([]sender.Data{{"a": 1, "c": 2}, {"c": 3}})
+	assert.Equal(t, []sender.Data{{"b": 1, "c": 2}, {"c": 3}}, datas)
+}
+
+func Test_DropTransformer(t *testing.T) {
+	trans, err := NewDropTransformer(conf.MapConf{KeyTransformKey: "a, b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "drop:a, b", trans.Name())
+	datas, _ := trans.Transform([]sender.Data{{"a": 1, "b": 2, "c": 3}, {"c": 4}})
+	assert.Equal(t, []sender.Data{{"c": 3}, {"c": 4}}, datas)
+}
+
+func Test_CopyTransformer(t *testing.T) {
+	_, err := NewCopyTransformer(conf.MapConf{KeyTransformKey: "a"})
+	assert.Error(t, err)
+	trans, err := NewCopyTransformer(conf.MapConf{KeyTransformKey: "a", KeyTransformNew: "b"})
+	assert.NoError(t, err)
+	datas, _ := trans.Transform([]sender.Data{{"a": "x"}, {"c": 1}})
+	assert.Equal(t, []sender.Data{{"a": "x", "b": "x"}, {"c": 1}}, datas)
+}
+
+func Test_SetTransformer(t *testing.T) {
+	_, err := NewSetTransformer(conf.MapConf{KeyTransformKey: "a"})
+	assert.Error(t, err)
+	trans, err := NewSetTransformer(conf.MapConf{KeyTransformKey: "idc", KeyTransformValue: "nb"})
+	assert.NoError(t, err)
+	datas, _ := trans.Transform([]sender.Data{{"idc": "xs"}, {"c": 1}})
+	assert.Equal(t, []sender.Data{{"idc": "nb"}, {"c": 1, "idc": "nb"}}, datas)
+}
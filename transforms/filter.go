@@ -0,0 +1,45 @@
+package transforms
+
+import (
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+const (
+	KeyFilterExpr = "filter_expr" // 条件表达式，只保留满足条件的数据
+)
+
+// FilterTransformer 只保留满足表达式的数据，表达式语法见 Expr
+type FilterTransformer struct {
+	name string
+	expr *Expr
+}
+
+func NewFilterTransformer(c conf.MapConf) (Transformer, error) {
+	src, err := c.GetString(KeyFilterExpr)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := NewExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := c.GetStringOr(KeyTransformName, TypeFilter)
+	return &FilterTransformer{
+		name: name,
+		expr: expr,
+	}, nil
+}
+
+func (t *FilterTransformer) Name() string {
+	return t.name
+}
+
+func (t *FilterTransformer) Transform(datas []sender.Data) ([]sender.Data, error) {
+	se := &utils.StatsError{}
+	for range datas {
+		se.AddSuccess()
+	}
+	return t.expr.Filter(datas), se
+}
@@ -0,0 +1,220 @@
+package transforms
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	KeyGeoIPDB       = "geoip_db"       // MaxMind City 数据库(mmdb)路径
+	KeyGeoIPASNDB    = "geoip_asn_db"   // MaxMind ASN 数据库(mmdb)路径，可选
+	KeyGeoIPLanguage = "geoip_language" // 地名使用的语言，默认zh-CN，找不到时使用en
+	KeyGeoIPPrefix   = "geoip_prefix"   // 结果字段名的前缀，默认为 <key>_
+)
+
+// geoip 添加的字段
+const (
+	GeoIPCountry     = "country"
+	GeoIPCountryCode = "country_code"
+	GeoIPRegion      = "region"
+	GeoIPCity        = "city"
+	GeoIPASN         = "asn"
+	GeoIPASNOrg      = "asn_org"
+)
+
+const defaultGeoIPLanguage = "zh-CN"
+
+type geoNames struct {
+	Names map[string]string `maxminddb:"names"`
+}
+
+type geoCityRecord struct {
+	City    geoNames `maxminddb:"city"`
+	Country struct {
+		Names   map[string]string `maxminddb:"names"`
+		IsoCode string            `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []geoNames `maxminddb:"subdivisions"`
+}
+
+type geoASNRecord struct {
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// GeoIPTransformer 根据IP字段查询本地MaxMind数据库，添加国家、地区、城市以及ASN信息
+type GeoIPTransformer struct {
+	name     string
+	key      string
+	prefix   string
+	language string
+
+	cityDB    *maxminddb.Reader
+	asnDB     *maxminddb.Reader
+	reloaders []*fileReloader
+	cache     *utils.LRUCache
+	// 查询ip对应的信息，返回的map的key为不带前缀的字段名
+	lookup func(ip net.IP) (map[string]interface{}, error)
+}
+
+func NewGeoIPTransformer(c conf.MapConf) (Transformer, error) {
+	key, err := c.GetString(KeyTransformKey)
+	if err != nil {
+		return nil, err
+	}
+	cityPath, err := c.GetString(KeyGeoIPDB)
+	if err != nil {
+		return nil, err
+	}
+	asnPath, _ := c.GetStringOr(KeyGeoIPASNDB, "")
+	name, _ := c.GetStringOr(KeyTransformName, TypeGeoIP+":"+key)
+	prefix, _ := c.GetStringOr(KeyGeoIPPrefix, key+"_")
+	language, _ := c.GetStringOr(KeyGeoIPLanguage, defaultGeoIPLanguage)
+	cacheSize, _ := c.GetIntOr(KeyCacheSize, defaultCacheSize)
+	interval, _ := c.GetIntOr(KeyReloadInterval, defaultReloadInterval)
+
+	t := &GeoIPTransformer{
+		name:     name,
+		key:      key,
+		prefix:   prefix,
+		language: language,
+		cache:    utils.NewLRUCache(cacheSize),
+	}
+	t.lookup = t.lookupDB
+	reloader, err := newFileReloader(cityPath, time.Duration(interval)*time.Second, func(path string) error {
+		return t.openDB(path, &t.cityDB)
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.reloaders = append(t.reloaders, reloader)
+	if asnPath != "" {
+		reloader, err = newFileReloader(asnPath, time.Duration(interval)*time.Second, func(path string) error {
+			return t.openDB(path, &t.asnDB)
+		})
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.reloaders = append(t.reloaders, reloader)
+	}
+	return t, nil
+}
+
+// openDB 打开新的数据库替换旧的，并清空缓存
+func (t *GeoIPTransformer) openDB(path string, db **maxminddb.Reader) error {
+	newDB, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	if *db != nil {
+		(*db).Close()
+	}
+	*db = newDB
+	t.cache.Purge()
+	return nil
+}
+
+func (t *GeoIPTransformer) Name() string {
+	return t.name
+}
+
+func (t *GeoIPTransformer) Close() error {
+	if t.cityDB != nil {
+		t.cityDB.Close()
+	}
+	if t.asnDB != nil {
+		t.asnDB.Close()
+	}
+	return nil
+}
+
+func (t *GeoIPTransformer) localName(names map[string]string) string {
+	if n, ok := names[t.language]; ok {
+		return n
+	}
+	return names["en"]
+}
+
+func (t *GeoIPTransformer) lookupDB(ip net.IP) (map[string]interface{}, error) {
+	info := make(map[string]interface{})
+	var city geoCityRecord
+	if err := t.cityDB.Lookup(ip, &city); err != nil {
+		return nil, err
+	}
+	if n := t.localName(city.Country.Names); n != "" {
+		info[GeoIPCountry] = n
+	}
+	if city.Country.IsoCode != "" {
+		info[GeoIPCountryCode] = city.Country.IsoCode
+	}
+	if len(city.Subdivisions) > 0 {
+		if n := t.localName(city.Subdivisions[0].Names); n != "" {
+			info[GeoIPRegion] = n
+		}
+	}
+	if n := t.localName(city.City.Names); n != "" {
+		info[GeoIPCity] = n
+	}
+	if t.asnDB == nil {
+		return info, nil
+	}
+	var asn geoASNRecord
+	if err := t.asnDB.Lookup(ip, &asn); err != nil {
+		return nil, err
+	}
+	if asn.AutonomousSystemNumber > 0 {
+		info[GeoIPASN] = int64(asn.AutonomousSystemNumber)
+	}
+	if asn.AutonomousSystemOrganization != "" {
+		info[GeoIPASNOrg] = asn.AutonomousSystemOrganization
+	}
+	return info, nil
+}
+
+func (t *GeoIPTransformer) Transform(datas []sender.Data) ([]sender.Data, error) {
+	for _, r := range t.reloaders {
+		r.checkReload()
+	}
+	se := &utils.StatsError{}
+	for _, d := range datas {
+		v, exist := d[t.key]
+		if !exist {
+			se.AddSuccess()
+			continue
+		}
+		s := strings.TrimSpace(fmt.Sprintf("%v", v))
+		var info map[string]interface{}
+		if cached, ok := t.cache.Get(s); ok {
+			info = cached.(map[string]interface{})
+		} else {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				se.AddErrors()
+				se.ErrorDetail = fmt.Errorf("transform key %v value %v is not a valid ip", t.key, v)
+				continue
+			}
+			var err error
+			info, err = t.lookup(ip)
+			if err != nil {
+				se.AddErrors()
+				se.ErrorDetail = fmt.Errorf("lookup geoip of %v error %v", s, err)
+				continue
+			}
+			t.cache.Add(s, info)
+		}
+		for k, iv := range info {
+			d[t.prefix+k] = iv
+		}
+		se.AddSuccess()
+	}
+	return datas, se
+}
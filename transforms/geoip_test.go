@@ -0,0 +1,81 @@
+package transforms
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GeoIPTransformerConf(t *testing.T) {
+	_, err := NewGeoIPTransformer(conf.MapConf{"geoip_db": "GeoLite2-City.mmdb"})
+	assert.Error(t, err)
+	_, err = NewGeoIPTransformer(conf.MapConf{"key": "ip"})
+	assert.Error(t, err)
+	_, err = NewGeoIPTransformer(conf.MapConf{"key": "ip", "geoip_db": "not_exist.mmdb"})
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "Test_GeoIPTransformerConf")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "bad.mmdb")
+	assert.NoError(t, ioutil.WriteFile(dbPath, []byte("not a mmdb file"), 0644))
+	_, err = NewGeoIPTransformer(conf.MapConf{"key": "ip", "geoip_db": dbPath})
+	assert.Error(t, err)
+}
+
+func Test_GeoIPTransformer(t *testing.T) {
+	lookups := 0
+	trans := &GeoIPTransformer{
+		name:   "geoip:ip",
+		key:    "ip",
+		prefix: "ip_",
+		cache:  utils.NewLRUCache(10),
+		lookup: func(ip net.IP) (map[string]interface{}, error) {
+			lookups++
+			if ip.Equal(net.ParseIP("10.0.0.1")) {
+				return nil, errors.New("lookup error")
+			}
+			return map[string]interface{}{
+				GeoIPCountry:     "中国",
+				GeoIPCountryCode: "CN",
+				GeoIPCity:        "上海",
+				GeoIPASN:         int64(4812),
+			}, nil
+		},
+	}
+	datas, err := trans.Transform([]sender.Data{
+		{"ip": "180.168.1.1"},
+		{"ip": " 180.168.1.1"},
+		{"ip": "not ip"},
+		{"ip": "10.0.0.1"},
+		{"other": "x"},
+	})
+	assert.Equal(t, []sender.Data{
+		{"ip": "180.168.1.1", "ip_country": "中国", "ip_country_code": "CN", "ip_city": "上海", "ip_asn": int64(4812)},
+		{"ip": " 180.168.1.1", "ip_country": "中国", "ip_country_code": "CN", "ip_city": "上海", "ip_asn": int64(4812)},
+		{"ip": "not ip"},
+		{"ip": "10.0.0.1"},
+		{"other": "x"},
+	}, datas)
+	se, ok := err.(*utils.StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), se.Success)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, 2, lookups)
+}
+
+func Test_GeoIPLocalName(t *testing.T) {
+	trans := &GeoIPTransformer{language: "zh-CN"}
+	assert.Equal(t, "北京", trans.localName(map[string]string{"en": "Beijing", "zh-CN": "北京"}))
+	assert.Equal(t, "Beijing", trans.localName(map[string]string{"en": "Beijing"}))
+	assert.Equal(t, "", trans.localName(nil))
+}
@@ -0,0 +1,239 @@
+package transforms
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+const (
+	KeyMaskDetectors = "mask_detectors" // 内置的敏感信息检测器，多个用逗号分隔
+	KeyMaskRegex     = "mask_regex"     // 自定义的敏感信息正则表达式
+	KeyMaskMode      = "mask_mode"      // 脱敏方式 full/partial/hash，默认full
+	KeyMaskChar      = "mask_char"      // 替换使用的字符，默认为*
+	KeyMaskKeepHead  = "mask_keep_head" // partial 模式保留开头的字符数，默认3
+	KeyMaskKeepTail  = "mask_keep_tail" // partial 模式保留结尾的字符数，默认4
+	KeyMaskSalt      = "mask_salt"      // hash 模式使用的盐
+)
+
+// 脱敏方式
+const (
+	MaskFull    = "full"
+	MaskPartial = "partial"
+	MaskHash    = "hash"
+)
+
+// 内置的检测器，以及自定义正则和整个字段脱敏在计数中的名字
+const (
+	DetectorPhone  = "phone"
+	DetectorIDCard = "idcard"
+	DetectorEmail  = "email"
+	DetectorToken  = "token"
+	DetectorRegex  = "regex"
+	DetectorField  = "field"
+)
+
+// 正则中有分组时只对第一个分组脱敏，否则对整个匹配的内容脱敏
+var maskDetectors = map[string]*regexp.Regexp{
+	DetectorPhone:  regexp.MustCompile(`\b1[3-9]\d{9}\b`),
+	DetectorIDCard: regexp.MustCompile(`\b[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`),
+	DetectorEmail:  regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	DetectorToken:  regexp.MustCompile(`(?i)(?:bearer\s+|(?:token|access_token|api_key|apikey|secret|password|passwd)["']?\s*[:=]\s*["']?)([A-Za-z0-9\-._~+/]{8,}=*)`),
+}
+
+type maskDetector struct {
+	name  string
+	regex *regexp.Regexp
+}
+
+// MaskTransformer 对字段中的手机号、身份证号、邮箱、token等敏感信息脱敏
+// key 不填时处理数据中所有字符串类型的字段；既没有检测器也没有正则时对整个字段脱敏
+type MaskTransformer struct {
+	name      string
+	keys      []string
+	detectors []maskDetector
+	mask      func(s string) string
+
+	mux    sync.Mutex
+	counts map[string]int64
+}
+
+func NewMaskTransformer(c conf.MapConf) (Transformer, error) {
+	keys, _ := c.GetStringListOr(KeyTransformKey, []string{})
+	defaultName := TypeMask
+	if len(keys) > 0 {
+		defaultName += ":" + strings.Join(keys, ",")
+	}
+	name, _ := c.GetStringOr(KeyTransformName, defaultName)
+	t := &MaskTransformer{
+		name:   name,
+		keys:   keys,
+		counts: make(map[string]int64),
+	}
+	detectors, _ := c.GetStringListOr(KeyMaskDetectors, []string{})
+	for _, d := range detectors {
+		re, ok := maskDetectors[d]
+		if !ok {
+			return nil, fmt.Errorf("mask detector %v not supported", d)
+		}
+		t.detectors = append(t.detectors, maskDetector{name: d, regex: re})
+	}
+	expr, _ := c.GetStringOr(KeyMaskRegex, "")
+	if expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		t.detectors = append(t.detectors, maskDetector{name: DetectorRegex, regex: re})
+	}
+
+	mode, _ := c.GetStringOr(KeyMaskMode, MaskFull)
+	maskChar, _ := c.GetStringOr(KeyMaskChar, "*")
+	switch mode {
+	case MaskFull:
+		t.mask = func(s string) string {
+			return strings.Repeat(maskChar, utf8.RuneCountInString(s))
+		}
+	case MaskPartial:
+		head, _ := c.GetIntOr(KeyMaskKeepHead, 3)
+		tail, _ := c.GetIntOr(KeyMaskKeepTail, 4)
+		if head < 0 || tail < 0 {
+			return nil, fmt.Errorf("%v and %v can not be negative", KeyMaskKeepHead, KeyMaskKeepTail)
+		}
+		t.mask = func(s string) string {
+			rs := []rune(s)
+			if len(rs) <= head+tail {
+				return strings.Repeat(maskChar, len(rs))
+			}
+			return string(rs[:head]) + strings.Repeat(maskChar, len(rs)-head-tail) + string(rs[len(rs)-tail:])
+		}
+	case MaskHash:
+		salt, _ := c.GetStringOr(KeyMaskSalt, "")
+		t.mask = func(s string) string {
+			sum := sha256.Sum256([]byte(salt + s))
+			return hex.EncodeToString(sum[:])
+		}
+	default:
+		return nil, fmt.Errorf("mask mode %v not supported", mode)
+	}
+	return t, nil
+}
+
+func (t *MaskTransformer) Name() string {
+	return t.name
+}
+
+// Counts 返回每种检测器累计脱敏的次数
+func (t *MaskTransformer) Counts() map[string]int64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	counts := make(map[string]int64, len(t.counts))
+	for k, v := range t.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+// maskValueString 把字段的值转为字符串，浮点数不使用科学计数法，避免手机号被转换为1.3912345678e+10
+func maskValueString(v interface{}) string {
+	switch nv := v.(type) {
+	case string:
+		return nv
+	case float64:
+		return strconv.FormatFloat(nv, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(nv), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
+
+// maskString 返回脱敏后的字符串，以及是否有内容被脱敏
+func (t *MaskTransformer) maskString(s string, counts map[string]int64) (string, bool) {
+	if len(t.detectors) <= 0 {
+		counts[DetectorField]++
+		return t.mask(s), true
+	}
+	masked := false
+	for _, d := range t.detectors {
+		matches := d.regex.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) <= 0 {
+			continue
+		}
+		var buf bytes.Buffer
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if len(m) >= 4 {
+				start, end = m[2], m[3]
+			}
+			if start < 0 {
+				continue
+			}
+			buf.WriteString(s[last:start])
+			buf.WriteString(t.mask(s[start:end]))
+			last = end
+			counts[d.name]++
+			masked = true
+		}
+		buf.WriteString(s[last:])
+		s = buf.String()
+	}
+	return s, masked
+}
+
+func (t *MaskTransformer) Transform(datas []sender.Data) ([]sender.Data, error) {
+	se := &utils.StatsError{}
+	counts := make(map[string]int64)
+	for _, d := range datas {
+		if len(t.keys) <= 0 {
+			for k, v := range d {
+				if s, ok := v.(string); ok {
+					if ns, masked := t.maskString(s, counts); masked {
+						d[k] = ns
+					}
+				}
+			}
+			se.AddSuccess()
+			continue
+		}
+		for _, k := range t.keys {
+			v, exist := d[k]
+			if !exist || v == nil {
+				continue
+			}
+			// 手机号等可能已经被parser解析为数字，统一按字符串处理
+			if ns, masked := t.maskString(maskValueString(v), counts); masked {
+				d[k] = ns
+			}
+		}
+		se.AddSuccess()
+	}
+	t.addCounts(counts)
+	return datas, se
+}
+
+// MaskRaw 对解析失败的原始数据使用同样的检测器脱敏，无法定位字段，没有检测器和正则时整行脱敏
+func (t *MaskTransformer) MaskRaw(raw string) string {
+	counts := make(map[string]int64)
+	raw, _ = t.maskString(raw, counts)
+	t.addCounts(counts)
+	return raw
+}
+
+func (t *MaskTransformer) addCounts(counts map[string]int64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for k, v := range counts {
+		t.counts[k] += v
+	}
+}
@@ -0,0 +1,65 @@
+package transforms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MaskTransformer(t *testing.T) {
+	_, err := NewMaskTransformer(conf.MapConf{"mask_detectors": "phone,unknown"})
+	assert.Error(t, err)
+	_, err = NewMaskTransformer(conf.MapConf{"mask_mode": "unknown"})
+	assert.Error(t, err)
+	_, err = NewMaskTransformer(conf.MapConf{"mask_regex": "(abc"})
+	assert.Error(t, err)
+
+	trans, err := NewMaskTransformer(conf.MapConf{
+		"key":            "msg,phone",
+		"mask_detectors": "phone,idcard,email,token",
+		"mask_mode":      "partial",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "mask:msg,phone", trans.Name())
+	datas, _ := trans.Transform([]sender.Data{
+		{"msg": "user 13812345678 id 110101199003071234 mail zhangsan@qiniu.com", "phone": int64(13912345678)},
+		{"msg": "Authorization: Bearer abcdefghijklmn token=0123456789abcdef", "other": "13812345678"},
+		{"msg": "nothing to mask 123", "phone": float64(13712345678)},
+	})
+	assert.Equal(t, []sender.Data{
+		{"msg": "user 138****5678 id 110***********1234 mail zha***********.com", "phone": "139****5678"},
+		{"msg": "Authorization: Bearer abc*******klmn token=012*********cdef", "other": "13812345678"},
+		{"msg": "nothing to mask 123", "phone": "137****5678"},
+	}, datas)
+	assert.Equal(t, map[string]int64{"phone": 3, "idcard": 1, "email": 1, "token": 2}, trans.(Counter).Counts())
+
+	// 原始数据不区分字段，只使用检测器
+	assert.Equal(t, `{"msg":"call 138****5678","phone":139****5678}`, trans.(RawMasker).MaskRaw(`{"msg":"call 13812345678","phone":13912345678}`))
+	assert.Equal(t, int64(5), trans.(Counter).Counts()["phone"])
+}
+
+func Test_MaskTransformerModes(t *testing.T) {
+	trans, err := NewMaskTransformer(conf.MapConf{"key": "password"})
+	assert.NoError(t, err)
+	datas, _ := trans.Transform([]sender.Data{{"password": "秘密123"}, {"user": "x"}})
+	assert.Equal(t, []sender.Data{{"password": "*****"}, {"user": "x"}}, datas)
+	assert.Equal(t, map[string]int64{"field": 1}, trans.(Counter).Counts())
+	assert.Equal(t, "*****", trans.(RawMasker).MaskRaw("a=b c"))
+
+	trans, err = NewMaskTransformer(conf.MapConf{
+		"mask_regex": `uid=(\d+)`,
+		"mask_mode":  "hash",
+		"mask_salt":  "salt",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "mask", trans.Name())
+	sum := sha256.Sum256([]byte("salt10086"))
+	datas, _ = trans.Transform([]sender.Data{{"raw": "GET /?uid=10086", "code": 200}})
+	assert.Equal(t, []sender.Data{{"raw": "GET /?uid=" + hex.EncodeToString(sum[:]), "code": 200}}, datas)
+	assert.Equal(t, map[string]int64{"regex": 1}, trans.(Counter).Counts())
+}
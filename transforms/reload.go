@@ -0,0 +1,70 @@
+package transforms
+
+import (
+	"os"
+	"time"
+
+	"github.com/qiniu/log"
+)
+
+// 本地数据库类transformer的通用配置
+const (
+	KeyCacheSize      = "cache_size"      // 查询结果LRU缓存的条数
+	KeyReloadInterval = "reload_interval" // 检查数据库文件是否变化的间隔，单位秒
+)
+
+const (
+	defaultCacheSize      = 10000
+	defaultReloadInterval = 60
+)
+
+// fileReloader 在每次处理数据前按间隔检查本地文件，文件变化后重新加载
+// 加载失败时保留原来的数据，等待下一次检查
+type fileReloader struct {
+	path      string
+	interval  time.Duration
+	lastCheck time.Time
+	modTime   time.Time
+	size      int64
+	load      func(path string) error
+}
+
+func newFileReloader(path string, interval time.Duration, load func(path string) error) (*fileReloader, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = load(path); err != nil {
+		return nil, err
+	}
+	return &fileReloader{
+		path:      path,
+		interval:  interval,
+		lastCheck: time.Now(),
+		modTime:   fi.ModTime(),
+		size:      fi.Size(),
+		load:      load,
+	}, nil
+}
+
+func (r *fileReloader) checkReload() {
+	if r.interval <= 0 || time.Now().Sub(r.lastCheck) < r.interval {
+		return
+	}
+	r.lastCheck = time.Now()
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		log.Errorf("stat %v error %v, keep using the loaded one", r.path, err)
+		return
+	}
+	if fi.ModTime().Equal(r.modTime) && fi.Size() == r.size {
+		return
+	}
+	if err = r.load(r.path); err != nil {
+		log.Errorf("reload %v error %v, keep using the loaded one", r.path, err)
+		return
+	}
+	r.modTime = fi.ModTime()
+	r.size = fi.Size()
+	log.Infof("%v was changed and reloaded", r.path)
+}
@@ -0,0 +1,161 @@
+package transforms
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/qiniu/logkit/conf"
+)
+
+const (
+	KeySplitSep        = "split_sep"        // split 的分隔符，默认为逗号
+	KeyTrimCutset      = "trim_cutset"      // trim 去除的字符集合，默认去除空白字符
+	KeyTrimSide        = "trim_side"        // trim 的方向 both/left/right，默认both
+	KeyCaseMode        = "case_mode"        // 大小写转换方式 upper/lower
+	KeySubstringStart  = "substring_start"  // 截取的起始位置，按字符计算，从0开始
+	KeySubstringLength = "substring_length" // 截取的长度，小于等于0表示截取到末尾
+	KeyReplaceRegex    = "replace_regex"    // 需要替换的正则表达式
+	KeyReplaceWith     = "replace_with"     // 替换的内容，支持 $1 这样的分组引用
+)
+
+const (
+	TrimBoth  = "both"
+	TrimLeft  = "left"
+	TrimRight = "right"
+
+	CaseUpper = "upper"
+	CaseLower = "lower"
+)
+
+func stringValue(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("value %v type %v is not string", v, reflect.TypeOf(v))
+	}
+	return s, nil
+}
+
+// NewSplitTransformer 将字符串字段按split_sep分隔为数组
+func NewSplitTransformer(c conf.MapConf) (Transformer, error) {
+	sep, _ := c.GetStringOr(KeySplitSep, ",")
+	if sep == "" {
+		return nil, fmt.Errorf("%v can not be empty", KeySplitSep)
+	}
+	return newFieldTransformer(c, TypeSplit, func(v interface{}) (interface{}, error) {
+		s, err := stringValue(v)
+		if err != nil {
+			return v, err
+		}
+		return strings.Split(s, sep), nil
+	})
+}
+
+// NewTrimTransformer 去除字符串字段首尾的空白字符或trim_cutset中的字符
+func NewTrimTransformer(c conf.MapConf) (Transformer, error) {
+	cutset, _ := c.GetStringOr(KeyTrimCutset, "")
+	side, _ := c.GetStringOr(KeyTrimSide, TrimBoth)
+	var trim func(string) string
+	switch side {
+	case TrimBoth:
+		trim = func(s string) string {
+			if cutset == "" {
+				return strings.TrimSpace(s)
+			}
+			return strings.Trim(s, cutset)
+		}
+	case TrimLeft:
+		trim = func(s string) string {
+			if cutset == "" {
+				return strings.TrimLeftFunc(s, unicode.IsSpace)
+			}
+			return strings.TrimLeft(s, cutset)
+		}
+	case TrimRight:
+		trim = func(s string) string {
+			if cutset == "" {
+				return strings.TrimRightFunc(s, unicode.IsSpace)
+			}
+			return strings.TrimRight(s, cutset)
+		}
+	default:
+		return nil, fmt.Errorf("%v %v not supported, only support %v, %v and %v", KeyTrimSide, side, TrimBoth, TrimLeft, TrimRight)
+	}
+	return newFieldTransformer(c, TypeTrim, func(v interface{}) (interface{}, error) {
+		s, err := stringValue(v)
+		if err != nil {
+			return v, err
+		}
+		return trim(s), nil
+	})
+}
+
+// NewCaseTransformer 将字符串字段转换为大写或小写
+func NewCaseTransformer(c conf.MapConf) (Transformer, error) {
+	mode, err := c.GetString(KeyCaseMode)
+	if err != nil {
+		return nil, err
+	}
+	var fn func(string) string
+	switch mode {
+	case CaseUpper:
+		fn = strings.ToUpper
+	case CaseLower:
+		fn = strings.ToLower
+	default:
+		return nil, fmt.Errorf("%v %v not supported, only support %v and %v", KeyCaseMode, mode, CaseUpper, CaseLower)
+	}
+	return newFieldTransformer(c, TypeCase, func(v interface{}) (interface{}, error) {
+		s, err := stringValue(v)
+		if err != nil {
+			return v, err
+		}
+		return fn(s), nil
+	})
+}
+
+// NewSubstringTransformer 截取字符串字段的一部分，按字符计算位置
+func NewSubstringTransformer(c conf.MapConf) (Transformer, error) {
+	start, _ := c.GetIntOr(KeySubstringStart, 0)
+	length, _ := c.GetIntOr(KeySubstringLength, 0)
+	if start < 0 {
+		return nil, fmt.Errorf("%v must not be negative, but got %v", KeySubstringStart, start)
+	}
+	return newFieldTransformer(c, TypeSubstring, func(v interface{}) (interface{}, error) {
+		s, err := stringValue(v)
+		if err != nil {
+			return v, err
+		}
+		rs := []rune(s)
+		if start >= len(rs) {
+			return "", nil
+		}
+		end := len(rs)
+		if length > 0 && start+length < end {
+			end = start + length
+		}
+		return string(rs[start:end]), nil
+	})
+}
+
+// NewReplaceTransformer 将字符串字段中匹配replace_regex的部分替换为replace_with
+func NewReplaceTransformer(c conf.MapConf) (Transformer, error) {
+	expr, err := c.GetString(KeyReplaceRegex)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	with, _ := c.GetStringOr(KeyReplaceWith, "")
+	return newFieldTransformer(c, TypeReplace, func(v interface{}) (interface{}, error) {
+		s, err := stringValue(v)
+		if err != nil {
+			return v, err
+		}
+		return reg.ReplaceAllString(s, with), nil
+	})
+}
@@ -0,0 +1,102 @@
+package transforms
+
+import (
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SplitTransformer(t *testing.T) {
+	trans, err := NewSplitTransformer(conf.MapConf{KeyTransformKey: "a"})
+	assert.NoError(t, err)
+	datas, _ := trans.Transform([]sender.Data{{"a": "x,y,z"}, {"a": 1}})
+	assert.Equal(t, []sender.Data{{"a": []string{"x", "y", "z"}}, {"a": 1}}, datas)
+
+	trans, err = NewSplitTransformer(conf.MapConf{KeyTransformKey: "a", KeyTransformNew: "b", KeySplitSep: "|"})
+	assert.NoError(t, err)
+	datas, _ = trans.Transform([]sender.Data{{"a": "x|y"}})
+	assert.Equal(t, []sender.Data{{"a": "x|y", "b": []string{"x", "y"}}}, datas)
+}
+
+func Test_TrimTransformer(t *testing.T) {
+	_, err := NewTrimTransformer(conf.MapConf{KeyTransformKey: "a", KeyTrimSide: "middle"})
+	assert.Error(t, err)
+
+	tests := []struct {
+		side   string
+		cutset string
+		exp    string
+	}{
+		{TrimBoth, "", "--x--"},
+		{TrimLeft, "", "--x-- \n"},
+		{TrimRight, "", " \t--x--"},
+		{TrimBoth, " \t\n-", "x"},
+		{TrimLeft, " \t-", "x-- \n"},
+		{TrimRight, " \n-", " \t--x"},
+	}
+	for _, ti := range tests {
+		trans, err := NewTrimTransformer(conf.MapConf{KeyTransformKey: "a", KeyTrimSide: ti.side, KeyTrimCutset: ti.cutset})
+		assert.NoError(t, err)
+		datas, _ := trans.Transform([]sender.Data{{"a": " \t--x-- \n"}})
+		assert.Equal(t, ti.exp, datas[0]["a"])
+	}
+}
+
+func Test_CaseTransformer(t *testing.T) {
+	_, err := NewCaseTransformer(conf.MapConf{KeyTransformKey: "a"})
+	assert.Error(t, err)
+	trans, err := NewCaseTransformer(conf.MapConf{KeyTransformKey: "a", KeyCaseMode: CaseUpper})
+	assert.NoError(t, err)
+	datas, _ := trans.Transform([]sender.Data{{"a": "Get"}})
+	assert.Equal(t, "GET", datas[0]["a"])
+	trans, err = NewCaseTransformer(conf.MapConf{KeyTransformKey: "a", KeyCaseMode: CaseLower})
+	assert.NoError(t, err)
+	datas, _ = trans.Transform([]sender.Data{{"a": "Get"}})
+	assert.Equal(t, "get", datas[0]["a"])
+}
+
+func Test_SubstringTransformer(t *testing.T) {
+	_, err := NewSubstringTransformer(conf.MapConf{KeyTransformKey: "a", KeySubstringStart: "-1"})
+	assert.Error(t, err)
+	tests := []struct {
+		start  string
+		length string
+		exp    string
+	}{
+		{"0", "2", "七牛"},
+		{"2", "0", "logkit"},
+		{"4", "100", "gkit"},
+		{"20", "1", ""},
+	}
+	for _, ti := range tests {
+		trans, err := NewSubstringTransformer(conf.MapConf{KeyTransformKey: "a", KeySubstringStart: ti.start, KeySubstringLength: ti.length})
+		assert.NoError(t, err)
+		datas, _ := trans.Transform([]sender.Data{{"a": "七牛logkit"}})
+		assert.Equal(t, ti.exp, datas[0]["a"])
+	}
+}
+
+func Test_ReplaceTransformer(t *testing.T) {
+	_, err := NewReplaceTransformer(conf.MapConf{KeyTransformKey: "a", KeyReplaceRegex: "("})
+	assert.Error(t, err)
+	trans, err := NewReplaceTransformer(conf.MapConf{
+		KeyTransformKey: "path",
+		KeyReplaceRegex: `/users/(\d+)`,
+		KeyReplaceWith:  "/users/:id",
+	})
+	assert.NoError(t, err)
+	datas, _ := trans.Transform([]sender.Data{{"path": "/users/123/info"}})
+	assert.Equal(t, "/users/:id/info", datas[0]["path"])
+
+	trans, err = NewReplaceTransformer(conf.MapConf{
+		KeyTransformKey: "a",
+		KeyReplaceRegex: `(\w+)@(\w+)`,
+		KeyReplaceWith:  "$2@$1",
+	})
+	assert.NoError(t, err)
+	datas, _ = trans.Transform([]sender.Data{{"a": "x@y"}})
+	assert.Equal(t, "y@x", datas[0]["a"])
+}
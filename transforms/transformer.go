@@ -0,0 +1,153 @@
+package transforms
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+)
+
+// Transformer 对parser解析出来的数据做进一步处理，处理后的数据交给sender发送
+type Transformer interface {
+	Name() string
+	// transform datas, return the datas after transformed. datas may be dropped by transformer
+	Transform(datas []sender.Data) ([]sender.Data, error)
+}
+
+// Counter 由需要上报额外计数的transformer实现，计数会展示在runner的状态中
+type Counter interface {
+	// 返回自创建以来累计的计数，需要并发安全
+	Counts() map[string]int64
+}
+
+// RawMasker 由脱敏类transformer实现，解析失败的原始数据写入dead letter前会先经过脱敏
+type RawMasker interface {
+	// 返回脱敏后的原始数据，需要并发安全
+	MaskRaw(raw string) string
+}
+
+// conf 字段
+const (
+	KeyTransformName  = utils.GlobalKeyName
+	KeyTransformType  = "type"
+	KeyTransformKey   = "key"   // 需要处理的字段名
+	KeyTransformNew   = "new"   // 处理后的结果存放的字段名，不填则覆盖原字段
+	KeyTransformValue = "value" // set transformer 设置的常量值
+)
+
+// transformer 的类型
+const (
+	TypeRename    = "rename"
+	TypeDrop      = "drop"
+	TypeCopy      = "copy"
+	TypeSet       = "set"
+	TypeConvert   = "convert"
+	TypeSplit     = "split"
+	TypeTrim      = "trim"
+	TypeCase      = "case"
+	TypeSubstring = "substring"
+	TypeReplace   = "replace"
+	TypeDropIf    = "drop_if"
+	TypeGeoIP     = "geoip"
+	TypeUserAgent = "useragent"
+	TypeMask      = "mask"
+	TypeFilter    = "filter"
+)
+
+// TransformerRegistry transformer 的工厂类。可以注册自定义transformer
+type TransformerRegistry struct {
+	transformerTypeMap map[string]func(conf.MapConf) (Transformer, error)
+}
+
+func NewTransformerRegistry() *TransformerRegistry {
+	tr := &TransformerRegistry{
+		transformerTypeMap: map[string]func(conf.MapConf) (Transformer, error){},
+	}
+	tr.RegisterTransformer(TypeRename, NewRenameTransformer)
+	tr.RegisterTransformer(TypeDrop, NewDropTransformer)
+	tr.RegisterTransformer(TypeCopy, NewCopyTransformer)
+	tr.RegisterTransformer(TypeSet, NewSetTransformer)
+	tr.RegisterTransformer(TypeConvert, NewConvertTransformer)
+	tr.RegisterTransformer(TypeSplit, NewSplitTransformer)
+	tr.RegisterTransformer(TypeTrim, NewTrimTransformer)
+	tr.RegisterTransformer(TypeCase, NewCaseTransformer)
+	tr.RegisterTransformer(TypeSubstring, NewSubstringTransformer)
+	tr.RegisterTransformer(TypeReplace, NewReplaceTransformer)
+	tr.RegisterTransformer(TypeDropIf, NewDropIfTransformer)
+	tr.RegisterTransformer(TypeGeoIP, NewGeoIPTransformer)
+	tr.RegisterTransformer(TypeUserAgent, NewUserAgentTransformer)
+	tr.RegisterTransformer(TypeMask, NewMaskTransformer)
+	tr.RegisterTransformer(TypeFilter, NewFilterTransformer)
+	return tr
+}
+
+func (tr *TransformerRegistry) RegisterTransformer(transformerType string, constructor func(conf.MapConf) (Transformer, error)) error {
+	_, exist := tr.transformerTypeMap[transformerType]
+	if exist {
+		return errors.New("transformerType " + transformerType + " has been existed")
+	}
+	tr.transformerTypeMap[transformerType] = constructor
+	return nil
+}
+
+func (tr *TransformerRegistry) NewTransformer(conf conf.MapConf) (t Transformer, err error) {
+	tt, err := conf.GetString(KeyTransformType)
+	if err != nil {
+		return
+	}
+	f, exist := tr.transformerTypeMap[tt]
+	if !exist {
+		return nil, fmt.Errorf("transformer type not supported: %v", tt)
+	}
+	return f(conf)
+}
+
+// fieldTransformer 是只对单个字段做处理的transformer的公共部分
+type fieldTransformer struct {
+	name   string
+	key    string
+	newKey string
+	// 对字段值做处理，返回处理后的值
+	fn func(v interface{}) (interface{}, error)
+}
+
+func newFieldTransformer(c conf.MapConf, tType string, fn func(interface{}) (interface{}, error)) (Transformer, error) {
+	key, err := c.GetString(KeyTransformKey)
+	if err != nil {
+		return nil, err
+	}
+	newKey, _ := c.GetStringOr(KeyTransformNew, key)
+	name, _ := c.GetStringOr(KeyTransformName, tType+":"+key)
+	return &fieldTransformer{
+		name:   name,
+		key:    key,
+		newKey: newKey,
+		fn:     fn,
+	}, nil
+}
+
+func (t *fieldTransformer) Name() string {
+	return t.name
+}
+
+func (t *fieldTransformer) Transform(datas []sender.Data) ([]sender.Data, error) {
+	se := &utils.StatsError{}
+	for _, d := range datas {
+		v, exist := d[t.key]
+		if !exist {
+			se.AddSuccess()
+			continue
+		}
+		ret, err := t.fn(v)
+		if err != nil {
+			se.AddErrors()
+			se.ErrorDetail = fmt.Errorf("transform key %v value %v error %v", t.key, v, err)
+			continue
+		}
+		d[t.newKey] = ret
+		se.AddSuccess()
+	}
+	return datas, se
+}
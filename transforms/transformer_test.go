@@ -0,0 +1,55 @@
+package transforms
+
+import (
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TransformerRegistry(t *testing.T) {
+	tr := NewTransformerRegistry()
+	_, err := tr.NewTransformer(conf.MapConf{KeyTransformType: "not_exist", KeyTransformKey: "a"})
+	assert.Error(t, err)
+	_, err = tr.NewTransformer(conf.MapConf{KeyTransformKey: "a"})
+	assert.Error(t, err)
+
+	err = tr.RegisterTransformer(TypeRename, NewRenameTransformer)
+	assert.Error(t, err)
+
+	trans, err := tr.NewTransformer(conf.MapConf{
+		KeyTransformType: TypeRename,
+		KeyTransformKey:  "a",
+		KeyTransformNew:  "b",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "rename:a", trans.Name())
+
+	trans, err = tr.NewTransformer(conf.MapConf{
+		KeyTransformName: "my_upper",
+		KeyTransformType: TypeCase,
+		KeyTransformKey:  "a",
+		KeyCaseMode:      CaseUpper,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "my_upper", trans.Name())
+}
+
+func Test_FieldTransformerStats(t *testing.T) {
+	trans, err := NewConvertTransformer(conf.MapConf{
+		KeyTransformKey: "a",
+		KeyConvertTo:    ConvertLong,
+	})
+	assert.NoError(t, err)
+	datas := []sender.Data{{"a": "1"}, {"a": "x"}, {"b": "2"}}
+	datas, err = trans.Transform(datas)
+	se, ok := err.(*utils.StatsError)
+	assert.True(t, ok)
+	assert.Error(t, se.ErrorDetail)
+	assert.Equal(t, int64(2), se.Success)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Equal(t, []sender.Data{{"a": int64(1)}, {"a": "x"}, {"b": "2"}}, datas)
+}
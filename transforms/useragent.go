@@ -0,0 +1,244 @@
+package transforms
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+
+	"github.com/qiniu/log"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	KeyUARegexes = "ua_regexes" // uap-core 格式的 regexes.yaml 路径
+	KeyUAPrefix  = "ua_prefix"  // 结果字段名的前缀，默认为 <key>_
+)
+
+// useragent 添加的字段
+const (
+	UABrowser        = "browser"
+	UABrowserVersion = "browser_version"
+	UAOS             = "os"
+	UAOSVersion      = "os_version"
+	UADevice         = "device"
+	UADeviceBrand    = "device_brand"
+	UADeviceModel    = "device_model"
+)
+
+const uaOther = "Other"
+
+var uaGroupRef = regexp.MustCompile(`\$(\d)`)
+
+// uaRule 是 regexes.yaml 中的一条规则
+// replacements 和 groups 按输出的值一一对应，replacement 为空时取正则中对应分组的值，分组为0表示没有默认值
+type uaRule struct {
+	regex        *regexp.Regexp
+	replacements []string
+	groups       []int
+}
+
+func (r *uaRule) match(s string) ([]string, bool) {
+	m := r.regex.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	ret := make([]string, len(r.groups))
+	for i, g := range r.groups {
+		if r.replacements[i] != "" {
+			ret[i] = strings.TrimSpace(uaGroupRef.ReplaceAllStringFunc(r.replacements[i], func(ref string) string {
+				idx, _ := strconv.Atoi(ref[1:])
+				if idx < len(m) {
+					return m[idx]
+				}
+				return ""
+			}))
+			continue
+		}
+		if g > 0 && g < len(m) {
+			ret[i] = m[g]
+		}
+	}
+	return ret, true
+}
+
+type uaRegexes struct {
+	UserAgentParsers []map[string]string `yaml:"user_agent_parsers"`
+	OSParsers        []map[string]string `yaml:"os_parsers"`
+	DeviceParsers    []map[string]string `yaml:"device_parsers"`
+}
+
+func compileUARules(parsers []map[string]string, replacementKeys []string, groups []int) []*uaRule {
+	rules := make([]*uaRule, 0, len(parsers))
+	for _, p := range parsers {
+		expr := p["regex"]
+		if p["regex_flag"] == "i" {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			// regexes.yaml 中有少量 Go 不支持的正则语法，跳过这些规则
+			log.Debugf("skip useragent regex %v: %v", p["regex"], err)
+			continue
+		}
+		rule := &uaRule{regex: re, groups: groups}
+		for _, k := range replacementKeys {
+			rule.replacements = append(rule.replacements, p[k])
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// UserAgentTransformer 使用 uap-core 的 regexes.yaml 解析 User-Agent 字段，添加浏览器、操作系统和设备信息
+type UserAgentTransformer struct {
+	name   string
+	key    string
+	prefix string
+
+	uaRules     []*uaRule
+	osRules     []*uaRule
+	deviceRules []*uaRule
+	reloader    *fileReloader
+	cache       *utils.LRUCache
+}
+
+func NewUserAgentTransformer(c conf.MapConf) (Transformer, error) {
+	key, err := c.GetString(KeyTransformKey)
+	if err != nil {
+		return nil, err
+	}
+	path, err := c.GetString(KeyUARegexes)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := c.GetStringOr(KeyTransformName, TypeUserAgent+":"+key)
+	prefix, _ := c.GetStringOr(KeyUAPrefix, key+"_")
+	cacheSize, _ := c.GetIntOr(KeyCacheSize, defaultCacheSize)
+	interval, _ := c.GetIntOr(KeyReloadInterval, defaultReloadInterval)
+
+	t := &UserAgentTransformer{
+		name:   name,
+		key:    key,
+		prefix: prefix,
+		cache:  utils.NewLRUCache(cacheSize),
+	}
+	t.reloader, err = newFileReloader(path, time.Duration(interval)*time.Second, t.loadRegexes)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *UserAgentTransformer) loadRegexes(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var regexes uaRegexes
+	if err = yaml.Unmarshal(content, &regexes); err != nil {
+		return err
+	}
+	uaRules := compileUARules(regexes.UserAgentParsers,
+		[]string{"family_replacement", "v1_replacement", "v2_replacement", "v3_replacement"}, []int{1, 2, 3, 4})
+	osRules := compileUARules(regexes.OSParsers,
+		[]string{"os_replacement", "os_v1_replacement", "os_v2_replacement", "os_v3_replacement"}, []int{1, 2, 3, 4})
+	deviceRules := compileUARules(regexes.DeviceParsers,
+		[]string{"device_replacement", "brand_replacement", "model_replacement"}, []int{1, 0, 1})
+	if len(uaRules)+len(osRules)+len(deviceRules) == 0 {
+		return fmt.Errorf("no valid useragent regex found in %v", path)
+	}
+	t.uaRules, t.osRules, t.deviceRules = uaRules, osRules, deviceRules
+	t.cache.Purge()
+	return nil
+}
+
+func (t *UserAgentTransformer) Name() string {
+	return t.name
+}
+
+func firstMatch(rules []*uaRule, s string) []string {
+	for _, r := range rules {
+		if m, ok := r.match(s); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+func joinVersion(parts []string) string {
+	var vs []string
+	for _, p := range parts {
+		if p == "" {
+			break
+		}
+		vs = append(vs, p)
+	}
+	return strings.Join(vs, ".")
+}
+
+func (t *UserAgentTransformer) parse(ua string) map[string]interface{} {
+	info := map[string]interface{}{
+		UABrowser: uaOther,
+		UAOS:      uaOther,
+		UADevice:  uaOther,
+	}
+	if m := firstMatch(t.uaRules, ua); m != nil && m[0] != "" {
+		info[UABrowser] = m[0]
+		if v := joinVersion(m[1:]); v != "" {
+			info[UABrowserVersion] = v
+		}
+	}
+	if m := firstMatch(t.osRules, ua); m != nil && m[0] != "" {
+		info[UAOS] = m[0]
+		if v := joinVersion(m[1:]); v != "" {
+			info[UAOSVersion] = v
+		}
+	}
+	if m := firstMatch(t.deviceRules, ua); m != nil && m[0] != "" {
+		info[UADevice] = m[0]
+		if m[1] != "" {
+			info[UADeviceBrand] = m[1]
+		}
+		if m[2] != "" {
+			info[UADeviceModel] = m[2]
+		}
+	}
+	return info
+}
+
+func (t *UserAgentTransformer) Transform(datas []sender.Data) ([]sender.Data, error) {
+	t.reloader.checkReload()
+	se := &utils.StatsError{}
+	for _, d := range datas {
+		v, exist := d[t.key]
+		if !exist {
+			se.AddSuccess()
+			continue
+		}
+		ua, ok := v.(string)
+		if !ok {
+			se.AddErrors()
+			se.ErrorDetail = fmt.Errorf("transform key %v value %v is not a string", t.key, v)
+			continue
+		}
+		var info map[string]interface{}
+		if cached, ok := t.cache.Get(ua); ok {
+			info = cached.(map[string]interface{})
+		} else {
+			info = t.parse(ua)
+			t.cache.Add(ua, info)
+		}
+		for k, iv := range info {
+			d[t.prefix+k] = iv
+		}
+		se.AddSuccess()
+	}
+	return datas, se
+}
@@ -0,0 +1,110 @@
+package transforms
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testUARegexes = `
+user_agent_parsers:
+  - regex: '(Chrome)/(\d+)\.(\d+)\.(\d+)'
+  - regex: '(MicroMessenger)/(\d+)\.(\d+)'
+    family_replacement: 'WeChat'
+os_parsers:
+  - regex: '(Android) (\d+)\.(\d+)'
+  - regex: '(Windows NT) (\d+)\.(\d+)'
+device_parsers:
+  - regex: '; *(mi) (\d+) Build'
+    regex_flag: 'i'
+    device_replacement: 'XiaoMi $2'
+    brand_replacement: 'XiaoMi'
+    model_replacement: 'Mi $2'
+`
+
+func Test_UserAgentTransformer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_UserAgentTransformer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "regexes.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testUARegexes), 0644))
+
+	_, err = NewUserAgentTransformer(conf.MapConf{"key": "ua"})
+	assert.Error(t, err)
+	_, err = NewUserAgentTransformer(conf.MapConf{"key": "ua", "ua_regexes": filepath.Join(dir, "not_exist.yaml")})
+	assert.Error(t, err)
+
+	trans, err := NewUserAgentTransformer(conf.MapConf{
+		"type":       "useragent",
+		"key":        "ua",
+		"ua_regexes": path,
+		"ua_prefix":  "",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "useragent:ua", trans.Name())
+	datas, err := trans.Transform([]sender.Data{
+		{"ua": "Mozilla/5.0 (Linux; Android 7.1; MI 6 Build/NMF26X) AppleWebKit/537.36 Chrome/57.0.2987.132 Mobile"},
+		{"ua": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) MicroMessenger/6.5"},
+		{"ua": "curl/7.54.0"},
+		{"ua": 123},
+	})
+	assert.Equal(t, []sender.Data{
+		{
+			"ua":              "Mozilla/5.0 (Linux; Android 7.1; MI 6 Build/NMF26X) AppleWebKit/537.36 Chrome/57.0.2987.132 Mobile",
+			"browser":         "Chrome",
+			"browser_version": "57.0.2987",
+			"os":              "Android",
+			"os_version":      "7.1",
+			"device":          "XiaoMi 6",
+			"device_brand":    "XiaoMi",
+			"device_model":    "Mi 6",
+		},
+		{
+			"ua":              "Mozilla/5.0 (Windows NT 10.0; Win64; x64) MicroMessenger/6.5",
+			"browser":         "WeChat",
+			"browser_version": "6.5",
+			"os":              "Windows NT",
+			"os_version":      "10.0",
+			"device":          "Other",
+		},
+		{"ua": "curl/7.54.0", "browser": "Other", "os": "Other", "device": "Other"},
+		{"ua": 123},
+	}, datas)
+	assert.Error(t, err)
+}
+
+func Test_UserAgentTransformerReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_UserAgentTransformerReload")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "regexes.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testUARegexes), 0644))
+
+	trans, err := NewUserAgentTransformer(conf.MapConf{"key": "ua", "ua_regexes": path})
+	assert.NoError(t, err)
+	uat := trans.(*UserAgentTransformer)
+	uat.reloader.interval = time.Millisecond
+	datas, _ := trans.Transform([]sender.Data{{"ua": "curl/7.54.0"}})
+	assert.Equal(t, "Other", datas[0]["ua_browser"])
+
+	// 文件损坏时继续使用原来的规则
+	assert.NoError(t, ioutil.WriteFile(path, []byte("user_agent_parsers: ["), 0644))
+	time.Sleep(10 * time.Millisecond)
+	datas, _ = trans.Transform([]sender.Data{{"ua": "Chrome/57.0.2987.132"}})
+	assert.Equal(t, "Chrome", datas[0]["ua_browser"])
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(strings.Replace(testUARegexes, "user_agent_parsers:\n",
+		"user_agent_parsers:\n  - regex: '(curl)/(\\d+)\\.(\\d+)'\n", 1)), 0644))
+	time.Sleep(10 * time.Millisecond)
+	datas, _ = trans.Transform([]sender.Data{{"ua": "curl/7.54.0"}})
+	assert.Equal(t, "curl", datas[0]["ua_browser"])
+	assert.Equal(t, "7.54", datas[0]["ua_browser_version"])
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff 指数退避，第n次重试前等待Initial*Multiplier^(n-1)，不超过Max，
+// 再加上正负Jitter比例的随机抖动，避免多个logkit同时重试
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// Duration 返回第retry次重试前的等待时间，retry从1开始
+func (b Backoff) Duration(retry int) time.Duration {
+	if retry < 1 {
+		retry = 1
+	}
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(retry-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*rand.Float64() - 1)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// 熔断器的状态
+const (
+	BreakerClosed   = "closed"    // 正常发送
+	BreakerOpen     = "open"      // 连续失败过多，暂停发送
+	BreakerHalfOpen = "half_open" // 熔断后到了探测的时间，允许尝试一次
+)
+
+// CircuitBreaker 连续失败threshold次后熔断，熔断期间每隔probeInterval允许尝试一次，成功后恢复。
+// threshold小于等于0时永远不会熔断
+type CircuitBreaker struct {
+	mux           sync.Mutex
+	threshold     int
+	probeInterval time.Duration
+	failures      int
+	lastFailure   time.Time
+}
+
+func NewCircuitBreaker(threshold int, probeInterval time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, probeInterval: probeInterval}
+}
+
+// Wait 返回下一次尝试前还需要等待的时间，没有熔断或者已经可以探测时为0
+func (cb *CircuitBreaker) Wait() time.Duration {
+	return cb.waitAt(time.Now())
+}
+
+func (cb *CircuitBreaker) waitAt(now time.Time) time.Duration {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	if !cb.open() {
+		return 0
+	}
+	if wait := cb.lastFailure.Add(cb.probeInterval).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func (cb *CircuitBreaker) State() string {
+	return cb.stateAt(time.Now())
+}
+
+func (cb *CircuitBreaker) stateAt(now time.Time) string {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	if !cb.open() {
+		return BreakerClosed
+	}
+	if now.Before(cb.lastFailure.Add(cb.probeInterval)) {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+func (cb *CircuitBreaker) open() bool {
+	return cb.threshold > 0 && cb.failures >= cb.threshold
+}
+
+func (cb *CircuitBreaker) Success() {
+	cb.mux.Lock()
+	cb.failures = 0
+	cb.mux.Unlock()
+}
+
+// Failure 记录一次失败，熔断期间的探测失败会重新开始计算探测间隔
+func (cb *CircuitBreaker) Failure() {
+	cb.failureAt(time.Now())
+}
+
+func (cb *CircuitBreaker) failureAt(now time.Time) {
+	cb.mux.Lock()
+	cb.failures++
+	cb.lastFailure = now
+	cb.mux.Unlock()
+}
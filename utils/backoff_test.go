@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Backoff(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+	assert.Equal(t, time.Second, b.Duration(0))
+	assert.Equal(t, time.Second, b.Duration(1))
+	assert.Equal(t, 4*time.Second, b.Duration(3))
+	assert.Equal(t, 10*time.Second, b.Duration(5))
+	assert.Equal(t, 10*time.Second, b.Duration(100))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Duration(2)
+		assert.True(t, d >= time.Second && d <= 3*time.Second, "duration %v out of jitter range", d)
+	}
+}
+
+func Test_CircuitBreaker(t *testing.T) {
+	cb := NewCircuitBreaker(3, 10*time.Second)
+	now := time.Unix(1500000000, 0)
+	cb.failureAt(now)
+	cb.failureAt(now)
+	assert.Equal(t, BreakerClosed, cb.stateAt(now))
+	assert.Equal(t, time.Duration(0), cb.waitAt(now))
+
+	cb.failureAt(now)
+	assert.Equal(t, BreakerOpen, cb.stateAt(now))
+	assert.Equal(t, 6*time.Second, cb.waitAt(now.Add(4*time.Second)))
+	// 到了探测时间，探测失败后重新熔断
+	assert.Equal(t, BreakerHalfOpen, cb.stateAt(now.Add(10*time.Second)))
+	assert.Equal(t, time.Duration(0), cb.waitAt(now.Add(10*time.Second)))
+	cb.failureAt(now.Add(10 * time.Second))
+	assert.Equal(t, BreakerOpen, cb.stateAt(now.Add(15*time.Second)))
+	cb.Success()
+	assert.Equal(t, BreakerClosed, cb.stateAt(now.Add(15*time.Second)))
+
+	never := NewCircuitBreaker(0, time.Second)
+	for i := 0; i < 10; i++ {
+		never.failureAt(now)
+	}
+	assert.Equal(t, BreakerClosed, never.stateAt(now))
+}
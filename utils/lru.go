@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRUCache 并发安全的定长LRU缓存，超过容量时淘汰最久未使用的数据
+type LRUCache struct {
+	mux   sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+// NewLRUCache 创建容量为size的LRU缓存，size小于等于0时缓存不生效
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *LRUCache) Get(key string) (value interface{}, ok bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+func (c *LRUCache) Add(key string, value interface{}) {
+	if c.size <= 0 {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*lruEntry).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Purge 清空缓存
+func (c *LRUCache) Purge() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+func (c *LRUCache) Len() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.ll.Len()
+}
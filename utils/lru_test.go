@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	// b 最久未使用，被淘汰
+	c.Add("c", 3)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+	c.Add("a", 4)
+	v, _ = c.Get("a")
+	assert.Equal(t, 4, v)
+	c.Purge()
+	assert.Equal(t, 0, c.Len())
+
+	c = NewLRUCache(0)
+	c.Add("a", 1)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+}
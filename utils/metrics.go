@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// 以下类型用于输出 Prometheus 文本格式的监控指标，所有方法都可以并发调用
+
+// Counter 只增不减的计数器
+type Counter struct {
+	v int64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.v, 1)
+}
+
+func (c *Counter) Add(n int64) {
+	atomic.AddInt64(&c.v, n)
+}
+
+// Set 直接设置计数，用于已经在别处累计好的计数，如 fault tolerant sender 的统计
+func (c *Counter) Set(n int64) {
+	atomic.StoreInt64(&c.v, n)
+}
+
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.v)
+}
+
+// Gauge 可增可减的当前值
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// DefaultLatencyBuckets 延迟直方图默认的桶，单位为秒
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Histogram 按桶统计观测值的分布
+type Histogram struct {
+	buckets []float64
+	counts  []uint64 // 每个桶的计数，不累加，最后一个为 +Inf
+	count   uint64
+	sumBits uint64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	bs := append([]float64{}, buckets...)
+	sort.Float64s(bs)
+	return &Histogram{
+		buckets: bs,
+		counts:  make([]uint64, len(bs)+1),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	for {
+		old := atomic.LoadUint64(&h.sumBits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, old, sum) {
+			return
+		}
+	}
+}
+
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&h.sumBits))
+}
+
+// MetricLabel 监控指标的标签
+type MetricLabel struct {
+	Name  string
+	Value string
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatMetricLabels(labels []MetricLabel) string {
+	if len(labels) <= 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, l.Name+`="`+labelValueEscaper.Replace(l.Value)+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// WriteMetricHeader 输出一个指标的 HELP 和 TYPE 行，同名指标的所有数据需要紧跟在后面输出
+func WriteMetricHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// WriteMetric 输出一条指标数据
+func WriteMetric(w io.Writer, name string, labels []MetricLabel, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, formatMetricLabels(labels), formatMetricValue(v))
+}
+
+// WriteHistogram 输出直方图的 _bucket, _sum 和 _count 数据
+func WriteHistogram(w io.Writer, name string, labels []MetricLabel, h *Histogram) {
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		le := math.Inf(1)
+		if i < len(h.buckets) {
+			le = h.buckets[i]
+		}
+		bl := append(append([]MetricLabel{}, labels...), MetricLabel{Name: "le", Value: formatMetricValue(le)})
+		WriteMetric(w, name+"_bucket", bl, float64(cumulative))
+	}
+	WriteMetric(w, name+"_sum", labels, h.Sum())
+	WriteMetric(w, name+"_count", labels, float64(cumulative))
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Metrics(t *testing.T) {
+	var c Counter
+	c.Inc()
+	c.Add(2)
+	assert.Equal(t, int64(3), c.Value())
+	var g Gauge
+	g.Set(0.5)
+	assert.Equal(t, 0.5, g.Value())
+
+	h := NewHistogram([]float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(5)
+	buf := &bytes.Buffer{}
+	WriteMetricHeader(buf, "test_seconds", "Test histogram.", "histogram")
+	WriteHistogram(buf, "test_seconds", []MetricLabel{{Name: "name", Value: "a\"b"}}, h)
+	WriteMetric(buf, "test_total", nil, float64(c.Value()))
+	exp := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{name="a\"b",le="0.1"} 2
+test_seconds_bucket{name="a\"b",le="1"} 3
+test_seconds_bucket{name="a\"b",le="+Inf"} 4
+test_seconds_sum{name="a\"b"} 5.65
+test_seconds_count{name="a\"b"} 4
+test_total 3
+`
+	assert.Equal(t, exp, buf.String())
+}
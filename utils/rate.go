@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateCounter 按秒分桶统计最近一段时间内的计数，用于计算每秒的平均速度
+type RateCounter struct {
+	mux     sync.Mutex
+	created int64
+	buckets []rateBucket
+}
+
+type rateBucket struct {
+	sec int64
+	n   int64
+}
+
+// NewRateCounter 创建RateCounter，window为统计的时间窗口，精度为秒
+func NewRateCounter(window time.Duration) *RateCounter {
+	size := int(window / time.Second)
+	if size <= 0 {
+		size = 1
+	}
+	return &RateCounter{
+		created: time.Now().Unix(),
+		buckets: make([]rateBucket, size),
+	}
+}
+
+func (c *RateCounter) Add(n int64) {
+	c.addAt(time.Now(), n)
+}
+
+func (c *RateCounter) addAt(t time.Time, n int64) {
+	sec := t.Unix()
+	c.mux.Lock()
+	b := &c.buckets[sec%int64(len(c.buckets))]
+	if b.sec != sec {
+		b.sec = sec
+		b.n = 0
+	}
+	b.n += n
+	c.mux.Unlock()
+}
+
+// Rate 返回时间窗口内每秒的平均计数，创建不足一个窗口时按已经经过的时间计算
+func (c *RateCounter) Rate() float64 {
+	return c.rateAt(time.Now())
+}
+
+func (c *RateCounter) rateAt(t time.Time) float64 {
+	now := t.Unix()
+	window := int64(len(c.buckets))
+	c.mux.Lock()
+	var total int64
+	for _, b := range c.buckets {
+		if b.sec > now-window && b.sec <= now {
+			total += b.n
+		}
+	}
+	c.mux.Unlock()
+	span := now - c.created + 1
+	if span > window {
+		span = window
+	}
+	if span <= 0 {
+		span = 1
+	}
+	return float64(total) / float64(span)
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RateCounter(t *testing.T) {
+	c := NewRateCounter(10 * time.Second)
+	start := time.Unix(1500000000, 0)
+	c.created = start.Unix()
+	c.addAt(start, 10)
+	c.addAt(start.Add(time.Second), 10)
+	// 创建不足一个窗口时按已经经过的时间计算
+	assert.Equal(t, float64(10), c.rateAt(start.Add(time.Second)))
+	c.addAt(start.Add(9*time.Second), 30)
+	assert.Equal(t, float64(5), c.rateAt(start.Add(9*time.Second)))
+	// 超出窗口的计数不再统计，复用的桶会被清零
+	c.addAt(start.Add(10*time.Second), 5)
+	assert.Equal(t, 4.5, c.rateAt(start.Add(10*time.Second)))
+	assert.Equal(t, float64(0), c.rateAt(start.Add(30*time.Second)))
+}
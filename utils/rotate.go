@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RotateOptions RotateWriter的滚动方式
+type RotateOptions struct {
+	MaxSize    int64         // 文件超过该大小后滚动，小于等于0时不按大小滚动
+	MaxBackups int           // 最多保留的历史文件数，等于0时不保留，小于0时全部保留
+	Interval   time.Duration // 文件打开超过该时间后滚动，小于等于0时不按时间滚动
+	Compress   bool          // 历史文件使用gzip压缩，文件名为 <path>.1.gz
+	Header     []byte        // 每个新文件开头写入的内容，例如csv的表头
+}
+
+// RotateWriter 按大小或时间滚动的文件writer，滚动时当前文件重命名为 <path>.1，
+// 原来的 <path>.1 重命名为 <path>.2，以此类推，最多保留MaxBackups个历史文件
+type RotateWriter struct {
+	mux      sync.Mutex
+	path     string
+	opt      RotateOptions
+	f        *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// NewRotateWriter 创建按大小滚动的RotateWriter，maxSize小于等于0时不滚动
+func NewRotateWriter(path string, maxSize int64, maxBackups int) (*RotateWriter, error) {
+	return NewRotateWriterWithOptions(path, RotateOptions{MaxSize: maxSize, MaxBackups: maxBackups})
+}
+
+// NewRotateWriterWithOptions 创建RotateWriter，文件已经存在时追加写入
+func NewRotateWriterWithOptions(path string, opt RotateOptions) (*RotateWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	w := &RotateWriter{
+		path: path,
+		opt:  opt,
+		now:  time.Now,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotateWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.size = fi.Size()
+	w.openedAt = w.now()
+	if w.size == 0 && len(w.opt.Header) > 0 {
+		n, err := f.Write(w.opt.Header)
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *RotateWriter) backupName(i int) string {
+	if w.opt.Compress {
+		return fmt.Sprintf("%s.%d.gz", w.path, i)
+	}
+	return fmt.Sprintf("%s.%d", w.path, i)
+}
+
+func (w *RotateWriter) needRotate(n int) bool {
+	// 只有表头的文件不滚动
+	if w.size <= int64(len(w.opt.Header)) {
+		return false
+	}
+	if w.opt.MaxSize > 0 && w.size+int64(n) > w.opt.MaxSize {
+		return true
+	}
+	return w.opt.Interval > 0 && w.now().Sub(w.openedAt) >= w.opt.Interval
+}
+
+func (w *RotateWriter) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	if w.opt.MaxBackups == 0 {
+		os.Remove(w.path)
+		return w.open()
+	}
+	last := w.opt.MaxBackups
+	if last < 0 {
+		last = 1
+		for {
+			if _, err := os.Stat(w.backupName(last)); err != nil {
+				break
+			}
+			last++
+		}
+	}
+	os.Remove(w.backupName(last))
+	for i := last - 1; i >= 1; i-- {
+		os.Rename(w.backupName(i), w.backupName(i+1))
+	}
+	first := fmt.Sprintf("%s.%d", w.path, 1)
+	if err := os.Rename(w.path, first); err != nil {
+		return err
+	}
+	if w.opt.Compress {
+		if err := GzipFile(first); err != nil {
+			return err
+		}
+	}
+	return w.open()
+}
+
+func (w *RotateWriter) Write(p []byte) (n int, err error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+	if w.needRotate(len(p)) {
+		if err = w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = w.f.Write(p)
+	w.size += int64(n)
+	return
+}
+
+func (w *RotateWriter) Close() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// GzipFile 把文件压缩为 <src>.gz 后删除原文件。<src>.gz 已经存在时追加一个新的gzip member，
+// 解压得到的是两部分内容的拼接
+func GzipFile(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(src+".gz", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(out)
+	if _, err = io.Copy(gw, in); err != nil {
+		gw.Close()
+		out.Close()
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RotateWriter(t *testing.T) {
+	dir := "Test_RotateWriter"
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.log")
+	w, err := NewRotateWriter(path, 10, 2)
+	assert.NoError(t, err)
+	for _, s := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		_, err = w.Write([]byte(s))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "dddddd\n", string(content))
+	content, err = ioutil.ReadFile(path + ".1")
+	assert.NoError(t, err)
+	assert.Equal(t, "cccccc\n", string(content))
+	content, err = ioutil.ReadFile(path + ".2")
+	assert.NoError(t, err)
+	assert.Equal(t, "bbbbbb\n", string(content))
+	// 超过maxBackups的历史文件被删除
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	// 重新打开时追加到已有的文件
+	w, err = NewRotateWriter(path, 100, 2)
+	assert.NoError(t, err)
+	w.Write([]byte("eeeeee\n"))
+	w.Close()
+	content, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "dddddd\neeeeee\n", string(content))
+	_, err = w.Write([]byte("x"))
+	assert.Error(t, err)
+}
+
+func Test_RotateWriterOptions(t *testing.T) {
+	dir := "Test_RotateWriterOptions"
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.csv")
+	w, err := NewRotateWriterWithOptions(path, RotateOptions{
+		MaxBackups: -1,
+		Interval:   time.Hour,
+		Compress:   true,
+		Header:     []byte("h\n"),
+	})
+	assert.NoError(t, err)
+	now := time.Unix(1500000000, 0)
+	w.now = func() time.Time { return now }
+	w.openedAt = now
+	for i, s := range []string{"1\n", "2\n", "3\n"} {
+		now = now.Add(time.Duration(i) * time.Hour)
+		_, err = w.Write([]byte(s))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "h\n3\n", string(content))
+	assert.Equal(t, "h\n2\n", readGzip(t, path+".1.gz"))
+	assert.Equal(t, "h\n1\n", readGzip(t, path+".2.gz"))
+	_, err = os.Stat(path + ".1")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_GzipFile(t *testing.T) {
+	dir := "Test_GzipFile"
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	path := filepath.Join(dir, "a.log")
+	for _, s := range []string{"a\n", "b\n"} {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(s), 0644))
+		assert.NoError(t, GzipFile(path))
+	}
+	// 已有的gz文件追加为新的member
+	assert.Equal(t, "a\nb\n", readGzip(t, path+".gz"))
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func readGzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	content, err := ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	return string(content)
+}
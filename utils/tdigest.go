@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// Centroid t-digest 中的一个质心
+type Centroid struct {
+	Mean  float64 `json:"mean"`
+	Count float64 `json:"count"`
+}
+
+// TDigest 使用 merging t-digest 算法估算分位数，占用的内存只与压缩参数有关
+// 所有字段都可以直接序列化为json，用于持久化
+type TDigest struct {
+	Compression float64    `json:"compression"`
+	Centroids   []Centroid `json:"centroids"`
+	Count       float64    `json:"count"`
+	Min         float64    `json:"min"`
+	Max         float64    `json:"max"`
+
+	buffer []Centroid
+}
+
+const DefaultTDigestCompression = 100
+
+// NewTDigest 创建TDigest，compression越大越精确，占用的内存也越多
+func NewTDigest(compression float64) *TDigest {
+	if compression <= 0 {
+		compression = DefaultTDigestCompression
+	}
+	return &TDigest{Compression: compression}
+}
+
+func (t *TDigest) Add(x float64) {
+	t.AddWeighted(x, 1)
+}
+
+func (t *TDigest) AddWeighted(x, w float64) {
+	if math.IsNaN(x) || w <= 0 {
+		return
+	}
+	if t.Count <= 0 || x < t.Min {
+		t.Min = x
+	}
+	if t.Count <= 0 || x > t.Max {
+		t.Max = x
+	}
+	t.buffer = append(t.buffer, Centroid{Mean: x, Count: w})
+	t.Count += w
+	if len(t.buffer) >= int(t.Compression)*5 {
+		t.compress()
+	}
+}
+
+// MarshalJSON 序列化之前先把缓冲区中的数据合并到质心中
+func (t *TDigest) MarshalJSON() ([]byte, error) {
+	t.compress()
+	type tdigest TDigest
+	return json.Marshal((*tdigest)(t))
+}
+
+func (t *TDigest) compress() {
+	if len(t.buffer) <= 0 {
+		return
+	}
+	all := append(t.Centroids, t.buffer...)
+	t.buffer = nil
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Mean < all[j].Mean
+	})
+	merged := make([]Centroid, 0, len(all))
+	cur := all[0]
+	var cumulative float64
+	for _, c := range all[1:] {
+		proposed := cur.Count + c.Count
+		q := (cumulative + proposed/2) / t.Count
+		// 越靠近两端的质心允许的数量越少，以保证尾部分位数的精度
+		if proposed <= 4*t.Count*q*(1-q)/t.Compression {
+			cur.Mean += (c.Mean - cur.Mean) * c.Count / proposed
+			cur.Count = proposed
+			continue
+		}
+		cumulative += cur.Count
+		merged = append(merged, cur)
+		cur = c
+	}
+	t.Centroids = append(merged, cur)
+}
+
+// Quantile 返回分位数q(0到1之间)的估计值，没有数据时返回NaN
+func (t *TDigest) Quantile(q float64) float64 {
+	t.compress()
+	if len(t.Centroids) <= 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return t.Min
+	}
+	if q >= 1 {
+		return t.Max
+	}
+	target := q * t.Count
+	// 质心的数据均匀分布在质心的左右两边，按质心中点之间线性插值
+	prevPos, prevMean := 0.0, t.Min
+	var cumulative float64
+	for _, c := range t.Centroids {
+		pos := cumulative + c.Count/2
+		if target < pos {
+			if pos == prevPos {
+				return c.Mean
+			}
+			return prevMean + (c.Mean-prevMean)*(target-prevPos)/(pos-prevPos)
+		}
+		prevPos, prevMean = pos, c.Mean
+		cumulative += c.Count
+	}
+	if t.Count == prevPos {
+		return t.Max
+	}
+	return prevMean + (t.Max-prevMean)*(target-prevPos)/(t.Count-prevPos)
+}
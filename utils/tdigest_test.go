@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TDigest(t *testing.T) {
+	td := NewTDigest(0)
+	assert.True(t, math.IsNaN(td.Quantile(0.5)))
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		td.Add(r.Float64() * 1000)
+	}
+	assert.Equal(t, float64(100000), td.Count)
+	assert.True(t, len(td.Centroids) < 1000)
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99} {
+		assert.InDelta(t, q*1000, td.Quantile(q), 10, "quantile %v", q)
+	}
+	assert.Equal(t, td.Min, td.Quantile(0))
+	assert.Equal(t, td.Max, td.Quantile(1))
+
+	bs, err := json.Marshal(td)
+	assert.NoError(t, err)
+	var td2 TDigest
+	assert.NoError(t, json.Unmarshal(bs, &td2))
+	assert.Equal(t, td.Quantile(0.9), td2.Quantile(0.9))
+	td2.Add(-1)
+	assert.Equal(t, float64(-1), td2.Min)
+
+	single := NewTDigest(100)
+	single.Add(3)
+	assert.Equal(t, float64(3), single.Quantile(0.5))
+}
@@ -145,12 +145,14 @@ type StatsError struct {
 	ErrorDetail error `json:"error"`
 	Ft          bool  `json:"-"`
 	ErrorIndex  []int
+	IndexErrors map[int]error `json:"-"` // ErrorIndex 中每条数据失败的原因
 }
 
 type StatsInfo struct {
 	Errors  int64 `json:"errors"`
 	Success int64 `json:"success"`
 	Ftlag   int64 `json:"-"`
+	FtBytes int64 `json:"-"` // fault tolerant 队列中数据的大小
 }
 
 func (se *StatsError) AddSuccess() {
@@ -174,6 +176,15 @@ func (se *StatsError) Error() string {
 	return fmt.Sprintf("success %v errors %v errordetail %v", se.Success, se.Errors, se.ErrorDetail)
 }
 
+// AddErrorIndex 记录第idx条数据处理失败以及失败的原因
+func (se *StatsError) AddErrorIndex(idx int, err error) {
+	se.ErrorIndex = append(se.ErrorIndex, idx)
+	if se.IndexErrors == nil {
+		se.IndexErrors = make(map[int]error)
+	}
+	se.IndexErrors[idx] = err
+}
+
 func (se *StatsError) ErrorIndexIn(idx int) bool {
 	for _, v := range se.ErrorIndex {
 		if v == idx {